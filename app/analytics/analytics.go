@@ -0,0 +1,376 @@
+package analytics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/app/server/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// analytics counts how the deals of businesses perform.
+//
+// Handlers record an event for what happened to deals: one event has every deal a nearby search returned,
+// claims and redemptions come from the domain events in the outbox. A job folds the events into hourly
+// rollups, each event exactly once, and adds the hours up into daily rollups for the days it touched.
+// Hourly rollups are kept for 40 days, daily rollups for good.
+
+const (
+	// rollupBatch is the most events folded into the rollups by one run of the job
+	rollupBatch = 500
+	// topDealsLimit is how many deals a report ranks
+	topDealsLimit = 10
+	// maxHourlyRange is the longest report by the hour
+	maxHourlyRange = 7 * 24 * time.Hour
+	// maxDailyRange is the longest report by the day
+	maxDailyRange = 366 * 24 * time.Hour
+	// defaultRange is the length of a report that does not say where it starts
+	defaultRange = 30 * 24 * time.Hour
+)
+
+// ErrInvalidRange is returned for reports over a time range that can not be reported on
+var ErrInvalidRange = errors.New("invalid analytics range")
+
+// fields are the counts of each event type in the rollups
+var fields = map[string]string{
+	model.AnalyticsImpression: "impressions",
+	model.AnalyticsView:       "views",
+	model.AnalyticsClaim:      "claims",
+	model.AnalyticsRedemption: "redemptions",
+}
+
+// Query is the time range and granularity of a report, a nil To is now and a nil From is 30 days (7 by the hour) before To
+type Query struct {
+	From        *time.Time
+	To          *time.Time
+	Granularity string // [model.AnalyticsHour] or [model.AnalyticsDay] (default)
+}
+
+// Service records analytics events and reports on them
+type Service struct {
+	db     *database.Database
+	events model.Collection
+	hourly model.Collection
+	daily  model.Collection
+	deals  model.Collection
+}
+
+// NewService returns a [Service]
+func NewService(db *database.Database) *Service {
+	return &Service{
+		db:     db,
+		events: db.GetAnalyticsEvents(),
+		hourly: db.GetAnalyticsHourly(),
+		daily:  db.GetAnalyticsDaily(),
+		deals:  db.GetDeals(),
+	}
+}
+
+// Record saves that an event of eventType happened to the deals now
+func (s *Service) Record(ctx context.Context, eventType string, deals []model.AnalyticsDeal) error {
+	if len(deals) == 0 {
+		return nil
+	}
+	_, err := s.events.InsertOne(ctx, &model.AnalyticsEvent{
+		ID:          primitive.NewObjectID(),
+		Type:        eventType,
+		Deals:       deals,
+		Occurred_at: time.Now().UTC(),
+	})
+	return err
+}
+
+// HandleEvent records the claims and redemptions of deals, it is subscribed to the domain events.
+// An event that is dispatched again is recorded once
+func (s *Service) HandleEvent(ctx context.Context, event *model.DomainEvent) error {
+	var eventType string
+	switch event.Type {
+	case model.EventDealClaimed:
+		eventType = model.AnalyticsClaim
+	case model.EventDealRedeemed:
+		eventType = model.AnalyticsRedemption
+	default:
+		return nil
+	}
+	redemption := new(model.Redemption)
+	if err := event.DecodeData(redemption); err != nil {
+		return err
+	}
+
+	_, err := s.events.InsertOne(ctx, &model.AnalyticsEvent{
+		ID:   primitive.NewObjectID(),
+		Type: eventType,
+		Key:  event.ID.Hex(),
+		Deals: []model.AnalyticsDeal{
+			{Business_id: redemption.Business_id, Deal_id: redemption.Deal_id, Band: model.DistanceUnknown},
+		},
+		Occurred_at: event.Occurred_at,
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+// Rollup folds the events that are not rolled up yet into the hourly rollups and updates the daily
+// rollups of the days they happened on. It returns how many events were rolled up
+func (s *Service) Rollup(ctx context.Context) (int, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(rollupBatch)
+	cursor, err := s.events.Find(ctx, bson.M{"rolled_up": false}, opts)
+	if err != nil {
+		return 0, err
+	}
+	var events []*model.AnalyticsEvent
+	if err := cursor.All(ctx, &events); err != nil {
+		return 0, err
+	}
+
+	type dealDay struct {
+		business primitive.ObjectID
+		deal     primitive.ObjectID
+		day      int64
+	}
+	days := map[dealDay]bool{}
+	rolledUp := 0
+	for _, event := range events {
+		field, ok := fields[event.Type]
+		if !ok {
+			return rolledUp, fmt.Errorf("unknown analytics event type %s", event.Type)
+		}
+		hour := event.Occurred_at.UTC().Truncate(time.Hour)
+		// the event is marked in the same transaction as it is counted so it is never counted twice
+		err := s.db.WithTransaction(ctx, func(ctx context.Context) error {
+			res, err := s.events.UpdateOne(ctx, bson.M{"_id": event.ID, "rolled_up": false}, bson.M{"$set": bson.M{"rolled_up": true}})
+			if err != nil || res.MatchedCount == 0 {
+				return err
+			}
+			for _, deal := range event.Deals {
+				filter := bson.M{"business_id": deal.Business_id, "deal_id": deal.Deal_id, "start": hour, "band": deal.Band}
+				_, err := s.hourly.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{field: 1}}, options.Update().SetUpsert(true))
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return rolledUp, err
+		}
+		rolledUp++
+		for _, deal := range event.Deals {
+			days[dealDay{business: deal.Business_id, deal: deal.Deal_id, day: startOfDay(hour).Unix()}] = true
+		}
+	}
+
+	for key := range days {
+		if err := s.rollupDay(ctx, key.business, key.deal, time.Unix(key.day, 0).UTC()); err != nil {
+			return rolledUp, err
+		}
+	}
+	return rolledUp, nil
+}
+
+// Report returns how the deals of the business performed over the time range of query
+func (s *Service) Report(ctx context.Context, businessID primitive.ObjectID, query Query) (*model.AnalyticsReport, error) {
+	from, to, granularity, err := reportRange(query, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	rollups, step := s.daily, 24*time.Hour
+	if granularity == model.AnalyticsHour {
+		rollups, step = s.hourly, time.Hour
+	}
+
+	cursor, err := rollups.Find(ctx, bson.M{"business_id": businessID, "start": bson.M{"$gte": from, "$lt": to}})
+	if err != nil {
+		return nil, err
+	}
+	var counts []*model.AnalyticsRollup
+	if err := cursor.All(ctx, &counts); err != nil {
+		return nil, err
+	}
+
+	report := &model.AnalyticsReport{
+		From:           from,
+		To:             to,
+		Granularity:    granularity,
+		Series:         []*model.AnalyticsPoint{},
+		By_day_of_week: []*model.AnalyticsBreakdown{},
+		By_distance:    []*model.AnalyticsBreakdown{},
+	}
+	points := map[int64]*model.AnalyticsPoint{}
+	for start := from; start.Before(to); start = start.Add(step) {
+		point := &model.AnalyticsPoint{Start: start}
+		points[start.Unix()] = point
+		report.Series = append(report.Series, point)
+	}
+	weekdays := map[time.Weekday]*model.AnalyticsBreakdown{}
+	for i := 1; i <= 7; i++ {
+		weekday := time.Weekday(i % 7) // the week starts on monday
+		weekdays[weekday] = &model.AnalyticsBreakdown{Key: strings.ToLower(weekday.String())}
+		report.By_day_of_week = append(report.By_day_of_week, weekdays[weekday])
+	}
+	bands := map[string]*model.AnalyticsBreakdown{}
+	for _, band := range model.DistanceBands {
+		bands[band.Name] = &model.AnalyticsBreakdown{Key: band.Name}
+		report.By_distance = append(report.By_distance, bands[band.Name])
+	}
+	bands[model.DistanceUnknown] = &model.AnalyticsBreakdown{Key: model.DistanceUnknown}
+	report.By_distance = append(report.By_distance, bands[model.DistanceUnknown])
+
+	deals := map[primitive.ObjectID]*model.AnalyticsDealStats{}
+	for _, rollup := range counts {
+		report.Totals.Add(rollup.AnalyticsCounts)
+		if point, ok := points[rollup.Start.Unix()]; ok {
+			point.Add(rollup.AnalyticsCounts)
+		}
+		weekdays[rollup.Start.Weekday()].Add(rollup.AnalyticsCounts)
+		if band, ok := bands[rollup.Band]; ok {
+			band.Add(rollup.AnalyticsCounts)
+		}
+		if deals[rollup.Deal_id] == nil {
+			deals[rollup.Deal_id] = &model.AnalyticsDealStats{Deal_id: rollup.Deal_id}
+		}
+		deals[rollup.Deal_id].Add(rollup.AnalyticsCounts)
+	}
+
+	report.Top_deals, err = s.topDeals(ctx, deals)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// topDeals ranks the deals by claims, then views, then impressions and names the best ones
+func (s *Service) topDeals(ctx context.Context, deals map[primitive.ObjectID]*model.AnalyticsDealStats) ([]*model.AnalyticsDealStats, error) {
+	top := make([]*model.AnalyticsDealStats, 0, len(deals))
+	for _, deal := range deals {
+		top = append(top, deal)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		a, b := top[i], top[j]
+		if a.Claims != b.Claims {
+			return a.Claims > b.Claims
+		}
+		if a.Views != b.Views {
+			return a.Views > b.Views
+		}
+		if a.Impressions != b.Impressions {
+			return a.Impressions > b.Impressions
+		}
+		return a.Deal_id.Hex() < b.Deal_id.Hex()
+	})
+	if len(top) > topDealsLimit {
+		top = top[:topDealsLimit]
+	}
+	if len(top) == 0 {
+		return top, nil
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(top))
+	for _, deal := range top {
+		ids = append(ids, deal.Deal_id)
+	}
+	// archived deals are named too, their counts are still part of the report
+	cursor, err := s.deals.Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, options.Find().SetProjection(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var named []*model.Deal
+	if err := cursor.All(ctx, &named); err != nil {
+		return nil, err
+	}
+	names := map[primitive.ObjectID]*string{}
+	for _, deal := range named {
+		names[deal.ID] = deal.Name
+	}
+	for _, deal := range top {
+		deal.Name = names[deal.Deal_id]
+	}
+	return top, nil
+}
+
+// rollupDay sets the daily rollups of a deal on day to the sum of its hourly rollups
+func (s *Service) rollupDay(ctx context.Context, businessID primitive.ObjectID, dealID primitive.ObjectID, day time.Time) error {
+	cursor, err := s.hourly.Find(ctx, bson.M{
+		"business_id": businessID,
+		"deal_id":     dealID,
+		"start":       bson.M{"$gte": day, "$lt": day.Add(24 * time.Hour)},
+	})
+	if err != nil {
+		return err
+	}
+	var hours []*model.AnalyticsRollup
+	if err := cursor.All(ctx, &hours); err != nil {
+		return err
+	}
+
+	bands := map[string]*model.AnalyticsCounts{}
+	for _, hour := range hours {
+		if bands[hour.Band] == nil {
+			bands[hour.Band] = &model.AnalyticsCounts{}
+		}
+		bands[hour.Band].Add(hour.AnalyticsCounts)
+	}
+	for band, counts := range bands {
+		filter := bson.M{"business_id": businessID, "deal_id": dealID, "start": day, "band": band}
+		_, err := s.daily.UpdateOne(ctx, filter, bson.M{"$set": counts}, options.Update().SetUpsert(true))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reportRange works out the start, end and granularity of a report
+func reportRange(query Query, now time.Time) (time.Time, time.Time, string, error) {
+	granularity, step, longest, length := model.AnalyticsDay, 24*time.Hour, maxDailyRange, defaultRange
+	switch query.Granularity {
+	case "", model.AnalyticsDay:
+	case model.AnalyticsHour:
+		granularity, step, longest, length = model.AnalyticsHour, time.Hour, maxHourlyRange, maxHourlyRange
+	default:
+		return time.Time{}, time.Time{}, "", fmt.Errorf("%w: granularity is hour or day", ErrInvalidRange)
+	}
+
+	// the range is widened to whole hours or days
+	to := now
+	if query.To != nil {
+		to = query.To.UTC()
+	}
+	to = truncate(to.Add(step-time.Nanosecond), step)
+	from := to.Add(-length)
+	if query.From != nil {
+		from = truncate(query.From.UTC(), step)
+	}
+	if !to.After(from) {
+		return time.Time{}, time.Time{}, "", fmt.Errorf("%w: from must be before to", ErrInvalidRange)
+	}
+	if to.Sub(from) > longest {
+		return time.Time{}, time.Time{}, "", fmt.Errorf("%w: a report by the %s covers at most %d days", ErrInvalidRange, granularity, int(longest/(24*time.Hour)))
+	}
+	return from, to, granularity, nil
+}
+
+// truncate rounds t down to the hour or UTC day
+func truncate(t time.Time, step time.Duration) time.Time {
+	if step == time.Hour {
+		return t.Truncate(time.Hour)
+	}
+	return startOfDay(t)
+}
+
+// startOfDay is the start of the UTC day of t
+func startOfDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
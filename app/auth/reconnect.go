@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reconnect tokens let a live connection that dropped (e.g. a phone changing networks)
+// come back without logging in again. They are short lived and carry over the login they came from:
+// its issue time so revoking the sessions of the account revokes them too, its expiry so a connection
+// never outlives the login and the admin of an impersonation login.
+
+// ErrInvalidReconnectToken is returned when a reconnect token was not signed by this server or expired
+var ErrInvalidReconnectToken = errors.New("invalid reconnect token")
+
+// ReconnectSession is the login a reconnect token resumes
+type ReconnectSession struct {
+	Uid          string
+	IssuedAt     int64  // when the login token was issued, unix seconds
+	ExpiresAt    int64  // when the login token expires, unix seconds
+	Impersonator string // the admin acting as the account, only set for impersonation logins
+}
+
+// NewReconnectSession returns the session of a login token
+func NewReconnectSession(claims *SignedDetails) ReconnectSession {
+	return ReconnectSession{
+		Uid:          claims.Uid,
+		IssuedAt:     claims.IssuedAt,
+		ExpiresAt:    claims.ExpiresAt,
+		Impersonator: claims.Impersonator,
+	}
+}
+
+// Expired checks if the login of the session ran out, a login without an expiry can not be resumed
+func (s ReconnectSession) Expired(now time.Time) bool {
+	return now.Unix() >= s.ExpiresAt
+}
+
+// SignReconnectToken creates a reconnect token for the session that expires at expires or when the login does,
+// whichever is first. Returns the token and when it expires
+func SignReconnectToken(session ReconnectSession, expires time.Time) (string, time.Time) {
+	if login := time.Unix(session.ExpiresAt, 0); login.Before(expires) {
+		expires = login
+	}
+	body := strings.Join([]string{
+		session.Uid,
+		strconv.FormatInt(session.IssuedAt, 10),
+		strconv.FormatInt(session.ExpiresAt, 10),
+		session.Impersonator,
+		strconv.FormatInt(expires.Unix(), 10),
+	}, ".")
+	return body + "." + reconnectSignature(body), expires.UTC()
+}
+
+// VerifyReconnectToken checks a reconnect token and returns the session it resumes
+func VerifyReconnectToken(token string) (ReconnectSession, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 6 {
+		return ReconnectSession{}, ErrInvalidReconnectToken
+	}
+
+	body := strings.Join(parts[:5], ".")
+	if !hmac.Equal([]byte(reconnectSignature(body)), []byte(parts[5])) {
+		return ReconnectSession{}, ErrInvalidReconnectToken
+	}
+
+	session := ReconnectSession{Uid: parts[0], Impersonator: parts[3]}
+	var err error
+	if session.IssuedAt, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+		return ReconnectSession{}, ErrInvalidReconnectToken
+	}
+	if session.ExpiresAt, err = strconv.ParseInt(parts[2], 10, 64); err != nil {
+		return ReconnectSession{}, ErrInvalidReconnectToken
+	}
+	expires, err := strconv.ParseInt(parts[4], 10, 64)
+	now := time.Now()
+	if err != nil || now.Unix() > expires || session.Expired(now) {
+		return ReconnectSession{}, ErrInvalidReconnectToken
+	}
+
+	return session, nil
+}
+
+func reconnectSignature(body string) string {
+	mac := hmac.New(sha256.New, []byte(SECRET_KEY))
+	mac.Write([]byte("reconnect:" + body))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
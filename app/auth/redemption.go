@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Redemption codes are what a customer shows at the counter when using a deal.
+// The short code can be typed in by staff and the QR payload carries a signature
+// so a scanned code can be checked before going to the database.
+
+const qrPayloadPrefix = "whir:r:"
+
+// the alphabet leaves out characters that are easy to mix up when read out loud (0/O, 1/I/L)
+const codeAlphabet = "23456789ABCDEFGHJKMNPQRSTUVWXYZ"
+
+// ErrInvalidRedemptionPayload is returned when a QR payload was not signed by this server
+var ErrInvalidRedemptionPayload = errors.New("invalid redemption code")
+
+// codeByteLimit is the largest multiple of the alphabet length that fits in a byte, random bytes from it up
+// are drawn again so every character of the alphabet is as likely
+const codeByteLimit = 256 - 256%len(codeAlphabet)
+
+// GenerateRedemptionCode creates a random human friendly code like 7KQ4-MZ9D
+func GenerateRedemptionCode() (string, error) {
+	var code strings.Builder
+	b := make([]byte, 16)
+	for n := 0; n < 8; {
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		for _, c := range b {
+			if n == 8 || int(c) >= codeByteLimit {
+				continue
+			}
+			if n == 4 {
+				code.WriteByte('-')
+			}
+			code.WriteByte(codeAlphabet[int(c)%len(codeAlphabet)])
+			n++
+		}
+	}
+	return code.String(), nil
+}
+
+// SignRedemption creates the payload to put in the QR code for a redemption
+func SignRedemption(redemptionID primitive.ObjectID, code string) string {
+	return qrPayloadPrefix + redemptionID.Hex() + "." + code + "." + redemptionSignature(redemptionID, code)
+}
+
+// VerifyRedemptionPayload checks the signature of a QR payload and returns the redemption it is for
+func VerifyRedemptionPayload(payload string) (primitive.ObjectID, string, error) {
+	if !strings.HasPrefix(payload, qrPayloadPrefix) {
+		return primitive.NilObjectID, "", ErrInvalidRedemptionPayload
+	}
+
+	parts := strings.Split(strings.TrimPrefix(payload, qrPayloadPrefix), ".")
+	if len(parts) != 3 {
+		return primitive.NilObjectID, "", ErrInvalidRedemptionPayload
+	}
+
+	redemptionID, err := primitive.ObjectIDFromHex(parts[0])
+	if err != nil {
+		return primitive.NilObjectID, "", ErrInvalidRedemptionPayload
+	}
+
+	expected := redemptionSignature(redemptionID, parts[1])
+	if !hmac.Equal([]byte(expected), []byte(parts[2])) {
+		return primitive.NilObjectID, "", ErrInvalidRedemptionPayload
+	}
+
+	return redemptionID, parts[1], nil
+}
+
+// NormalizeRedemptionCode cleans up a code typed in by staff
+func NormalizeRedemptionCode(code string) string {
+	code = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+	if len(code) == 8 && !strings.Contains(code, "-") {
+		code = code[:4] + "-" + code[4:]
+	}
+	return code
+}
+
+func redemptionSignature(redemptionID primitive.ObjectID, code string) string {
+	mac := hmac.New(sha256.New, []byte(SECRET_KEY))
+	mac.Write([]byte("redemption:" + redemptionID.Hex() + ":" + code))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
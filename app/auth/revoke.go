@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrTokenRevoked is returned for a token issued before the sessions of its account were revoked
+var ErrTokenRevoked = errors.New("the session was revoked")
+
+// ErrAccountSuspended is returned for the tokens of an account an admin suspended
+var ErrAccountSuspended = errors.New("the account is suspended")
+
+// RevokeTokens logs an account out everywhere, every token issued until now stops working.
+// Works for both the users and the businesses collection
+func RevokeTokens(ctx context.Context, userCollection model.Collection, id primitive.ObjectID) error {
+	_, err := userCollection.UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"tokens_revoked_at": time.Now().UTC()}},
+	)
+	return err
+}
+
+// CheckSession makes sure the account of a token issued at issuedAt still exists, was not revoked and is not suspended.
+// Long lived connections call it from time to time because they only check the token once
+func CheckSession(ctx context.Context, userCollection model.Collection, uid string, issuedAt int64) error {
+	id, err := primitive.ObjectIDFromHex(uid)
+	if err != nil {
+		return err
+	}
+
+	foundUser := new(model.User)
+	if err := userCollection.FindOne(foundUser, ctx, bson.M{"_id": id}); err != nil {
+		return err
+	}
+	if tokenRevoked(foundUser, issuedAt) {
+		return ErrTokenRevoked
+	}
+	if foundUser.IsSuspended() {
+		return ErrAccountSuspended
+	}
+	return nil
+}
+
+// tokenRevoked reports whether a token issued at issuedAt (unix seconds) was revoked,
+// tokens from before issue times were added have none and count as revoked too
+func tokenRevoked(user *model.User, issuedAt int64) bool {
+	return user.Tokens_revoked_at != nil && issuedAt <= user.Tokens_revoked_at.Unix()
+}
+
+// ErrImpersonationEnded is returned for an impersonation token of an admin who can no longer act as the account
+var ErrImpersonationEnded = errors.New("the impersonation ended")
+
+// CheckImpersonator makes sure the admin of an impersonation token issued at issuedAt is still an admin and
+// did not revoke their sessions since. Long lived connections of impersonation logins call it with [CheckSession]
+func CheckImpersonator(ctx context.Context, userCollection model.Collection, uid string, issuedAt int64) error {
+	id, err := primitive.ObjectIDFromHex(uid)
+	if err != nil {
+		return err
+	}
+
+	admin := new(model.User)
+	if err := userCollection.FindOne(admin, ctx, bson.M{"_id": id}); err != nil {
+		return err
+	}
+	if admin.Role != model.RoleAdmin || tokenRevoked(admin, issuedAt) || admin.IsSuspended() {
+		return ErrImpersonationEnded
+	}
+	return nil
+}
@@ -21,6 +21,7 @@ type SignedDetails struct {
 	First_name string
 	Last_name  string
 	Uid        string
+	Impersonator string // the admin acting as the account, only set on impersonation tokens
 	jwt.StandardClaims
 }
 
@@ -34,6 +35,8 @@ func GenerateAllTokens(email string, firstName string, lastName *string, uid str
         Uid:        uid,
         StandardClaims: jwt.StandardClaims{
             ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(24)).Unix(),
+            // the issue time is checked against RevokeTokens
+            IssuedAt:  time.Now().Unix(),
         },
     }
 
@@ -59,6 +62,25 @@ func GenerateAllTokens(email string, firstName string, lastName *string, uid str
     return token, refreshToken, err
 }
 
+// ImpersonationTTL is how long an admin can act as an account with one impersonation token
+const ImpersonationTTL = time.Hour
+
+// GenerateImpersonationToken generates a short lived token for the account uid marked with the admin using it.
+// There is no refresh token, the admin asks for a new one. Revoking the sessions of the account ends it too
+func GenerateImpersonationToken(email string, firstName string, uid string, impersonatorUid string) (string, error) {
+    claims := &SignedDetails{
+        Email:        email,
+        First_name:   firstName,
+        Uid:          uid,
+        Impersonator: impersonatorUid,
+        StandardClaims: jwt.StandardClaims{
+            ExpiresAt: time.Now().Add(ImpersonationTTL).Unix(),
+            IssuedAt:  time.Now().Unix(),
+        },
+    }
+    return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(SECRET_KEY))
+}
+
 //ValidateToken validates the jwt token
 func ValidateToken(userCollection model.Collection, signedToken string) (claims *SignedDetails, err error) {
 	foundUser := new(model.User)
@@ -99,6 +121,16 @@ func ValidateToken(userCollection model.Collection, signedToken string) (claims
 		return
 	}
 
+	if tokenRevoked(foundUser, claims.IssuedAt) {
+		err = ErrTokenRevoked
+		return
+	}
+
+	if foundUser.IsSuspended() {
+		err = ErrAccountSuspended
+		return
+	}
+
 	return claims, err
 }
 
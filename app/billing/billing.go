@@ -0,0 +1,533 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/app/plans"
+	"github.com/CoffeeHausGames/whir-server/app/server/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// billing sells the paid plans of the plan catalog through a payment provider.
+//
+// A business subscribes through a checkout page of the provider. The provider then sends events to the billing
+// webhook for every change to the subscription and its invoices, these are the only way subscriptions change here.
+// Events can arrive more than once and out of order: each event is handled once and an event older than what
+// is stored is ignored. The plan of the business follows its newest subscription that is paid for,
+// a subscription with a failed payment keeps its plan for a grace period before the business is moved to the default plan.
+
+const (
+	// defaultGraceDays is how long a past due subscription keeps its plan when BILLING_GRACE_DAYS is not set
+	defaultGraceDays = 7
+	// invoicesLimit is the most invoices returned at once
+	invoicesLimit = 100
+)
+
+var (
+	// ErrInvalidEvent is returned for webhook requests with a body that is not an event
+	ErrInvalidEvent = errors.New("invalid billing event")
+	// ErrDefaultPlan is returned when a business tries to subscribe to the plan every business gets for free
+	ErrDefaultPlan = errors.New("the default plan needs no subscription, cancel the subscription instead")
+	// ErrAlreadySubscribed is returned for a checkout by a business that already pays for a plan
+	ErrAlreadySubscribed = errors.New("the business already has a subscription, change its plan instead")
+	// ErrNoSubscription is returned when a business without a subscription changes or cancels it
+	ErrNoSubscription = errors.New("the business has no subscription")
+	// ErrSamePlan is returned when a subscription is changed to the plan it is on
+	ErrSamePlan = errors.New("the subscription is already on this plan")
+)
+
+// Config is how billing behaves
+type Config struct {
+	GracePeriod time.Duration // how long a past due subscription keeps its plan
+	SuccessURL  string        // where the checkout sends the business after paying
+	CancelURL   string        // where the checkout sends the business when it gives up
+}
+
+// ConfigFromEnv returns the billing config from BILLING_GRACE_DAYS, BILLING_SUCCESS_URL and BILLING_CANCEL_URL
+func ConfigFromEnv() (Config, error) {
+	days := defaultGraceDays
+	if value := os.Getenv("BILLING_GRACE_DAYS"); value != "" {
+		var err error
+		days, err = strconv.Atoi(value)
+		if err != nil || days < 0 {
+			return Config{}, errors.New("BILLING_GRACE_DAYS must be a number of days")
+		}
+	}
+	config := Config{
+		GracePeriod: time.Duration(days) * 24 * time.Hour,
+		SuccessURL:  os.Getenv("BILLING_SUCCESS_URL"),
+		CancelURL:   os.Getenv("BILLING_CANCEL_URL"),
+	}
+	if config.SuccessURL == "" {
+		config.SuccessURL = "http://localhost:3000/billing?checkout=success"
+	}
+	if config.CancelURL == "" {
+		config.CancelURL = "http://localhost:3000/billing?checkout=canceled"
+	}
+	return config, nil
+}
+
+// Service keeps the subscriptions of businesses in sync with the payment provider
+type Service struct {
+	db            *database.Database
+	provider      Provider
+	catalog       *plans.Catalog
+	config        Config
+	customers     model.Collection
+	subscriptions model.Collection
+	invoices      model.Collection
+	events        model.Collection
+	businesses    model.Collection
+	audit         model.Collection
+}
+
+// NewService returns a [Service] billing through provider for the plans in catalog.
+// The events of a [Fake] provider are handled right away
+func NewService(db *database.Database, provider Provider, catalog *plans.Catalog, config Config) *Service {
+	s := &Service{
+		db:            db,
+		provider:      provider,
+		catalog:       catalog,
+		config:        config,
+		customers:     db.GetBillingCustomers(),
+		subscriptions: db.GetSubscriptions(),
+		invoices:      db.GetInvoices(),
+		events:        db.GetBillingEvents(),
+		businesses:    db.GetBusinesses(),
+		audit:         db.GetAuditLog(),
+	}
+	if fake, ok := provider.(*Fake); ok {
+		fake.Deliver = s.HandleWebhook
+	}
+	return s
+}
+
+// Current returns the newest subscription of the business, nil when it never subscribed
+func (s *Service) Current(ctx context.Context, businessID primitive.ObjectID) (*model.Subscription, error) {
+	subscription := new(model.Subscription)
+	opts := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	err := s.subscriptions.FindOne(subscription, ctx, bson.M{"business_id": businessID}, opts)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return subscription, nil
+}
+
+// Invoices returns the newest invoices of the business
+func (s *Service) Invoices(ctx context.Context, businessID primitive.ObjectID) ([]*model.Invoice, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(invoicesLimit)
+	cursor, err := s.invoices.Find(ctx, bson.M{"business_id": businessID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	invoices := make([]*model.Invoice, 0)
+	if err := cursor.All(ctx, &invoices); err != nil {
+		return nil, err
+	}
+	return invoices, nil
+}
+
+// Checkout returns the URL where the business pays for a subscription to plan
+func (s *Service) Checkout(ctx context.Context, business *model.BusinessUser, plan string) (string, error) {
+	if err := s.checkPlan(plan); err != nil {
+		return "", err
+	}
+	current, err := s.entitled(ctx, business.ID, time.Now().UTC())
+	if err != nil {
+		return "", err
+	}
+	if current != nil {
+		return "", ErrAlreadySubscribed
+	}
+	customerID, err := s.customer(ctx, business)
+	if err != nil {
+		return "", err
+	}
+	return s.provider.CreateCheckout(ctx, customerID, plan, s.config.SuccessURL, s.config.CancelURL)
+}
+
+// ChangePlan moves the subscription of the business to plan, the plan of the business changes once the provider confirms it
+func (s *Service) ChangePlan(ctx context.Context, business *model.BusinessUser, plan string) error {
+	if err := s.checkPlan(plan); err != nil {
+		return err
+	}
+	current, err := s.entitled(ctx, business.ID, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return ErrNoSubscription
+	}
+	if current.Plan == plan {
+		return ErrSamePlan
+	}
+	return s.provider.ChangePlan(ctx, current.Provider_id, plan)
+}
+
+// Cancel ends the subscription of the business at the end of the period it paid for
+func (s *Service) Cancel(ctx context.Context, business *model.BusinessUser) error {
+	current, err := s.entitled(ctx, business.ID, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return ErrNoSubscription
+	}
+	return s.provider.CancelSubscription(ctx, current.Provider_id)
+}
+
+// HandleWebhook checks and handles a webhook request of the provider,
+// it returns [ErrInvalidSignature] or [ErrInvalidEvent] for requests that should be refused
+func (s *Service) HandleWebhook(ctx context.Context, payload []byte, header http.Header) error {
+	event, err := s.provider.ParseEvent(payload, header)
+	if errors.Is(err, ErrInvalidSignature) {
+		return err
+	}
+	if err != nil || event.ID == "" {
+		return fmt.Errorf("%w: %v", ErrInvalidEvent, err)
+	}
+	return s.HandleEvent(ctx, event)
+}
+
+// HandleEvent applies a provider event once, an event that was handled before is ignored
+func (s *Service) HandleEvent(ctx context.Context, event *Event) error {
+	key := s.provider.Name() + ":" + event.ID
+	return s.db.WithTransaction(ctx, func(ctx context.Context) error {
+		err := s.events.FindOne(new(model.BillingEvent), ctx, bson.M{"key": key})
+		if err == nil {
+			return nil
+		}
+		if err != mongo.ErrNoDocuments {
+			return err
+		}
+		if err := s.apply(ctx, event); err != nil {
+			return err
+		}
+		_, err = s.events.InsertOne(ctx, &model.BillingEvent{Key: key, Type: event.Type, Processed_at: time.Now().UTC()})
+		return err
+	})
+}
+
+// ExpireGracePeriods moves businesses whose past due subscription ran out of grace to the plan they still pay for,
+// usually the default plan, and returns how many subscriptions ran out
+func (s *Service) ExpireGracePeriods(ctx context.Context, now time.Time) (int, error) {
+	cursor, err := s.subscriptions.Find(ctx, bson.M{
+		"status":        bson.M{"$in": bson.A{model.SubscriptionPastDue, model.SubscriptionUnpaid}},
+		"grace_until":   bson.M{"$lte": now},
+		"downgraded_at": bson.M{"$exists": false},
+	})
+	if err != nil {
+		return 0, err
+	}
+	var lapsed []*model.Subscription
+	if err := cursor.All(ctx, &lapsed); err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, subscription := range lapsed {
+		downgraded := false
+		err := s.db.WithTransaction(ctx, func(ctx context.Context) error {
+			result, err := s.subscriptions.UpdateOne(ctx,
+				bson.M{"_id": subscription.ID, "downgraded_at": bson.M{"$exists": false}},
+				bson.M{"$set": bson.M{"downgraded_at": now}},
+			)
+			// an event may have paid the subscription in the meantime
+			if err != nil || result.ModifiedCount == 0 {
+				return err
+			}
+			downgraded = true
+			return s.syncPlan(ctx, subscription.Business_id, subscription.Provider_id, "grace period ended")
+		})
+		if err != nil {
+			return expired, err
+		}
+		if downgraded {
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+// apply stores what the event changed and updates the plan of the business
+func (s *Service) apply(ctx context.Context, event *Event) error {
+	customer := new(model.BillingCustomer)
+	err := s.customers.FindOne(customer, ctx, bson.M{"provider": s.provider.Name(), "provider_id": event.Customer})
+	if err == mongo.ErrNoDocuments {
+		log.Printf("Billing event %s is for %q which is not a customer of a business\n", event.ID, event.Customer)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch event.Type {
+	case EventSubscriptionUpdated, EventSubscriptionDeleted:
+		if event.Subscription == nil {
+			return fmt.Errorf("%w: %s without a subscription", ErrInvalidEvent, event.Type)
+		}
+		data := *event.Subscription
+		if event.Type == EventSubscriptionDeleted {
+			data.Status = model.SubscriptionCanceled
+		}
+		applied, err := s.saveSubscription(ctx, customer.Business_id, event.Created, &data)
+		if err != nil || !applied {
+			return err
+		}
+		return s.syncPlan(ctx, customer.Business_id, data.ID, "subscription "+data.Status)
+
+	case EventInvoiceUpdated, EventInvoicePaid, EventInvoicePaymentFailed:
+		if event.Invoice == nil {
+			return fmt.Errorf("%w: %s without an invoice", ErrInvalidEvent, event.Type)
+		}
+		if err := s.saveInvoice(ctx, customer.Business_id, event.Created, event.Invoice); err != nil {
+			return err
+		}
+		if event.Invoice.Subscription == "" {
+			return nil
+		}
+		if event.Type == EventInvoicePaid {
+			// a retried payment went through, the next failure gets a new grace period
+			_, err := s.subscriptions.UpdateOne(ctx,
+				bson.M{"provider_id": event.Invoice.Subscription, "status": bson.M{"$in": bson.A{model.SubscriptionActive, model.SubscriptionTrialing}}},
+				bson.M{"$unset": bson.M{"grace_until": "", "downgraded_at": ""}},
+			)
+			return err
+		}
+		if event.Type == EventInvoicePaymentFailed {
+			// the subscription event that makes it past due can come later, the grace period starts with the failure
+			_, err := s.subscriptions.UpdateOne(ctx,
+				bson.M{"provider_id": event.Invoice.Subscription, "grace_until": bson.M{"$exists": false}},
+				bson.M{"$set": bson.M{"grace_until": event.Created.Add(s.config.GracePeriod)}},
+			)
+			return err
+		}
+	}
+	return nil
+}
+
+// saveSubscription stores the subscription as of eventAt, it returns false when a newer event was already stored
+func (s *Service) saveSubscription(ctx context.Context, businessID primitive.ObjectID, eventAt time.Time, data *SubscriptionData) (bool, error) {
+	now := time.Now().UTC()
+	pastDue := data.Status == model.SubscriptionPastDue || data.Status == model.SubscriptionUnpaid
+
+	existing := new(model.Subscription)
+	err := s.subscriptions.FindOne(existing, ctx, bson.M{"provider_id": data.ID})
+	if err == mongo.ErrNoDocuments {
+		subscription := &model.Subscription{
+			Business_id:          businessID,
+			Provider_id:          data.ID,
+			Plan:                 data.Plan,
+			Status:               data.Status,
+			Current_period_end:   optionalTime(data.Current_period_end),
+			Cancel_at_period_end: data.Cancel_at_period_end,
+			Event_at:             eventAt,
+			Created_at:           data.Created,
+			Updated_at:           now,
+		}
+		if subscription.Created_at.IsZero() {
+			subscription.Created_at = now
+		}
+		if pastDue {
+			graceUntil := eventAt.Add(s.config.GracePeriod)
+			subscription.Grace_until = &graceUntil
+		}
+		_, err = s.subscriptions.InsertOne(ctx, subscription)
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+	if existing.Event_at.After(eventAt) {
+		log.Printf("Ignoring an old %s event for subscription %s\n", data.Status, data.ID)
+		return false, nil
+	}
+
+	set := bson.M{
+		"plan":                 data.Plan,
+		"status":               data.Status,
+		"current_period_end":   optionalTime(data.Current_period_end),
+		"cancel_at_period_end": data.Cancel_at_period_end,
+		"event_at":             eventAt,
+		"updated_at":           now,
+	}
+	update := bson.M{"$set": set}
+	if pastDue && existing.Grace_until == nil {
+		set["grace_until"] = eventAt.Add(s.config.GracePeriod)
+	}
+	if data.Status == model.SubscriptionActive || data.Status == model.SubscriptionTrialing {
+		update["$unset"] = bson.M{"grace_until": "", "downgraded_at": ""}
+	}
+	result, err := s.subscriptions.UpdateOne(ctx, bson.M{"_id": existing.ID, "event_at": bson.M{"$lte": eventAt}}, update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// saveInvoice stores the invoice as of eventAt unless a newer event was already stored
+func (s *Service) saveInvoice(ctx context.Context, businessID primitive.ObjectID, eventAt time.Time, data *InvoiceData) error {
+	now := time.Now().UTC()
+	existing := new(model.Invoice)
+	err := s.invoices.FindOne(existing, ctx, bson.M{"provider_id": data.ID})
+	if err == mongo.ErrNoDocuments {
+		invoice := &model.Invoice{
+			Business_id:     businessID,
+			Provider_id:     data.ID,
+			Subscription_id: data.Subscription,
+			Status:          data.Status,
+			Currency:        data.Currency,
+			Amount_due:      data.Amount_due,
+			Amount_paid:     data.Amount_paid,
+			Hosted_url:      data.Hosted_url,
+			Period_start:    optionalTime(data.Period_start),
+			Period_end:      optionalTime(data.Period_end),
+			Event_at:        eventAt,
+			Created_at:      data.Created,
+			Updated_at:      now,
+		}
+		if invoice.Created_at.IsZero() {
+			invoice.Created_at = now
+		}
+		_, err = s.invoices.InsertOne(ctx, invoice)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if existing.Event_at.After(eventAt) {
+		log.Printf("Ignoring an old %s event for invoice %s\n", data.Status, data.ID)
+		return nil
+	}
+	_, err = s.invoices.UpdateOne(ctx, bson.M{"_id": existing.ID, "event_at": bson.M{"$lte": eventAt}}, bson.M{"$set": bson.M{
+		"status":      data.Status,
+		"amount_due":  data.Amount_due,
+		"amount_paid": data.Amount_paid,
+		"hosted_url":  data.Hosted_url,
+		"event_at":    eventAt,
+		"updated_at":  now,
+	}})
+	return err
+}
+
+// syncPlan puts the business on the plan of its newest subscription that is paid for, or the default plan,
+// and records the change in the audit log
+func (s *Service) syncPlan(ctx context.Context, businessID primitive.ObjectID, subscriptionID string, reason string) error {
+	plan := s.catalog.Default
+	entitled, err := s.entitled(ctx, businessID, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if entitled != nil {
+		plan = entitled.Plan
+	}
+
+	business := new(model.BusinessUser)
+	if err := s.businesses.FindOne(business, ctx, bson.M{"_id": businessID}); err != nil {
+		return err
+	}
+	previous := s.catalog.PlanOf(business).ID
+	if previous == plan {
+		return nil
+	}
+	if _, err := s.businesses.UpdateOne(ctx, bson.M{"_id": businessID}, bson.M{"$set": bson.M{"plan": plan}}); err != nil {
+		return err
+	}
+	_, err = s.audit.InsertOne(ctx, &model.AuditEntry{
+		ID:          primitive.NewObjectID(),
+		Action:      model.AuditPlanChanged,
+		Actor_type:  model.ActorSystem,
+		Business_id: &businessID,
+		Target_type: model.AuditTargetBusiness,
+		Target_id:   businessID,
+		Changes:     []model.FieldChange{{Field: "plan", Before: previous, After: plan}},
+		Data:        bson.M{"subscription": subscriptionID, "reason": reason},
+		Created_at:  time.Now().UTC(),
+	})
+	return err
+}
+
+// entitled returns the newest subscription of the business that gives it a plan of the catalog at now, nil when there is none
+func (s *Service) entitled(ctx context.Context, businessID primitive.ObjectID, now time.Time) (*model.Subscription, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := s.subscriptions.Find(ctx, bson.M{"business_id": businessID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var subscriptions []*model.Subscription
+	if err := cursor.All(ctx, &subscriptions); err != nil {
+		return nil, err
+	}
+	for _, subscription := range subscriptions {
+		if _, ok := s.catalog.Get(subscription.Plan); ok && subscription.Entitles(now) {
+			return subscription, nil
+		}
+	}
+	return nil, nil
+}
+
+// customer returns the provider customer of the business, it is created the first time the business checks out
+func (s *Service) customer(ctx context.Context, business *model.BusinessUser) (string, error) {
+	filter := bson.M{"business_id": business.ID, "provider": s.provider.Name()}
+	customer := new(model.BillingCustomer)
+	err := s.customers.FindOne(customer, ctx, filter)
+	if err == nil {
+		return customer.Provider_id, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return "", err
+	}
+
+	customerID, err := s.provider.CreateCustomer(ctx, business)
+	if err != nil {
+		return "", err
+	}
+	_, err = s.customers.InsertOne(ctx, &model.BillingCustomer{
+		Business_id: business.ID,
+		Provider:    s.provider.Name(),
+		Provider_id: customerID,
+		Created_at:  time.Now().UTC(),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		// another checkout of the business created its customer first
+		if err := s.customers.FindOne(customer, ctx, filter); err != nil {
+			return "", err
+		}
+		return customer.Provider_id, nil
+	}
+	return customerID, err
+}
+
+// checkPlan makes sure plan is a paid plan of the catalog
+func (s *Service) checkPlan(plan string) error {
+	if _, ok := s.catalog.Get(plan); !ok {
+		return ErrUnknownPlan
+	}
+	if plan == s.catalog.Default {
+		return ErrDefaultPlan
+	}
+	return nil
+}
+
+// optionalTime is nil for the zero time
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
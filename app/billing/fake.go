@@ -0,0 +1,212 @@
+package billing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/app/webhooks"
+)
+
+// fakeSignatureHeader carries the signature of the events of the fake provider
+const fakeSignatureHeader = "Fake-Billing-Signature"
+
+// fakePeriod is how long a fake subscription period lasts
+const fakePeriod = 30 * 24 * time.Hour
+
+// Fake is a payment provider that keeps subscriptions in memory and charges nothing, for development and tests.
+// Checkouts succeed right away and every change is sent as a signed event to Deliver,
+// which [NewService] points at [Service.HandleWebhook] so the events take the same path as real ones
+type Fake struct {
+	// Deliver receives the webhook requests of the provider, events are dropped when it is nil
+	Deliver func(ctx context.Context, payload []byte, header http.Header) error
+
+	secret        string
+	mu            sync.Mutex
+	subscriptions map[string]*SubscriptionData
+	customers     map[string]string // subscription ID to customer ID
+}
+
+// NewFake returns a [Fake] provider with a random signing secret
+func NewFake() (*Fake, error) {
+	secret, err := fakeID("whsec_fake_")
+	if err != nil {
+		return nil, err
+	}
+	return &Fake{
+		secret:        secret,
+		subscriptions: map[string]*SubscriptionData{},
+		customers:     map[string]string{},
+	}, nil
+}
+
+// Name is "fake"
+func (f *Fake) Name() string {
+	return "fake"
+}
+
+// CreateCustomer returns a new customer ID
+func (f *Fake) CreateCustomer(ctx context.Context, business *model.BusinessUser) (string, error) {
+	return fakeID("cus_fake_")
+}
+
+// CreateCheckout starts an active subscription with a paid invoice and returns successURL
+func (f *Fake) CreateCheckout(ctx context.Context, customerID string, plan string, successURL string, cancelURL string) (string, error) {
+	id, err := fakeID("sub_fake_")
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	subscription := &SubscriptionData{
+		ID:                 id,
+		Plan:               plan,
+		Status:             model.SubscriptionActive,
+		Current_period_end: now.Add(fakePeriod),
+		Created:            now,
+	}
+	f.mu.Lock()
+	f.subscriptions[id] = subscription
+	f.customers[id] = customerID
+	f.mu.Unlock()
+
+	if err := f.sendSubscription(ctx, EventSubscriptionUpdated, id); err != nil {
+		return "", err
+	}
+	if err := f.sendInvoice(ctx, id, model.InvoicePaid, EventInvoicePaid); err != nil {
+		return "", err
+	}
+	return successURL, nil
+}
+
+// ChangePlan moves the subscription to plan
+func (f *Fake) ChangePlan(ctx context.Context, subscriptionID string, plan string) error {
+	if err := f.update(subscriptionID, func(s *SubscriptionData) { s.Plan = plan }); err != nil {
+		return err
+	}
+	return f.sendSubscription(ctx, EventSubscriptionUpdated, subscriptionID)
+}
+
+// CancelSubscription marks the subscription to end with its period, see [Fake.EndSubscription]
+func (f *Fake) CancelSubscription(ctx context.Context, subscriptionID string) error {
+	if err := f.update(subscriptionID, func(s *SubscriptionData) { s.Cancel_at_period_end = true }); err != nil {
+		return err
+	}
+	return f.sendSubscription(ctx, EventSubscriptionUpdated, subscriptionID)
+}
+
+// FailPayment fails the renewal of the subscription, it becomes past due
+func (f *Fake) FailPayment(ctx context.Context, subscriptionID string) error {
+	if err := f.update(subscriptionID, func(s *SubscriptionData) { s.Status = model.SubscriptionPastDue }); err != nil {
+		return err
+	}
+	if err := f.sendInvoice(ctx, subscriptionID, model.InvoiceOpen, EventInvoicePaymentFailed); err != nil {
+		return err
+	}
+	return f.sendSubscription(ctx, EventSubscriptionUpdated, subscriptionID)
+}
+
+// EndSubscription ends the subscription right away
+func (f *Fake) EndSubscription(ctx context.Context, subscriptionID string) error {
+	if err := f.update(subscriptionID, func(s *SubscriptionData) { s.Status = model.SubscriptionCanceled }); err != nil {
+		return err
+	}
+	return f.sendSubscription(ctx, EventSubscriptionDeleted, subscriptionID)
+}
+
+// ParseEvent checks the signature the fake provider added and returns the event
+func (f *Fake) ParseEvent(payload []byte, header http.Header) (*Event, error) {
+	if err := verifySignature(header.Get(fakeSignatureHeader), f.secret, payload, time.Now()); err != nil {
+		return nil, err
+	}
+	event := new(Event)
+	if err := json.Unmarshal(payload, event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// update changes a subscription of the provider
+func (f *Fake) update(subscriptionID string, change func(s *SubscriptionData)) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	subscription, ok := f.subscriptions[subscriptionID]
+	if !ok {
+		return errors.New("no such subscription " + subscriptionID)
+	}
+	change(subscription)
+	return nil
+}
+
+// sendSubscription sends an event with the subscription as it is now
+func (f *Fake) sendSubscription(ctx context.Context, eventType string, subscriptionID string) error {
+	f.mu.Lock()
+	subscription := *f.subscriptions[subscriptionID]
+	customerID := f.customers[subscriptionID]
+	f.mu.Unlock()
+	return f.send(ctx, &Event{Type: eventType, Customer: customerID, Subscription: &subscription})
+}
+
+// sendInvoice sends an event with a new invoice for the current period of the subscription
+func (f *Fake) sendInvoice(ctx context.Context, subscriptionID string, status string, eventType string) error {
+	id, err := fakeID("in_fake_")
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	subscription := *f.subscriptions[subscriptionID]
+	customerID := f.customers[subscriptionID]
+	f.mu.Unlock()
+
+	invoice := &InvoiceData{
+		ID:           id,
+		Subscription: subscriptionID,
+		Status:       status,
+		Currency:     "usd",
+		Amount_due:   1000,
+		Hosted_url:   "https://billing.example.com/invoices/" + id,
+		Period_start: subscription.Current_period_end.Add(-fakePeriod),
+		Period_end:   subscription.Current_period_end,
+		Created:      time.Now().UTC(),
+	}
+	if status == model.InvoicePaid {
+		invoice.Amount_paid = invoice.Amount_due
+	}
+	return f.send(ctx, &Event{Type: eventType, Customer: customerID, Invoice: invoice})
+}
+
+// send signs the event and hands it to Deliver
+func (f *Fake) send(ctx context.Context, event *Event) error {
+	id, err := fakeID("evt_fake_")
+	if err != nil {
+		return err
+	}
+	event.ID = id
+	event.Created = time.Now().UTC()
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if f.Deliver == nil {
+		log.Println("Fake billing event " + event.Type + " was dropped, nothing receives it")
+		return nil
+	}
+	header := http.Header{}
+	header.Set(fakeSignatureHeader, webhooks.Sign(f.secret, event.Created, payload))
+	return f.Deliver(ctx, payload, header)
+}
+
+// fakeID returns a random ID with prefix
+func fakeID(prefix string) (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(b), nil
+}
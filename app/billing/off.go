@@ -0,0 +1,41 @@
+package billing
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+)
+
+// Off is the payment provider while billing is off, no paid plan can be bought and no event is accepted
+type Off struct{}
+
+// Name is "off"
+func (Off) Name() string {
+	return "off"
+}
+
+// CreateCustomer returns [ErrBillingOff]
+func (Off) CreateCustomer(ctx context.Context, business *model.BusinessUser) (string, error) {
+	return "", ErrBillingOff
+}
+
+// CreateCheckout returns [ErrBillingOff]
+func (Off) CreateCheckout(ctx context.Context, customerID string, plan string, successURL string, cancelURL string) (string, error) {
+	return "", ErrBillingOff
+}
+
+// ChangePlan returns [ErrBillingOff]
+func (Off) ChangePlan(ctx context.Context, subscriptionID string, plan string) error {
+	return ErrBillingOff
+}
+
+// CancelSubscription returns [ErrBillingOff]
+func (Off) CancelSubscription(ctx context.Context, subscriptionID string) error {
+	return ErrBillingOff
+}
+
+// ParseEvent refuses every event with [ErrInvalidSignature], nothing can sign them
+func (Off) ParseEvent(payload []byte, header http.Header) (*Event, error) {
+	return nil, ErrInvalidSignature
+}
@@ -0,0 +1,173 @@
+package billing
+
+import (
+	"context"
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/app/webhooks"
+)
+
+// Provider is a payment provider that charges businesses for their subscriptions.
+// Changes to subscriptions and invoices are not returned by the calls,
+// they come back as events to the billing webhook and are applied by [Service.HandleEvent]
+type Provider interface {
+	// Name is stored with the customers of the provider
+	Name() string
+	// CreateCustomer creates the customer that pays for the subscriptions of the business and returns its ID
+	CreateCustomer(ctx context.Context, business *model.BusinessUser) (string, error)
+	// CreateCheckout starts a subscription of the customer to plan and returns the URL where it is paid for
+	CreateCheckout(ctx context.Context, customerID string, plan string, successURL string, cancelURL string) (string, error)
+	// ChangePlan moves a subscription to another plan
+	ChangePlan(ctx context.Context, subscriptionID string, plan string) error
+	// CancelSubscription ends a subscription at the end of the period that was paid for
+	CancelSubscription(ctx context.Context, subscriptionID string) error
+	// ParseEvent checks the signature of a webhook request and returns the event in it
+	ParseEvent(payload []byte, header http.Header) (*Event, error)
+}
+
+// Types of provider events, events of other types are acknowledged and ignored
+const (
+	EventSubscriptionUpdated  = "subscription.updated" // created or changed
+	EventSubscriptionDeleted  = "subscription.deleted"
+	EventInvoiceUpdated       = "invoice.updated"
+	EventInvoicePaid          = "invoice.paid"
+	EventInvoicePaymentFailed = "invoice.payment_failed"
+)
+
+// signatureTolerance is how old the timestamp of a signed event can be
+const signatureTolerance = 5 * time.Minute
+
+var (
+	// ErrInvalidSignature is returned for webhook requests that were not signed by the provider
+	ErrInvalidSignature = errors.New("invalid billing webhook signature")
+	// ErrUnknownPlan is returned for plans that can not be subscribed to
+	ErrUnknownPlan = errors.New("the plan can not be subscribed to")
+	// ErrBillingOff is returned when a business subscribes while billing is off
+	ErrBillingOff = errors.New("paid plans can not be bought right now")
+)
+
+// Event is a change at the payment provider
+type Event struct {
+	ID           string            `json:"id"`
+	Type         string            `json:"type"`
+	Created      time.Time         `json:"created"`
+	Customer     string            `json:"customer"`
+	Subscription *SubscriptionData `json:"subscription,omitempty"`
+	Invoice      *InvoiceData      `json:"invoice,omitempty"`
+}
+
+// SubscriptionData is a subscription as the provider sees it
+type SubscriptionData struct {
+	ID                   string    `json:"id"`
+	Plan                 string    `json:"plan"` // empty when the provider bills something that is not a plan of the catalog
+	Status               string    `json:"status"`
+	Current_period_end   time.Time `json:"current_period_end"`
+	Cancel_at_period_end bool      `json:"cancel_at_period_end"`
+	Created              time.Time `json:"created"`
+}
+
+// InvoiceData is an invoice as the provider sees it
+type InvoiceData struct {
+	ID           string    `json:"id"`
+	Subscription string    `json:"subscription"`
+	Status       string    `json:"status"`
+	Currency     string    `json:"currency"`
+	Amount_due   int64     `json:"amount_due"`
+	Amount_paid  int64     `json:"amount_paid"`
+	Hosted_url   string    `json:"hosted_url"`
+	Period_start time.Time `json:"period_start"`
+	Period_end   time.Time `json:"period_end"`
+	Created      time.Time `json:"created"`
+}
+
+// NewProviderFromEnv returns the payment provider configured by the environment
+//
+//   - BILLING_DRIVER - has to be set: `stripe` uses Stripe, `off` sells no paid plans and `fake` keeps subscriptions
+//     in memory and sends its events to the server itself. Every checkout of the fake provider succeeds without paying
+//     so it is refused unless BILLING_ALLOW_FAKE=true, only set that for development and tests
+//   - Stripe: STRIPE_SECRET_KEY, STRIPE_WEBHOOK_SECRET and STRIPE_PRICES, the price of each paid plan, e.g. `pro:price_123,enterprise:price_456`
+func NewProviderFromEnv() (Provider, error) {
+	driver := strings.ToLower(os.Getenv("BILLING_DRIVER"))
+	switch driver {
+	case "":
+		return nil, errors.New("BILLING_DRIVER must be set to stripe, off or fake")
+	case "off":
+		log.Println("Billing is off, paid plans can not be bought")
+		return Off{}, nil
+	case "fake":
+		if os.Getenv("BILLING_ALLOW_FAKE") != "true" {
+			return nil, errors.New("the fake BILLING_DRIVER gives paid plans away, it needs BILLING_ALLOW_FAKE=true")
+		}
+		log.Println("Billing uses the fake payment provider, nothing is charged")
+		return NewFake()
+	case "stripe":
+		prices, err := parsePrices(os.Getenv("STRIPE_PRICES"))
+		if err != nil {
+			return nil, err
+		}
+		return NewStripe(StripeConfig{
+			SecretKey:     os.Getenv("STRIPE_SECRET_KEY"),
+			WebhookSecret: os.Getenv("STRIPE_WEBHOOK_SECRET"),
+			Prices:        prices,
+		})
+	}
+	return nil, errors.New("unknown BILLING_DRIVER " + driver)
+}
+
+// parsePrices reads the plan:price pairs of STRIPE_PRICES
+func parsePrices(value string) (map[string]string, error) {
+	prices := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		plan, price, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || plan == "" || price == "" {
+			return nil, fmt.Errorf("STRIPE_PRICES: %q is not plan:price", pair)
+		}
+		prices[plan] = price
+	}
+	return prices, nil
+}
+
+// verifySignature checks a `t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<payload>">` signature header,
+// any of several v1 signatures can match so the secret can be rolled
+func verifySignature(header string, secret string, payload []byte, now time.Time) error {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return ErrInvalidSignature
+	}
+	signedAt := time.Unix(seconds, 0)
+	if now.Sub(signedAt) > signatureTolerance || signedAt.Sub(now) > signatureTolerance {
+		return ErrInvalidSignature
+	}
+
+	expected := webhooks.Sign(secret, signedAt, payload)
+	_, expected, _ = strings.Cut(expected, ",v1=")
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(signature), []byte(expected)) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
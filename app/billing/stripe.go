@@ -0,0 +1,260 @@
+package billing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+)
+
+// stripeAPI is the base URL of the Stripe API
+const stripeAPI = "https://api.stripe.com/v1"
+
+// StripeConfig is the configuration of the Stripe account subscriptions are billed with
+type StripeConfig struct {
+	SecretKey     string
+	WebhookSecret string            // the signing secret of the webhook endpoint
+	Prices        map[string]string // the Stripe price of each paid plan
+}
+
+// Stripe bills subscriptions with Stripe Billing and Checkout
+type Stripe struct {
+	config StripeConfig
+	plans  map[string]string // price to plan
+	client *http.Client
+}
+
+// NewStripe returns a [Stripe] provider
+func NewStripe(config StripeConfig) (*Stripe, error) {
+	if config.SecretKey == "" || config.WebhookSecret == "" {
+		return nil, errors.New("Stripe needs STRIPE_SECRET_KEY and STRIPE_WEBHOOK_SECRET")
+	}
+	if len(config.Prices) == 0 {
+		return nil, errors.New("Stripe needs STRIPE_PRICES to know what to charge for each plan")
+	}
+	plans := map[string]string{}
+	for plan, price := range config.Prices {
+		plans[price] = plan
+	}
+	return &Stripe{
+		config: config,
+		plans:  plans,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Name is "stripe"
+func (s *Stripe) Name() string {
+	return "stripe"
+}
+
+// CreateCustomer creates a Stripe customer for the business
+func (s *Stripe) CreateCustomer(ctx context.Context, business *model.BusinessUser) (string, error) {
+	form := url.Values{"metadata[business_id]": {business.ID.Hex()}}
+	if business.Email != nil {
+		form.Set("email", *business.Email)
+	}
+	if business.Business_name != nil {
+		form.Set("name", *business.Business_name)
+	}
+	var customer struct {
+		ID string `json:"id"`
+	}
+	if err := s.do(ctx, http.MethodPost, "/customers", form, &customer); err != nil {
+		return "", err
+	}
+	return customer.ID, nil
+}
+
+// CreateCheckout creates a Checkout session for a subscription to the price of plan
+func (s *Stripe) CreateCheckout(ctx context.Context, customerID string, plan string, successURL string, cancelURL string) (string, error) {
+	price, ok := s.config.Prices[plan]
+	if !ok {
+		return "", ErrUnknownPlan
+	}
+	form := url.Values{
+		"mode":                    {"subscription"},
+		"customer":                {customerID},
+		"line_items[0][price]":    {price},
+		"line_items[0][quantity]": {"1"},
+		"success_url":             {successURL},
+		"cancel_url":              {cancelURL},
+	}
+	var session struct {
+		Url string `json:"url"`
+	}
+	if err := s.do(ctx, http.MethodPost, "/checkout/sessions", form, &session); err != nil {
+		return "", err
+	}
+	return session.Url, nil
+}
+
+// ChangePlan swaps the price of the subscription, the difference is prorated
+func (s *Stripe) ChangePlan(ctx context.Context, subscriptionID string, plan string) error {
+	price, ok := s.config.Prices[plan]
+	if !ok {
+		return ErrUnknownPlan
+	}
+	var subscription stripeSubscription
+	if err := s.do(ctx, http.MethodGet, "/subscriptions/"+url.PathEscape(subscriptionID), nil, &subscription); err != nil {
+		return err
+	}
+	if len(subscription.Items.Data) == 0 {
+		return errors.New("the Stripe subscription has no items")
+	}
+	form := url.Values{
+		"items[0][id]":       {subscription.Items.Data[0].ID},
+		"items[0][price]":    {price},
+		"proration_behavior": {"create_prorations"},
+	}
+	return s.do(ctx, http.MethodPost, "/subscriptions/"+url.PathEscape(subscriptionID), form, nil)
+}
+
+// CancelSubscription cancels the subscription at the end of its period
+func (s *Stripe) CancelSubscription(ctx context.Context, subscriptionID string) error {
+	form := url.Values{"cancel_at_period_end": {"true"}}
+	return s.do(ctx, http.MethodPost, "/subscriptions/"+url.PathEscape(subscriptionID), form, nil)
+}
+
+// ParseEvent checks the Stripe-Signature header and turns a Stripe event into an [Event]
+func (s *Stripe) ParseEvent(payload []byte, header http.Header) (*Event, error) {
+	if err := verifySignature(header.Get("Stripe-Signature"), s.config.WebhookSecret, payload, time.Now()); err != nil {
+		return nil, err
+	}
+	var raw struct {
+		ID      string `json:"id"`
+		Type    string `json:"type"`
+		Created int64  `json:"created"`
+		Data    struct {
+			Object json.RawMessage `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return nil, err
+	}
+	event := &Event{ID: raw.ID, Created: unixTime(raw.Created)}
+
+	switch raw.Type {
+	case "customer.subscription.created", "customer.subscription.updated", "customer.subscription.deleted":
+		var subscription stripeSubscription
+		if err := json.Unmarshal(raw.Data.Object, &subscription); err != nil {
+			return nil, err
+		}
+		event.Type = EventSubscriptionUpdated
+		if raw.Type == "customer.subscription.deleted" {
+			event.Type = EventSubscriptionDeleted
+		}
+		event.Customer = subscription.Customer
+		event.Subscription = &SubscriptionData{
+			ID:                   subscription.ID,
+			Status:               subscription.Status,
+			Current_period_end:   unixTime(subscription.Current_period_end),
+			Cancel_at_period_end: subscription.Cancel_at_period_end,
+			Created:              unixTime(subscription.Created),
+		}
+		if len(subscription.Items.Data) > 0 {
+			event.Subscription.Plan = s.plans[subscription.Items.Data[0].Price.ID]
+		}
+	case "invoice.paid", "invoice.payment_failed", "invoice.finalized", "invoice.updated", "invoice.voided", "invoice.marked_uncollectible":
+		var invoice struct {
+			ID                 string `json:"id"`
+			Customer           string `json:"customer"`
+			Subscription       string `json:"subscription"`
+			Status             string `json:"status"`
+			Currency           string `json:"currency"`
+			Amount_due         int64  `json:"amount_due"`
+			Amount_paid        int64  `json:"amount_paid"`
+			Hosted_invoice_url string `json:"hosted_invoice_url"`
+			Period_start       int64  `json:"period_start"`
+			Period_end         int64  `json:"period_end"`
+			Created            int64  `json:"created"`
+		}
+		if err := json.Unmarshal(raw.Data.Object, &invoice); err != nil {
+			return nil, err
+		}
+		switch raw.Type {
+		case "invoice.paid":
+			event.Type = EventInvoicePaid
+		case "invoice.payment_failed":
+			event.Type = EventInvoicePaymentFailed
+		default:
+			event.Type = EventInvoiceUpdated
+		}
+		event.Customer = invoice.Customer
+		event.Invoice = &InvoiceData{
+			ID:           invoice.ID,
+			Subscription: invoice.Subscription,
+			Status:       invoice.Status,
+			Currency:     invoice.Currency,
+			Amount_due:   invoice.Amount_due,
+			Amount_paid:  invoice.Amount_paid,
+			Hosted_url:   invoice.Hosted_invoice_url,
+			Period_start: unixTime(invoice.Period_start),
+			Period_end:   unixTime(invoice.Period_end),
+			Created:      unixTime(invoice.Created),
+		}
+	default:
+		event.Type = raw.Type
+	}
+	return event, nil
+}
+
+// stripeSubscription is the part of a Stripe subscription the server uses
+type stripeSubscription struct {
+	ID                   string `json:"id"`
+	Customer             string `json:"customer"`
+	Status               string `json:"status"`
+	Current_period_end   int64  `json:"current_period_end"`
+	Cancel_at_period_end bool   `json:"cancel_at_period_end"`
+	Created              int64  `json:"created"`
+	Items                struct {
+		Data []struct {
+			ID    string `json:"id"`
+			Price struct {
+				ID string `json:"id"`
+			} `json:"price"`
+		} `json:"data"`
+	} `json:"items"`
+}
+
+// do calls the Stripe API and decodes the response into out when it is not nil
+func (s *Stripe) do(ctx context.Context, method string, path string, form url.Values, out interface{}) error {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, stripeAPI+path, body)
+	if err != nil {
+		return err
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req.SetBasicAuth(s.config.SecretKey, "")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("Stripe responded with %d: %s", resp.StatusCode, message)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// unixTime is the UTC time of unix seconds
+func unixTime(seconds int64) time.Time {
+	return time.Unix(seconds, 0).UTC()
+}
@@ -0,0 +1,196 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/app/queue"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AllEvents subscribes to every event type
+const AllEvents = "*"
+
+// maxAttempts is how many times an event is dispatched before it is marked as failed
+const maxAttempts = 10
+
+// Subscriber handles one event, returning an error dispatches the event to it again later
+type Subscriber func(ctx context.Context, event *model.DomainEvent) error
+
+type subscription struct {
+	name      string
+	eventType string
+	handle    Subscriber
+}
+
+// Dispatcher takes events from the outbox and fans them out to the subscribers
+type Dispatcher struct {
+	outbox        model.Collection
+	subscriptions []subscription
+	pollInterval  time.Duration
+	lease         time.Duration
+	owner         string
+	wg            sync.WaitGroup
+}
+
+// NewDispatcher returns a [Dispatcher] reading the outbox collection
+func NewDispatcher(outbox model.Collection) *Dispatcher {
+	hostname, _ := os.Hostname()
+	return &Dispatcher{
+		outbox:       outbox,
+		pollInterval: time.Second,
+		lease:        time.Minute,
+		owner:        fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), primitive.NewObjectID().Hex()[18:]),
+	}
+}
+
+// Subscribe adds a subscriber for an event type, or [AllEvents] for every type.
+// The name must be unique, it is used to remember which subscribers already handled an event.
+// Must be called before [Dispatcher.Start]
+func (d *Dispatcher) Subscribe(name string, eventType string, subscriber Subscriber) {
+	d.subscriptions = append(d.subscriptions, subscription{name: name, eventType: eventType, handle: subscriber})
+}
+
+// Start dispatches events until ctx is cancelled
+func (d *Dispatcher) Start(ctx context.Context) {
+	d.wg.Add(1)
+	go d.run(ctx)
+	log.Printf("Event dispatcher started with %d subscribers\n", len(d.subscriptions))
+}
+
+// Wait blocks until the dispatcher stopped after ctx given to [Dispatcher.Start] was cancelled
+func (d *Dispatcher) Wait() {
+	d.wg.Wait()
+}
+
+// run dispatches events one at a time and sleeps when the outbox is empty
+func (d *Dispatcher) run(ctx context.Context) {
+	defer d.wg.Done()
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		event, err := d.next(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Println("Event dispatcher could not read the outbox: " + err.Error())
+		}
+
+		if event == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(d.pollInterval):
+			}
+			continue
+		}
+
+		d.dispatch(event)
+	}
+}
+
+// next takes the oldest event that is ready for the lease time
+// returns nil when there is nothing to do
+func (d *Dispatcher) next(ctx context.Context) (*model.DomainEvent, error) {
+	now := time.Now().UTC()
+	filter := bson.M{
+		"$or": bson.A{
+			bson.M{"status": model.EventPending, "available_at": bson.M{"$lte": now}},
+			// the dispatcher holding this event died or took too long
+			bson.M{"status": model.EventDispatching, "leased_until": bson.M{"$lt": now}},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"status":       model.EventDispatching,
+			"leased_until": now.Add(d.lease),
+			"lease_owner":  d.owner,
+		},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	event := new(model.DomainEvent)
+	err := d.outbox.FindOneAndUpdate(event, ctx, filter, update, opts)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// dispatch hands the event to every subscriber that wants it and has not handled it yet.
+// An event that is already being dispatched is allowed to finish when the server is stopping
+func (d *Dispatcher) dispatch(event *model.DomainEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.lease)
+	defer cancel()
+
+	var failures []string
+	for _, sub := range d.subscriptions {
+		if (sub.eventType != AllEvents && sub.eventType != event.Type) || event.HandledBy(sub.name) {
+			continue
+		}
+
+		if err := call(ctx, sub, event); err != nil {
+			failures = append(failures, sub.name+": "+err.Error())
+			continue
+		}
+
+		// remember the subscriber so a retry of the event does not run it again
+		_, err := d.outbox.UpdateOne(ctx,
+			bson.M{"_id": event.ID, "lease_owner": d.owner},
+			bson.M{"$addToSet": bson.M{"handled_by": sub.name}},
+		)
+		if err != nil {
+			log.Printf("Event %s (%s) handled by %s could not be recorded: %v\n", event.ID.Hex(), event.Type, sub.name, err)
+		}
+	}
+
+	now := time.Now().UTC()
+	set := bson.M{"status": model.EventDispatched, "dispatched_at": now}
+	if len(failures) > 0 {
+		set = bson.M{
+			"status":       model.EventPending,
+			"available_at": now.Add(queue.Backoff(event.Attempts)),
+			"last_error":   strings.Join(failures, "; "),
+		}
+		log.Printf("Event %s (%s) attempt %d failed: %s\n", event.ID.Hex(), event.Type, event.Attempts, set["last_error"])
+		if event.Attempts >= maxAttempts {
+			set["status"] = model.EventFailed
+		}
+	}
+
+	_, err := d.outbox.UpdateOne(ctx,
+		bson.M{"_id": event.ID, "lease_owner": d.owner},
+		bson.M{"$set": set, "$unset": bson.M{"leased_until": "", "lease_owner": ""}},
+	)
+	if err != nil {
+		log.Printf("Event %s (%s) could not be marked as %s: %v\n", event.ID.Hex(), event.Type, set["status"], err)
+	}
+}
+
+// call runs the subscriber turning a panic into an error so one bad subscriber can not stop the dispatcher
+func call(ctx context.Context, sub subscription, event *model.DomainEvent) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return sub.handle(ctx, event)
+}
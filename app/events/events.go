@@ -0,0 +1,48 @@
+package events
+
+import (
+	"context"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// events is the transactional outbox of domain events.
+//
+// A handler that changes something records an event with [Record] inside the same
+// transaction as the change (see database.WithTransaction). The [Dispatcher] reads the
+// outbox and hands every event to the in-process subscribers (webhooks, notifications, ...).
+//
+// Events are delivered at least once, a subscriber can see the same event again if the
+// server stops while it is being dispatched, so subscribers must be idempotent (the event ID helps).
+
+// Record writes an event to the outbox, ctx should be the ctx of the transaction the change is made in.
+// data must be able to be marshalled to bson
+func Record(ctx context.Context, outbox model.Collection, eventType string, businessID primitive.ObjectID, aggregateID primitive.ObjectID, data interface{}) (*model.DomainEvent, error) {
+	raw, err := bson.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	event := &model.DomainEvent{
+		ID:           primitive.NewObjectID(),
+		Type:         eventType,
+		Aggregate_id: aggregateID,
+		Business_id:  businessID,
+		Data:         raw,
+		Occurred_at:  now,
+		Status:       model.EventPending,
+		Available_at: now,
+		Handled_by:   []string{},
+	}
+
+	_, err = outbox.InsertOne(ctx, event)
+	if err != nil {
+		return nil, err
+	}
+	return event, nil
+}
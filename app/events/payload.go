@@ -0,0 +1,46 @@
+package events
+
+import (
+	"github.com/CoffeeHausGames/whir-server/app/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// payloadTypes are the types the data of each event is recorded with
+var payloadTypes = map[string]func() interface{}{
+	model.EventDealCreated:       func() interface{} { return new(model.Deal) },
+	model.EventDealUpdated:       func() interface{} { return new(model.Deal) },
+	model.EventDealStatusChanged: func() interface{} { return new(model.DealStatusChangedData) },
+	model.EventDealPinned:        func() interface{} { return new(model.DealPinData) },
+	model.EventDealUnpinned:      func() interface{} { return new(model.DealPinData) },
+	model.EventDealClaimed:       func() interface{} { return new(model.Redemption) },
+	model.EventDealRedeemed:      func() interface{} { return new(model.Redemption) },
+	model.EventReviewCreated:     func() interface{} { return new(model.Review) },
+}
+
+// Payload decodes the data of an event so it can be sent to clients as JSON.
+// Events without a type of their own (e.g. business.updated) are decoded to a bson.M
+func Payload(event *model.DomainEvent) (interface{}, error) {
+	newPayload, ok := payloadTypes[event.Type]
+	if !ok {
+		data := bson.M{}
+		err := event.DecodeData(&data)
+		return data, err
+	}
+
+	payload := newPayload()
+	if err := event.DecodeData(payload); err != nil {
+		return nil, err
+	}
+
+	// the computed fields of a deal are not stored
+	switch data := payload.(type) {
+	case *model.Deal:
+		data.ComputeFields()
+	case *model.DealStatusChangedData:
+		if data.Deal != nil {
+			data.Deal.ComputeFields()
+		}
+	}
+	return payload, nil
+}
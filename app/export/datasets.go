@@ -0,0 +1,189 @@
+package export
+
+import (
+	"strings"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/app/server/database"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// dataset is a collection that can be exported and how its documents become rows
+type dataset struct {
+	collection func(db *database.Database) model.Collection
+	// timeField is what the date range filters on, deals have no creation time so theirs is the time in the _id
+	timeField string
+	// businessField is what the rows of one business are found by
+	businessField string
+	columns       []Column
+	row           func(cursor *mongo.Cursor) ([]interface{}, error)
+}
+
+// datasets are the exportable datasets by name, only what a business would see about itself is exported.
+// Credentials and the contact details of businesses are never part of an export
+var datasets = map[string]dataset{
+	model.ExportDeals: {
+		collection:    (*database.Database).GetDeals,
+		timeField:     "_id",
+		businessField: "business_id",
+		columns: []Column{
+			{"id", TypeString}, {"business_id", TypeString}, {"name", TypeString}, {"status", TypeString},
+			{"categories", TypeString}, {"pricing_type", TypeString}, {"currency", TypeString},
+			{"original_price", TypeInt}, {"deal_price", TypeInt}, {"percent_off", TypeFloat}, {"amount_off", TypeInt},
+			{"quantity", TypeInt}, {"remaining", TypeInt}, {"start_date", TypeTime}, {"end_date", TypeTime},
+			{"published_at", TypeTime}, {"archived_at", TypeTime}, {"moderation_status", TypeString}, {"created_at", TypeTime},
+		},
+		row: func(cursor *mongo.Cursor) ([]interface{}, error) {
+			deal := new(model.Deal)
+			if err := cursor.Decode(deal); err != nil {
+				return nil, err
+			}
+			pricing := deal.Pricing
+			if pricing == nil {
+				pricing = &model.DealPricing{}
+			}
+			return []interface{}{
+				deal.ID.Hex(), deal.Business_id.Hex(), str(deal.Name), deal.CurrentStatus(),
+				orNil(strings.Join(deal.Categories, ",")), orNil(pricing.Type), orNil(pricing.Currency),
+				i64(pricing.Original_price), i64(pricing.Deal_price), f64(pricing.Percent_off), i64(pricing.Amount_off),
+				integer(deal.Quantity), integer(deal.Remaining), moment(deal.Start_date), moment(deal.End_date),
+				moment(deal.Published_at), moment(deal.Archived_at), orNil(deal.Moderation_status), deal.ID.Timestamp().UTC(),
+			}, nil
+		},
+	},
+	model.ExportBusinesses: {
+		collection:    (*database.Database).GetBusinesses,
+		timeField:     "created_at",
+		businessField: "_id",
+		columns: []Column{
+			{"id", TypeString}, {"business_name", TypeString}, {"city", TypeString}, {"state", TypeString},
+			{"postal_code", TypeString}, {"country", TypeString}, {"latitude", TypeFloat}, {"longitude", TypeFloat},
+			{"plan", TypeString}, {"verification_status", TypeString}, {"verified_at", TypeTime},
+			{"moderation_status", TypeString}, {"suspended", TypeBool}, {"rating_average", TypeFloat},
+			{"rating_count", TypeInt}, {"created_at", TypeTime},
+		},
+		row: func(cursor *mongo.Cursor) ([]interface{}, error) {
+			business := new(model.BusinessUser)
+			if err := cursor.Decode(business); err != nil {
+				return nil, err
+			}
+			address := business.Address
+			if address == nil {
+				address = &model.Address{}
+			}
+			var latitude, longitude interface{}
+			if business.Location != nil && len(business.Location.Coordinates) == 2 {
+				longitude, latitude = business.Location.Coordinates[0], business.Location.Coordinates[1]
+			}
+			var average, count interface{}
+			if rating := business.Rating.Summary(); rating != nil {
+				average, count = rating.Average, rating.Count
+			}
+			return []interface{}{
+				business.ID.Hex(), str(business.Business_name), orNil(address.City), orNil(address.State),
+				orNil(address.PostalCode), orNil(address.Country), latitude, longitude,
+				orNil(business.Plan), business.VerificationStatus(), moment(business.Verified_at),
+				orNil(business.Moderation_status), business.Suspended_at != nil, average,
+				count, business.Created_at.UTC(),
+			}, nil
+		},
+	},
+	model.ExportRedemptions: {
+		collection:    (*database.Database).GetRedemptions,
+		timeField:     "claimed_at",
+		businessField: "business_id",
+		columns: []Column{
+			{"id", TypeString}, {"business_id", TypeString}, {"deal_id", TypeString}, {"user_id", TypeString},
+			{"deal_name", TypeString}, {"status", TypeString}, {"claimed_at", TypeTime}, {"expires_at", TypeTime},
+			{"redeemed_at", TypeTime},
+		},
+		row: func(cursor *mongo.Cursor) ([]interface{}, error) {
+			redemption := new(model.Redemption)
+			if err := cursor.Decode(redemption); err != nil {
+				return nil, err
+			}
+			return []interface{}{
+				redemption.ID.Hex(), redemption.Business_id.Hex(), redemption.Deal_id.Hex(), redemption.User_id.Hex(),
+				str(redemption.Deal_name), redemption.Status, redemption.Claimed_at.UTC(), redemption.Expires_at.UTC(),
+				moment(redemption.Redeemed_at),
+			}, nil
+		},
+	},
+	model.ExportAnalyticsHourly: rollups((*database.Database).GetAnalyticsHourly),
+	model.ExportAnalyticsDaily:  rollups((*database.Database).GetAnalyticsDaily),
+}
+
+// rollups is the dataset of the analytics rollups in collection
+func rollups(collection func(db *database.Database) model.Collection) dataset {
+	return dataset{
+		collection:    collection,
+		timeField:     "start",
+		businessField: "business_id",
+		columns: []Column{
+			{"business_id", TypeString}, {"deal_id", TypeString}, {"start", TypeTime}, {"band", TypeString},
+			{"impressions", TypeInt}, {"views", TypeInt}, {"claims", TypeInt}, {"redemptions", TypeInt},
+		},
+		row: func(cursor *mongo.Cursor) ([]interface{}, error) {
+			rollup := new(model.AnalyticsRollup)
+			if err := cursor.Decode(rollup); err != nil {
+				return nil, err
+			}
+			return []interface{}{
+				rollup.Business_id.Hex(), rollup.Deal_id.Hex(), rollup.Start.UTC(), rollup.Band,
+				rollup.Impressions, rollup.Views, rollup.Claims, rollup.Redemptions,
+			}, nil
+		},
+	}
+}
+
+// IsDataset checks that name is one of the exportable datasets
+func IsDataset(name string) bool {
+	_, ok := datasets[name]
+	return ok
+}
+
+// The values of optional fields, nil when the field is not set
+
+func str(v *string) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+func orNil(v string) interface{} {
+	if v == "" {
+		return nil
+	}
+	return v
+}
+
+func i64(v *int64) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+func integer(v *int) interface{} {
+	if v == nil {
+		return nil
+	}
+	return int64(*v)
+}
+
+func f64(v *float64) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+func moment(v *time.Time) interface{} {
+	if v == nil {
+		return nil
+	}
+	return v.UTC()
+}
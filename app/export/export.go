@@ -0,0 +1,335 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/app/queue"
+	"github.com/CoffeeHausGames/whir-server/app/server/database"
+	"github.com/CoffeeHausGames/whir-server/app/storage"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// export writes datasets as CSV or Parquet files.
+//
+// A business downloads its own rows straight away, the file is streamed while it is written.
+// Admins export whole datasets in the background: the export is written to a temporary file by a task
+// and kept in storage for [Retention], where it can be downloaded through the admin API.
+
+const (
+	// RunTask writes an export to storage
+	RunTask = "run_export"
+	// KeyPrefix is where exports are kept in private storage
+	KeyPrefix = "private/exports/"
+	// Retention is how long exports are kept
+	Retention = 30 * 24 * time.Hour
+	// maxAttempts is how often writing an export is tried
+	maxAttempts = 3
+)
+
+var (
+	// ErrInvalidQuery is returned for an unknown dataset or format or a range that ends before it starts
+	ErrInvalidQuery = errors.New("invalid export")
+	// ErrExportNotFound is returned for an unknown export
+	ErrExportNotFound = errors.New("export not found")
+	// ErrNotReady is returned when an export that is not completed is downloaded
+	ErrNotReady = errors.New("the export is not completed")
+)
+
+// Query is what is exported
+type Query struct {
+	Dataset     string              // see [model.ExportDeals]
+	Format      string              // [FormatCSV] or [FormatParquet]
+	Business_id *primitive.ObjectID // only the rows of this business
+	From        *time.Time
+	To          *time.Time
+}
+
+// Validate checks the dataset and format are known and the range does not end before it starts
+func (q Query) Validate() error {
+	if !IsDataset(q.Dataset) {
+		return fmt.Errorf("%w: unknown dataset %s", ErrInvalidQuery, q.Dataset)
+	}
+	if q.Format != FormatCSV && q.Format != FormatParquet {
+		return fmt.Errorf("%w: format is csv or parquet", ErrInvalidQuery)
+	}
+	if q.From != nil && q.To != nil && !q.From.Before(*q.To) {
+		return fmt.Errorf("%w: from must be before to", ErrInvalidQuery)
+	}
+	return nil
+}
+
+// FileName is the name a file of the query is downloaded as
+func (q Query) FileName(now time.Time) string {
+	return q.Dataset + "-" + now.UTC().Format("20060102") + "." + q.Format
+}
+
+// RunPayload is the payload of a [RunTask] task
+type RunPayload struct {
+	Export_id primitive.ObjectID `bson:"export_id"`
+}
+
+// Service writes exports
+type Service struct {
+	db      *database.Database
+	store   storage.Storage
+	queue   *queue.Queue
+	exports model.Collection
+}
+
+// NewService returns a [Service] keeping the exports of admins in store, which must be private storage
+func NewService(db *database.Database, store storage.Storage, q *queue.Queue) *Service {
+	return &Service{
+		db:      db,
+		store:   store,
+		queue:   q,
+		exports: db.GetExports(),
+	}
+}
+
+// Register adds the task handler of the service to the worker pool, it needs a pool with a visibility
+// long enough to write a whole dataset
+func (s *Service) Register(pool *queue.Pool) {
+	pool.Handle(RunTask, s.run)
+}
+
+// Write writes the rows matching query to w oldest first, it returns how many rows were written
+func (s *Service) Write(ctx context.Context, w io.Writer, query Query) (int64, error) {
+	if err := query.Validate(); err != nil {
+		return 0, err
+	}
+	set := datasets[query.Dataset]
+
+	filter := bson.M{}
+	if query.Business_id != nil {
+		filter[set.businessField] = *query.Business_id
+	}
+	if r := timeRange(set.timeField, query.From, query.To); len(r) > 0 {
+		filter[set.timeField] = r
+	}
+	// a whole dataset may not fit the memory mongo sorts in
+	opts := options.Find().SetSort(bson.D{{Key: set.timeField, Value: 1}}).SetAllowDiskUse(true)
+	cursor, err := set.collection(s.db).Find(ctx, filter, opts)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	writer, err := NewWriter(query.Format, w, set.columns)
+	if err != nil {
+		return 0, err
+	}
+	var rows int64
+	for cursor.Next(ctx) {
+		row, err := set.row(cursor)
+		if err != nil {
+			return rows, err
+		}
+		if err := writer.Write(row); err != nil {
+			return rows, err
+		}
+		rows++
+	}
+	if err := cursor.Err(); err != nil {
+		return rows, err
+	}
+	return rows, writer.Close()
+}
+
+// timeRange filters field on the range, the time of an _id is the time it was created
+func timeRange(field string, from *time.Time, to *time.Time) bson.M {
+	r := bson.M{}
+	if from != nil {
+		r["$gte"] = rangeValue(field, *from)
+	}
+	if to != nil {
+		r["$lt"] = rangeValue(field, *to)
+	}
+	return r
+}
+
+func rangeValue(field string, t time.Time) interface{} {
+	if field == "_id" {
+		return primitive.NewObjectIDFromTimestamp(t)
+	}
+	return t
+}
+
+// Start adds an export of query requested by the admin and queues writing it
+func (s *Service) Start(ctx context.Context, query Query, requestedBy primitive.ObjectID) (*model.Export, error) {
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	export := &model.Export{
+		ID:           primitive.NewObjectID(),
+		Dataset:      query.Dataset,
+		Format:       query.Format,
+		Business_id:  query.Business_id,
+		From:         query.From,
+		To:           query.To,
+		Status:       model.ExportPending,
+		Requested_by: requestedBy,
+		Created_at:   now,
+		Updated_at:   now,
+	}
+	if _, err := s.exports.InsertOne(ctx, export); err != nil {
+		return nil, err
+	}
+	_, err := s.queue.Enqueue(ctx, RunTask, RunPayload{Export_id: export.ID}, queue.EnqueueOptions{
+		IdempotencyKey: RunTask + ":" + export.ID.Hex(),
+		MaxAttempts:    maxAttempts,
+	})
+	if err != nil {
+		// an export that is never written would stay pending for good
+		s.exports.DeleteOne(ctx, bson.M{"_id": export.ID})
+		return nil, err
+	}
+	return export, nil
+}
+
+// List returns the newest exports
+func (s *Service) List(ctx context.Context, limit int64) ([]*model.Export, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(limit)
+	cursor, err := s.exports.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	exports := []*model.Export{}
+	if err := cursor.All(ctx, &exports); err != nil {
+		return nil, err
+	}
+	return exports, nil
+}
+
+// Get returns the export with the ID
+func (s *Service) Get(ctx context.Context, id primitive.ObjectID) (*model.Export, error) {
+	export := new(model.Export)
+	err := s.exports.FindOne(export, ctx, bson.M{"_id": id})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrExportNotFound
+	}
+	return export, err
+}
+
+// Open opens the file of a completed export, the caller must close it
+func (s *Service) Open(ctx context.Context, export *model.Export) (io.ReadCloser, error) {
+	if export.Status != model.ExportCompleted {
+		return nil, ErrNotReady
+	}
+	return s.store.Get(ctx, export.Key)
+}
+
+// run writes the export of the task to a temporary file and stores it
+func (s *Service) run(ctx context.Context, task *model.Task) error {
+	payload := new(RunPayload)
+	if err := task.DecodePayload(payload); err != nil {
+		return err
+	}
+	export, err := s.Get(ctx, payload.Export_id)
+	if errors.Is(err, ErrExportNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if export.Status == model.ExportCompleted {
+		return nil
+	}
+	if err := s.setStatus(ctx, export.ID, bson.M{"status": model.ExportRunning}); err != nil {
+		return err
+	}
+
+	rows, size, key, err := s.storeFile(ctx, export)
+	if err != nil {
+		// the context may be used up by now
+		failCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.setStatus(failCtx, export.ID, bson.M{"status": model.ExportFailed, "error": err.Error()})
+		return err
+	}
+
+	now := time.Now().UTC()
+	return s.setStatus(ctx, export.ID, bson.M{
+		"status":       model.ExportCompleted,
+		"key":          key,
+		"rows":         rows,
+		"size":         size,
+		"error":        "",
+		"completed_at": now,
+	})
+}
+
+// storeFile writes the export to a temporary file and puts it in storage, storage needs to know the size
+// before the upload starts
+func (s *Service) storeFile(ctx context.Context, export *model.Export) (int64, int64, string, error) {
+	file, err := os.CreateTemp("", "export-*")
+	if err != nil {
+		return 0, 0, "", err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	rows, err := s.Write(ctx, file, Query{
+		Dataset:     export.Dataset,
+		Format:      export.Format,
+		Business_id: export.Business_id,
+		From:        export.From,
+		To:          export.To,
+	})
+	if err != nil {
+		return 0, 0, "", err
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, "", err
+	}
+	key := KeyPrefix + export.ID.Hex() + "." + export.Format
+	if err := s.store.Put(ctx, key, file, size, ContentType(export.Format)); err != nil {
+		return 0, 0, "", err
+	}
+	return rows, size, key, nil
+}
+
+func (s *Service) setStatus(ctx context.Context, id primitive.ObjectID, set bson.M) error {
+	set["updated_at"] = time.Now().UTC()
+	_, err := s.exports.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": set})
+	return err
+}
+
+// Expire deletes the exports created before cutoff and their files, it returns how many were deleted
+func (s *Service) Expire(ctx context.Context, cutoff time.Time) (int, error) {
+	cursor, err := s.exports.Find(ctx, bson.M{"created_at": bson.M{"$lt": cutoff}})
+	if err != nil {
+		return 0, err
+	}
+	var exports []*model.Export
+	if err := cursor.All(ctx, &exports); err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, export := range exports {
+		if export.Key != "" {
+			if err := s.store.Delete(ctx, export.Key); err != nil {
+				return deleted, err
+			}
+		}
+		if _, err := s.exports.DeleteOne(ctx, bson.M{"_id": export.ID}); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
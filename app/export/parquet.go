@@ -0,0 +1,311 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// parquet writes Apache Parquet files with the standard library only.
+//
+// The files are as simple as the format allows: a flat schema of optional columns, rows are buffered into
+// row groups of rowGroupSize rows and each column of a row group is one uncompressed PLAIN data page (v1)
+// with RLE definition levels for the nulls. Every parquet reader can read these.
+
+// rowGroupSize is how many rows are buffered before they are written as a row group
+const rowGroupSize = 10000
+
+// parquetMagic starts and ends every parquet file
+const parquetMagic = "PAR1"
+
+// Physical types, encodings and converted types of the parquet format
+const (
+	parquetBoolean   = 0
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	repetitionOptional = 1
+
+	convertedUTF8            = 0
+	convertedTimestampMillis = 9
+)
+
+// countingWriter tracks the offset in the file, column chunks are referred to by offset in the footer
+type countingWriter struct {
+	w      io.Writer
+	offset int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.offset += int64(n)
+	return n, err
+}
+
+// parquetChunk is where a column of a row group was written
+type parquetChunk struct {
+	offset int64
+	size   int64
+	values int64
+}
+
+// parquetRowGroup is a row group that was written
+type parquetRowGroup struct {
+	rows   int64
+	chunks []parquetChunk
+}
+
+// parquetWriter is the [RowWriter] of parquet files
+type parquetWriter struct {
+	w         *countingWriter
+	columns   []Column
+	values    [][]interface{} // the buffered values of each column
+	rowGroups []parquetRowGroup
+	rows      int64
+}
+
+func newParquetWriter(w io.Writer, columns []Column) (*parquetWriter, error) {
+	p := &parquetWriter{
+		w:       &countingWriter{w: w},
+		columns: columns,
+		values:  make([][]interface{}, len(columns)),
+	}
+	_, err := io.WriteString(p.w, parquetMagic)
+	return p, err
+}
+
+func (p *parquetWriter) Write(row []interface{}) error {
+	if len(row) != len(p.columns) {
+		return fmt.Errorf("row has %d values for %d columns", len(row), len(p.columns))
+	}
+	for i, value := range row {
+		if value != nil && !p.holds(p.columns[i].Type, value) {
+			return fmt.Errorf("column %s can not hold a %T", p.columns[i].Name, value)
+		}
+		p.values[i] = append(p.values[i], value)
+	}
+	if len(p.values[0]) >= rowGroupSize {
+		return p.flush()
+	}
+	return nil
+}
+
+// Close writes the buffered rows and the footer
+func (p *parquetWriter) Close() error {
+	if len(p.columns) > 0 && len(p.values[0]) > 0 {
+		if err := p.flush(); err != nil {
+			return err
+		}
+	}
+	footer := p.footer()
+	if _, err := p.w.Write(footer); err != nil {
+		return err
+	}
+	if err := binary.Write(p.w, binary.LittleEndian, uint32(len(footer))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(p.w, parquetMagic)
+	return err
+}
+
+// holds tells if value is of the Go type of columnType
+func (p *parquetWriter) holds(columnType ColumnType, value interface{}) bool {
+	switch value.(type) {
+	case string:
+		return columnType == TypeString
+	case int64:
+		return columnType == TypeInt
+	case float64:
+		return columnType == TypeFloat
+	case bool:
+		return columnType == TypeBool
+	case time.Time:
+		return columnType == TypeTime
+	}
+	return false
+}
+
+// flush writes the buffered rows as a row group
+func (p *parquetWriter) flush() error {
+	group := parquetRowGroup{rows: int64(len(p.values[0]))}
+	for i, column := range p.columns {
+		chunk, err := p.writePage(column, p.values[i])
+		if err != nil {
+			return err
+		}
+		group.chunks = append(group.chunks, chunk)
+		p.values[i] = p.values[i][:0]
+	}
+	p.rowGroups = append(p.rowGroups, group)
+	p.rows += group.rows
+	return nil
+}
+
+// writePage writes the values of a column as a data page: the length prefixed definition levels
+// (1 for a value, 0 for null) and then the values that are not null
+func (p *parquetWriter) writePage(column Column, values []interface{}) (parquetChunk, error) {
+	var body bytes.Buffer
+	levels := definitionLevels(values)
+	binary.Write(&body, binary.LittleEndian, uint32(len(levels)))
+	body.Write(levels)
+	plainValues(&body, column.Type, values)
+
+	header := &thriftWriter{}
+	header.begin()
+	header.i32(1, 0) // DATA_PAGE
+	header.i32(2, int32(body.Len()))
+	header.i32(3, int32(body.Len()))
+	header.structField(5)
+	header.i32(1, int32(len(values)))
+	header.i32(2, encodingPlain)
+	header.i32(3, encodingRLE) // definition levels
+	header.i32(4, encodingRLE) // repetition levels, there are none in a flat schema
+	header.end()
+	header.end()
+
+	chunk := parquetChunk{
+		offset: p.w.offset,
+		size:   int64(header.buf.Len() + body.Len()),
+		values: int64(len(values)),
+	}
+	if _, err := p.w.Write(header.buf.Bytes()); err != nil {
+		return chunk, err
+	}
+	_, err := p.w.Write(body.Bytes())
+	return chunk, err
+}
+
+// footer is the file metadata: the schema and where the column chunks of each row group are
+func (p *parquetWriter) footer() []byte {
+	t := &thriftWriter{}
+	t.begin()
+	t.i32(1, 1) // version
+	t.list(2, thriftStruct, len(p.columns)+1)
+	t.begin()
+	t.binary(4, "schema")
+	t.i32(5, int32(len(p.columns)))
+	t.end()
+	for _, column := range p.columns {
+		physical, converted := parquetTypes(column.Type)
+		t.begin()
+		t.i32(1, physical)
+		t.i32(3, repetitionOptional)
+		t.binary(4, column.Name)
+		if converted >= 0 {
+			t.i32(6, converted)
+		}
+		t.end()
+	}
+	t.i64(3, p.rows)
+
+	t.list(4, thriftStruct, len(p.rowGroups))
+	for _, group := range p.rowGroups {
+		t.begin()
+		t.list(1, thriftStruct, len(group.chunks))
+		var size int64
+		for i, chunk := range group.chunks {
+			physical, _ := parquetTypes(p.columns[i].Type)
+			size += chunk.size
+			t.begin()
+			t.i64(2, chunk.offset)
+			t.structField(3)
+			t.i32(1, physical)
+			t.i32List(2, encodingPlain, encodingRLE)
+			t.binaryList(3, p.columns[i].Name)
+			t.i32(4, 0) // UNCOMPRESSED
+			t.i64(5, chunk.values)
+			t.i64(6, chunk.size)
+			t.i64(7, chunk.size)
+			t.i64(9, chunk.offset)
+			t.end()
+			t.end()
+		}
+		t.i64(2, size)
+		t.i64(3, group.rows)
+		t.end()
+	}
+	t.binary(6, "whir-server")
+	t.end()
+	return t.buf.Bytes()
+}
+
+// parquetTypes is the physical and converted type of a column type, -1 when it has no converted type
+func parquetTypes(columnType ColumnType) (int32, int32) {
+	switch columnType {
+	case TypeString:
+		return parquetByteArray, convertedUTF8
+	case TypeInt:
+		return parquetInt64, -1
+	case TypeFloat:
+		return parquetDouble, -1
+	case TypeBool:
+		return parquetBoolean, -1
+	}
+	return parquetInt64, convertedTimestampMillis
+}
+
+// definitionLevels encodes whether each value is there with the RLE hybrid encoding at a bit width of 1,
+// as runs of the same level
+func definitionLevels(values []interface{}) []byte {
+	var buf bytes.Buffer
+	var scratch [binary.MaxVarintLen64]byte
+	for start := 0; start < len(values); {
+		present := values[start] != nil
+		end := start + 1
+		for end < len(values) && (values[end] != nil) == present {
+			end++
+		}
+		// a run header is the run length shifted left once, the low bit 0 marks a repeated run
+		n := binary.PutUvarint(scratch[:], uint64(end-start)<<1)
+		buf.Write(scratch[:n])
+		if present {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+		start = end
+	}
+	return buf.Bytes()
+}
+
+// plainValues writes the values that are not null with the PLAIN encoding
+func plainValues(buf *bytes.Buffer, columnType ColumnType, values []interface{}) {
+	var bits byte
+	var count int
+	for _, value := range values {
+		if value == nil {
+			continue
+		}
+		switch v := value.(type) {
+		case string:
+			binary.Write(buf, binary.LittleEndian, uint32(len(v)))
+			buf.WriteString(v)
+		case int64:
+			binary.Write(buf, binary.LittleEndian, v)
+		case float64:
+			binary.Write(buf, binary.LittleEndian, math.Float64bits(v))
+		case time.Time:
+			binary.Write(buf, binary.LittleEndian, v.UnixMilli())
+		case bool:
+			// booleans are packed 8 to a byte, the first value in the lowest bit
+			if v {
+				bits |= 1 << (count % 8)
+			}
+			count++
+			if count%8 == 0 {
+				buf.WriteByte(bits)
+				bits = 0
+			}
+		}
+	}
+	if columnType == TypeBool && count%8 != 0 {
+		buf.WriteByte(bits)
+	}
+}
@@ -0,0 +1,111 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Types of the thrift compact protocol
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter writes the thrift compact protocol, the encoding of the parquet page headers and footer.
+// Only what parquet needs is here: i32, i64, binary, lists and structs
+type thriftWriter struct {
+	buf    bytes.Buffer
+	lastID int16
+	stack  []int16
+}
+
+// field writes a field header, the ID is a delta from the last field of the struct when it fits in 4 bits
+func (t *thriftWriter) field(id int16, fieldType byte) {
+	if delta := id - t.lastID; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | fieldType)
+	} else {
+		t.buf.WriteByte(fieldType)
+		t.varint(zigzag(int64(id)))
+	}
+	t.lastID = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(zigzag(int64(v)))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(zigzag(v))
+}
+
+func (t *thriftWriter) binary(id int16, v string) {
+	t.field(id, thriftBinary)
+	t.rawBinary(v)
+}
+
+// list writes the header of a list field of size elements of elemType, the elements are written after it
+func (t *thriftWriter) list(id int16, elemType byte, size int) {
+	t.field(id, thriftList)
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | elemType)
+		return
+	}
+	t.buf.WriteByte(0xf0 | elemType)
+	t.varint(uint64(size))
+}
+
+// i32List writes a list field of i32 values
+func (t *thriftWriter) i32List(id int16, values ...int32) {
+	t.list(id, thriftI32, len(values))
+	for _, v := range values {
+		t.varint(zigzag(int64(v)))
+	}
+}
+
+// binaryList writes a list field of strings
+func (t *thriftWriter) binaryList(id int16, values ...string) {
+	t.list(id, thriftBinary, len(values))
+	for _, v := range values {
+		t.rawBinary(v)
+	}
+}
+
+// structField starts a struct field, it is ended with [thriftWriter.end]
+func (t *thriftWriter) structField(id int16) {
+	t.field(id, thriftStruct)
+	t.begin()
+}
+
+// begin starts a struct that has no field header, the top level struct or an element of a list
+func (t *thriftWriter) begin() {
+	t.stack = append(t.stack, t.lastID)
+	t.lastID = 0
+}
+
+// end writes the stop byte of the current struct
+func (t *thriftWriter) end() {
+	t.buf.WriteByte(0)
+	t.lastID = t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+}
+
+func (t *thriftWriter) rawBinary(v string) {
+	t.varint(uint64(len(v)))
+	t.buf.WriteString(v)
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	t.buf.Write(b[:n])
+}
+
+// zigzag maps signed integers to unsigned ones so small negative numbers stay short
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
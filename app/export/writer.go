@@ -0,0 +1,104 @@
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Formats files can be exported in
+const (
+	FormatCSV     = "csv"
+	FormatParquet = "parquet"
+)
+
+// ColumnType is the type of the values of a column
+type ColumnType int
+
+// Types of columns, a value can always be nil
+const (
+	TypeString ColumnType = iota // string
+	TypeInt                      // int64
+	TypeFloat                    // float64
+	TypeBool                     // bool
+	TypeTime                     // time.Time, written as UTC milliseconds
+)
+
+// Column is a column of an exported file
+type Column struct {
+	Name string
+	Type ColumnType
+}
+
+// RowWriter writes the rows of an exported file, each row has a value for every column
+type RowWriter interface {
+	Write(row []interface{}) error
+	// Close finishes the file, it does not close the underlying writer
+	Close() error
+}
+
+// NewWriter returns a [RowWriter] writing the columns in format to w
+func NewWriter(format string, w io.Writer, columns []Column) (RowWriter, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVWriter(w, columns)
+	case FormatParquet:
+		return newParquetWriter(w, columns)
+	}
+	return nil, fmt.Errorf("unknown export format %s", format)
+}
+
+// ContentType is the media type of files in format
+func ContentType(format string) string {
+	if format == FormatParquet {
+		return "application/vnd.apache.parquet"
+	}
+	return "text/csv"
+}
+
+// csvWriter writes a header with the column names and then a line per row, nil values are empty
+type csvWriter struct {
+	w       *csv.Writer
+	columns []Column
+	record  []string
+}
+
+func newCSVWriter(w io.Writer, columns []Column) (*csvWriter, error) {
+	c := &csvWriter{w: csv.NewWriter(w), columns: columns, record: make([]string, len(columns))}
+	for i, column := range columns {
+		c.record[i] = column.Name
+	}
+	return c, c.w.Write(c.record)
+}
+
+func (c *csvWriter) Write(row []interface{}) error {
+	if len(row) != len(c.columns) {
+		return fmt.Errorf("row has %d values for %d columns", len(row), len(c.columns))
+	}
+	for i, value := range row {
+		switch v := value.(type) {
+		case nil:
+			c.record[i] = ""
+		case string:
+			c.record[i] = v
+		case int64:
+			c.record[i] = strconv.FormatInt(v, 10)
+		case float64:
+			c.record[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			c.record[i] = strconv.FormatBool(v)
+		case time.Time:
+			c.record[i] = v.UTC().Format(time.RFC3339)
+		default:
+			return fmt.Errorf("column %s can not hold a %T", c.columns[i].Name, value)
+		}
+	}
+	return c.w.Write(c.record)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
@@ -0,0 +1,20 @@
+package helpers
+
+import "math"
+
+// earthRadiusMiles is the mean radius of the earth, radiuses in the API are in miles
+const earthRadiusMiles = 3958.8
+
+// MetersPerMile converts the radiuses of the API to the meters mongo geo queries use
+const MetersPerMile = 1609.34
+
+// DistanceMiles is the great circle (haversine) distance between two points
+func DistanceMiles(lat1, lng1, lat2, lng2 float64) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dLat := toRad(lat2 - lat1)
+	dLng := toRad(lng2 - lng1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusMiles * math.Asin(math.Min(1, math.Sqrt(a)))
+}
@@ -0,0 +1,26 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/analytics"
+)
+
+// RegisterAnalyticsJobs adds the job that folds analytics events into the hourly and daily rollups
+func RegisterAnalyticsJobs(s *Scheduler, service *analytics.Service) error {
+	return s.Register(Job{
+		Name:       "rollup-analytics",
+		Spec:       "@every 1m",
+		Timeout:    5 * time.Minute,
+		MaxRetries: 3,
+		Run: func(ctx context.Context) error {
+			rolledUp, err := service.Rollup(ctx)
+			if rolledUp > 0 {
+				log.Printf("Rolled up %d analytics events\n", rolledUp)
+			}
+			return err
+		},
+	})
+}
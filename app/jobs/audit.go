@@ -0,0 +1,59 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/server/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// defaultAuditRetentionDays is how long audit entries are kept when AUDIT_RETENTION_DAYS is not set
+const defaultAuditRetentionDays = 365
+
+// AuditRetentionFromEnv returns how long audit entries are kept, AUDIT_RETENTION_DAYS=0 keeps them forever
+func AuditRetentionFromEnv() (time.Duration, error) {
+	days := defaultAuditRetentionDays
+	if value := os.Getenv("AUDIT_RETENTION_DAYS"); value != "" {
+		var err error
+		days, err = strconv.Atoi(value)
+		if err != nil || days < 0 {
+			return 0, errors.New("AUDIT_RETENTION_DAYS must be a number of days")
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour, nil
+}
+
+// RegisterAuditJobs adds the job that removes audit entries older than retention, nothing is removed when it is 0
+func RegisterAuditJobs(s *Scheduler, db *database.Database, retention time.Duration) error {
+	if retention == 0 {
+		return nil
+	}
+	return s.Register(Job{
+		Name:       "prune-audit-log",
+		Spec:       "@daily",
+		Timeout:    5 * time.Minute,
+		MaxRetries: 3,
+		Run: func(ctx context.Context) error {
+			return PruneAuditLog(ctx, db, time.Now().UTC().Add(-retention))
+		},
+	})
+}
+
+// PruneAuditLog removes the audit entries recorded before the cutoff.
+// This is the only place entries are removed, the handlers only ever add them
+func PruneAuditLog(ctx context.Context, db *database.Database, cutoff time.Time) error {
+	result, err := db.GetAuditLog().DeleteMany(ctx, bson.M{"created_at": bson.M{"$lt": cutoff}})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount > 0 {
+		log.Printf("Removed %d audit entries\n", result.DeletedCount)
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/billing"
+)
+
+// RegisterBillingJobs adds the job that moves businesses whose failed payment ran out of grace off their paid plan
+func RegisterBillingJobs(s *Scheduler, service *billing.Service) error {
+	return s.Register(Job{
+		Name:       "expire-billing-grace",
+		Spec:       "@every 1h",
+		Timeout:    5 * time.Minute,
+		MaxRetries: 3,
+		Run: func(ctx context.Context) error {
+			expired, err := service.ExpireGracePeriods(ctx, time.Now().UTC())
+			if expired > 0 {
+				log.Printf("Grace period ended for %d subscriptions\n", expired)
+			}
+			return err
+		},
+	})
+}
@@ -0,0 +1,130 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/events"
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/app/server/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// dealBatchSize is how many deals one run of a deal job changes, the rest are picked up by the next run
+const dealBatchSize = 500
+
+// RegisterDealJobs adds the jobs that move deals along their lifecycle as time passes
+func RegisterDealJobs(s *Scheduler, db *database.Database) error {
+	err := s.Register(Job{
+		Name:       "publish-scheduled-deals",
+		Spec:       "@every 1m",
+		Timeout:    30 * time.Second,
+		MaxRetries: 3,
+		Run: func(ctx context.Context) error {
+			return PublishScheduledDeals(ctx, db, time.Now().UTC())
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.Register(Job{
+		Name:       "expire-deals",
+		Spec:       "@every 1m",
+		Timeout:    30 * time.Second,
+		MaxRetries: 3,
+		Run: func(ctx context.Context) error {
+			return ExpireDeals(ctx, db, time.Now().UTC())
+		},
+	})
+}
+
+// PublishScheduledDeals makes every scheduled deal whose publish time has passed live
+func PublishScheduledDeals(ctx context.Context, db *database.Database, now time.Time) error {
+	filter := bson.M{
+		"status":     model.DealScheduled,
+		"publish_at": bson.M{"$lte": now},
+	}
+
+	published, err := changeDealStatuses(ctx, db, filter, func(deal *model.Deal) bson.M {
+		deal.Status = model.DealLive
+		deal.Published_at = deal.Publish_at
+		deal.Publish_at = nil
+		return bson.M{
+			"$set":   bson.M{"status": deal.Status, "published_at": deal.Published_at},
+			"$unset": bson.M{"publish_at": ""},
+		}
+	})
+	if published > 0 {
+		log.Printf("Published %d scheduled deals\n", published)
+	}
+	return err
+}
+
+// ExpireDeals moves every live deal past its End_date to expired
+func ExpireDeals(ctx context.Context, db *database.Database, now time.Time) error {
+	filter := bson.M{
+		// deals from before there were statuses have no status and are live
+		"status":   bson.M{"$in": bson.A{model.DealLive, nil}},
+		"end_date": bson.M{"$lt": now},
+	}
+
+	expired, err := changeDealStatuses(ctx, db, filter, func(deal *model.Deal) bson.M {
+		deal.Status = model.DealExpired
+		return bson.M{"$set": bson.M{"status": deal.Status}}
+	})
+	if expired > 0 {
+		log.Printf("Expired %d deals\n", expired)
+	}
+	return err
+}
+
+// changeDealStatuses applies the change to each deal matching the filter and records a deal.status_changed event for it.
+// Each deal is saved with its event in one transaction, the filter is checked again so a deal changed
+// by its business in the meantime is left alone. Returns how many deals were changed
+func changeDealStatuses(ctx context.Context, db *database.Database, filter bson.M, change func(deal *model.Deal) bson.M) (int, error) {
+	dealCollection := db.GetDeals()
+	outbox := db.GetOutbox()
+
+	cursor, err := dealCollection.Find(ctx, filter, options.Find().SetLimit(dealBatchSize))
+	if err != nil {
+		return 0, err
+	}
+	var deals []*model.Deal
+	if err := cursor.All(ctx, &deals); err != nil {
+		return 0, err
+	}
+
+	changed := 0
+	for _, deal := range deals {
+		previousStatus := deal.CurrentStatus()
+		update := change(deal)
+
+		updated := false
+		err := db.WithTransaction(ctx, func(ctx context.Context) error {
+			dealFilter := bson.M{"_id": deal.ID}
+			for key, value := range filter {
+				dealFilter[key] = value
+			}
+
+			result, err := dealCollection.UpdateOne(ctx, dealFilter, update)
+			updated = err == nil && result.ModifiedCount > 0
+			if !updated {
+				return err
+			}
+
+			_, err = events.Record(ctx, outbox, model.EventDealStatusChanged, deal.Business_id, deal.ID, model.DealStatusChangedData{Deal: deal, Previous_status: previousStatus})
+			return err
+		})
+		if err != nil {
+			return changed, err
+		}
+		if updated {
+			changed++
+		}
+	}
+	return changed, nil
+}
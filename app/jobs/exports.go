@@ -0,0 +1,26 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/export"
+)
+
+// RegisterExportJobs adds the job that deletes exports older than [export.Retention] and their files
+func RegisterExportJobs(s *Scheduler, service *export.Service) error {
+	return s.Register(Job{
+		Name:       "expire-exports",
+		Spec:       "@every 1h",
+		Timeout:    5 * time.Minute,
+		MaxRetries: 3,
+		Run: func(ctx context.Context) error {
+			deleted, err := service.Expire(ctx, time.Now().UTC().Add(-export.Retention))
+			if deleted > 0 {
+				log.Printf("Deleted %d expired exports\n", deleted)
+			}
+			return err
+		},
+	})
+}
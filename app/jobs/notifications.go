@@ -0,0 +1,70 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/notifications"
+)
+
+// RegisterNotificationJobs adds the jobs that send reminders and saved search alerts on a schedule
+func RegisterNotificationJobs(s *Scheduler, service *notifications.Service) error {
+	err := s.Register(Job{
+		Name:       "remind-starting-deals",
+		Spec:       "@every 5m",
+		Timeout:    30 * time.Second,
+		MaxRetries: 3,
+		Run: func(ctx context.Context) error {
+			// a deal is only reminded about once, running often makes the reminder close to ReminderWindow before the start
+			queued, err := service.EnqueueReminders(ctx, time.Now().UTC())
+			if queued > 0 {
+				log.Printf("Queued reminders for %d deals\n", queued)
+			}
+			return err
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.Register(Job{
+		Name:       evaluateSavedSearchesJob,
+		Spec:       "@every 5m",
+		Timeout:    2 * time.Minute,
+		MaxRetries: 3,
+		Run: func(ctx context.Context) error {
+			since, err := savedSearchesCheckpoint(ctx, s)
+			if err != nil {
+				return err
+			}
+			found, err := service.EvaluateSavedSearches(ctx, since)
+			if found > 0 {
+				log.Printf("Found %d new saved search matches\n", found)
+			}
+			return err
+		},
+	})
+}
+
+const (
+	evaluateSavedSearchesJob = "evaluate-saved-searches"
+	// savedSearchLookback overlaps the runs, scheduled deals are published at their publish time
+	// which can be a little before the job that publishes them ran
+	savedSearchLookback = 5 * time.Minute
+	// savedSearchMaxCatchUp is how far back the first run, or one after a long outage, looks
+	savedSearchMaxCatchUp = 24 * time.Hour
+)
+
+// savedSearchesCheckpoint returns when the deals the saved search evaluator has not seen yet went live
+func savedSearchesCheckpoint(ctx context.Context, s *Scheduler) (time.Time, error) {
+	now := time.Now().UTC()
+	last, err := s.LastSucceeded(ctx, evaluateSavedSearchesJob)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if last == nil || now.Sub(*last) > savedSearchMaxCatchUp {
+		return now.Add(-savedSearchMaxCatchUp), nil
+	}
+	return last.Add(-savedSearchLookback), nil
+}
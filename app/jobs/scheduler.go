@@ -0,0 +1,253 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// jobs runs periodic work inside the server process.
+// Every server instance runs a scheduler but a lease document in mongo makes sure
+// each scheduled run of a job only happens on one instance.
+
+// Job is a piece of work that runs on a schedule
+type Job struct {
+	Name       string                          // unique name, also the id of the lease
+	Spec       string                          // see [ParseSchedule]
+	Run        func(ctx context.Context) error // the work, must stop when ctx is done
+	Timeout    time.Duration                   // how long one attempt can take, defaults to 1 minute
+	MaxRetries int                             // how many times a failed run is tried again
+
+	schedule Schedule
+}
+
+// Scheduler runs registered jobs on their schedules
+type Scheduler struct {
+	leases   model.Collection
+	runs     model.Collection
+	instance string
+	jobs     []*Job
+	wg       sync.WaitGroup
+}
+
+// NewScheduler returns a [Scheduler] that keeps its leases and history in the given collections
+func NewScheduler(leases model.Collection, runs model.Collection) *Scheduler {
+	hostname, _ := os.Hostname()
+	return &Scheduler{
+		leases:   leases,
+		runs:     runs,
+		instance: fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), primitive.NewObjectID().Hex()[18:]),
+	}
+}
+
+// Register adds a job to the scheduler, must be called before [Scheduler.Start]
+func (s *Scheduler) Register(job Job) error {
+	schedule, err := ParseSchedule(job.Spec)
+	if err != nil {
+		return fmt.Errorf("job %s: %v", job.Name, err)
+	}
+	if job.Timeout == 0 {
+		job.Timeout = time.Minute
+	}
+	job.schedule = schedule
+	s.jobs = append(s.jobs, &job)
+	return nil
+}
+
+// Start runs every job on its schedule until ctx is cancelled
+func (s *Scheduler) Start(ctx context.Context) {
+	for _, job := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(ctx, job)
+	}
+	log.Printf("Job scheduler started with %d jobs as %s\n", len(s.jobs), s.instance)
+}
+
+// Wait blocks until all the jobs stopped after ctx given to [Scheduler.Start] was cancelled
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+// loop sleeps until the next scheduled time of the job and then tries to run it
+// a job never overlaps with itself because the next run is only scheduled after this one finished
+func (s *Scheduler) loop(ctx context.Context, job *Job) {
+	defer s.wg.Done()
+
+	for {
+		next := job.schedule.Next(time.Now().UTC())
+		if next.IsZero() {
+			log.Printf("Job %s will never run again\n", job.Name)
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		s.runScheduled(ctx, job, next)
+	}
+}
+
+// LastSucceeded returns when the last successful run of the job started, nil when there is none in the history.
+// Jobs that work through what changed since they last ran use it as their checkpoint
+func (s *Scheduler) LastSucceeded(ctx context.Context, name string) (*time.Time, error) {
+	run := new(model.JobRun)
+	err := s.runs.FindOne(run, ctx,
+		bson.M{"job": name, "status": model.JobSucceeded},
+		options.FindOne().SetSort(bson.D{{Key: "started_at", Value: -1}}),
+	)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &run.Started_at, nil
+}
+
+// runScheduled runs the job for the scheduled time if this instance gets the lease
+func (s *Scheduler) runScheduled(ctx context.Context, job *Job, scheduledFor time.Time) {
+	lockFor := time.Duration(job.MaxRetries+1)*job.Timeout + backoff(job.MaxRetries) + time.Minute
+	acquired, err := s.acquireLease(ctx, job.Name, scheduledFor, lockFor)
+	if err != nil {
+		log.Printf("Job %s could not get its lease: %v\n", job.Name, err)
+		return
+	}
+	if !acquired {
+		// another instance has this run
+		return
+	}
+	defer s.releaseLease(job.Name)
+
+	run := &model.JobRun{
+		ID:            primitive.NewObjectID(),
+		Job:           job.Name,
+		Instance:      s.instance,
+		Scheduled_for: scheduledFor,
+		Started_at:    time.Now().UTC(),
+		Status:        model.JobRunning,
+	}
+	s.saveRun(run)
+
+	for attempt := 0; attempt <= job.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				err = ctx.Err()
+			case <-time.After(backoff(attempt)):
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+
+		run.Attempts = attempt + 1
+		err = s.attempt(ctx, job)
+		if err == nil {
+			break
+		}
+		log.Printf("Job %s attempt %d failed: %v\n", job.Name, run.Attempts, err)
+	}
+
+	finished := time.Now().UTC()
+	run.Finished_at = &finished
+	run.Status = model.JobSucceeded
+	if err != nil {
+		run.Status = model.JobFailed
+		run.Error = err.Error()
+	}
+	s.saveRun(run)
+}
+
+// attempt runs the job once with its timeout, a panic in the job is turned into an error
+func (s *Scheduler) attempt(ctx context.Context, job *Job) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, job.Timeout)
+	defer cancel()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return job.Run(ctx)
+}
+
+// acquireLease takes the lease of a job for one scheduled run.
+// The lease is only given out when nobody holds it and the run has not been done by another
+// instance yet. When the filter does not match, the upsert fails on the duplicate _id so only
+// one instance can ever win.
+func (s *Scheduler) acquireLease(ctx context.Context, name string, scheduledFor time.Time, lockFor time.Duration) (bool, error) {
+	now := time.Now().UTC()
+	filter := bson.M{
+		"_id":           name,
+		"locked_until":  bson.M{"$lt": now},
+		"last_schedule": bson.M{"$lt": scheduledFor},
+	}
+	update := bson.M{"$set": bson.M{
+		"owner":         s.instance,
+		"locked_until":  now.Add(lockFor),
+		"last_schedule": scheduledFor,
+	}}
+
+	_, err := s.leases.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// releaseLease lets the lease go early so a retry after a crash does not have to wait for it to run out
+func (s *Scheduler) releaseLease(name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := s.leases.UpdateOne(ctx,
+		bson.M{"_id": name, "owner": s.instance},
+		bson.M{"$set": bson.M{"locked_until": time.Now().UTC()}},
+	)
+	if err != nil {
+		log.Printf("Job %s could not release its lease: %v\n", name, err)
+	}
+}
+
+// saveRun stores the job run in the history
+func (s *Scheduler) saveRun(run *model.JobRun) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := s.runs.UpdateOne(ctx, bson.M{"_id": run.ID}, bson.M{"$set": run}, options.Update().SetUpsert(true))
+	if err != nil {
+		log.Printf("Job %s could not save its history: %v\n", run.Job, err)
+	}
+}
+
+// backoff is how long to wait before a retry, doubling each time up to 5 minutes
+func backoff(attempt int) time.Duration {
+	if attempt <= 0 {
+		return 0
+	}
+	d := time.Duration(1<<uint(attempt-1)) * 5 * time.Second
+	if d > 5*time.Minute || d <= 0 {
+		d = 5 * time.Minute
+	}
+	return d
+}
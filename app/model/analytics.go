@@ -0,0 +1,124 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Types of analytics events
+const (
+	AnalyticsImpression = "impression" // the deal was in the results of a nearby search
+	AnalyticsView       = "view"       // the deal was on a business profile that was opened
+	AnalyticsClaim      = "claim"
+	AnalyticsRedemption = "redemption"
+)
+
+// Granularities of analytics rollups
+const (
+	AnalyticsHour = "hour"
+	AnalyticsDay  = "day"
+)
+
+// DistanceBand is a range of distances between a searcher and a business, from the previous band up to Max miles
+type DistanceBand struct {
+	Name string
+	Max  float64
+}
+
+// DistanceBands are the bands impressions are broken down by, from near to far
+var DistanceBands = []DistanceBand{
+	{Name: "0-1", Max: 1},
+	{Name: "1-3", Max: 3},
+	{Name: "3-5", Max: 5},
+	{Name: "5-10", Max: 10},
+	{Name: "10+"},
+}
+
+// DistanceUnknown is the band of events that do not happen at a known distance, e.g. profile views and claims
+const DistanceUnknown = "unknown"
+
+// DistanceBandOf returns the name of the band miles falls in
+func DistanceBandOf(miles float64) string {
+	for _, band := range DistanceBands {
+		if band.Max == 0 || miles <= band.Max {
+			return band.Name
+		}
+	}
+	return DistanceUnknown
+}
+
+// AnalyticsEvent is something that happened to one or more deals, e.g. all the deals returned by a nearby search.
+// Events are folded into the hourly rollups by a job and removed after a week
+type AnalyticsEvent struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	Type        string             `bson:"type"`
+	Key         string             `bson:"key,omitempty"` // the domain event it came from, it is only recorded once
+	Deals       []AnalyticsDeal    `bson:"deals"`
+	Occurred_at time.Time          `bson:"occurred_at"`
+	Rolled_up   bool               `bson:"rolled_up"`
+}
+
+// AnalyticsDeal is a deal an analytics event happened to
+type AnalyticsDeal struct {
+	Business_id primitive.ObjectID `bson:"business_id"`
+	Deal_id     primitive.ObjectID `bson:"deal_id"`
+	Band        string             `bson:"band"` // see [DistanceBands]
+}
+
+// AnalyticsCounts counts each type of analytics event
+type AnalyticsCounts struct {
+	Impressions int64 `json:"impressions" bson:"impressions"`
+	Views       int64 `json:"views" bson:"views"`
+	Claims      int64 `json:"claims" bson:"claims"`
+	Redemptions int64 `json:"redemptions" bson:"redemptions"`
+}
+
+// Add adds other to the counts
+func (c *AnalyticsCounts) Add(other AnalyticsCounts) {
+	c.Impressions += other.Impressions
+	c.Views += other.Views
+	c.Claims += other.Claims
+	c.Redemptions += other.Redemptions
+}
+
+// AnalyticsRollup counts the events of a deal at a distance band in an hour or a day (UTC), starting at Start
+type AnalyticsRollup struct {
+	ID              primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	Business_id     primitive.ObjectID `json:"business_id" bson:"business_id"`
+	Deal_id         primitive.ObjectID `json:"deal_id" bson:"deal_id"`
+	Start           time.Time          `json:"start" bson:"start"`
+	Band            string             `json:"band" bson:"band"`
+	AnalyticsCounts `bson:",inline"`
+}
+
+// AnalyticsReport is how the deals of a business performed between From and To
+type AnalyticsReport struct {
+	From           time.Time             `json:"from"`
+	To             time.Time             `json:"to"`
+	Granularity    string                `json:"granularity"`
+	Totals         AnalyticsCounts       `json:"totals"`
+	Series         []*AnalyticsPoint     `json:"series"`
+	Top_deals      []*AnalyticsDealStats `json:"top_deals"`
+	By_day_of_week []*AnalyticsBreakdown `json:"by_day_of_week"`
+	By_distance    []*AnalyticsBreakdown `json:"by_distance"`
+}
+
+// AnalyticsPoint is the counts of one hour or day of a report
+type AnalyticsPoint struct {
+	Start time.Time `json:"start"`
+	AnalyticsCounts
+}
+
+// AnalyticsDealStats is the counts of one deal in a report
+type AnalyticsDealStats struct {
+	Deal_id primitive.ObjectID `json:"deal_id"`
+	Name    *string            `json:"name"`
+	AnalyticsCounts
+}
+
+// AnalyticsBreakdown is the counts of a report for one day of the week or distance band
+type AnalyticsBreakdown struct {
+	Key string `json:"key"`
+	AnalyticsCounts
+}
@@ -0,0 +1,182 @@
+package model
+
+import (
+	"reflect"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Who did something recorded in the audit log
+const (
+	ActorUser     = "user"
+	ActorBusiness = "business"
+	ActorAdmin    = "admin"
+	ActorSystem   = "system" // the server itself, e.g. billing changing a plan
+)
+
+// Actions recorded in the audit log
+const (
+	AuditUserSignedUp          = "user.signed_up"
+	AuditUserLoggedIn          = "user.logged_in"
+	AuditBusinessSignedUp      = "business.signed_up"
+	AuditBusinessLoggedIn      = "business.logged_in"
+	AuditBusinessUpdated       = "business.updated"
+	AuditDealCreated           = "deal.created"
+	AuditDealUpdated           = "deal.updated"
+	AuditDealDeleted           = "deal.deleted" // archived by the business
+	AuditDealRestored          = "deal.restored"
+	AuditDealStatusChanged     = "deal.status_changed"
+	AuditDealPinned            = "deal.pinned"
+	AuditDealUnpinned          = "deal.unpinned"
+	AuditAccountSuspended      = "account.suspended"
+	AuditAccountReinstated     = "account.reinstated"
+	AuditAccountLoggedOut      = "account.logged_out" // every session ended by an admin
+	AuditBusinessImpersonated  = "business.impersonated"
+	AuditDealEdited            = "deal.edited"
+	AuditDealRemoved           = "deal.removed"
+	AuditVerificationSubmitted = "business.verification_submitted" // a document or confirmed code waits for an admin
+	AuditBusinessVerified      = "business.verified"
+	AuditVerificationRejected  = "business.verification_rejected"
+	AuditPlanChanged           = "business.plan_changed"
+	AuditDataExported          = "data.exported" // an admin exported a dataset
+)
+
+// What an audit entry is about
+const (
+	AuditTargetUser     = "user"
+	AuditTargetBusiness = "business"
+	AuditTargetDeal     = "deal"
+	AuditTargetExport   = "export"
+)
+
+// AuditEntry records who changed what. Entries are only ever added, never changed
+type AuditEntry struct {
+	ID              primitive.ObjectID  `json:"id" bson:"_id"`
+	Action          string              `json:"action" bson:"action"`
+	Actor_type      string              `json:"actor_type" bson:"actor_type"`
+	Actor_id        primitive.ObjectID  `json:"actor_id" bson:"actor_id"`
+	Impersonator_id *primitive.ObjectID `json:"impersonator_id,omitempty" bson:"impersonator_id,omitempty"` // the admin acting as the business
+	Business_id     *primitive.ObjectID `json:"business_id,omitempty" bson:"business_id,omitempty"`         // the business the change is about
+	Target_type     string              `json:"target_type" bson:"target_type"`
+	Target_id       primitive.ObjectID  `json:"target_id" bson:"target_id"`
+	Changes         []FieldChange       `json:"changes,omitempty" bson:"changes,omitempty"`
+	Data            interface{}         `json:"data,omitempty" bson:"data,omitempty"`
+	Ip              string              `json:"ip,omitempty" bson:"ip,omitempty"`
+	User_agent      string              `json:"user_agent,omitempty" bson:"user_agent,omitempty"`
+	Request_id      string              `json:"request_id,omitempty" bson:"request_id,omitempty"`
+	Created_at      time.Time           `json:"created_at" bson:"created_at"`
+}
+
+// FieldChange is the value of a field before and after a change, nil when the field was not set
+type FieldChange struct {
+	Field  string      `json:"field" bson:"field"`
+	Before interface{} `json:"before" bson:"before"`
+	After  interface{} `json:"after" bson:"after"`
+}
+
+// auditHiddenFields are recorded as changed without their values
+var auditHiddenFields = map[string]bool{"password": true, "token": true, "refresh_token": true}
+
+// auditSkippedFields change all the time and are not recorded
+var auditSkippedFields = map[string]bool{"updated_at": true, "tokens_revoked_at": true}
+
+// Diff compares two versions of a document the way they are stored and returns the fields that changed,
+// sorted by name. Secrets like the password are recorded as changed without their values
+func Diff(before interface{}, after interface{}) ([]FieldChange, error) {
+	beforeFields, err := storedFields(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := storedFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name := range beforeFields {
+		names = append(names, name)
+	}
+	for name := range afterFields {
+		if _, ok := beforeFields[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var changes []FieldChange
+	for _, name := range names {
+		if auditSkippedFields[name] || reflect.DeepEqual(beforeFields[name], afterFields[name]) {
+			continue
+		}
+		change := FieldChange{Field: name, Before: beforeFields[name], After: afterFields[name]}
+		if auditHiddenFields[name] {
+			change.Before, change.After = nil, nil
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// storedFields returns the fields of the document as they are stored in mongo
+func storedFields(doc interface{}) (bson.M, error) {
+	fields := bson.M{}
+	if value := reflect.ValueOf(doc); doc == nil || (value.Kind() == reflect.Ptr && value.IsNil()) {
+		return fields, nil
+	}
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	err = bson.Unmarshal(data, &fields)
+	return fields, err
+}
+
+// AdminAccount is a user or business as admins see it, without passwords and tokens
+type AdminAccount struct {
+	ID                  primitive.ObjectID `json:"id"`
+	Email               *string            `json:"email"`
+	First_name          *string            `json:"first_name,omitempty"`
+	Last_name           *string            `json:"last_name,omitempty"`
+	Business_name       *string            `json:"business_name,omitempty"`
+	Role                string             `json:"role,omitempty"`
+	Moderation_status   string             `json:"moderation_status,omitempty"`
+	Verification_status string             `json:"verification_status,omitempty"`
+	Plan                string             `json:"plan,omitempty"` // empty is the default plan
+	Suspended_at        *time.Time         `json:"suspended_at,omitempty"`
+	Suspension_reason   string             `json:"suspension_reason,omitempty"`
+	Created_at          time.Time          `json:"created_at"`
+}
+
+// NewAdminUser sets up the [AdminAccount] of a user
+func NewAdminUser(user *User) *AdminAccount {
+	return &AdminAccount{
+		ID:                user.ID,
+		Email:             user.Email,
+		First_name:        user.First_name,
+		Last_name:         user.Last_name,
+		Role:              user.Role,
+		Suspended_at:      user.Suspended_at,
+		Suspension_reason: user.Suspension_reason,
+		Created_at:        user.Created_at,
+	}
+}
+
+// NewAdminBusiness sets up the [AdminAccount] of a business
+func NewAdminBusiness(business *BusinessUser) *AdminAccount {
+	return &AdminAccount{
+		ID:                  business.ID,
+		Email:               business.Email,
+		First_name:          business.First_name,
+		Last_name:           business.Last_name,
+		Business_name:       business.Business_name,
+		Moderation_status:   business.Moderation_status,
+		Verification_status: business.VerificationStatus(),
+		Plan:                business.Plan,
+		Suspended_at:        business.Suspended_at,
+		Suspension_reason:   business.Suspension_reason,
+		Created_at:          business.Created_at,
+	}
+}
@@ -0,0 +1,89 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Statuses of a subscription, as the payment provider reports them
+const (
+	SubscriptionActive            = "active"
+	SubscriptionTrialing          = "trialing"
+	SubscriptionPastDue           = "past_due" // a payment failed and is being retried
+	SubscriptionUnpaid            = "unpaid"   // the provider gave up retrying
+	SubscriptionIncomplete        = "incomplete"
+	SubscriptionIncompleteExpired = "incomplete_expired"
+	SubscriptionCanceled          = "canceled"
+)
+
+// Statuses of an invoice
+const (
+	InvoiceDraft         = "draft"
+	InvoiceOpen          = "open"
+	InvoicePaid          = "paid"
+	InvoiceVoid          = "void"
+	InvoiceUncollectible = "uncollectible"
+)
+
+// BillingCustomer links a business to its customer at the payment provider
+type BillingCustomer struct {
+	ID          primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	Business_id primitive.ObjectID `json:"-" bson:"business_id"`
+	Provider    string             `json:"provider" bson:"provider"`
+	Provider_id string             `json:"id" bson:"provider_id"`
+	Created_at  time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// Subscription is a subscription of a business to a paid plan, kept in sync by the events of the payment provider
+type Subscription struct {
+	ID                   primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	Business_id          primitive.ObjectID `json:"-" bson:"business_id"`
+	Provider_id          string             `json:"id" bson:"provider_id"`
+	Plan                 string             `json:"plan" bson:"plan"`
+	Status               string             `json:"status" bson:"status"`
+	Current_period_end   *time.Time         `json:"current_period_end,omitempty" bson:"current_period_end,omitempty"`
+	Cancel_at_period_end bool               `json:"cancel_at_period_end" bson:"cancel_at_period_end"`
+	Grace_until          *time.Time         `json:"grace_until,omitempty" bson:"grace_until,omitempty"`     // a past due subscription keeps its plan until then
+	Downgraded_at        *time.Time         `json:"downgraded_at,omitempty" bson:"downgraded_at,omitempty"` // the grace period ran out
+	Event_at             time.Time          `json:"-" bson:"event_at"`                                      // the newest provider event applied, older events are ignored
+	Created_at           time.Time          `json:"created_at" bson:"created_at"`
+	Updated_at           time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// Entitles tells if the business gets the plan of the subscription at now
+func (s *Subscription) Entitles(now time.Time) bool {
+	switch s.Status {
+	case SubscriptionActive, SubscriptionTrialing:
+		return true
+	case SubscriptionPastDue, SubscriptionUnpaid:
+		return s.Grace_until == nil || s.Grace_until.After(now)
+	}
+	return false
+}
+
+// Invoice is an invoice of a subscription, amounts are in the smallest unit of the currency (e.g. cents)
+type Invoice struct {
+	ID              primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	Business_id     primitive.ObjectID `json:"-" bson:"business_id"`
+	Provider_id     string             `json:"id" bson:"provider_id"`
+	Subscription_id string             `json:"subscription_id,omitempty" bson:"subscription_id,omitempty"` // the provider ID of the subscription
+	Status          string             `json:"status" bson:"status"`
+	Currency        string             `json:"currency" bson:"currency"`
+	Amount_due      int64              `json:"amount_due" bson:"amount_due"`
+	Amount_paid     int64              `json:"amount_paid" bson:"amount_paid"`
+	Hosted_url      string             `json:"hosted_url,omitempty" bson:"hosted_url,omitempty"` // where the business can see and pay the invoice
+	Period_start    *time.Time         `json:"period_start,omitempty" bson:"period_start,omitempty"`
+	Period_end      *time.Time         `json:"period_end,omitempty" bson:"period_end,omitempty"`
+	Event_at        time.Time          `json:"-" bson:"event_at"`
+	Created_at      time.Time          `json:"created_at" bson:"created_at"`
+	Updated_at      time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// BillingEvent records a payment provider event that was handled, so a redelivered event is not handled twice
+type BillingEvent struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty"`
+	Key          string             `bson:"key"` // <provider>:<event ID>
+	Type         string             `bson:"type"`
+	Processed_at time.Time          `bson:"processed_at"`
+}
@@ -37,7 +37,18 @@ type BusinessUser struct {
 		Address 			*Address           		`json:"address" bson:"address"`
 		Location			*Location					 		`json:"location" bson:"location"`
 		Description	  *string						 		`json:"description"`	
+		Phone_number	*string								`json:"phone_number" bson:"phone_number,omitempty"` // the listing phone, verification calls go to it
 		PinnedDeals	 []*primitive.ObjectID	`json:"pinned_deals"`
+		Logo					*Image								`json:"logo" bson:"logo,omitempty"`
+		Cover_photo		*Image								`json:"cover_photo" bson:"cover_photo,omitempty"`
+		Tokens_revoked_at *time.Time				`json:"-" bson:"tokens_revoked_at,omitempty"`
+		Rating				*BusinessRating				`json:"-" bson:"rating,omitempty"`
+		Moderation_status string						`json:"-" bson:"moderation_status,omitempty"` // see [ModerationPending]
+		Suspended_at	*time.Time						`json:"-" bson:"suspended_at,omitempty"` // set by an admin, suspended businesses can not sign in and are not shown
+		Suspension_reason string						`json:"-" bson:"suspension_reason,omitempty"`
+		Verification_status string					`json:"-" bson:"verification_status,omitempty"` // see [BusinessVerified]
+		Verified_at		*time.Time						`json:"-" bson:"verified_at,omitempty"`
+		Plan					string								`json:"-" bson:"plan,omitempty"` // the ID of the plan in the catalog, empty is the default plan
 }
 
 //BusinessUserWrapper is the model that represents the user to be sent to the frontend
@@ -51,7 +62,17 @@ type BusinessUserWrapper struct {
 	Location			*Location					 		`json:"location" bson:"location"`
 	Deals 				[]*Deal	    			 		`json:"deals"`	
 	Description	  *string						 		`json:"description"`	
+	Phone_number	*string								`json:"phone_number,omitempty"`
 	PinnedDeals	 []*primitive.ObjectID	`json:"pinned_deals"`
+	Logo					*Image								`json:"logo"`
+	Cover_photo		*Image								`json:"cover_photo"`
+	Followers_count *int64							`json:"followers_count,omitempty"` // only sent to the business itself
+	Favorites_count *int64							`json:"favorites_count,omitempty"` // how often its deals were favorited, only sent to the business itself
+	Rating				*RatingSummary				`json:"rating,omitempty"` // left out until the business has a review
+	Moderation_status string						`json:"moderation_status,omitempty"` // only sent to the business itself
+	Verified			bool									`json:"verified"` // the verified badge
+	Verification_status string					`json:"verification_status,omitempty"` // only sent to the business itself
+	Sponsored			bool									`json:"sponsored,omitempty"` // a paid placement in a nearby search
 }
 
 // newUser sets up a frontend appropriate [model.User]
@@ -65,7 +86,14 @@ func NewBusinessAuthenticatedUser(business *BusinessUser, deals []*Deal) *Busine
 		Location:				 business.Location,
 		Deals: 					 deals,
 		Description:     business.Description,
+		Phone_number:		 business.Phone_number,
 		PinnedDeals:		 business.PinnedDeals,
+		Logo:						 business.Logo,
+		Cover_photo:		 business.Cover_photo,
+		Rating:					 business.Rating.Summary(),
+		Moderation_status: business.Moderation_status,
+		Verified:				 business.IsVerified(),
+		Verification_status: business.VerificationStatus(),
 	}
 }
 
@@ -78,7 +106,12 @@ func NewBusinessUser(business *BusinessUser, deals []*Deal) *BusinessUserWrapper
 		Location:				 business.Location,
 		Deals: 					 deals,
 		Description:     business.Description,
+		Phone_number:		 business.Phone_number,
 		PinnedDeals:		 business.PinnedDeals,
+		Logo:						 business.Logo,
+		Cover_photo:		 business.Cover_photo,
+		Rating:					 business.Rating.Summary(),
+		Verified:				 business.IsVerified(),
 	}
 }
 
@@ -124,10 +157,14 @@ func (b *BusinessUser) SetRefreshToken(refreshToken string) {
 	b.Refresh_token = &refreshToken
 }
 
-func (business *BusinessUser) PinDeal(dealID *primitive.ObjectID) error {
-	maxPinnedDeals := 3
-	if len(business.PinnedDeals) >= maxPinnedDeals {
-		return fmt.Errorf("cannot pin more than %d deals", maxPinnedDeals)
+func (b *BusinessUser) IsSuspended() bool {
+	return b.Suspended_at != nil
+}
+
+// PinDeal pins the deal unless the business already has maxPinned pinned deals, [Unlimited] has no maximum
+func (business *BusinessUser) PinDeal(dealID *primitive.ObjectID, maxPinned int64) error {
+	if maxPinned != Unlimited && int64(len(business.PinnedDeals)) >= maxPinned {
+		return fmt.Errorf("cannot pin more than %d deals", maxPinned)
 	}
 	business.PinnedDeals = append(business.PinnedDeals, dealID)
 	return nil
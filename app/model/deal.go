@@ -16,4 +16,67 @@ type Deal struct {
 	Start_date  *time.Time         `json:"start_date" bson:"start_date,omitempty"`
 	End_date  *time.Time         	 `json:"end_date" bson:"end_date,omitempty"`
 	Description *string            `json:"description" bson:"description,omitempty"`
-} 
+	Pricing     *DealPricing       `json:"pricing,omitempty" bson:"pricing,omitempty"`
+	Savings     *Savings           `json:"savings,omitempty" bson:"-"`
+	Image       *Image             `json:"image,omitempty" bson:"image,omitempty"`
+	Claim_limits *ClaimLimits      `json:"claim_limits,omitempty" bson:"claim_limits,omitempty"`
+	Quantity    *int               `json:"quantity,omitempty" bson:"quantity,omitempty"`
+	Remaining   *int               `json:"remaining,omitempty" bson:"remaining,omitempty"`
+	Sold_out    bool               `json:"sold_out" bson:"-"`
+	Status      string             `json:"status" bson:"status,omitempty"`
+	Publish_at  *time.Time         `json:"publish_at,omitempty" bson:"publish_at,omitempty"`
+	Published_at *time.Time        `json:"published_at,omitempty" bson:"published_at,omitempty"`
+	Archived_at *time.Time         `json:"archived_at,omitempty" bson:"archived_at,omitempty"`
+	Archived_from string           `json:"-" bson:"archived_from,omitempty"`
+	Categories  []string           `json:"categories,omitempty" bson:"categories,omitempty"`
+	Moderation_status string       `json:"moderation_status,omitempty" bson:"moderation_status,omitempty"` // see [ModerationPending]
+	Sponsored   bool               `json:"sponsored,omitempty" bson:"-"` // shown as a paid placement in a nearby search
+	Promotion_id *primitive.ObjectID `json:"promotion_id,omitempty" bson:"-"` // sent back when a sponsored deal is clicked
+	Rating      *BusinessRating    `json:"-" bson:"rating,omitempty"` // the totals of the reviews of the deal
+	Rating_summary *RatingSummary  `json:"rating,omitempty" bson:"-"` // left out until the deal has a review
+}
+
+// DealCategories are the categories a deal can be put in
+var DealCategories = []string{"food", "drinks", "coffee", "desserts", "happy_hour", "groceries", "retail", "beauty", "fitness", "entertainment", "services", "other"}
+
+// IsDealCategory checks that category is one of the [DealCategories]
+func IsDealCategory(category string) bool {
+	for _, known := range DealCategories {
+		if category == known {
+			return true
+		}
+	}
+	return false
+}
+
+// ComputeFields fills in everything on the deal that is worked out instead of stored
+// should be called before a deal is sent to the client
+func (d *Deal) ComputeFields() {
+	d.ComputeSavings()
+	d.Sold_out = d.IsSoldOut()
+	d.Status = d.CurrentStatus()
+	d.Rating_summary = d.Rating.Summary()
+}
+
+// IsSoldOut checks if a limited quantity deal has no claims left
+func (d *Deal) IsSoldOut() bool {
+	return d.Quantity != nil && d.Remaining != nil && *d.Remaining <= 0
+}
+
+// ComputeSavings fills in the savings of the deal from its pricing so it can be sent to the client
+func (d *Deal) ComputeSavings() {
+	if d.Pricing == nil {
+		d.Savings = nil
+		return
+	}
+	d.Savings = d.Pricing.ComputeSavings()
+}
+
+// SavingsPercent is used to compare deals priced in different currencies
+// deals without pricing have no savings
+func (d *Deal) SavingsPercent() float64 {
+	if d.Savings == nil {
+		return 0
+	}
+	return d.Savings.Percent
+}
@@ -0,0 +1,109 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// The lifecycle of a deal
+//
+//	draft -> scheduled -> live -> expired -> archived
+//
+// Only live deals are shown to customers. Deleting a deal archives it so it can be restored later.
+const (
+	DealDraft     = "draft"     // being prepared by the business, not visible
+	DealScheduled = "scheduled" // will go live at Publish_at
+	DealLive      = "live"      // visible to customers
+	DealExpired   = "expired"   // past its End_date
+	DealArchived  = "archived"  // deleted by the business
+)
+
+// dealStatusTransitions lists the statuses each status can be changed to by a business
+var dealStatusTransitions = map[string][]string{
+	DealDraft:     {DealScheduled, DealLive, DealArchived},
+	DealScheduled: {DealDraft, DealLive, DealArchived},
+	DealLive:      {DealDraft, DealExpired, DealArchived},
+	DealExpired:   {DealLive, DealArchived}, // going live again only sticks if End_date was moved
+	DealArchived:  {},
+}
+
+// IsDealStatus checks that status is one of the deal statuses
+func IsDealStatus(status string) bool {
+	_, ok := dealStatusTransitions[status]
+	return ok
+}
+
+// CurrentStatus is the status of the deal, deals from before there were statuses are live
+func (d *Deal) CurrentStatus() string {
+	if d.Status == "" {
+		return DealLive
+	}
+	return d.Status
+}
+
+// TransitionTo moves the deal to a new status if the lifecycle allows it
+// publishAt is only used when scheduling
+func (d *Deal) TransitionTo(status string, publishAt *time.Time, now time.Time) error {
+	current := d.CurrentStatus()
+	if !IsDealStatus(status) {
+		return fmt.Errorf("unknown deal status %q", status)
+	}
+
+	allowed := false
+	for _, next := range dealStatusTransitions[current] {
+		if next == status {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("a %s deal cannot be changed to %s", current, status)
+	}
+
+	switch status {
+	case DealScheduled:
+		if publishAt == nil || !publishAt.After(now) {
+			return fmt.Errorf("publish_at must be in the future to schedule a deal")
+		}
+		d.Publish_at = publishAt
+	case DealLive:
+		d.Published_at = &now
+		d.Publish_at = nil
+	case DealArchived:
+		d.Archived_from = current
+		d.Archived_at = &now
+	}
+
+	d.Status = status
+	d.ResolveStatus(now)
+	return nil
+}
+
+// Restore brings back an archived deal to the status it had when it was archived
+func (d *Deal) Restore(now time.Time) error {
+	if d.CurrentStatus() != DealArchived {
+		return fmt.Errorf("only archived deals can be restored")
+	}
+
+	d.Status = d.Archived_from
+	if !IsDealStatus(d.Status) || d.Status == DealArchived {
+		d.Status = DealDraft
+	}
+	d.Archived_from = ""
+	d.Archived_at = nil
+	d.ResolveStatus(now)
+	return nil
+}
+
+// ResolveStatus moves the deal along the lifecycle based on time,
+// scheduled deals go live at Publish_at and live deals expire after End_date
+func (d *Deal) ResolveStatus(now time.Time) {
+	if d.Status == DealScheduled && d.Publish_at != nil && !d.Publish_at.After(now) {
+		d.Status = DealLive
+		d.Published_at = d.Publish_at
+		d.Publish_at = nil
+	}
+	if d.CurrentStatus() == DealLive && d.End_date != nil && d.End_date.Before(now) {
+		d.Status = DealExpired
+	}
+}
@@ -0,0 +1,75 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Domain event types, named <aggregate>.<what happened>
+const (
+	EventDealCreated       = "deal.created"
+	EventDealUpdated       = "deal.updated"
+	EventDealStatusChanged = "deal.status_changed"
+	EventDealPinned        = "deal.pinned"
+	EventDealUnpinned      = "deal.unpinned"
+	EventDealClaimed       = "deal.claimed"
+	EventDealRedeemed      = "deal.redeemed"
+	EventBusinessUpdated   = "business.updated"
+	EventReviewCreated     = "review.created"
+)
+
+// Status of an event in the outbox
+const (
+	EventPending     = "pending"     // waiting for the dispatcher
+	EventDispatching = "dispatching" // taken by a dispatcher until Leased_until
+	EventDispatched  = "dispatched"  // every subscriber handled it
+	EventFailed      = "failed"      // a subscriber kept failing, kept to be looked at
+)
+
+// DomainEvent records that something changed. It is written to the outbox collection
+// in the same transaction as the change so an event is never lost or sent for a change that was rolled back
+type DomainEvent struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id"`
+	Type          string             `json:"type" bson:"type"`
+	Aggregate_id  primitive.ObjectID `json:"aggregate_id" bson:"aggregate_id"`
+	Business_id   primitive.ObjectID `json:"business_id" bson:"business_id"`
+	Data          bson.Raw           `json:"-" bson:"data"`
+	Occurred_at   time.Time          `json:"occurred_at" bson:"occurred_at"`
+	Status        string             `json:"status" bson:"status"`
+	Attempts      int                `json:"attempts" bson:"attempts"`
+	Available_at  time.Time          `json:"available_at" bson:"available_at"`
+	Leased_until  *time.Time         `json:"leased_until,omitempty" bson:"leased_until,omitempty"`
+	Lease_owner   string             `json:"-" bson:"lease_owner,omitempty"`
+	Handled_by    []string           `json:"handled_by" bson:"handled_by"`
+	Last_error    string             `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	Dispatched_at *time.Time         `json:"dispatched_at,omitempty" bson:"dispatched_at,omitempty"`
+}
+
+// DecodeData unmarshals the data of the event into v
+func (e *DomainEvent) DecodeData(v interface{}) error {
+	return bson.Unmarshal(e.Data, v)
+}
+
+// HandledBy reports whether the subscriber already handled the event on an earlier attempt
+func (e *DomainEvent) HandledBy(subscriber string) bool {
+	for _, name := range e.Handled_by {
+		if name == subscriber {
+			return true
+		}
+	}
+	return false
+}
+
+// DealStatusChangedData is the data of a deal.status_changed event
+type DealStatusChangedData struct {
+	Deal            *Deal  `json:"deal" bson:"deal"`
+	Previous_status string `json:"previous_status" bson:"previous_status"`
+}
+
+// DealPinData is the data of deal.pinned and deal.unpinned events
+type DealPinData struct {
+	Deal_id      primitive.ObjectID    `json:"deal_id" bson:"deal_id"`
+	Pinned_deals []*primitive.ObjectID `json:"pinned_deals" bson:"pinned_deals"`
+}
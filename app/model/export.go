@@ -0,0 +1,43 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Datasets that can be exported
+const (
+	ExportDeals           = "deals"
+	ExportBusinesses      = "businesses"
+	ExportRedemptions     = "redemptions"
+	ExportAnalyticsHourly = "analytics_hourly"
+	ExportAnalyticsDaily  = "analytics_daily"
+)
+
+// States of an export job
+const (
+	ExportPending   = "pending"
+	ExportRunning   = "running"
+	ExportCompleted = "completed"
+	ExportFailed    = "failed" // the last attempt failed, see [Export.Error]
+)
+
+// Export is a file of a dataset written to storage in the background
+type Export struct {
+	ID           primitive.ObjectID  `json:"id" bson:"_id"`
+	Dataset      string              `json:"dataset" bson:"dataset"`                             // see [ExportDeals]
+	Format       string              `json:"format" bson:"format"`                               // csv or parquet
+	Business_id  *primitive.ObjectID `json:"business_id,omitempty" bson:"business_id,omitempty"` // only the rows of this business
+	From         *time.Time          `json:"from,omitempty" bson:"from,omitempty"`
+	To           *time.Time          `json:"to,omitempty" bson:"to,omitempty"`
+	Status       string              `json:"status" bson:"status"`
+	Key          string              `json:"-" bson:"key,omitempty"` // where the file is in storage
+	Rows         int64               `json:"rows" bson:"rows"`
+	Size         int64               `json:"size" bson:"size"` // bytes
+	Error        string              `json:"error,omitempty" bson:"error,omitempty"`
+	Requested_by primitive.ObjectID  `json:"requested_by" bson:"requested_by"` // the admin
+	Created_at   time.Time           `json:"created_at" bson:"created_at"`
+	Updated_at   time.Time           `json:"updated_at" bson:"updated_at"`
+	Completed_at *time.Time          `json:"completed_at,omitempty" bson:"completed_at,omitempty"`
+}
@@ -0,0 +1,36 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Favorite is a deal a user saved, Business_id is kept so the business can count its favorites
+type Favorite struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	User_id     primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Deal_id     primitive.ObjectID `json:"deal_id" bson:"deal_id"`
+	Business_id primitive.ObjectID `json:"business_id" bson:"business_id"`
+	Created_at  time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// Follow is a business a user follows, the deals of followed businesses make up the user's following feed
+type Follow struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	User_id     primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Business_id primitive.ObjectID `json:"business_id" bson:"business_id"`
+	Created_at  time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// FavoriteDeal is a favorite sent to the user with its deal
+type FavoriteDeal struct {
+	Favorited_at time.Time `json:"favorited_at"`
+	Deal         *Deal     `json:"deal"`
+}
+
+// FollowedBusiness is a follow sent to the user with its business
+type FollowedBusiness struct {
+	Followed_at time.Time            `json:"followed_at"`
+	Business    *BusinessUserWrapper `json:"business"`
+}
@@ -0,0 +1,36 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Status of a job run
+const (
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// JobRun is the history of one run of a background job
+type JobRun struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id"`
+	Job           string             `json:"job" bson:"job"`
+	Instance      string             `json:"instance" bson:"instance"`
+	Scheduled_for time.Time          `json:"scheduled_for" bson:"scheduled_for"`
+	Started_at    time.Time          `json:"started_at" bson:"started_at"`
+	Finished_at   *time.Time         `json:"finished_at,omitempty" bson:"finished_at,omitempty"`
+	Attempts      int                `json:"attempts" bson:"attempts"`
+	Status        string             `json:"status" bson:"status"`
+	Error         string             `json:"error,omitempty" bson:"error,omitempty"`
+}
+
+// JobLease makes sure only one server instance runs a job at a time
+// there is one lease document per job
+type JobLease struct {
+	Job           string    `bson:"_id"`
+	Owner         string    `bson:"owner"`
+	Locked_until  time.Time `bson:"locked_until"`
+	Last_schedule time.Time `bson:"last_schedule"`
+}
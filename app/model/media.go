@@ -0,0 +1,19 @@
+package model
+
+import (
+	"time"
+)
+
+// Image represents an uploaded image and its thumbnail kept in blob storage
+// the keys are used to find the files in storage and are not sent to the client
+type Image struct {
+	Key           string    `json:"-" bson:"key"`
+	Thumbnail_key string    `json:"-" bson:"thumbnail_key"`
+	Url           string    `json:"url" bson:"url"`
+	Thumbnail_url string    `json:"thumbnail_url" bson:"thumbnail_url"`
+	Content_type  string    `json:"content_type" bson:"content_type"`
+	Width         int       `json:"width" bson:"width"`
+	Height        int       `json:"height" bson:"height"`
+	Size          int64     `json:"size" bson:"size"`
+	Uploaded_at   time.Time `json:"uploaded_at" bson:"uploaded_at"`
+}
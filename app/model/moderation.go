@@ -0,0 +1,80 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Moderation status of deals, businesses and reviews. Content without a status was never flagged and is public
+const (
+	ModerationPending  = "pending"  // flagged by the pre-screen and held back until a moderator looks at it
+	ModerationApproved = "approved" // a moderator checked it, it is public
+	ModerationRejected = "rejected" // a moderator found it breaks the rules, editing it sends it back to the queue
+	ModerationHidden   = "hidden"   // hidden while it is looked at, e.g. after several reports
+)
+
+// What can be reported and moderated
+const (
+	ModerationTargetDeal     = "deal"
+	ModerationTargetBusiness = "business"
+	ModerationTargetReview   = "review"
+)
+
+// Status of a moderation case
+const (
+	CaseOpen     = "open"
+	CaseApproved = "approved"
+	CaseRejected = "rejected"
+)
+
+// Why a case was opened
+const (
+	CaseSourcePrescreen = "prescreen"
+	CaseSourceReport    = "report"
+)
+
+// ReportReasons are the reasons a user can give when reporting content
+var ReportReasons = []string{"spam", "offensive", "misleading", "scam", "other"}
+
+// IsPubliclyVisible checks if content with the moderation status can be shown to everyone
+func IsPubliclyVisible(status string) bool {
+	return status == "" || status == ModerationApproved
+}
+
+// PubliclyVisible is the filter on moderation_status for content that can be shown to everyone
+func PubliclyVisible() bson.M {
+	return bson.M{"$nin": bson.A{ModerationPending, ModerationRejected, ModerationHidden}}
+}
+
+// ModerationCase is an entry in the moderation queue, a piece of content has at most one open case.
+// Reports and pre-screen findings of the same content are added to it
+type ModerationCase struct {
+	ID            primitive.ObjectID  `json:"id" bson:"_id"`
+	Target_type   string              `json:"target_type" bson:"target_type"`
+	Target_id     primitive.ObjectID  `json:"target_id" bson:"target_id"`
+	Business_id   primitive.ObjectID  `json:"business_id" bson:"business_id"` // the business the content belongs to
+	Status        string              `json:"status" bson:"status"`
+	Sources       []string            `json:"sources" bson:"sources"`
+	Findings      []string            `json:"findings,omitempty" bson:"findings,omitempty"` // what the pre-screen found
+	Reports_count int                 `json:"reports_count" bson:"reports_count"`
+	Content       string              `json:"content" bson:"content"` // the text when the case was last updated
+	Created_at    time.Time           `json:"created_at" bson:"created_at"`
+	Updated_at    time.Time           `json:"updated_at" bson:"updated_at"`
+	Resolved_at   *time.Time          `json:"resolved_at,omitempty" bson:"resolved_at,omitempty"`
+	Resolved_by   *primitive.ObjectID `json:"resolved_by,omitempty" bson:"resolved_by,omitempty"`
+	Note          string              `json:"note,omitempty" bson:"note,omitempty"`
+}
+
+// Report is a user telling the moderators about a deal, business or review, a user reports a piece of content once
+type Report struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	Reporter_id primitive.ObjectID `json:"reporter_id" bson:"reporter_id"`
+	Target_type string             `json:"target_type" bson:"target_type"`
+	Target_id   primitive.ObjectID `json:"target_id" bson:"target_id"`
+	Reason      string             `json:"reason" bson:"reason"`
+	Details     string             `json:"details,omitempty" bson:"details,omitempty"`
+	Case_id     primitive.ObjectID `json:"case_id" bson:"case_id"`
+	Created_at  time.Time          `json:"created_at" bson:"created_at"`
+}
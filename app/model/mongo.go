@@ -21,9 +21,11 @@ type UpdateResult struct {
 type Collection interface {
 	CountDocuments(ctx context.Context, docs interface{}) (int64, error)
 	UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+	UpdateMany(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
 	InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (primitive.ObjectID, error)
 	FindOne(doc interface{}, ctx context.Context, filter interface{},opts ...*options.FindOneOptions) error
-	Find(ctx context.Context, filter interface{},opts ...*options.FindOneOptions) (*mongo.Cursor, error)
+	FindOneAndUpdate(doc interface{}, ctx context.Context, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) error
+	Find(ctx context.Context, filter interface{},opts ...*options.FindOptions) (*mongo.Cursor, error)
 	DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
 	DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
 }
@@ -0,0 +1,156 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Platforms a device can be registered for
+const (
+	PlatformIOS     = "ios"
+	PlatformAndroid = "android"
+)
+
+// Kinds of push notifications, each can be turned off in the [NotificationPreferences]
+const (
+	NotificationNewDeal      = "new_deal"      // a followed business posted a deal
+	NotificationDealStarting = "deal_starting" // a favorited deal is about to start
+	NotificationNearbyDeal   = "nearby_deal"   // the user is close to a live deal, only for users who opted in
+)
+
+// DefaultNearbyRadius is how close in meters a deal is for a nearby alert, about a few blocks.
+// Users can pick between 100 and 1000
+const DefaultNearbyRadius = 400
+
+// Status of a notification
+const (
+	NotificationPending    = "pending"    // waiting to be sent, maybe until quiet hours end
+	NotificationSent       = "sent"       // sent to at least one device
+	NotificationSuppressed = "suppressed" // not sent, see Reason
+	NotificationFailed     = "failed"     // every device failed
+)
+
+// Device is a phone a user gets push notifications on. A token belongs to one device,
+// registering it again (e.g. after logging in as someone else) moves it to the new user
+type Device struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id"`
+	User_id    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Token      string             `json:"token" bson:"token"`
+	Platform   string             `json:"platform" bson:"platform"`
+	Created_at time.Time          `json:"created_at" bson:"created_at"`
+	Updated_at time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// NotificationPreferences are what push notifications a user wants, users without any get [DefaultNotificationPreferences]
+type NotificationPreferences struct {
+	Enabled        bool        `json:"enabled" bson:"enabled"`
+	New_deals      bool        `json:"new_deals" bson:"new_deals"`
+	Deal_reminders bool        `json:"deal_reminders" bson:"deal_reminders"`
+	Nearby_alerts  bool        `json:"nearby_alerts" bson:"nearby_alerts"`
+	Nearby_radius  int         `json:"nearby_radius,omitempty" bson:"nearby_radius,omitempty"` // meters
+	Quiet_hours    *QuietHours `json:"quiet_hours,omitempty" bson:"quiet_hours,omitempty"`
+}
+
+// QuietHours is a time of day notifications wait for, e.g. 22:00 to 07:00 in America/Chicago
+type QuietHours struct {
+	Start     string `json:"start" bson:"start"` // HH:MM
+	End       string `json:"end" bson:"end"`     // HH:MM, before Start when the quiet hours go over midnight
+	Time_zone string `json:"time_zone" bson:"time_zone"`
+}
+
+// Notification is one push notification to a user, kept for a while so the same one is not sent twice
+// and so the number sent to a user can be limited
+type Notification struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id"`
+	User_id    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Kind       string             `json:"kind" bson:"kind"`
+	Dedup_key  string             `json:"-" bson:"dedup_key"`
+	Title      string             `json:"title" bson:"title"`
+	Body       string             `json:"body" bson:"body"`
+	Data       map[string]string  `json:"data,omitempty" bson:"data,omitempty"`
+	Status     string             `json:"status" bson:"status"`
+	Reason     string             `json:"reason,omitempty" bson:"reason,omitempty"`
+	Send_at    time.Time          `json:"send_at" bson:"send_at"`
+	Sent_at    *time.Time         `json:"sent_at,omitempty" bson:"sent_at,omitempty"`
+	Created_at time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// DefaultNotificationPreferences turns every notification but nearby alerts on with no quiet hours
+func DefaultNotificationPreferences() *NotificationPreferences {
+	return &NotificationPreferences{Enabled: true, New_deals: true, Deal_reminders: true}
+}
+
+// Wants checks if the user wants notifications of the kind
+func (p *NotificationPreferences) Wants(kind string) bool {
+	if !p.Enabled {
+		return false
+	}
+	switch kind {
+	case NotificationNewDeal:
+		return p.New_deals
+	case NotificationDealStarting:
+		return p.Deal_reminders
+	case NotificationNearbyDeal:
+		return p.Nearby_alerts
+	}
+	return true
+}
+
+// NearbyRadius is how close in meters a deal has to be for a nearby alert
+func (p *NotificationPreferences) NearbyRadius() int {
+	if p.Nearby_radius == 0 {
+		return DefaultNearbyRadius
+	}
+	return p.Nearby_radius
+}
+
+// Validate checks the times and the time zone
+func (q *QuietHours) Validate() error {
+	if _, err := time.Parse("15:04", q.Start); err != nil {
+		return errors.New("quiet hours start must be HH:MM")
+	}
+	if _, err := time.Parse("15:04", q.End); err != nil {
+		return errors.New("quiet hours end must be HH:MM")
+	}
+	if _, err := time.LoadLocation(q.Time_zone); err != nil || q.Time_zone == "" {
+		return errors.New("unknown time zone " + q.Time_zone)
+	}
+	return nil
+}
+
+// NextAllowed returns now if it is outside the quiet hours, otherwise when they end
+func (q *QuietHours) NextAllowed(now time.Time) time.Time {
+	location, err := time.LoadLocation(q.Time_zone)
+	if err != nil {
+		return now
+	}
+	start, errStart := time.Parse("15:04", q.Start)
+	end, errEnd := time.Parse("15:04", q.End)
+	if errStart != nil || errEnd != nil || q.Start == q.End {
+		return now
+	}
+
+	local := now.In(location)
+	minute := local.Hour()*60 + local.Minute()
+	startMinute := start.Hour()*60 + start.Minute()
+	endMinute := end.Hour()*60 + end.Minute()
+
+	quiet := false
+	if startMinute < endMinute {
+		quiet = minute >= startMinute && minute < endMinute
+	} else {
+		// the quiet hours go over midnight
+		quiet = minute >= startMinute || minute < endMinute
+	}
+	if !quiet {
+		return now
+	}
+
+	endsAt := time.Date(local.Year(), local.Month(), local.Day(), end.Hour(), end.Minute(), 0, 0, location)
+	if !endsAt.After(local) {
+		endsAt = time.Date(local.Year(), local.Month(), local.Day()+1, end.Hour(), end.Minute(), 0, 0, location)
+	}
+	return endsAt.UTC()
+}
@@ -0,0 +1,51 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Unlimited is the value of a plan limit without a maximum
+const Unlimited = -1
+
+// Limits a plan puts on a business
+const (
+	LimitPinnedDeals  = "pinned_deals"
+	LimitActiveDeals  = "active_deals" // live and scheduled deals, drafts and archived deals do not count
+	LimitStaffSeats   = "staff_seats"
+	LimitImageUploads = "image_uploads" // per calendar month
+	LimitWebhooks     = "webhooks"
+	// LimitPromotionBudget is the budget in cents of the promotions that have not ended, canceled ones do not count.
+	// Promotion spend is not charged through billing, the plan caps what a business can spend
+	LimitPromotionBudget = "promotion_budget"
+)
+
+// Plan is a subscription tier from the plan catalog
+type Plan struct {
+	ID     string           `json:"id"`
+	Name   string           `json:"name"`
+	Limits map[string]int64 `json:"limits"` // see [LimitPinnedDeals], a missing limit is 0 and [Unlimited] has no maximum
+}
+
+// Limit is the most the plan allows of limit, [Unlimited] when there is no maximum
+func (p *Plan) Limit(limit string) int64 {
+	return p.Limits[limit]
+}
+
+// Allows tells if a business on the plan that already has used of limit can add one more
+func (p *Plan) Allows(limit string, used int64) bool {
+	return p.AllowsMore(limit, used, 1)
+}
+
+// AllowsMore tells if a business on the plan that already has used of limit can add more
+func (p *Plan) AllowsMore(limit string, used int64, more int64) bool {
+	max := p.Limit(limit)
+	return max == Unlimited || used+more <= max
+}
+
+// PlanUsage counts what a business used of the limits that reset every month
+type PlanUsage struct {
+	ID            primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	Business_id   primitive.ObjectID `json:"-" bson:"business_id"`
+	Period        string             `json:"period" bson:"period"` // the month, e.g. 2024-01
+	Image_uploads int64              `json:"image_uploads" bson:"image_uploads"`
+}
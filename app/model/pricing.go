@@ -0,0 +1,189 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Pricing types that can be attached to a deal
+const (
+	PricingPercentOff = "percent_off" // e.g. 20% off
+	PricingAmountOff  = "amount_off"  // e.g. $5 off
+	PricingFixedPrice = "fixed_price" // e.g. $8 burger (normally $12)
+	PricingBOGO       = "bogo"        // e.g. buy one get one 50% off
+)
+
+// currencyMinorUnits maps the supported ISO 4217 currency codes to the number
+// of minor units (decimal places) the currency uses.
+// All prices on a deal are stored in minor units (cents for USD) to avoid floating point money
+var currencyMinorUnits = map[string]int{
+	"USD": 2,
+	"CAD": 2,
+	"MXN": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"AUD": 2,
+	"JPY": 0,
+}
+
+// DealPricing is the structured price of a deal.
+// Prices and amounts are in the minor units of Currency
+type DealPricing struct {
+	Type            string   `json:"type" bson:"type"`
+	Currency        string   `json:"currency" bson:"currency"`
+	Original_price  *int64   `json:"original_price,omitempty" bson:"original_price,omitempty"`
+	Deal_price      *int64   `json:"deal_price,omitempty" bson:"deal_price,omitempty"`
+	Percent_off     *float64 `json:"percent_off,omitempty" bson:"percent_off,omitempty"`
+	Amount_off      *int64   `json:"amount_off,omitempty" bson:"amount_off,omitempty"`
+	Buy_quantity    *int     `json:"buy_quantity,omitempty" bson:"buy_quantity,omitempty"`
+	Get_quantity    *int     `json:"get_quantity,omitempty" bson:"get_quantity,omitempty"`
+	Get_percent_off *float64 `json:"get_percent_off,omitempty" bson:"get_percent_off,omitempty"`
+}
+
+// Savings is computed from a deal's pricing and is only sent to the client, never stored
+type Savings struct {
+	Amount   *int64  `json:"amount,omitempty"`
+	Currency string  `json:"currency"`
+	Percent  float64 `json:"percent"`
+}
+
+// IsSupportedCurrency reports whether the ISO 4217 code is one we can price deals in
+func IsSupportedCurrency(code string) bool {
+	_, ok := currencyMinorUnits[code]
+	return ok
+}
+
+// Validate normalizes the pricing and checks that the values are consistent with each other.
+// Any value that can be derived from the others (e.g. the deal price of a percent off deal
+// with an original price) is filled in.
+func (p *DealPricing) Validate() error {
+	p.Currency = strings.ToUpper(strings.TrimSpace(p.Currency))
+	if !IsSupportedCurrency(p.Currency) {
+		return fmt.Errorf("unsupported currency %q", p.Currency)
+	}
+	if p.Original_price != nil && *p.Original_price <= 0 {
+		return errors.New("original_price must be greater than 0")
+	}
+	if p.Deal_price != nil && *p.Deal_price < 0 {
+		return errors.New("deal_price cannot be negative")
+	}
+
+	switch p.Type {
+	case PricingPercentOff:
+		if p.Percent_off == nil || *p.Percent_off <= 0 || *p.Percent_off > 100 {
+			return errors.New("percent_off must be greater than 0 and at most 100")
+		}
+		if p.Original_price != nil {
+			expected := int64(math.Round(float64(*p.Original_price) * (1 - *p.Percent_off/100)))
+			if p.Deal_price == nil {
+				p.Deal_price = &expected
+			} else if abs64(*p.Deal_price-expected) > 1 {
+				return errors.New("deal_price does not match original_price and percent_off")
+			}
+		} else if p.Deal_price != nil {
+			return errors.New("original_price is required when deal_price is given")
+		}
+	case PricingAmountOff:
+		if p.Amount_off == nil || *p.Amount_off <= 0 {
+			return errors.New("amount_off must be greater than 0")
+		}
+		if p.Original_price != nil {
+			if *p.Amount_off > *p.Original_price {
+				return errors.New("amount_off cannot be more than original_price")
+			}
+			expected := *p.Original_price - *p.Amount_off
+			if p.Deal_price == nil {
+				p.Deal_price = &expected
+			} else if *p.Deal_price != expected {
+				return errors.New("deal_price does not match original_price and amount_off")
+			}
+		} else if p.Deal_price != nil {
+			return errors.New("original_price is required when deal_price is given")
+		}
+	case PricingFixedPrice:
+		if p.Original_price == nil || p.Deal_price == nil {
+			return errors.New("original_price and deal_price are required for a fixed price deal")
+		}
+		if *p.Deal_price >= *p.Original_price {
+			return errors.New("deal_price must be less than original_price")
+		}
+	case PricingBOGO:
+		one := 1
+		hundred := 100.0
+		if p.Buy_quantity == nil {
+			p.Buy_quantity = &one
+		}
+		if p.Get_quantity == nil {
+			p.Get_quantity = &one
+		}
+		if p.Get_percent_off == nil {
+			p.Get_percent_off = &hundred
+		}
+		if *p.Buy_quantity < 1 || *p.Get_quantity < 1 {
+			return errors.New("buy_quantity and get_quantity must be at least 1")
+		}
+		if *p.Get_percent_off <= 0 || *p.Get_percent_off > 100 {
+			return errors.New("get_percent_off must be greater than 0 and at most 100")
+		}
+		if p.Deal_price != nil {
+			return errors.New("deal_price is not used for a bogo deal")
+		}
+	default:
+		return fmt.Errorf("unknown pricing type %q", p.Type)
+	}
+
+	return nil
+}
+
+// ComputeSavings works out how much a customer saves with this pricing.
+// Amount is only set when it can be known from the pricing (an original price was given)
+func (p *DealPricing) ComputeSavings() *Savings {
+	savings := &Savings{Currency: p.Currency}
+
+	switch p.Type {
+	case PricingPercentOff:
+		if p.Percent_off != nil {
+			savings.Percent = *p.Percent_off
+		}
+		if p.Original_price != nil && p.Deal_price != nil {
+			amount := *p.Original_price - *p.Deal_price
+			savings.Amount = &amount
+		}
+	case PricingAmountOff:
+		if p.Amount_off != nil {
+			amount := *p.Amount_off
+			savings.Amount = &amount
+			if p.Original_price != nil {
+				savings.Percent = float64(amount) / float64(*p.Original_price) * 100
+			}
+		}
+	case PricingFixedPrice:
+		if p.Original_price != nil && p.Deal_price != nil {
+			amount := *p.Original_price - *p.Deal_price
+			savings.Amount = &amount
+			savings.Percent = float64(amount) / float64(*p.Original_price) * 100
+		}
+	case PricingBOGO:
+		if p.Buy_quantity != nil && p.Get_quantity != nil && p.Get_percent_off != nil {
+			// Savings are spread over every item the customer walks out with
+			items := float64(*p.Buy_quantity + *p.Get_quantity)
+			savings.Percent = float64(*p.Get_quantity) * *p.Get_percent_off / items
+			if p.Original_price != nil {
+				amount := int64(math.Round(float64(*p.Original_price) * float64(*p.Get_quantity) * *p.Get_percent_off / 100))
+				savings.Amount = &amount
+			}
+		}
+	}
+
+	savings.Percent = math.Round(savings.Percent*100) / 100
+	return savings
+}
+
+func abs64(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
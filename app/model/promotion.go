@@ -0,0 +1,89 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Statuses a business sets on a promotion
+const (
+	PromotionActive   = "active"
+	PromotionPaused   = "paused"
+	PromotionCanceled = "canceled" // canceled promotions can not be started again
+)
+
+// States of a promotion that are worked out from its dates and budget, see [Promotion.CurrentState]
+const (
+	PromotionScheduled = "scheduled" // active but before its start date
+	PromotionExhausted = "exhausted" // the budget can not pay for another impression
+	PromotionEnded     = "ended"     // past its end date
+)
+
+const (
+	// MaxPromotionRadius is the largest radius in miles a promotion can target
+	MaxPromotionRadius = 25
+	// DefaultPromotionRadius is the radius in miles of a promotion that does not set one
+	DefaultPromotionRadius = 5
+	// MinPromotionBudget is the smallest budget of a promotion in cents
+	MinPromotionBudget = 500
+	// MaxSponsoredResults is how many sponsored businesses a nearby search shows at most
+	MaxSponsoredResults = 2
+)
+
+// Promotion pays for a deal to be shown as sponsored at the top of nearby searches within Radius miles of Location
+// between Start_date and End_date. Money is in cents, each impression and click is paid for out of Budget
+type Promotion struct {
+	ID             primitive.ObjectID `json:"id" bson:"_id"`
+	Business_id    primitive.ObjectID `json:"business_id" bson:"business_id"`
+	Deal_id        primitive.ObjectID `json:"deal_id" bson:"deal_id"`
+	Status         string             `json:"status" bson:"status"`
+	State          string             `json:"state" bson:"-"`
+	Budget         int64              `json:"budget" bson:"budget"`
+	Spent          int64              `json:"spent" bson:"spent"`
+	Impressions    int64              `json:"impressions" bson:"impressions"`
+	Clicks         int64              `json:"clicks" bson:"clicks"`
+	Location       *Location          `json:"location" bson:"location"`
+	Radius         float64            `json:"radius" bson:"radius"` // miles
+	Start_date     time.Time          `json:"start_date" bson:"start_date"`
+	End_date       time.Time          `json:"end_date" bson:"end_date"`
+	Last_served_at *time.Time         `json:"last_served_at,omitempty" bson:"last_served_at,omitempty"` // promotions shown longest ago go first
+	Created_at     time.Time          `json:"created_at" bson:"created_at"`
+	Updated_at     time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// CurrentState is the status of the promotion with its dates and budget taken into account,
+// impressionCost is what one impression is charged
+func (p *Promotion) CurrentState(now time.Time, impressionCost int64) string {
+	if p.Status != PromotionActive {
+		return p.Status
+	}
+	switch {
+	case !now.Before(p.End_date):
+		return PromotionEnded
+	case now.Before(p.Start_date):
+		return PromotionScheduled
+	case p.Spent+impressionCost > p.Budget:
+		return PromotionExhausted
+	}
+	return PromotionActive
+}
+
+// PromotionStats counts the impressions, clicks and spend of a promotion on one day (UTC)
+type PromotionStats struct {
+	ID           primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	Promotion_id primitive.ObjectID `json:"-" bson:"promotion_id"`
+	Day          string             `json:"day" bson:"day"` // 2006-01-02
+	Impressions  int64              `json:"impressions" bson:"impressions"`
+	Clicks       int64              `json:"clicks" bson:"clicks"`
+	Spent        int64              `json:"spent" bson:"spent"`
+}
+
+// PromotionClick records that a visitor clicked a promotion on a day, a visitor is only charged for once a day
+type PromotionClick struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty"`
+	Promotion_id primitive.ObjectID `bson:"promotion_id"`
+	Visitor      string             `bson:"visitor"`
+	Day          string             `bson:"day"`
+	Created_at   time.Time          `bson:"created_at"`
+}
@@ -0,0 +1,39 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Status of a redemption
+const (
+	RedemptionClaimed  = "claimed"
+	RedemptionRedeemed = "redeemed"
+)
+
+// Redemption is created when a user claims a deal and is redeemed by the business at the counter
+type Redemption struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	Deal_id     primitive.ObjectID `json:"deal_id" bson:"deal_id"`
+	Business_id primitive.ObjectID `json:"business_id" bson:"business_id"`
+	User_id     primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Code        string             `json:"code" bson:"code"`
+	Qr_payload  string             `json:"qr_payload,omitempty" bson:"-"`
+	Status      string             `json:"status" bson:"status"`
+	Claimed_at  time.Time          `json:"claimed_at" bson:"claimed_at"`
+	Expires_at  time.Time          `json:"expires_at" bson:"expires_at"`
+	Redeemed_at *time.Time         `json:"redeemed_at,omitempty" bson:"redeemed_at,omitempty"`
+	Deal_name   *string            `json:"deal_name,omitempty" bson:"deal_name,omitempty"`
+}
+
+// ClaimLimits is set by a business to control how often a user can claim a deal
+// a nil limit means there is no limit. The total number of claims is limited with [Deal.Quantity]
+type ClaimLimits struct {
+	Per_user_per_day *int `json:"per_user_per_day,omitempty" bson:"per_user_per_day,omitempty" validate:"omitempty,min=1"`
+}
+
+// IsExpired checks if the redemption code can no longer be used
+func (r *Redemption) IsExpired(now time.Time) bool {
+	return r.Status == RedemptionClaimed && now.After(r.Expires_at)
+}
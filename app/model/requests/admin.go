@@ -0,0 +1,16 @@
+package model
+
+import (
+	"github.com/go-playground/validator/v10"
+)
+
+// Suspension is sent by an admin to suspend an account
+type Suspension struct {
+	Reason *string `json:"reason" validate:"required,min=1,max=500"`
+}
+
+// ValidateSuspensionStruct validates a Suspension struct
+func ValidateSuspensionStruct(suspension *Suspension) error {
+	validate := validator.New()
+	return validate.Struct(suspension)
+}
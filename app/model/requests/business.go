@@ -17,6 +17,7 @@ type Business struct {
 	Latitude			*float64					 `json:"latitude"`
 	Longitude			*float64					 `json:"longitude"`
 	Description	  *string						 `json:"description"`	
+	Phone_number	*string						 `json:"phone_number" validate:"omitempty,e164"`
 }
 
 type BusinessUserUpdate struct {
@@ -28,6 +29,13 @@ type BusinessUserUpdate struct {
 	Address       *model.Address `json:"address"`
 	Location      *model.Location `json:"location"`
 	Description   *string   `json:"description"`	
+	Phone_number  *string   `json:"phone_number" validate:"omitempty,e164"` // e.g. +13125550100
+}
+
+// ValidateBusinessUserUpdateStruct validates a BusinessUserUpdate struct
+func ValidateBusinessUserUpdateStruct(b *BusinessUserUpdate) error {
+	validate := validator.New()
+	return validate.Struct(b)
 }
 
 // ValidateLocationStruct validates a Location struct
@@ -51,5 +59,6 @@ func NewBusinessUser(b Business) *model.BusinessUser {
 		Password:				 b.Password,
 		Email: 					 b.Email,
 		Address:				b.Address,
+		Phone_number:		b.Phone_number,
 	}
 }
\ No newline at end of file
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"fmt"
 	"time"
 	"github.com/CoffeeHausGames/whir-server/app/model"
 	"github.com/go-playground/validator/v10"
@@ -17,6 +18,37 @@ type Deal struct {
 	Start_date  *time.Time         `json:"start_date"`
 	End_date  *time.Time         	 `json:"end_date"`
 	Description *string            `json:"description"`
+	Pricing     *model.DealPricing `json:"pricing"`
+	Claim_limits *ClaimLimits       `json:"claim_limits"`
+	Quantity    *int               `json:"quantity" validate:"omitempty,min=1"`
+	Status      *string            `json:"status" validate:"omitempty,oneof=draft scheduled live"`
+	Publish_at  *time.Time         `json:"publish_at"`
+	Categories  []string           `json:"categories" validate:"omitempty,max=3"`
+}
+
+// ClaimLimits are the claim limits sent for a deal. Total is how the total number of claims was limited before
+// deals had a quantity, it is still accepted and becomes the quantity when no quantity is sent
+type ClaimLimits struct {
+	model.ClaimLimits
+	Total *int `json:"total" validate:"omitempty,min=1"`
+}
+
+// DealStatusChange is sent to move a deal along its lifecycle
+type DealStatusChange struct {
+	ID          primitive.ObjectID `json:"id" validate:"required"`
+	Status      string             `json:"status" validate:"required,oneof=draft scheduled live expired archived"`
+	Publish_at  *time.Time         `json:"publish_at"`
+}
+
+// ValidateDealStatusChangeStruct validates a DealStatusChange struct
+func ValidateDealStatusChangeStruct(d *DealStatusChange) error {
+	validate := validator.New()
+
+	if err := validate.Struct(d); err != nil {
+		return err
+	}
+
+	return nil
 }
 
 // ValidateLocationStruct validates a Location struct
@@ -27,10 +59,33 @@ func ValidateDealStruct(d *Deal) error {
 		return err
 	}
 
+	if d.Pricing != nil {
+		if err := d.Pricing.Validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, category := range d.Categories {
+		if !model.IsDealCategory(category) {
+			return fmt.Errorf("unknown deal category %q", category)
+		}
+	}
+
 	return nil
 }
 
 func NewDeal(d Deal) *model.Deal {
+	var claimLimits *model.ClaimLimits
+	quantity := d.Quantity
+	if d.Claim_limits != nil {
+		if d.Claim_limits.Per_user_per_day != nil {
+			claimLimits = &d.Claim_limits.ClaimLimits
+		}
+		if quantity == nil {
+			quantity = d.Claim_limits.Total
+		}
+	}
+
 	return &model.Deal{
 		ID: 					d.ID,
 		Business_id:  d.Business_id,
@@ -41,5 +96,9 @@ func NewDeal(d Deal) *model.Deal {
 		Start_date: 	d.Start_date,
 		End_date:     d.End_date,
 		Description:	d.Description,
+		Pricing:			d.Pricing,
+		Claim_limits: claimLimits,
+		Quantity:			quantity,
+		Categories:		d.Categories,
 	}
 }
\ No newline at end of file
@@ -0,0 +1,32 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Export is sent by an admin to export a dataset, optionally only the rows of a business in a time range.
+// The format defaults to csv
+type Export struct {
+	Dataset     *string             `json:"dataset" validate:"required,oneof=deals businesses redemptions analytics_hourly analytics_daily"`
+	Format      *string             `json:"format" validate:"omitempty,oneof=csv parquet"`
+	Business_id *primitive.ObjectID `json:"business_id"`
+	From        *time.Time          `json:"from"`
+	To          *time.Time          `json:"to"`
+}
+
+// ValidateExportStruct validates an Export struct
+func ValidateExportStruct(e *Export) error {
+	validate := validator.New()
+	if err := validate.Struct(e); err != nil {
+		return err
+	}
+
+	if e.From != nil && e.To != nil && !e.To.After(*e.From) {
+		return errors.New("to must be after from")
+	}
+	return nil
+}
@@ -11,6 +11,10 @@ type Location struct {
 	Latitude  *float64           `json:"latitude" validate:"required,latitude"`
 	Longitude *float64           `json:"longitude" validate:"required,longitude"`
 	Radius    *float64           `json:"radius"`
+	Sort_by   *string            `json:"sort_by" validate:"omitempty,oneof=distance savings rating"`
+	Min_savings_percent *float64 `json:"min_savings_percent" validate:"omitempty,min=0,max=100"`
+	Currency  *string            `json:"currency" validate:"omitempty,len=3"`
+	Verified_first *bool         `json:"verified_first"` // verified businesses go before the rest, each group keeps the sort order
 }
 
 // ValidateLocationStruct validates a Location struct
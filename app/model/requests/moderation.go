@@ -0,0 +1,30 @@
+package model
+
+import (
+	"github.com/go-playground/validator/v10"
+)
+
+// Report is sent by a user to report a deal, business or review to the moderators
+type Report struct {
+	Target_type *string `json:"target_type" validate:"required,oneof=deal business review"`
+	Target_id   *string `json:"target_id" validate:"required,len=24,hexadecimal"`
+	Reason      *string `json:"reason" validate:"required,oneof=spam offensive misleading scam other"`
+	Details     *string `json:"details" validate:"omitempty,max=1000"`
+}
+
+// ModerationDecision is sent by a moderator when deciding a case
+type ModerationDecision struct {
+	Note *string `json:"note" validate:"omitempty,max=1000"`
+}
+
+// ValidateReportStruct validates a Report struct
+func ValidateReportStruct(report *Report) error {
+	validate := validator.New()
+	return validate.Struct(report)
+}
+
+// ValidateModerationDecisionStruct validates a ModerationDecision struct
+func ValidateModerationDecisionStruct(decision *ModerationDecision) error {
+	validate := validator.New()
+	return validate.Struct(decision)
+}
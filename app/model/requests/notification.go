@@ -0,0 +1,84 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/go-playground/validator/v10"
+)
+
+// Device is sent to register a device for push notifications
+type Device struct {
+	Token    *string `json:"token" validate:"required,min=1,max=4096"`
+	Platform *string `json:"platform" validate:"required,oneof=ios android"`
+}
+
+// NotificationPreferences is sent to change the push notification preferences of a user,
+// fields that are left out are not changed and "quiet_hours": null removes the quiet hours
+type NotificationPreferences struct {
+	Enabled        *bool           `json:"enabled"`
+	New_deals      *bool           `json:"new_deals"`
+	Deal_reminders *bool           `json:"deal_reminders"`
+	Nearby_alerts  *bool           `json:"nearby_alerts"`
+	Nearby_radius  *int            `json:"nearby_radius" validate:"omitempty,min=100,max=1000"`
+	Quiet_hours    json.RawMessage `json:"quiet_hours"`
+}
+
+// LocationReport is sent by the app when the user moved, it is only used to look for nearby deals and is not saved
+type LocationReport struct {
+	Latitude  *float64 `json:"latitude" validate:"required,latitude"`
+	Longitude *float64 `json:"longitude" validate:"required,longitude"`
+}
+
+// ValidateDeviceStruct validates a Device struct
+func ValidateDeviceStruct(device *Device) error {
+	validate := validator.New()
+	return validate.Struct(device)
+}
+
+// ValidateLocationReportStruct validates a LocationReport struct
+func ValidateLocationReportStruct(report *LocationReport) error {
+	validate := validator.New()
+	return validate.Struct(report)
+}
+
+// Apply validates the request and changes preferences with it
+func (p *NotificationPreferences) Apply(preferences *model.NotificationPreferences) error {
+	validate := validator.New()
+	if err := validate.Struct(p); err != nil {
+		return err
+	}
+
+	if p.Quiet_hours != nil {
+		if bytes.Equal(bytes.TrimSpace(p.Quiet_hours), []byte("null")) {
+			preferences.Quiet_hours = nil
+		} else {
+			quietHours := new(model.QuietHours)
+			if err := json.Unmarshal(p.Quiet_hours, quietHours); err != nil {
+				return err
+			}
+			if err := quietHours.Validate(); err != nil {
+				return err
+			}
+			preferences.Quiet_hours = quietHours
+		}
+	}
+
+	if p.Enabled != nil {
+		preferences.Enabled = *p.Enabled
+	}
+	if p.New_deals != nil {
+		preferences.New_deals = *p.New_deals
+	}
+	if p.Deal_reminders != nil {
+		preferences.Deal_reminders = *p.Deal_reminders
+	}
+	if p.Nearby_alerts != nil {
+		preferences.Nearby_alerts = *p.Nearby_alerts
+	}
+	if p.Nearby_radius != nil {
+		preferences.Nearby_radius = *p.Nearby_radius
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+package model
+
+import (
+	"github.com/go-playground/validator/v10"
+)
+
+// PlanChange is sent by an admin to move a business to another plan
+type PlanChange struct {
+	Plan *string `json:"plan" validate:"required,min=1,max=100"`
+}
+
+// ValidatePlanChangeStruct validates a PlanChange struct
+func ValidatePlanChangeStruct(change *PlanChange) error {
+	validate := validator.New()
+	return validate.Struct(change)
+}
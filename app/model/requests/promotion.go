@@ -0,0 +1,67 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Promotion is sent to create or change a promotion. Budget is in cents and radius in miles,
+// a promotion without a location targets the location of the business
+type Promotion struct {
+	Deal_id    *primitive.ObjectID `json:"deal_id"`
+	Budget     *int64              `json:"budget" validate:"omitempty,min=500"`
+	Latitude   *float64            `json:"latitude" validate:"required_with=Longitude,omitempty,latitude"`
+	Longitude  *float64            `json:"longitude" validate:"required_with=Latitude,omitempty,longitude"`
+	Radius     *float64            `json:"radius" validate:"omitempty,gt=0,max=25"`
+	Start_date *time.Time          `json:"start_date"`
+	End_date   *time.Time          `json:"end_date"`
+	Status     *string             `json:"status" validate:"omitempty,oneof=active paused"`
+}
+
+// ValidatePromotionStruct validates a Promotion struct
+func ValidatePromotionStruct(p *Promotion) error {
+	validate := validator.New()
+	if err := validate.Struct(p); err != nil {
+		return err
+	}
+
+	if p.Start_date != nil && p.End_date != nil && !p.End_date.After(*p.Start_date) {
+		return errors.New("end_date must be after start_date")
+	}
+	return nil
+}
+
+// NewPromotion creates the promotion of a deal of the business from the request,
+// it starts at now unless a start date is given
+func NewPromotion(p Promotion, business *model.BusinessUser, deal *model.Deal, now time.Time) *model.Promotion {
+	promotion := &model.Promotion{
+		ID:          primitive.NewObjectID(),
+		Business_id: business.ID,
+		Deal_id:     deal.ID,
+		Status:      model.PromotionActive,
+		Budget:      *p.Budget,
+		Location:    business.Location,
+		Radius:      model.DefaultPromotionRadius,
+		Start_date:  now,
+		End_date:    p.End_date.UTC(),
+		Created_at:  now,
+		Updated_at:  now,
+	}
+	if p.Latitude != nil {
+		promotion.Location = &model.Location{Type: "Point", Coordinates: []float64{*p.Longitude, *p.Latitude}}
+	}
+	if p.Radius != nil {
+		promotion.Radius = *p.Radius
+	}
+	if p.Start_date != nil {
+		promotion.Start_date = p.Start_date.UTC()
+	}
+	if p.Status != nil {
+		promotion.Status = *p.Status
+	}
+	return promotion
+}
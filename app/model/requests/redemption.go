@@ -0,0 +1,40 @@
+package model
+
+import (
+	"errors"
+
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Claim is sent by a user to claim a deal
+type Claim struct {
+	Deal_id primitive.ObjectID `json:"deal_id" validate:"required"`
+}
+
+// Redeem is sent by a business to verify or redeem a code
+// either the typed in code or the scanned QR payload is needed
+type Redeem struct {
+	Code       *string `json:"code"`
+	Qr_payload *string `json:"qr_payload"`
+}
+
+// ValidateClaimStruct validates a Claim struct
+func ValidateClaimStruct(c *Claim) error {
+	validate := validator.New()
+
+	if err := validate.Struct(c); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ValidateRedeemStruct validates a Redeem struct
+func ValidateRedeemStruct(r *Redeem) error {
+	if (r.Code == nil || *r.Code == "") && (r.Qr_payload == nil || *r.Qr_payload == "") {
+		return errors.New("code or qr_payload is required")
+	}
+
+	return nil
+}
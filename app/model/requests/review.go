@@ -0,0 +1,42 @@
+package model
+
+import (
+	"github.com/go-playground/validator/v10"
+)
+
+// Review is sent to review a business or a deal
+type Review struct {
+	Target_type *string `json:"target_type" validate:"required,oneof=business deal"`
+	Target_id   *string `json:"target_id" validate:"required,len=24,hexadecimal"`
+	Rating      *int    `json:"rating" validate:"required,min=1,max=5"`
+	Text        *string `json:"text" validate:"omitempty,max=2000"`
+}
+
+// ReviewEdit is sent to change a review, fields that are left out are not changed
+type ReviewEdit struct {
+	Rating *int    `json:"rating" validate:"omitempty,min=1,max=5"`
+	Text   *string `json:"text" validate:"omitempty,max=2000"`
+}
+
+// ReviewReply is sent by a business to answer a review
+type ReviewReply struct {
+	Text *string `json:"text" validate:"required,min=1,max=1000"`
+}
+
+// ValidateReviewStruct validates a Review struct
+func ValidateReviewStruct(review *Review) error {
+	validate := validator.New()
+	return validate.Struct(review)
+}
+
+// ValidateReviewEditStruct validates a ReviewEdit struct
+func ValidateReviewEditStruct(edit *ReviewEdit) error {
+	validate := validator.New()
+	return validate.Struct(edit)
+}
+
+// ValidateReviewReplyStruct validates a ReviewReply struct
+func ValidateReviewReplyStruct(reply *ReviewReply) error {
+	validate := validator.New()
+	return validate.Struct(reply)
+}
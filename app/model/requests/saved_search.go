@@ -0,0 +1,63 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SavedSearch is sent to save a search, radius is in miles
+type SavedSearch struct {
+	Name       *string  `json:"name" validate:"required,min=1,max=100"`
+	Latitude   *float64 `json:"latitude" validate:"required,latitude"`
+	Longitude  *float64 `json:"longitude" validate:"required,longitude"`
+	Radius     *float64 `json:"radius" validate:"required,gt=0,max=25"`
+	Categories []string `json:"categories" validate:"omitempty,max=5"`
+	Query      *string  `json:"query" validate:"omitempty,max=100"`
+	Channels   []string `json:"channels" validate:"omitempty,max=2,dive,oneof=push email"`
+}
+
+// ValidateSavedSearchStruct validates a SavedSearch struct
+func ValidateSavedSearchStruct(s *SavedSearch) error {
+	validate := validator.New()
+	if err := validate.Struct(s); err != nil {
+		return err
+	}
+
+	for _, category := range s.Categories {
+		if !model.IsDealCategory(category) {
+			return fmt.Errorf("unknown deal category %q", category)
+		}
+	}
+	return nil
+}
+
+// NewSavedSearch creates the saved search of the user from the request, it alerts with push notifications
+// unless other channels are asked for
+func NewSavedSearch(s SavedSearch, userID primitive.ObjectID, now time.Time) *model.SavedSearch {
+	search := &model.SavedSearch{
+		ID:      primitive.NewObjectID(),
+		User_id: userID,
+		Name:    strings.TrimSpace(*s.Name),
+		Location: &model.Location{
+			Type:        "Point",
+			Coordinates: []float64{*s.Longitude, *s.Latitude},
+		},
+		Radius:     *s.Radius,
+		Categories: s.Categories,
+		Channels:   []string{model.SearchChannelPush},
+		Created_at: now,
+		Updated_at: now,
+	}
+	if s.Query != nil {
+		search.Query = strings.TrimSpace(*s.Query)
+	}
+	if len(s.Channels) > 0 {
+		search.Channels = s.Channels
+	}
+	return search
+}
@@ -0,0 +1,27 @@
+package model
+
+import (
+	"github.com/go-playground/validator/v10"
+)
+
+// VerificationCode is the code a business got in a call or on a postcard
+type VerificationCode struct {
+	Code *string `json:"code" validate:"required,len=6,numeric"`
+}
+
+// VerificationDecision is sent by an admin when approving or rejecting a verification request
+type VerificationDecision struct {
+	Note *string `json:"note" validate:"omitempty,max=1000"`
+}
+
+// ValidateVerificationCodeStruct validates a VerificationCode struct
+func ValidateVerificationCodeStruct(code *VerificationCode) error {
+	validate := validator.New()
+	return validate.Struct(code)
+}
+
+// ValidateVerificationDecisionStruct validates a VerificationDecision struct
+func ValidateVerificationDecisionStruct(decision *VerificationDecision) error {
+	validate := validator.New()
+	return validate.Struct(decision)
+}
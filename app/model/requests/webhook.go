@@ -0,0 +1,47 @@
+package model
+
+import (
+	"errors"
+	"net/url"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/go-playground/validator/v10"
+)
+
+// Webhook is sent to create or change a webhook subscription
+type Webhook struct {
+	Url    *string  `json:"url" validate:"omitempty,url,max=2048"`
+	Events []string `json:"events" validate:"omitempty,dive,required"`
+	Active *bool    `json:"active"`
+}
+
+// ValidateWebhookStruct validates a Webhook struct
+func ValidateWebhookStruct(wh *Webhook) error {
+	validate := validator.New()
+
+	if err := validate.Struct(wh); err != nil {
+		return err
+	}
+
+	if wh.Url != nil {
+		u, err := url.Parse(*wh.Url)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return errors.New("url must be an http or https URL")
+		}
+	}
+
+	for _, event := range wh.Events {
+		known := false
+		for _, eventType := range model.WebhookEventTypes {
+			if event == eventType {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return errors.New("unknown webhook event " + event)
+		}
+	}
+
+	return nil
+}
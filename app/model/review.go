@@ -0,0 +1,88 @@
+package model
+
+import (
+	"math"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// What a review can be about
+const (
+	ReviewTargetBusiness = "business"
+	ReviewTargetDeal     = "deal"
+)
+
+// MaxReviewHistory is how many earlier versions of a review are kept
+const MaxReviewHistory = 20
+
+// Review is what a customer thought of a business or one of its deals. A user reviews each business
+// and each deal once, edits keep the earlier versions in History. Only users who redeemed a deal
+// of the business can review it, so every review is from a verified customer
+type Review struct {
+	ID                primitive.ObjectID `json:"id" bson:"_id"`
+	User_id           primitive.ObjectID `json:"-" bson:"user_id"`
+	Author_name       string             `json:"author_name" bson:"author_name"`
+	Business_id       primitive.ObjectID `json:"business_id" bson:"business_id"`
+	Target_type       string             `json:"target_type" bson:"target_type"`
+	Target_id         primitive.ObjectID `json:"target_id" bson:"target_id"` // the business or the deal
+	Rating            int                `json:"rating" bson:"rating"`
+	Text              string             `json:"text,omitempty" bson:"text,omitempty"`
+	Photo             *Image             `json:"photo,omitempty" bson:"photo,omitempty"`
+	History           []ReviewRevision   `json:"history,omitempty" bson:"history,omitempty"`
+	Reply             *ReviewReply       `json:"reply,omitempty" bson:"reply,omitempty"`
+	Moderation_status string             `json:"moderation_status,omitempty" bson:"moderation_status,omitempty"` // see [ModerationPending]
+	Created_at        time.Time          `json:"created_at" bson:"created_at"`
+	Updated_at        time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// ReviewRevision is an earlier version of an edited review
+type ReviewRevision struct {
+	Rating    int       `json:"rating" bson:"rating"`
+	Text      string    `json:"text,omitempty" bson:"text,omitempty"`
+	Edited_at time.Time `json:"edited_at" bson:"edited_at"` // when this version was replaced
+}
+
+// ReviewReply is the answer of the business to a review
+type ReviewReply struct {
+	Text       string    `json:"text" bson:"text"`
+	Created_at time.Time `json:"created_at" bson:"created_at"`
+	Updated_at time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// BusinessRating is the running total of the reviews of a business, kept on the business so nearby searches
+// do not have to count reviews. It is changed with $inc as reviews come and go. Reviews of a deal are kept
+// on the deal in the same way and are not part of the rating of its business, a customer who reviews the
+// business and some of its deals counts once towards the business
+type BusinessRating struct {
+	Sum   int64 `json:"-" bson:"sum"`
+	Count int64 `json:"-" bson:"count"`
+}
+
+// RatingSummary is the rating of a business or a deal sent to the client
+type RatingSummary struct {
+	Average float64 `json:"average"` // rounded to one decimal
+	Count   int64   `json:"count"`
+}
+
+// Summary returns the average rating, nil when there are no reviews
+func (r *BusinessRating) Summary() *RatingSummary {
+	if r == nil || r.Count <= 0 {
+		return nil
+	}
+	average := float64(r.Sum) / float64(r.Count)
+	return &RatingSummary{Average: math.Round(average*10) / 10, Count: r.Count}
+}
+
+// Revision returns the current version of the review to keep in its history before it is edited
+func (r *Review) Revision(now time.Time) ReviewRevision {
+	return ReviewRevision{Rating: r.Rating, Text: r.Text, Edited_at: now}
+}
+
+// RatingContribution is what the review adds to the rating of its business or deal, reviews that are not public do not count
+func (r *Review) RatingContribution() (int64, int64) {
+	if !IsPubliclyVisible(r.Moderation_status) {
+		return 0, 0
+	}
+	return int64(r.Rating), 1
+}
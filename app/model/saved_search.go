@@ -0,0 +1,107 @@
+package model
+
+import (
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Channels a saved search can alert on
+const (
+	SearchChannelPush  = "push"
+	SearchChannelEmail = "email"
+)
+
+// NotificationSavedSearch is the kind of push notification about a new match of a saved search.
+// Saved searches are turned off by pausing them so there is no preference for it
+const NotificationSavedSearch = "saved_search"
+
+const (
+	// MaxSavedSearchRadius is the largest radius in miles a saved search can have
+	MaxSavedSearchRadius = 25
+	// MaxSavedSearches is how many saved searches a user can have
+	MaxSavedSearches = 10
+)
+
+// SavedSearch is a search a user wants to hear about when a new deal matches it.
+// A deal matches when its business is within Radius miles of Location, it is in one of the
+// Categories and its name or description has every word of Query. Empty Categories or Query match every deal
+type SavedSearch struct {
+	ID              primitive.ObjectID `json:"id" bson:"_id"`
+	User_id         primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Name            string             `json:"name" bson:"name"`
+	Location        *Location          `json:"location" bson:"location"`
+	Radius          float64            `json:"radius" bson:"radius"` // miles
+	Categories      []string           `json:"categories,omitempty" bson:"categories,omitempty"`
+	Query           string             `json:"query,omitempty" bson:"query,omitempty"`
+	Channels        []string           `json:"channels" bson:"channels"`
+	Paused          bool               `json:"paused" bson:"paused"`
+	Last_matched_at *time.Time         `json:"last_matched_at,omitempty" bson:"last_matched_at,omitempty"`
+	Created_at      time.Time          `json:"created_at" bson:"created_at"`
+	Updated_at      time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// SavedSearchMatch is a deal that matched a saved search, a deal matches a search once
+type SavedSearchMatch struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	Search_id   primitive.ObjectID `json:"search_id" bson:"search_id"`
+	User_id     primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Deal_id     primitive.ObjectID `json:"deal_id" bson:"deal_id"`
+	Business_id primitive.ObjectID `json:"business_id" bson:"business_id"`
+	Notified    bool               `json:"notified" bson:"notified"`
+	Matched_at  time.Time          `json:"matched_at" bson:"matched_at"`
+}
+
+// SavedSearchMatchDeal is a match sent to the user with its deal
+type SavedSearchMatchDeal struct {
+	Matched_at time.Time `json:"matched_at"`
+	Deal       *Deal     `json:"deal"`
+}
+
+// MatchesDeal checks the categories and the query of the search, the distance is checked by the caller
+func (s *SavedSearch) MatchesDeal(deal *Deal) bool {
+	if len(s.Categories) > 0 && !sharesCategory(s.Categories, deal.Categories) {
+		return false
+	}
+
+	text := strings.ToLower(dealText(deal))
+	for _, word := range strings.Fields(strings.ToLower(s.Query)) {
+		if !strings.Contains(text, word) {
+			return false
+		}
+	}
+	return true
+}
+
+// HasChannel checks if the search alerts on the channel
+func (s *SavedSearch) HasChannel(channel string) bool {
+	for _, c := range s.Channels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+func sharesCategory(wanted []string, categories []string) bool {
+	for _, w := range wanted {
+		for _, c := range categories {
+			if w == c {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func dealText(deal *Deal) string {
+	text := ""
+	if deal.Name != nil {
+		text += *deal.Name
+	}
+	if deal.Description != nil {
+		text += " " + *deal.Description
+	}
+	return text
+}
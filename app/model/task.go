@@ -0,0 +1,39 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Status of a task in the queue
+const (
+	TaskPending = "pending" // waiting for Run_at
+	TaskLeased  = "leased"  // being worked on until Leased_until
+	TaskDone    = "done"
+	TaskDead    = "dead" // failed too many times, kept in the dead letter collection
+)
+
+// Task is a unit of background work stored in the task queue
+type Task struct {
+	ID              primitive.ObjectID `json:"id" bson:"_id"`
+	Type            string             `json:"type" bson:"type"`
+	Payload         bson.Raw           `json:"-" bson:"payload"`
+	Status          string             `json:"status" bson:"status"`
+	Attempts        int                `json:"attempts" bson:"attempts"`
+	Max_attempts    int                `json:"max_attempts" bson:"max_attempts"`
+	Run_at          time.Time          `json:"run_at" bson:"run_at"`
+	Leased_until    *time.Time         `json:"leased_until,omitempty" bson:"leased_until,omitempty"`
+	Lease_owner     string             `json:"lease_owner,omitempty" bson:"lease_owner,omitempty"`
+	Last_error      string             `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	Idempotency_key *string            `json:"idempotency_key,omitempty" bson:"idempotency_key,omitempty"`
+	Created_at      time.Time          `json:"created_at" bson:"created_at"`
+	Updated_at      time.Time          `json:"updated_at" bson:"updated_at"`
+	Completed_at    *time.Time         `json:"completed_at,omitempty" bson:"completed_at,omitempty"`
+}
+
+// DecodePayload unmarshals the payload of the task into v
+func (t *Task) DecodePayload(v interface{}) error {
+	return bson.Unmarshal(t.Payload, v)
+}
@@ -16,6 +16,7 @@ type UserInterface interface {
     SetToken(token string)
     GetRefreshToken() *string
     SetRefreshToken(refreshToken string)
+    IsSuspended() bool
 }
 
 //User is the model that governs all account objects retrieved or inserted into the DB
@@ -30,8 +31,17 @@ type User struct {
     Refresh_token *string            `json:"refresh_token"`
     Created_at    time.Time          `json:"created_at"`
     Updated_at    time.Time          `json:"updated_at"`
+    Tokens_revoked_at *time.Time     `json:"-" bson:"tokens_revoked_at,omitempty"`
+    Notification_preferences *NotificationPreferences `json:"-" bson:"notification_preferences,omitempty"`
+    Nearby_checked_at *time.Time     `json:"-" bson:"nearby_checked_at,omitempty"` // only when, never where
+    Role          string             `json:"-" bson:"role,omitempty"` // empty for customers, see [RoleAdmin]
+    Suspended_at  *time.Time         `json:"-" bson:"suspended_at,omitempty"` // set by an admin, suspended accounts can not sign in
+    Suspension_reason string         `json:"-" bson:"suspension_reason,omitempty"`
 }
 
+// RoleAdmin is the role of the platform administrators, it is only given out in the database
+const RoleAdmin = "admin"
+
 //UserWrapper is the model that represents the user to be sent to the frontend
 type UserWrapper struct {
 	First_name    *string            `json:"first_name,omitempty"`
@@ -49,6 +59,14 @@ func NewUser(user *User) *UserWrapper {
 	}
 }
 
+// GetNotificationPreferences returns the push notification preferences of the user or the defaults
+func (u *User) GetNotificationPreferences() *NotificationPreferences {
+    if u.Notification_preferences == nil {
+        return DefaultNotificationPreferences()
+    }
+    return u.Notification_preferences
+}
+
 func (u *User) GetEmail() *string {
     return u.Email
 }
@@ -84,3 +102,7 @@ func (u *User) GetRefreshToken() *string {
 func (u *User) SetRefreshToken(refreshToken string) {
     u.Refresh_token = &refreshToken
 }
+
+func (u *User) IsSuspended() bool {
+    return u.Suspended_at != nil
+}
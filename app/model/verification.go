@@ -0,0 +1,74 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Verification statuses of a business, a business that never asked to be verified has no status.
+// A business is pending once it sent evidence and verified when an admin approved it
+const (
+	BusinessUnverified          = "unverified"
+	BusinessVerificationPending = "pending"
+	BusinessVerified            = "verified"
+)
+
+// How a business shows it is real and runs the listing
+const (
+	EvidenceDocument = "document" // e.g. a business license or utility bill
+	EvidencePhone    = "phone"    // a code read out in a call to the business phone
+	EvidencePostcard = "postcard" // a code mailed to the business address
+)
+
+// States of a verification request
+const (
+	VerificationAwaitingCode = "awaiting_code" // the code was sent by phone or postcard
+	VerificationSubmitted    = "submitted"     // waiting for an admin
+	VerificationApproved     = "approved"
+	VerificationRejected     = "rejected"
+	VerificationExpired      = "expired"  // the code expired or was entered wrong too often
+	VerificationCanceled     = "canceled" // replaced by a newer request
+)
+
+// VerificationRequest is the evidence a business sent to get verified
+type VerificationRequest struct {
+	ID                primitive.ObjectID    `json:"id" bson:"_id"`
+	Business_id       primitive.ObjectID    `json:"business_id" bson:"business_id"`
+	Method            string                `json:"method" bson:"method"` // see [EvidenceDocument]
+	Status            string                `json:"status" bson:"status"` // see [VerificationSubmitted]
+	Document          *VerificationDocument `json:"document,omitempty" bson:"document,omitempty"`
+	Phone_number      string                `json:"phone_number,omitempty" bson:"phone_number,omitempty"`
+	Address           *Address              `json:"address,omitempty" bson:"address,omitempty"` // where the postcard was sent
+	Code_hash         string                `json:"-" bson:"code_hash,omitempty"`
+	Code_attempts     int                   `json:"-" bson:"code_attempts"`
+	Code_expires_at   *time.Time            `json:"code_expires_at,omitempty" bson:"code_expires_at,omitempty"`
+	Code_confirmed_at *time.Time            `json:"code_confirmed_at,omitempty" bson:"code_confirmed_at,omitempty"`
+	Note              string                `json:"note,omitempty" bson:"note,omitempty"` // why an admin decided the way they did
+	Reviewed_by       *primitive.ObjectID   `json:"reviewed_by,omitempty" bson:"reviewed_by,omitempty"`
+	Reviewed_at       *time.Time            `json:"reviewed_at,omitempty" bson:"reviewed_at,omitempty"`
+	Created_at        time.Time             `json:"created_at" bson:"created_at"`
+	Updated_at        time.Time             `json:"updated_at" bson:"updated_at"`
+}
+
+// VerificationDocument is an uploaded document kept in private storage, only admins can download it
+type VerificationDocument struct {
+	Key          string    `json:"-" bson:"key"`
+	File_name    string    `json:"file_name" bson:"file_name"`
+	Content_type string    `json:"content_type" bson:"content_type"`
+	Size         int64     `json:"size" bson:"size"`
+	Uploaded_at  time.Time `json:"uploaded_at" bson:"uploaded_at"`
+}
+
+// VerificationStatus is the status of the business, [BusinessUnverified] when it never asked to be verified
+func (b *BusinessUser) VerificationStatus() string {
+	if b.Verification_status == "" {
+		return BusinessUnverified
+	}
+	return b.Verification_status
+}
+
+// IsVerified tells if an admin approved the evidence of the business
+func (b *BusinessUser) IsVerified() bool {
+	return b.Verification_status == BusinessVerified
+}
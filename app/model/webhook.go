@@ -0,0 +1,76 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Webhook event types a business can subscribe to
+const (
+	WebhookDealCreated  = "deal.created"
+	WebhookDealUpdated  = "deal.updated"
+	WebhookDealClaimed  = "deal.claimed"
+	WebhookDealRedeemed = "deal.redeemed"
+	WebhookPing         = "ping"
+	WebhookAllEvents    = "*"
+)
+
+// WebhookEventTypes are the events that can be put on a subscription
+var WebhookEventTypes = []string{WebhookDealCreated, WebhookDealUpdated, WebhookDealClaimed, WebhookDealRedeemed, WebhookAllEvents}
+
+// Status of a webhook delivery
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+	DeliveryCancelled = "cancelled"
+)
+
+// WebhookSubscription is a URL of a business that gets a signed POST when one of its events happens
+// the secret is only sent to the client when the subscription is created
+type WebhookSubscription struct {
+	ID                   primitive.ObjectID `json:"id" bson:"_id"`
+	Business_id          primitive.ObjectID `json:"business_id" bson:"business_id"`
+	Url                  string             `json:"url" bson:"url"`
+	Events               []string           `json:"events" bson:"events"`
+	Secret               string             `json:"secret,omitempty" bson:"secret"`
+	Active               bool               `json:"active" bson:"active"`
+	Disabled_reason      string             `json:"disabled_reason,omitempty" bson:"disabled_reason,omitempty"`
+	Consecutive_failures int                `json:"consecutive_failures" bson:"consecutive_failures"`
+	Created_at           time.Time          `json:"created_at" bson:"created_at"`
+	Updated_at           time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// WantsEvent checks if the subscription is for the event type
+func (s *WebhookSubscription) WantsEvent(event string) bool {
+	for _, e := range s.Events {
+		if e == event || e == WebhookAllEvents {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is the log of sending one event to one subscription
+type WebhookDelivery struct {
+	ID              primitive.ObjectID `json:"id" bson:"_id"`
+	Subscription_id primitive.ObjectID `json:"subscription_id" bson:"subscription_id"`
+	Business_id     primitive.ObjectID `json:"business_id" bson:"business_id"`
+	Event           string             `json:"event" bson:"event"`
+	Event_id        string             `json:"event_id" bson:"event_id"`
+	Payload         string             `json:"payload" bson:"payload"`
+	Status          string             `json:"status" bson:"status"`
+	Attempts        []WebhookAttempt   `json:"attempts" bson:"attempts"`
+	Created_at      time.Time          `json:"created_at" bson:"created_at"`
+	Updated_at      time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// WebhookAttempt is one try at sending a delivery
+type WebhookAttempt struct {
+	Attempted_at    time.Time `json:"attempted_at" bson:"attempted_at"`
+	Response_status int       `json:"response_status,omitempty" bson:"response_status,omitempty"`
+	Response_body   string    `json:"response_body,omitempty" bson:"response_body,omitempty"`
+	Error           string    `json:"error,omitempty" bson:"error,omitempty"`
+	Duration_ms     int64     `json:"duration_ms" bson:"duration_ms"`
+}
@@ -0,0 +1,320 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/app/server/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// moderation keeps content that breaks the rules from going public.
+//
+// Text of deals, businesses and reviews is screened when it is saved. Flagged content is saved as pending,
+// which keeps it out of public results, and opens a case in the moderation queue. Users can report anything
+// they see, which opens a case too, and content enough users reported is hidden until a moderator decides.
+
+// AutoHideReports is how many users have to report content before it is hidden, content a moderator approved is not
+const AutoHideReports = 3
+
+// Moderator decisions on a case
+const (
+	ActionApprove = "approve" // the content is fine and public
+	ActionReject  = "reject"  // the content breaks the rules and is taken down until it is edited
+	ActionHide    = "hide"    // the content is taken down while the case stays open
+)
+
+var (
+	// ErrTargetNotFound is returned when the content to report does not exist
+	ErrTargetNotFound = errors.New("content not found")
+	// ErrAlreadyReported is returned when a user reports the same content again
+	ErrAlreadyReported = errors.New("already reported")
+	// ErrCaseNotFound is returned for an unknown moderation case
+	ErrCaseNotFound = errors.New("moderation case not found")
+)
+
+// Target is the content a case is about
+type Target struct {
+	Type        string
+	ID          primitive.ObjectID
+	Business_id primitive.ObjectID
+}
+
+// Service screens content and keeps the moderation queue
+type Service struct {
+	db         *database.Database
+	screener   *Screener
+	cases      model.Collection
+	reports    model.Collection
+	deals      model.Collection
+	businesses model.Collection
+	reviews    model.Collection
+}
+
+// NewService returns a [Service] screening content with screener
+func NewService(db *database.Database, screener *Screener) *Service {
+	return &Service{
+		db:         db,
+		screener:   screener,
+		cases:      db.GetModerationCases(),
+		reports:    db.GetReports(),
+		deals:      db.GetDeals(),
+		businesses: db.GetBusinesses(),
+		reviews:    db.GetReviews(),
+	}
+}
+
+// Check screens the text of content that is about to be saved and returns the moderation status to save it with
+// and what the pre-screen found. Content that is held back, hidden or rejected goes back to the queue when it is edited.
+// When the status is [model.ModerationPending] the content has to be queued with [Service.Queue] once it is saved
+func (s *Service) Check(current string, texts ...string) (string, []string) {
+	findings := s.screener.Screen(texts...)
+	if len(findings) > 0 || !model.IsPubliclyVisible(current) {
+		return model.ModerationPending, findings
+	}
+	return current, nil
+}
+
+// Queue opens a case for content that was saved as pending, or adds to its open case
+func (s *Service) Queue(ctx context.Context, target Target, findings []string, texts ...string) error {
+	set := bson.M{"content": strings.Join(texts, "\n")}
+	if len(findings) > 0 {
+		set["findings"] = findings
+	}
+	_, err := s.openCase(ctx, target, model.CaseSourcePrescreen, set, 0)
+	return err
+}
+
+// Report saves the report of a user and adds it to the case of the content. Content reported by
+// [AutoHideReports] users is hidden until a moderator decides, unless a moderator already approved it
+func (s *Service) Report(ctx context.Context, reporterID primitive.ObjectID, targetType string, targetID primitive.ObjectID, reason string, details string) (*model.Report, error) {
+	target, status, content, err := s.loadTarget(ctx, targetType, targetID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the report goes in first so a second report of the same user does not count towards the case
+	report := &model.Report{
+		ID:          primitive.NewObjectID(),
+		Reporter_id: reporterID,
+		Target_type: targetType,
+		Target_id:   targetID,
+		Reason:      reason,
+		Details:     details,
+		Created_at:  time.Now().UTC(),
+	}
+	_, err = s.reports.InsertOne(ctx, report)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrAlreadyReported
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	moderationCase, err := s.openCase(ctx, target, model.CaseSourceReport, bson.M{"content": content}, 1)
+	if err != nil {
+		return nil, err
+	}
+	report.Case_id = moderationCase.ID
+	if _, err := s.reports.UpdateOne(ctx, bson.M{"_id": report.ID}, bson.M{"$set": bson.M{"case_id": moderationCase.ID}}); err != nil {
+		return nil, err
+	}
+
+	if moderationCase.Reports_count >= AutoHideReports && status == "" {
+		if err := s.setStatus(ctx, target, model.ModerationHidden); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// Resolve applies the decision of a moderator to the case and its content, a decision can be changed later
+func (s *Service) Resolve(ctx context.Context, caseID primitive.ObjectID, action string, moderatorID primitive.ObjectID, note string) (*model.ModerationCase, error) {
+	moderationCase := new(model.ModerationCase)
+	err := s.cases.FindOne(moderationCase, ctx, bson.M{"_id": caseID})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrCaseNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	target := Target{Type: moderationCase.Target_type, ID: moderationCase.Target_id, Business_id: moderationCase.Business_id}
+
+	now := time.Now().UTC()
+	set := bson.M{"updated_at": now, "note": note}
+	var contentStatus string
+	switch action {
+	case ActionApprove:
+		contentStatus = model.ModerationApproved
+		set["status"] = model.CaseApproved
+	case ActionReject:
+		contentStatus = model.ModerationRejected
+		set["status"] = model.CaseRejected
+	case ActionHide:
+		contentStatus = model.ModerationHidden
+		set["status"] = model.CaseOpen
+	default:
+		return nil, errors.New("unknown moderation action " + action)
+	}
+	if action != ActionHide {
+		set["resolved_at"] = now
+		set["resolved_by"] = moderatorID
+	}
+
+	err = s.db.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := s.setStatus(ctx, target, contentStatus); err != nil {
+			return err
+		}
+		// an open case is taken over by a new one, e.g. when content was reported again after it was approved
+		if action == ActionHide && moderationCase.Status != model.CaseOpen {
+			count, err := s.cases.CountDocuments(ctx, bson.M{"target_type": target.Type, "target_id": target.ID, "status": model.CaseOpen})
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				delete(set, "status")
+			}
+		}
+		return s.cases.FindOneAndUpdate(moderationCase, ctx, bson.M{"_id": caseID}, bson.M{"$set": set},
+			options.FindOneAndUpdate().SetReturnDocument(options.After))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return moderationCase, nil
+}
+
+// openCase adds to the open case of the target or opens one, reports is how many reports to add
+func (s *Service) openCase(ctx context.Context, target Target, source string, set bson.M, reports int) (*model.ModerationCase, error) {
+	now := time.Now().UTC()
+	set["business_id"] = target.Business_id
+	set["updated_at"] = now
+
+	moderationCase := new(model.ModerationCase)
+	upsert := func() error {
+		return s.cases.FindOneAndUpdate(moderationCase, ctx,
+			bson.M{"target_type": target.Type, "target_id": target.ID, "status": model.CaseOpen},
+			bson.M{
+				"$set":         set,
+				"$addToSet":    bson.M{"sources": source},
+				"$inc":         bson.M{"reports_count": reports},
+				"$setOnInsert": bson.M{"_id": primitive.NewObjectID(), "created_at": now},
+			},
+			options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+		)
+	}
+
+	err := upsert()
+	if mongo.IsDuplicateKeyError(err) {
+		// opened by another request at the same time, the case exists now
+		err = upsert()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return moderationCase, nil
+}
+
+// setStatus changes the moderation status of the content.
+// A review only counts towards the rating of its business or deal while it is public
+func (s *Service) setStatus(ctx context.Context, target Target, status string) error {
+	update := bson.M{"$set": bson.M{"moderation_status": status}}
+
+	switch target.Type {
+	case model.ModerationTargetDeal:
+		_, err := s.deals.UpdateOne(ctx, bson.M{"_id": target.ID}, update)
+		return err
+	case model.ModerationTargetBusiness:
+		_, err := s.businesses.UpdateOne(ctx, bson.M{"_id": target.ID}, update)
+		return err
+	case model.ModerationTargetReview:
+		review := new(model.Review)
+		err := s.reviews.FindOneAndUpdate(review, ctx, bson.M{"_id": target.ID}, update)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		oldSum, oldCount := review.RatingContribution()
+		review.Moderation_status = status
+		newSum, newCount := review.RatingContribution()
+		if oldSum == newSum && oldCount == newCount {
+			return nil
+		}
+		// the review counts towards what it is about, the business or the deal
+		rated := s.businesses
+		if review.Target_type == model.ReviewTargetDeal {
+			rated = s.deals
+		}
+		_, err = rated.UpdateOne(ctx,
+			bson.M{"_id": review.Target_id},
+			bson.M{"$inc": bson.M{"rating.sum": newSum - oldSum, "rating.count": newCount - oldCount}},
+		)
+		return err
+	}
+	return errors.New("unknown moderation target " + target.Type)
+}
+
+// loadTarget finds the content, returns its moderation status and its text
+func (s *Service) loadTarget(ctx context.Context, targetType string, targetID primitive.ObjectID) (Target, string, string, error) {
+	target := Target{Type: targetType, ID: targetID}
+	var err error
+	var status, content string
+
+	switch targetType {
+	case model.ModerationTargetDeal:
+		deal := new(model.Deal)
+		err = s.deals.FindOne(deal, ctx, bson.M{"_id": targetID})
+		target.Business_id = deal.Business_id
+		status = deal.Moderation_status
+		content = DealText(deal)
+	case model.ModerationTargetBusiness:
+		business := new(model.BusinessUser)
+		err = s.businesses.FindOne(business, ctx, bson.M{"_id": targetID})
+		target.Business_id = business.ID
+		status = business.Moderation_status
+		content = BusinessText(business)
+	case model.ModerationTargetReview:
+		review := new(model.Review)
+		err = s.reviews.FindOne(review, ctx, bson.M{"_id": targetID})
+		target.Business_id = review.Business_id
+		status = review.Moderation_status
+		content = review.Text
+	default:
+		return target, "", "", ErrTargetNotFound
+	}
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return target, "", "", ErrTargetNotFound
+	}
+	return target, status, content, err
+}
+
+// DealText is the text of a deal that is screened
+func DealText(deal *model.Deal) string {
+	return joinText(deal.Name, deal.Description)
+}
+
+// BusinessText is the text of a business that is screened
+func BusinessText(business *model.BusinessUser) string {
+	return joinText(business.Business_name, business.Description)
+}
+
+func joinText(texts ...*string) string {
+	var parts []string
+	for _, text := range texts {
+		if text != nil && *text != "" {
+			parts = append(parts, *text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
@@ -0,0 +1,96 @@
+package moderation
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// defaultBlockedWords are phrases that are almost always spam in a deal or review
+var defaultBlockedWords = []string{"click here", "free money", "crypto giveaway", "wire transfer", "whatsapp me", "telegram me"}
+
+var (
+	urlPattern   = regexp.MustCompile(`(?i)(https?://|www\.)\S+|\b[a-z0-9-]+\.(com|net|org|io|co|biz|info|xyz|ly|me|app|shop|store|site|online)\b`)
+	phonePattern = regexp.MustCompile(`(\+\d{1,3}[\s.\-]?)?\(?\b\d{3}\)?[\s.\-]?\d{3}[\s.\-]?\d{4}\b`)
+)
+
+// Screener looks for text that should not go public before a moderator sees it
+type Screener struct {
+	blockedWords []string
+	allowURLs    bool
+	allowPhones  bool
+}
+
+// NewScreener returns a [Screener] flagging the blocked words and phrases (matched as whole words, ignoring case)
+func NewScreener(blockedWords []string, allowURLs bool, allowPhones bool) *Screener {
+	s := &Screener{allowURLs: allowURLs, allowPhones: allowPhones}
+	for _, word := range blockedWords {
+		if normalized := normalize(word); normalized != "" {
+			s.blockedWords = append(s.blockedWords, normalized)
+		}
+	}
+	return s
+}
+
+// NewScreenerFromEnv returns the screener configured by the environment
+//
+//   - MODERATION_BLOCKED_WORDS - comma separated words and phrases, added to a short default list of spam phrases
+//   - MODERATION_BLOCKED_WORDS_FILE - a file with one word or phrase per line, lines starting with # are skipped
+//   - MODERATION_ALLOW_URLS and MODERATION_ALLOW_PHONE_NUMBERS - `true` stops flagging links and phone numbers
+func NewScreenerFromEnv() (*Screener, error) {
+	words := append([]string{}, defaultBlockedWords...)
+	if list := os.Getenv("MODERATION_BLOCKED_WORDS"); list != "" {
+		words = append(words, strings.Split(list, ",")...)
+	}
+
+	if path := os.Getenv("MODERATION_BLOCKED_WORDS_FILE"); path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				words = append(words, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return NewScreener(words, os.Getenv("MODERATION_ALLOW_URLS") == "true", os.Getenv("MODERATION_ALLOW_PHONE_NUMBERS") == "true"), nil
+}
+
+// Screen returns what is wrong with the texts, nothing when they can go public
+func (s *Screener) Screen(texts ...string) []string {
+	text := strings.Join(texts, "\n")
+	var findings []string
+
+	padded := " " + normalize(text) + " "
+	for _, word := range s.blockedWords {
+		if strings.Contains(padded, " "+word+" ") {
+			findings = append(findings, "blocked word: "+word)
+		}
+	}
+	if !s.allowURLs && urlPattern.MatchString(text) {
+		findings = append(findings, "contains a link")
+	}
+	if !s.allowPhones && phonePattern.MatchString(text) {
+		findings = append(findings, "contains a phone number")
+	}
+	return findings
+}
+
+// normalize lowercases the text and keeps only its words separated by single spaces
+func normalize(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,163 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+const (
+	apnsProductionURL = "https://api.push.apple.com"
+	apnsSandboxURL    = "https://api.sandbox.push.apple.com"
+	// Apple wants the provider token renewed between 20 and 60 minutes
+	apnsTokenLifetime = 40 * time.Minute
+)
+
+// APNsConfig is the token based (.p8 key) configuration of Apple Push Notifications
+type APNsConfig struct {
+	KeyFile string // the .p8 key downloaded from the Apple developer account
+	KeyID   string
+	TeamID  string
+	Topic   string // the bundle ID of the app
+	Sandbox bool   // development builds of the app get tokens for the sandbox
+}
+
+// APNs sends notifications to iOS devices with the APNs HTTP/2 API
+type APNs struct {
+	config APNsConfig
+	key    *ecdsa.PrivateKey
+	url    string
+	client *http.Client
+
+	mu       sync.Mutex
+	token    string
+	signedAt time.Time
+}
+
+// NewAPNs reads the signing key and returns an [APNs] notifier
+func NewAPNs(config APNsConfig) (*APNs, error) {
+	if config.KeyID == "" || config.TeamID == "" || config.Topic == "" {
+		return nil, errors.New("APNs needs a key ID, team ID and topic")
+	}
+
+	data, err := os.ReadFile(config.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("the APNs key is not a PEM file")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("the APNs key is not an ECDSA key")
+	}
+
+	url := apnsProductionURL
+	if config.Sandbox {
+		url = apnsSandboxURL
+	}
+
+	return &APNs{
+		config: config,
+		key:    key,
+		url:    url,
+		// net/http uses HTTP/2 for TLS connections, which APNs requires
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Send posts the notification for one device token
+func (a *APNs) Send(ctx context.Context, device *model.Device, message *Message) error {
+	payload := map[string]interface{}{
+		"aps": map[string]interface{}{
+			"alert": map[string]string{"title": message.Title, "body": message.Body},
+			"sound": "default",
+		},
+	}
+	for key, value := range message.Data {
+		payload[key] = value
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	token, err := a.providerToken()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url+"/3/device/"+device.Token, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "bearer "+token)
+	req.Header.Set("apns-topic", a.config.Topic)
+	req.Header.Set("apns-push-type", "alert")
+	req.Header.Set("apns-priority", "10")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var apnsError struct {
+		Reason string `json:"reason"`
+	}
+	responseBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	json.Unmarshal(responseBody, &apnsError)
+
+	if resp.StatusCode == http.StatusGone || apnsError.Reason == "BadDeviceToken" || apnsError.Reason == "Unregistered" {
+		return ErrInvalidToken
+	}
+	return fmt.Errorf("APNs responded with %d: %s", resp.StatusCode, apnsError.Reason)
+}
+
+// providerToken is the signed JWT APNs authenticates the server with, reused until it gets old
+func (a *APNs) providerToken() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token != "" && time.Since(a.signedAt) < apnsTokenLifetime {
+		return a.token, nil
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": a.config.TeamID,
+		"iat": now.Unix(),
+	})
+	token.Header["kid"] = a.config.KeyID
+
+	signed, err := token.SignedString(a.key)
+	if err != nil {
+		return "", err
+	}
+	a.token = signed
+	a.signedAt = now
+	return signed, nil
+}
@@ -0,0 +1,138 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/app/queue"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// EmailTask is the task type that sends one email
+const EmailTask = "send_email"
+
+// EmailPayload is the payload of an [EmailTask]
+type EmailPayload struct {
+	User_id primitive.ObjectID `bson:"user_id"`
+	Subject string             `bson:"subject"`
+	Body    string             `bson:"body"`
+}
+
+// Mailer sends a plain text email
+type Mailer interface {
+	SendEmail(ctx context.Context, to string, subject string, body string) error
+}
+
+// NewMailerFromEnv returns the mailer configured by the environment
+//
+//   - EMAIL_DRIVER - `log` (default) writes emails to the server log, `smtp` sends them
+//   - SMTP_HOST, SMTP_PORT (default 587), SMTP_USERNAME, SMTP_PASSWORD and EMAIL_FROM
+func NewMailerFromEnv() (Mailer, error) {
+	driver := strings.ToLower(os.Getenv("EMAIL_DRIVER"))
+	switch driver {
+	case "", "log":
+		log.Println("Emails are written to the log")
+		return LogMailer{}, nil
+	case "smtp":
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		return NewSMTPMailer(SMTPConfig{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("EMAIL_FROM"),
+		})
+	default:
+		return nil, errors.New("unknown EMAIL_DRIVER " + driver)
+	}
+}
+
+// LogMailer writes emails to the server log instead of sending them, for development
+type LogMailer struct{}
+
+// SendEmail logs the email
+func (LogMailer) SendEmail(ctx context.Context, to string, subject string, body string) error {
+	log.Println("Email to " + to + ": " + subject + "\n" + body)
+	return nil
+}
+
+// SMTPConfig is the mail server emails are sent through
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// SMTPMailer sends emails through a mail server with STARTTLS
+type SMTPMailer struct {
+	config SMTPConfig
+}
+
+// NewSMTPMailer returns an [SMTPMailer]
+func NewSMTPMailer(config SMTPConfig) (*SMTPMailer, error) {
+	if config.Host == "" || config.From == "" {
+		return nil, errors.New("SMTP needs a host and a from address")
+	}
+	return &SMTPMailer{config: config}, nil
+}
+
+// SendEmail sends a plain text email
+func (m *SMTPMailer) SendEmail(ctx context.Context, to string, subject string, body string) error {
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return errors.New("email headers can not contain line breaks")
+	}
+
+	message := "From: " + m.config.From + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Date: " + time.Now().UTC().Format(time.RFC1123Z) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + strings.ReplaceAll(body, "\n", "\r\n")
+
+	var auth smtp.Auth
+	if m.config.Username != "" {
+		auth = smtp.PlainAuth("", m.config.Username, m.config.Password, m.config.Host)
+	}
+	return smtp.SendMail(net.JoinHostPort(m.config.Host, m.config.Port), auth, m.config.From, []string{to}, []byte(message))
+}
+
+// Email queues an email to the user, dedupKey makes sure the same email is only sent once
+func (s *Service) Email(ctx context.Context, userID primitive.ObjectID, dedupKey string, subject string, body string) error {
+	_, err := s.queue.Enqueue(ctx, EmailTask, EmailPayload{User_id: userID, Subject: subject, Body: body}, queue.EnqueueOptions{
+		IdempotencyKey: EmailTask + ":" + dedupKey,
+	})
+	return err
+}
+
+// sendEmail sends an [EmailTask] to the address of the user
+func (s *Service) sendEmail(ctx context.Context, task *model.Task) error {
+	var payload EmailPayload
+	if err := task.DecodePayload(&payload); err != nil {
+		return err
+	}
+
+	user := new(model.User)
+	if err := s.users.FindOne(user, ctx, bson.M{"_id": payload.User_id}); err != nil {
+		return err
+	}
+	if user.Email == nil || *user.Email == "" {
+		return nil
+	}
+
+	return s.mailer.SendEmail(ctx, *user.Email, payload.Subject, payload.Body)
+}
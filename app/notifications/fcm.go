@@ -0,0 +1,117 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const fcmScope = "https://www.googleapis.com/auth/firebase.messaging"
+
+// FCMConfig is the configuration of Firebase Cloud Messaging
+type FCMConfig struct {
+	CredentialsFile string // service account JSON key of the Firebase project
+	ProjectID       string // defaults to the project of the service account
+}
+
+// FCM sends notifications to Android devices with the FCM HTTP v1 API
+type FCM struct {
+	url    string
+	tokens oauth2.TokenSource
+	client *http.Client
+}
+
+// NewFCM reads the service account and returns an [FCM] notifier
+func NewFCM(ctx context.Context, config FCMConfig) (*FCM, error) {
+	data, err := os.ReadFile(config.CredentialsFile)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := google.CredentialsFromJSON(ctx, data, fcmScope)
+	if err != nil {
+		return nil, err
+	}
+
+	projectID := config.ProjectID
+	if projectID == "" {
+		projectID = credentials.ProjectID
+	}
+	if projectID == "" {
+		return nil, errors.New("FCM needs a project ID")
+	}
+
+	return &FCM{
+		url:    "https://fcm.googleapis.com/v1/projects/" + projectID + "/messages:send",
+		tokens: credentials.TokenSource,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Send posts the notification for one registration token
+func (f *FCM) Send(ctx context.Context, device *model.Device, message *Message) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"message": map[string]interface{}{
+			"token":        device.Token,
+			"notification": map[string]string{"title": message.Title, "body": message.Body},
+			"data":         message.Data,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// the token source caches the access token until it expires
+	accessToken, err := f.tokens.Token()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	accessToken.SetAuthHeader(req)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var fcmError struct {
+		Error struct {
+			Status  string `json:"status"`
+			Message string `json:"message"`
+			Details []struct {
+				ErrorCode string `json:"errorCode"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	responseBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	json.Unmarshal(responseBody, &fcmError)
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrInvalidToken
+	}
+	for _, detail := range fcmError.Error.Details {
+		if detail.ErrorCode == "UNREGISTERED" {
+			return ErrInvalidToken
+		}
+	}
+	return fmt.Errorf("FCM responded with %d: %s %s", resp.StatusCode, fcmError.Error.Status, fcmError.Error.Message)
+}
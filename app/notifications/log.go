@@ -0,0 +1,67 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+)
+
+// LogNotifier writes notifications as JSON lines instead of sending them, for development
+type LogNotifier struct {
+	mu  sync.Mutex
+	out io.Writer // nil writes to the server log
+}
+
+type loggedNotification struct {
+	Sent_at  time.Time         `json:"sent_at"`
+	User_id  string            `json:"user_id"`
+	Platform string            `json:"platform"`
+	Token    string            `json:"token"`
+	Title    string            `json:"title"`
+	Body     string            `json:"body"`
+	Data     map[string]string `json:"data,omitempty"`
+}
+
+// NewLogNotifier returns a [LogNotifier] appending to the file at path, or writing to the server log when path is empty
+func NewLogNotifier(path string) (*LogNotifier, error) {
+	if path == "" {
+		return &LogNotifier{}, nil
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &LogNotifier{out: file}, nil
+}
+
+// Send writes the notification
+func (l *LogNotifier) Send(ctx context.Context, device *model.Device, message *Message) error {
+	line, err := json.Marshal(loggedNotification{
+		Sent_at:  time.Now().UTC(),
+		User_id:  device.User_id.Hex(),
+		Platform: device.Platform,
+		Token:    device.Token,
+		Title:    message.Title,
+		Body:     message.Body,
+		Data:     message.Data,
+	})
+	if err != nil {
+		return err
+	}
+
+	if l.out == nil {
+		log.Println("Push notification: " + string(line))
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.out.Write(append(line, '\n'))
+	return err
+}
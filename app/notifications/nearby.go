@@ -0,0 +1,147 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// nearbyCheckInterval is how often the location of a user is looked at, reports in between are ignored
+	nearbyCheckInterval = 30 * time.Second
+	// nearbyBusinessLimit and nearbyDealLimit keep one report from notifying about a whole shopping mall
+	nearbyBusinessLimit = 10
+	nearbyDealLimit     = 5
+)
+
+// ErrNearbyAlertsOff is returned by [Service.CheckNearby] for a user who did not opt in to nearby alerts
+var ErrNearbyAlertsOff = errors.New("nearby alerts are turned off")
+
+// CheckNearby notifies the user about live deals within their nearby radius of the location.
+// The location is only used for the query and is never stored or logged. Each deal is only
+// alerted once per user (see [Service.Notify]), the nearest deals the user was not alerted about yet
+// are alerted first. A user is checked at most every 30 seconds. Returns how many deals were found
+func (s *Service) CheckNearby(ctx context.Context, userID primitive.ObjectID, latitude float64, longitude float64) (int, error) {
+	user := new(model.User)
+	if err := s.users.FindOne(user, ctx, bson.M{"_id": userID}); err != nil {
+		return 0, err
+	}
+	preferences := user.GetNotificationPreferences()
+	if !preferences.Wants(model.NotificationNearbyDeal) {
+		return 0, ErrNearbyAlertsOff
+	}
+
+	now := time.Now().UTC()
+	result, err := s.users.UpdateOne(ctx,
+		bson.M{"_id": userID, "$or": bson.A{
+			bson.M{"nearby_checked_at": bson.M{"$exists": false}},
+			bson.M{"nearby_checked_at": bson.M{"$lte": now.Add(-nearbyCheckInterval)}},
+		}},
+		bson.M{"$set": bson.M{"nearby_checked_at": now}},
+	)
+	if err != nil || result.MatchedCount == 0 {
+		return 0, err
+	}
+
+	cursor, err := s.businesses.Find(ctx, bson.M{
+		"location": bson.M{
+			"$near": bson.M{
+				"$geometry":    bson.M{"type": "Point", "coordinates": []float64{longitude, latitude}},
+				"$maxDistance": preferences.NearbyRadius(),
+			},
+		},
+	}, options.Find().SetLimit(nearbyBusinessLimit))
+	if err != nil {
+		return 0, err
+	}
+	var businesses []*model.BusinessUser
+	if err := cursor.All(ctx, &businesses); err != nil {
+		return 0, err
+	}
+	if len(businesses) == 0 {
+		return 0, nil
+	}
+
+	businessIDs := bson.A{}
+	businessesByID := make(map[primitive.ObjectID]*model.BusinessUser, len(businesses))
+	// the businesses come nearest first
+	distanceRank := make(map[primitive.ObjectID]int, len(businesses))
+	for i, business := range businesses {
+		businessIDs = append(businessIDs, business.ID)
+		businessesByID[business.ID] = business
+		distanceRank[business.ID] = i
+	}
+
+	alerted, err := s.alertedNearbyDeals(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	// the deals customers can see, like on the business profile, that the user was not alerted about yet
+	cursor, err = s.deals.Find(ctx, bson.M{
+		"_id":               bson.M{"$nin": alerted},
+		"business_id":       bson.M{"$in": businessIDs},
+		"status":            bson.M{"$in": bson.A{model.DealLive, nil}},
+		"remaining":         bson.M{"$not": bson.M{"$lte": 0}},
+		"moderation_status": model.PubliclyVisible(),
+	}, options.Find().SetSort(bson.D{{Key: "published_at", Value: -1}, {Key: "_id", Value: -1}}))
+	if err != nil {
+		return 0, err
+	}
+	var deals []*model.Deal
+	if err := cursor.All(ctx, &deals); err != nil {
+		return 0, err
+	}
+	// the nearest deals first, the newest first at the same business
+	sort.SliceStable(deals, func(i, j int) bool {
+		return distanceRank[deals[i].Business_id] < distanceRank[deals[j].Business_id]
+	})
+	if len(deals) > nearbyDealLimit {
+		deals = deals[:nearbyDealLimit]
+	}
+
+	for _, deal := range deals {
+		message := &Message{
+			Title: stringValue(deal.Name) + " nearby",
+			Body:  businessName(businessesByID[deal.Business_id]) + " is a short walk away",
+			Data:  dealData(model.NotificationNearbyDeal, deal),
+		}
+		err := s.Notify(ctx, userID, model.NotificationNearbyDeal, model.NotificationNearbyDeal+":"+deal.ID.Hex(), message)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(deals), nil
+}
+
+// alertedNearbyDeals returns the deals the user got a nearby alert about, notifications are kept for 30 days
+func (s *Service) alertedNearbyDeals(ctx context.Context, userID primitive.ObjectID) (bson.A, error) {
+	cursor, err := s.notifications.Find(ctx,
+		bson.M{"user_id": userID, "kind": model.NotificationNearbyDeal},
+		options.Find().SetProjection(bson.M{"dedup_key": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var notifications []*model.Notification
+	if err := cursor.All(ctx, &notifications); err != nil {
+		return nil, err
+	}
+
+	alerted := bson.A{}
+	for _, notification := range notifications {
+		dealID, err := primitive.ObjectIDFromHex(strings.TrimPrefix(notification.Dedup_key, model.NotificationNearbyDeal+":"))
+		if err == nil {
+			alerted = append(alerted, dealID)
+		}
+	}
+	return alerted, nil
+}
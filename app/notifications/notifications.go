@@ -0,0 +1,411 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/app/queue"
+	"github.com/CoffeeHausGames/whir-server/app/server/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// notifications sends push notifications to users about the businesses they follow and the deals they saved.
+//
+// Deal events fan out to one task per deal, which creates a notification for every user who should get it.
+// A notification is only created once per user and dedup key, is held back until the user's quiet hours end
+// and is dropped when the user already got too many. Sending happens in another task so a slow push
+// service never holds up the fan out.
+
+// Task types
+const (
+	NotifyFollowersTask = "notify_followers"
+	RemindFavoritesTask = "remind_favorites"
+	SendTask            = "send_notification"
+)
+
+const (
+	// a user gets at most this many notifications, the rest are suppressed
+	maxPerHour = 3
+	maxPerDay  = 10
+	// ReminderWindow is how long before a favorited deal starts the reminder is sent
+	ReminderWindow = 30 * time.Minute
+)
+
+// DealPayload is the payload of a [NotifyFollowersTask] or [RemindFavoritesTask]
+type DealPayload struct {
+	Deal_id primitive.ObjectID `bson:"deal_id"`
+}
+
+// SendPayload is the payload of a [SendTask]
+type SendPayload struct {
+	Notification_id primitive.ObjectID `bson:"notification_id"`
+}
+
+// Service decides who gets a notification and sends it
+type Service struct {
+	users              model.Collection
+	devices            model.Collection
+	notifications      model.Collection
+	follows            model.Collection
+	favorites          model.Collection
+	deals              model.Collection
+	businesses         model.Collection
+	savedSearches      model.Collection
+	savedSearchMatches model.Collection
+	queue              *queue.Queue
+	notifier           Notifier
+	mailer             Mailer
+}
+
+// NewService returns a [Service] sending push notifications with notifier and emails with mailer
+func NewService(db *database.Database, q *queue.Queue, notifier Notifier, mailer Mailer) *Service {
+	return &Service{
+		users:              db.GetUsers(),
+		devices:            db.GetDevices(),
+		notifications:      db.GetNotifications(),
+		follows:            db.GetFollows(),
+		favorites:          db.GetFavorites(),
+		deals:              db.GetDeals(),
+		businesses:         db.GetBusinesses(),
+		savedSearches:      db.GetSavedSearches(),
+		savedSearchMatches: db.GetSavedSearchMatches(),
+		queue:              q,
+		notifier:           notifier,
+		mailer:             mailer,
+	}
+}
+
+// Register adds the task handlers of the service to the worker pool
+func (s *Service) Register(pool *queue.Pool) {
+	pool.Handle(NotifyFollowersTask, s.notifyFollowers)
+	pool.Handle(RemindFavoritesTask, s.remindFavorites)
+	pool.Handle(SendTask, s.send)
+	pool.Handle(EmailTask, s.sendEmail)
+}
+
+// HandleEvent is the event dispatcher subscriber that tells followers about deals that went live
+func (s *Service) HandleEvent(ctx context.Context, event *model.DomainEvent) error {
+	var deal *model.Deal
+	switch event.Type {
+	case model.EventDealCreated:
+		deal = new(model.Deal)
+		if err := event.DecodeData(deal); err != nil {
+			return err
+		}
+	case model.EventDealStatusChanged:
+		data := new(model.DealStatusChangedData)
+		if err := event.DecodeData(data); err != nil {
+			return err
+		}
+		deal = data.Deal
+	default:
+		return nil
+	}
+
+	if deal == nil || deal.CurrentStatus() != model.DealLive {
+		return nil
+	}
+
+	// a deal that goes live again later (e.g. after being expired) is not announced twice
+	_, err := s.queue.Enqueue(ctx, NotifyFollowersTask, DealPayload{Deal_id: deal.ID}, queue.EnqueueOptions{
+		IdempotencyKey: NotifyFollowersTask + ":" + deal.ID.Hex(),
+	})
+	return err
+}
+
+// EnqueueReminders queues reminders for the deals that start within the [ReminderWindow] after now,
+// scheduled deals start when they are published, live ones at their start date
+func (s *Service) EnqueueReminders(ctx context.Context, now time.Time) (int, error) {
+	window := bson.M{"$gt": now, "$lte": now.Add(ReminderWindow)}
+	cursor, err := s.deals.Find(ctx, bson.M{"$or": bson.A{
+		bson.M{"status": model.DealScheduled, "publish_at": window},
+		bson.M{"status": bson.M{"$in": bson.A{model.DealLive, nil}}, "start_date": window},
+	}})
+	if err != nil {
+		return 0, err
+	}
+	var deals []*model.Deal
+	if err := cursor.All(ctx, &deals); err != nil {
+		return 0, err
+	}
+
+	for _, deal := range deals {
+		_, err := s.queue.Enqueue(ctx, RemindFavoritesTask, DealPayload{Deal_id: deal.ID}, queue.EnqueueOptions{
+			IdempotencyKey: RemindFavoritesTask + ":" + deal.ID.Hex(),
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(deals), nil
+}
+
+// notifyFollowers creates a notification of a new deal for every follower of its business
+func (s *Service) notifyFollowers(ctx context.Context, task *model.Task) error {
+	deal, business, err := s.loadDeal(ctx, task)
+	if err != nil || deal == nil || deal.CurrentStatus() != model.DealLive || !model.IsPubliclyVisible(deal.Moderation_status) {
+		return err
+	}
+
+	message := &Message{
+		Title: businessName(business),
+		Body:  "New deal: " + stringValue(deal.Name),
+		Data:  dealData(model.NotificationNewDeal, deal),
+	}
+	return s.forEachUser(ctx, s.follows, bson.M{"business_id": deal.Business_id}, func(userID primitive.ObjectID) error {
+		return s.Notify(ctx, userID, model.NotificationNewDeal, model.NotificationNewDeal+":"+deal.ID.Hex(), message)
+	})
+}
+
+// remindFavorites creates a reminder for every user who favorited a deal that is about to start
+func (s *Service) remindFavorites(ctx context.Context, task *model.Task) error {
+	deal, business, err := s.loadDeal(ctx, task)
+	if err != nil || deal == nil || !model.IsPubliclyVisible(deal.Moderation_status) {
+		return err
+	}
+	status := deal.CurrentStatus()
+	if status != model.DealLive && status != model.DealScheduled {
+		return nil
+	}
+
+	message := &Message{
+		Title: stringValue(deal.Name) + " starts soon",
+		Body:  "A deal you saved from " + businessName(business) + " is about to start",
+		Data:  dealData(model.NotificationDealStarting, deal),
+	}
+	return s.forEachUser(ctx, s.favorites, bson.M{"deal_id": deal.ID}, func(userID primitive.ObjectID) error {
+		return s.Notify(ctx, userID, model.NotificationDealStarting, model.NotificationDealStarting+":"+deal.ID.Hex(), message)
+	})
+}
+
+// Notify creates a notification for the user and queues sending it.
+// Nothing happens when the user turned the kind off, has no devices or already got the dedupKey
+func (s *Service) Notify(ctx context.Context, userID primitive.ObjectID, kind string, dedupKey string, message *Message) error {
+	user := new(model.User)
+	err := s.users.FindOne(user, ctx, bson.M{"_id": userID})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	preferences := user.GetNotificationPreferences()
+	if !preferences.Wants(kind) {
+		return nil
+	}
+
+	devices, err := s.devices.CountDocuments(ctx, bson.M{"user_id": userID})
+	if err != nil || devices == 0 {
+		return err
+	}
+
+	now := time.Now().UTC()
+	notification := &model.Notification{
+		ID:         primitive.NewObjectID(),
+		User_id:    userID,
+		Kind:       kind,
+		Dedup_key:  dedupKey,
+		Title:      message.Title,
+		Body:       message.Body,
+		Data:       message.Data,
+		Status:     model.NotificationPending,
+		Send_at:    now,
+		Created_at: now,
+	}
+	if preferences.Quiet_hours != nil {
+		notification.Send_at = preferences.Quiet_hours.NextAllowed(now)
+	}
+
+	limited, err := s.rateLimited(ctx, userID, now)
+	if err != nil {
+		return err
+	}
+	switch {
+	case limited:
+		notification.Status = model.NotificationSuppressed
+		notification.Reason = "rate limited"
+	case kind == model.NotificationNearbyDeal && notification.Send_at.After(now):
+		// the user will have moved on by the time the quiet hours end
+		notification.Status = model.NotificationSuppressed
+		notification.Reason = "quiet hours"
+	}
+
+	_, err = s.notifications.InsertOne(ctx, notification)
+	if mongo.IsDuplicateKeyError(err) {
+		// already notified, the send task of that notification is queued with it
+		return nil
+	}
+	if err != nil || notification.Status != model.NotificationPending {
+		return err
+	}
+
+	_, err = s.queue.Enqueue(ctx, SendTask, SendPayload{Notification_id: notification.ID}, queue.EnqueueOptions{
+		IdempotencyKey: SendTask + ":" + notification.ID.Hex(),
+		RunAt:          notification.Send_at,
+	})
+	return err
+}
+
+// rateLimited checks if the user already got as many notifications as they can in the last hour or day
+func (s *Service) rateLimited(ctx context.Context, userID primitive.ObjectID, now time.Time) (bool, error) {
+	counted := bson.A{model.NotificationPending, model.NotificationSent}
+
+	lastHour, err := s.notifications.CountDocuments(ctx, bson.M{
+		"user_id": userID, "status": bson.M{"$in": counted}, "created_at": bson.M{"$gte": now.Add(-time.Hour)},
+	})
+	if err != nil || lastHour >= maxPerHour {
+		return err == nil, err
+	}
+
+	lastDay, err := s.notifications.CountDocuments(ctx, bson.M{
+		"user_id": userID, "status": bson.M{"$in": counted}, "created_at": bson.M{"$gte": now.Add(-24 * time.Hour)},
+	})
+	return err == nil && lastDay >= maxPerDay, err
+}
+
+// send sends a notification to every device of its user, devices with an invalid token are removed
+func (s *Service) send(ctx context.Context, task *model.Task) error {
+	var payload SendPayload
+	if err := task.DecodePayload(&payload); err != nil {
+		return err
+	}
+
+	notification := new(model.Notification)
+	if err := s.notifications.FindOne(notification, ctx, bson.M{"_id": payload.Notification_id}); err != nil {
+		return err
+	}
+	if notification.Status != model.NotificationPending {
+		return nil
+	}
+
+	// the user may have turned notifications off during their quiet hours
+	user := new(model.User)
+	if err := s.users.FindOne(user, ctx, bson.M{"_id": notification.User_id}); err != nil {
+		return s.finish(ctx, notification, model.NotificationSuppressed, "user not found")
+	}
+	if !user.GetNotificationPreferences().Wants(notification.Kind) {
+		return s.finish(ctx, notification, model.NotificationSuppressed, "turned off")
+	}
+
+	cursor, err := s.devices.Find(ctx, bson.M{"user_id": notification.User_id})
+	if err != nil {
+		return err
+	}
+	var devices []*model.Device
+	if err := cursor.All(ctx, &devices); err != nil {
+		return err
+	}
+
+	message := &Message{Title: notification.Title, Body: notification.Body, Data: notification.Data}
+	sent := 0
+	var lastErr error
+	for _, device := range devices {
+		err := s.notifier.Send(ctx, device, message)
+		switch {
+		case err == nil:
+			sent++
+		case errors.Is(err, ErrInvalidToken):
+			s.devices.DeleteOne(ctx, bson.M{"_id": device.ID})
+		default:
+			log.Println("Push notification to device " + device.ID.Hex() + " failed: " + err.Error())
+			lastErr = err
+		}
+	}
+
+	switch {
+	case sent > 0:
+		// devices that failed are not tried again so the others do not get it twice
+		return s.finish(ctx, notification, model.NotificationSent, "")
+	case lastErr == nil:
+		return s.finish(ctx, notification, model.NotificationSuppressed, "no devices")
+	case task.Attempts >= task.Max_attempts:
+		return s.finish(ctx, notification, model.NotificationFailed, lastErr.Error())
+	}
+	return fmt.Errorf("notification %s was not sent: %v", notification.ID.Hex(), lastErr)
+}
+
+func (s *Service) finish(ctx context.Context, notification *model.Notification, status string, reason string) error {
+	set := bson.M{"status": status}
+	if status == model.NotificationSent {
+		set["sent_at"] = time.Now().UTC()
+	}
+	if reason != "" {
+		set["reason"] = reason
+	}
+	_, err := s.notifications.UpdateOne(ctx, bson.M{"_id": notification.ID}, bson.M{"$set": set})
+	return err
+}
+
+// loadDeal reads the deal of a [DealPayload] task and its business, the deal is nil if it is gone
+func (s *Service) loadDeal(ctx context.Context, task *model.Task) (*model.Deal, *model.BusinessUser, error) {
+	var payload DealPayload
+	if err := task.DecodePayload(&payload); err != nil {
+		return nil, nil, err
+	}
+
+	deal := new(model.Deal)
+	err := s.deals.FindOne(deal, ctx, bson.M{"_id": payload.Deal_id})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	business := new(model.BusinessUser)
+	if err := s.businesses.FindOne(business, ctx, bson.M{"_id": deal.Business_id}); err != nil {
+		return nil, nil, err
+	}
+	return deal, business, nil
+}
+
+// forEachUser calls fn with the user_id of every document in collection matching filter
+func (s *Service) forEachUser(ctx context.Context, collection model.Collection, filter bson.M, fn func(userID primitive.ObjectID) error) error {
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var document struct {
+			User_id primitive.ObjectID `bson:"user_id"`
+		}
+		if err := cursor.Decode(&document); err != nil {
+			return err
+		}
+		if err := fn(document.User_id); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func dealData(kind string, deal *model.Deal) map[string]string {
+	return map[string]string{
+		"kind":        kind,
+		"deal_id":     deal.ID.Hex(),
+		"business_id": deal.Business_id.Hex(),
+	}
+}
+
+func businessName(business *model.BusinessUser) string {
+	if business.Business_name == nil {
+		return "A business you follow"
+	}
+	return *business.Business_name
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,97 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+)
+
+// ErrInvalidToken is returned by a [Notifier] when the device token is not valid anymore
+// (the app was uninstalled or the token was rotated), the device is removed
+var ErrInvalidToken = errors.New("device token is no longer valid")
+
+// Message is what is shown on the device
+type Message struct {
+	Title string
+	Body  string
+	Data  map[string]string // sent with the notification for the app, e.g. the deal to open
+}
+
+// Notifier sends a push notification to one device
+type Notifier interface {
+	Send(ctx context.Context, device *model.Device, message *Message) error
+}
+
+// Platforms sends each device with the [Notifier] of its platform
+type Platforms map[string]Notifier
+
+// Send sends the message with the notifier of the device platform
+func (p Platforms) Send(ctx context.Context, device *model.Device, message *Message) error {
+	notifier, ok := p[device.Platform]
+	if !ok {
+		return errors.New("no notifier for platform " + device.Platform)
+	}
+	return notifier.Send(ctx, device, message)
+}
+
+// NewFromEnv returns the notifier configured by the environment
+//
+//   - PUSH_DRIVER - `log` (default) writes notifications to PUSH_LOG_FILE or the server log,
+//     `live` sends them with APNs and FCM
+//   - APNs: APNS_KEY_FILE (.p8), APNS_KEY_ID, APNS_TEAM_ID, APNS_TOPIC (the app bundle ID), APNS_SANDBOX=true for development builds
+//   - FCM: FCM_CREDENTIALS_FILE (service account JSON), FCM_PROJECT_ID defaults to the project of the credentials
+//
+// With the live driver a platform that is not configured is logged instead
+func NewFromEnv() (Notifier, error) {
+	sink, err := NewLogNotifier(os.Getenv("PUSH_LOG_FILE"))
+	if err != nil {
+		return nil, err
+	}
+
+	driver := strings.ToLower(os.Getenv("PUSH_DRIVER"))
+	switch driver {
+	case "", "log":
+		log.Println("Push notifications are written to the log")
+		return sink, nil
+	case "live":
+	default:
+		return nil, errors.New("unknown PUSH_DRIVER " + driver)
+	}
+
+	platforms := Platforms{model.PlatformIOS: sink, model.PlatformAndroid: sink}
+
+	if keyFile := os.Getenv("APNS_KEY_FILE"); keyFile != "" {
+		apns, err := NewAPNs(APNsConfig{
+			KeyFile: keyFile,
+			KeyID:   os.Getenv("APNS_KEY_ID"),
+			TeamID:  os.Getenv("APNS_TEAM_ID"),
+			Topic:   os.Getenv("APNS_TOPIC"),
+			Sandbox: os.Getenv("APNS_SANDBOX") == "true",
+		})
+		if err != nil {
+			return nil, err
+		}
+		platforms[model.PlatformIOS] = apns
+	} else {
+		log.Println("APNS_KEY_FILE is not set, iOS push notifications are written to the log")
+	}
+
+	if credentialsFile := os.Getenv("FCM_CREDENTIALS_FILE"); credentialsFile != "" {
+		fcm, err := NewFCM(context.Background(), FCMConfig{
+			CredentialsFile: credentialsFile,
+			ProjectID:       os.Getenv("FCM_PROJECT_ID"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		platforms[model.PlatformAndroid] = fcm
+	} else {
+		log.Println("FCM_CREDENTIALS_FILE is not set, Android push notifications are written to the log")
+	}
+
+	return platforms, nil
+}
@@ -0,0 +1,232 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/helpers"
+	"github.com/CoffeeHausGames/whir-server/app/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxMatchesPerRun keeps one run of the evaluator short, the rest is delivered by the next run
+const maxMatchesPerRun = 500
+
+// EvaluateSavedSearches matches the deals that went live since the time given against the saved searches
+// and alerts the users of every new match on the channels of their search. A deal only matches a search
+// that existed before the deal went live and is only alerted about once per search, so since can overlap
+// with the last run. Returns how many new matches were found
+func (s *Service) EvaluateSavedSearches(ctx context.Context, since time.Time) (int, error) {
+	cursor, err := s.deals.Find(ctx, bson.M{
+		"status":            model.DealLive,
+		"published_at":      bson.M{"$gte": since},
+		"remaining":         bson.M{"$not": bson.M{"$lte": 0}},
+		"moderation_status": model.PubliclyVisible(),
+	}, options.Find().SetSort(bson.D{{Key: "published_at", Value: 1}}))
+	if err != nil {
+		return 0, err
+	}
+	var deals []*model.Deal
+	if err := cursor.All(ctx, &deals); err != nil {
+		return 0, err
+	}
+
+	found := 0
+	for _, deal := range deals {
+		matched, err := s.matchSavedSearches(ctx, deal)
+		if err != nil {
+			return found, err
+		}
+		found += matched
+	}
+
+	return found, s.deliverMatches(ctx)
+}
+
+// matchSavedSearches saves a match of the deal for every saved search it is new to
+func (s *Service) matchSavedSearches(ctx context.Context, deal *model.Deal) (int, error) {
+	business := new(model.BusinessUser)
+	err := s.businesses.FindOne(business, ctx, bson.M{"_id": deal.Business_id})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if business.Location == nil || len(business.Location.Coordinates) != 2 {
+		return 0, nil
+	}
+	longitude, latitude := business.Location.Coordinates[0], business.Location.Coordinates[1]
+
+	// searches can have different radiuses, the query gets everything within the largest one
+	cursor, err := s.savedSearches.Find(ctx, bson.M{
+		"paused":     false,
+		"created_at": bson.M{"$lte": deal.Published_at},
+		"location": bson.M{
+			"$near": bson.M{
+				"$geometry":    bson.M{"type": "Point", "coordinates": []float64{longitude, latitude}},
+				"$maxDistance": model.MaxSavedSearchRadius * helpers.MetersPerMile,
+			},
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+	var searches []*model.SavedSearch
+	if err := cursor.All(ctx, &searches); err != nil {
+		return 0, err
+	}
+
+	matched := 0
+	for _, search := range searches {
+		distance := helpers.DistanceMiles(search.Location.Coordinates[1], search.Location.Coordinates[0], latitude, longitude)
+		if distance > search.Radius || !search.MatchesDeal(deal) {
+			continue
+		}
+
+		// the runs overlap, a match saved by an earlier run is left as it is
+		result, err := s.savedSearchMatches.UpdateOne(ctx,
+			bson.M{"search_id": search.ID, "deal_id": deal.ID},
+			bson.M{"$setOnInsert": bson.M{
+				"_id":         primitive.NewObjectID(),
+				"user_id":     search.User_id,
+				"business_id": deal.Business_id,
+				"notified":    false,
+				"matched_at":  time.Now().UTC(),
+			}},
+			options.Update().SetUpsert(true),
+		)
+		if mongo.IsDuplicateKeyError(err) {
+			// saved by a run at the same time
+			continue
+		}
+		if err != nil {
+			return matched, err
+		}
+		if result.UpsertedCount > 0 {
+			matched++
+		}
+	}
+	return matched, nil
+}
+
+// deliverMatches alerts the users of the matches that were not alerted yet.
+// Every new match of a search gets its own push notification, email gets one message per search
+func (s *Service) deliverMatches(ctx context.Context) error {
+	cursor, err := s.savedSearchMatches.Find(ctx, bson.M{"notified": false},
+		options.Find().SetSort(bson.D{{Key: "matched_at", Value: 1}}).SetLimit(maxMatchesPerRun))
+	if err != nil {
+		return err
+	}
+	var matches []*model.SavedSearchMatch
+	if err := cursor.All(ctx, &matches); err != nil {
+		return err
+	}
+
+	var searchIDs []primitive.ObjectID
+	matchesBySearch := make(map[primitive.ObjectID][]*model.SavedSearchMatch)
+	for _, match := range matches {
+		if _, ok := matchesBySearch[match.Search_id]; !ok {
+			searchIDs = append(searchIDs, match.Search_id)
+		}
+		matchesBySearch[match.Search_id] = append(matchesBySearch[match.Search_id], match)
+	}
+
+	for _, searchID := range searchIDs {
+		if err := s.deliverSearchMatches(ctx, searchID, matchesBySearch[searchID]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deliverSearchMatches alerts the user of one search about its matches and marks them notified.
+// Matches of a search that was paused or deleted in the meantime are marked without an alert
+func (s *Service) deliverSearchMatches(ctx context.Context, searchID primitive.ObjectID, matches []*model.SavedSearchMatch) error {
+	matchIDs := bson.A{}
+	for _, match := range matches {
+		matchIDs = append(matchIDs, match.ID)
+	}
+	markNotified := func() error {
+		_, err := s.savedSearchMatches.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": matchIDs}}, bson.M{"$set": bson.M{"notified": true}})
+		return err
+	}
+
+	search := new(model.SavedSearch)
+	err := s.savedSearches.FindOne(search, ctx, bson.M{"_id": searchID})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return markNotified()
+	}
+	if err != nil {
+		return err
+	}
+	if search.Paused {
+		return markNotified()
+	}
+
+	var lines []string
+	for _, match := range matches {
+		deal := new(model.Deal)
+		err := s.deals.FindOne(deal, ctx, bson.M{"_id": match.Deal_id})
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		business := new(model.BusinessUser)
+		if err := s.businesses.FindOne(business, ctx, bson.M{"_id": deal.Business_id}); err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return err
+		}
+		lines = append(lines, "- "+stringValue(deal.Name)+" at "+matchBusinessName(business))
+
+		if search.HasChannel(model.SearchChannelPush) {
+			data := dealData(model.NotificationSavedSearch, deal)
+			data["search_id"] = search.ID.Hex()
+			message := &Message{
+				Title: "New match for " + search.Name,
+				Body:  stringValue(deal.Name) + " at " + matchBusinessName(business),
+				Data:  data,
+			}
+			dedupKey := model.NotificationSavedSearch + ":" + search.ID.Hex() + ":" + deal.ID.Hex()
+			if err := s.Notify(ctx, search.User_id, model.NotificationSavedSearch, dedupKey, message); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(lines) > 0 && search.HasChannel(model.SearchChannelEmail) {
+		subject := "New deals for your saved search " + search.Name
+		body := "These deals just went live and match your saved search \"" + search.Name + "\":\n\n" +
+			strings.Join(lines, "\n") + "\n\nPause or delete the search in the app to stop these emails."
+		// the first match identifies this batch, a retried run queues the same email
+		dedupKey := model.NotificationSavedSearch + ":" + matches[0].ID.Hex()
+		if err := s.Email(ctx, search.User_id, dedupKey, subject, body); err != nil {
+			return err
+		}
+	}
+
+	if len(lines) > 0 {
+		_, err := s.savedSearches.UpdateOne(ctx,
+			bson.M{"_id": search.ID},
+			bson.M{"$set": bson.M{"last_matched_at": matches[len(matches)-1].Matched_at}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return markNotified()
+}
+
+func matchBusinessName(business *model.BusinessUser) string {
+	if business.Business_name == nil {
+		return "a business near you"
+	}
+	return *business.Business_name
+}
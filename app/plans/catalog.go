@@ -0,0 +1,137 @@
+package plans
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+)
+
+// plans decides what a business can do on its subscription plan.
+//
+// The catalog of plans comes from config, every business is on one of its plans and the limits of
+// the plan are checked by the handlers through [Service] before anything that counts against them is added,
+// a [Reservation] holds the limit while the check and the insert happen so concurrent requests wait their turn.
+// A business that goes over a limit after a downgrade keeps what it has but can not add more.
+
+// Catalog is the list of plans businesses can be on, from the cheapest to the most expensive
+type Catalog struct {
+	Default string        `json:"default"` // the plan of businesses that never picked one
+	Plans   []*model.Plan `json:"plans"`
+}
+
+// DefaultCatalog is the catalog used when PLANS_FILE is not set
+func DefaultCatalog() *Catalog {
+	return &Catalog{
+		Default: "free",
+		Plans: []*model.Plan{
+			{ID: "free", Name: "Free", Limits: map[string]int64{
+				model.LimitPinnedDeals:     3,
+				model.LimitActiveDeals:     10,
+				model.LimitStaffSeats:      1,
+				model.LimitImageUploads:    50,
+				model.LimitWebhooks:        1,
+				model.LimitPromotionBudget: 0,
+			}},
+			{ID: "pro", Name: "Pro", Limits: map[string]int64{
+				model.LimitPinnedDeals:     10,
+				model.LimitActiveDeals:     100,
+				model.LimitStaffSeats:      5,
+				model.LimitImageUploads:    1000,
+				model.LimitWebhooks:        5,
+				model.LimitPromotionBudget: 50000,
+			}},
+			{ID: "enterprise", Name: "Enterprise", Limits: map[string]int64{
+				model.LimitPinnedDeals:     model.Unlimited,
+				model.LimitActiveDeals:     model.Unlimited,
+				model.LimitStaffSeats:      model.Unlimited,
+				model.LimitImageUploads:    model.Unlimited,
+				model.LimitWebhooks:        model.Unlimited,
+				model.LimitPromotionBudget: 500000,
+			}},
+		},
+	}
+}
+
+// CatalogFromEnv returns the catalog in the JSON file at PLANS_FILE, or [DefaultCatalog] when it is not set
+func CatalogFromEnv() (*Catalog, error) {
+	path := os.Getenv("PLANS_FILE")
+	if path == "" {
+		log.Println("Using the default plan catalog")
+		return DefaultCatalog(), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	catalog := new(Catalog)
+	if err := json.Unmarshal(data, catalog); err != nil {
+		return nil, fmt.Errorf("PLANS_FILE: %v", err)
+	}
+	if err := catalog.validate(); err != nil {
+		return nil, fmt.Errorf("PLANS_FILE: %v", err)
+	}
+	log.Println("Using the plan catalog in " + path)
+	return catalog, nil
+}
+
+// Get returns the plan with the id
+func (c *Catalog) Get(id string) (*model.Plan, bool) {
+	for _, plan := range c.Plans {
+		if plan.ID == id {
+			return plan, true
+		}
+	}
+	return nil, false
+}
+
+// PlanOf returns the plan the business is on, the default plan when it never picked one
+// or its plan was taken out of the catalog
+func (c *Catalog) PlanOf(business *model.BusinessUser) *model.Plan {
+	if plan, ok := c.Get(business.Plan); ok {
+		return plan
+	}
+	plan, _ := c.Get(c.Default)
+	return plan
+}
+
+// upgradeFor returns the cheapest plan after current that allows more of limit, nil when there is none
+func (c *Catalog) upgradeFor(current *model.Plan, limit string, used int64, more int64) *model.Plan {
+	after := false
+	for _, plan := range c.Plans {
+		if after && plan.AllowsMore(limit, used, more) {
+			return plan
+		}
+		after = after || plan.ID == current.ID
+	}
+	return nil
+}
+
+// validate makes sure the catalog can be used
+func (c *Catalog) validate() error {
+	if len(c.Plans) == 0 {
+		return errors.New("the catalog has no plans")
+	}
+	seen := map[string]bool{}
+	for _, plan := range c.Plans {
+		if plan.ID == "" || seen[plan.ID] {
+			return fmt.Errorf("plan IDs must be set and unique, got %q", plan.ID)
+		}
+		seen[plan.ID] = true
+		for limit, max := range plan.Limits {
+			if _, ok := limitNames[limit]; !ok {
+				return fmt.Errorf("plan %s has an unknown limit %s", plan.ID, limit)
+			}
+			if max < model.Unlimited {
+				return fmt.Errorf("plan %s: limit %s must be -1 (unlimited) or more", plan.ID, limit)
+			}
+		}
+	}
+	if !seen[c.Default] {
+		return fmt.Errorf("the default plan %q is not in the catalog", c.Default)
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+package plans
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/app/server/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// limitNames is how each limit reads in an error
+var limitNames = map[string]string{
+	model.LimitPinnedDeals:     "pinned deals",
+	model.LimitActiveDeals:     "active deals",
+	model.LimitStaffSeats:      "staff seats",
+	model.LimitImageUploads:    "image uploads a month",
+	model.LimitWebhooks:        "webhooks",
+	model.LimitPromotionBudget: "cents of budget in promotions that have not ended",
+}
+
+// reservationFor is how long a reservation that is not released holds a limit, longer than any request takes
+const reservationFor = 10 * time.Second
+
+// reservationRetry is how long Reserve waits before trying again while another request holds the limit
+const reservationRetry = 20 * time.Millisecond
+
+// LimitError is returned when a business is at a limit of its plan
+type LimitError struct {
+	Limit   string
+	Max     int64
+	Plan    *model.Plan
+	Upgrade *model.Plan // the cheapest plan that allows more, nil when no plan does
+}
+
+func (e *LimitError) Error() string {
+	msg := fmt.Sprintf("the %s plan allows %d %s", e.Plan.Name, e.Max, limitNames[e.Limit])
+	if e.Upgrade != nil {
+		msg += fmt.Sprintf(", upgrade to %s for more", e.Upgrade.Name)
+	}
+	return msg
+}
+
+// Service checks what businesses use against the limits of their plan
+type Service struct {
+	catalog      *Catalog
+	deals        model.Collection
+	webhooks     model.Collection
+	promotions   model.Collection
+	usage        model.Collection
+	reservations model.Collection
+}
+
+// NewService returns a [Service] for the plans in catalog
+func NewService(db *database.Database, catalog *Catalog) *Service {
+	return &Service{
+		catalog:      catalog,
+		deals:        db.GetDeals(),
+		webhooks:     db.GetWebhooks(),
+		promotions:   db.GetPromotions(),
+		usage:        db.GetPlanUsage(),
+		reservations: db.GetPlanReservations(),
+	}
+}
+
+// Catalog returns the plans businesses can be on
+func (s *Service) Catalog() *Catalog {
+	return s.catalog
+}
+
+// PlanOf returns the plan the business is on
+func (s *Service) PlanOf(business *model.BusinessUser) *model.Plan {
+	return s.catalog.PlanOf(business)
+}
+
+// Check returns a [*LimitError] when the business can not add one more of limit
+func (s *Service) Check(ctx context.Context, business *model.BusinessUser, limit string) error {
+	plan := s.PlanOf(business)
+	if plan.Limit(limit) == model.Unlimited {
+		return nil
+	}
+	used, err := s.used(ctx, business, limit)
+	if err != nil {
+		return err
+	}
+	return s.limitError(plan, limit, used, 1)
+}
+
+// Reservation holds a limit of a business, see [Service.Reserve]
+type Reservation struct {
+	reservations model.Collection
+	id           string
+	token        primitive.ObjectID
+}
+
+// Reserve checks the business can add more of limit, e.g. more promotion budget, and holds the limit until the
+// reservation is released, so requests of a business adding to the same limit at once are checked one after
+// the other instead of all counting the same usage. Release it once the addition is saved or has failed.
+// It returns a [*LimitError] and holds nothing when the business is at the limit
+func (s *Service) Reserve(ctx context.Context, business *model.BusinessUser, limit string, more int64) (*Reservation, error) {
+	plan := s.PlanOf(business)
+	if plan.Limit(limit) == model.Unlimited {
+		return &Reservation{}, nil
+	}
+
+	reservation := &Reservation{reservations: s.reservations, id: business.ID.Hex() + ":" + limit, token: primitive.NewObjectID()}
+	for {
+		now := time.Now().UTC()
+		// a reservation that ran out was not released, e.g. the server stopped, and is taken over
+		_, err := s.reservations.UpdateOne(ctx,
+			bson.M{"_id": reservation.id, "expires_at": bson.M{"$lte": now}},
+			bson.M{"$set": bson.M{"token": reservation.token, "expires_at": now.Add(reservationFor)}},
+			options.Update().SetUpsert(true))
+		if err == nil {
+			break
+		}
+		// another request holds it, the upsert ran into the _id
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(reservationRetry):
+		}
+	}
+
+	used, err := s.used(ctx, business, limit)
+	if err == nil {
+		err = s.limitError(plan, limit, used, more)
+	}
+	if err != nil {
+		reservation.Release()
+		return nil, err
+	}
+	return reservation, nil
+}
+
+// Release gives the limit back to other requests of the business, it is safe to call more than once
+func (r *Reservation) Release() {
+	if r.reservations == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := r.reservations.DeleteOne(ctx, bson.M{"_id": r.id, "token": r.token}); err != nil {
+		// it runs out on its own
+		log.Println("Plan reservation " + r.id + " was not released: " + err.Error())
+	}
+	r.reservations = nil
+}
+
+// Usage returns how much of each limit the business uses
+func (s *Service) Usage(ctx context.Context, business *model.BusinessUser) (map[string]int64, error) {
+	usage := map[string]int64{}
+	for limit := range limitNames {
+		used, err := s.used(ctx, business, limit)
+		if err != nil {
+			return nil, err
+		}
+		usage[limit] = used
+	}
+	return usage, nil
+}
+
+// ConsumeImageUpload counts an image upload of the business this month,
+// it returns a [*LimitError] and counts nothing when the business is out of uploads
+func (s *Service) ConsumeImageUpload(ctx context.Context, business *model.BusinessUser) error {
+	plan := s.PlanOf(business)
+	max := plan.Limit(model.LimitImageUploads)
+	if max == 0 {
+		return s.limitError(plan, model.LimitImageUploads, 0, 1)
+	}
+
+	filter := bson.M{"business_id": business.ID, "period": period(time.Now())}
+	if max != model.Unlimited {
+		filter["image_uploads"] = bson.M{"$lt": max}
+	}
+	update := bson.M{"$inc": bson.M{"image_uploads": 1}}
+	// when the business is out of uploads the filter matches nothing and the upsert runs into the unique index
+	_, err := s.usage.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+	// the first upload of the month can race with another, the document exists now so try once more without upserting
+	res, err := s.usage.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return s.limitError(plan, model.LimitImageUploads, max, 1)
+	}
+	return nil
+}
+
+// ReleaseImageUpload gives back an upload counted by [Service.ConsumeImageUpload] when the image was not stored
+func (s *Service) ReleaseImageUpload(ctx context.Context, businessID primitive.ObjectID) error {
+	filter := bson.M{"business_id": businessID, "period": period(time.Now()), "image_uploads": bson.M{"$gt": 0}}
+	_, err := s.usage.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"image_uploads": -1}})
+	return err
+}
+
+// used counts how much of limit the business uses
+func (s *Service) used(ctx context.Context, business *model.BusinessUser, limit string) (int64, error) {
+	switch limit {
+	case model.LimitPinnedDeals:
+		return int64(len(business.PinnedDeals)), nil
+	case model.LimitActiveDeals:
+		return s.deals.CountDocuments(ctx, bson.M{
+			"business_id": business.ID,
+			"$or": bson.A{
+				bson.M{"status": bson.M{"$in": bson.A{model.DealLive, model.DealScheduled}}},
+				// deals from before statuses are live
+				bson.M{"status": bson.M{"$in": bson.A{nil, ""}}},
+			},
+		})
+	case model.LimitWebhooks:
+		return s.webhooks.CountDocuments(ctx, bson.M{"business_id": business.ID})
+	case model.LimitStaffSeats:
+		// the owner is the only account of a business for now
+		return 1, nil
+	case model.LimitPromotionBudget:
+		cursor, err := s.promotions.Find(ctx, bson.M{
+			"business_id": business.ID,
+			"status":      bson.M{"$ne": model.PromotionCanceled},
+			"end_date":    bson.M{"$gt": time.Now().UTC()},
+		}, options.Find().SetProjection(bson.M{"budget": 1}))
+		if err != nil {
+			return 0, err
+		}
+		var open []struct {
+			Budget int64 `bson:"budget"`
+		}
+		if err := cursor.All(ctx, &open); err != nil {
+			return 0, err
+		}
+		var budget int64
+		for _, promotion := range open {
+			budget += promotion.Budget
+		}
+		return budget, nil
+	case model.LimitImageUploads:
+		var usage model.PlanUsage
+		err := s.usage.FindOne(&usage, ctx, bson.M{"business_id": business.ID, "period": period(time.Now())})
+		if err == mongo.ErrNoDocuments {
+			return 0, nil
+		}
+		return usage.Image_uploads, err
+	}
+	return 0, fmt.Errorf("unknown plan limit %s", limit)
+}
+
+// limitError returns a [*LimitError] when used and more go over the limit of the plan, nil otherwise
+func (s *Service) limitError(plan *model.Plan, limit string, used int64, more int64) error {
+	if plan.AllowsMore(limit, used, more) {
+		return nil
+	}
+	return &LimitError{
+		Limit:   limit,
+		Max:     plan.Limit(limit),
+		Plan:    plan,
+		Upgrade: s.catalog.upgradeFor(plan, limit, used, more),
+	}
+}
+
+// period is the month usage is counted in
+func period(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
@@ -0,0 +1,308 @@
+package promotions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/helpers"
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/app/server/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// promotions shows the deals businesses pay to promote at the top of nearby searches.
+//
+// There is no auction: every running promotion that targets the searcher is equally eligible and the ones
+// shown longest ago go first, so promotions take turns no matter their budget. The budget only decides
+// how long a promotion keeps running. Each impression and click is paid for out of the budget with
+// a conditional update, so a promotion never spends more than its budget even when searches race.
+
+const (
+	// defaultImpressionCost is what an impression costs in cents when PROMOTION_IMPRESSION_CENTS is not set
+	defaultImpressionCost = 1
+	// defaultClickCost is what a click costs in cents when PROMOTION_CLICK_CENTS is not set
+	defaultClickCost = 25
+	// candidatesLimit is how many promotions near a search are looked at for its sponsored results
+	candidatesLimit = 50
+	// earthRadiusMiles turns miles into the radians $centerSphere takes
+	earthRadiusMiles = 3963.2
+)
+
+// ErrPromotionNotFound is returned for clicks on a promotion that does not exist
+var ErrPromotionNotFound = errors.New("promotion not found")
+
+// Pricing is what impressions and clicks of promotions cost in cents
+type Pricing struct {
+	Impression int64
+	Click      int64
+}
+
+// PricingFromEnv returns the pricing from PROMOTION_IMPRESSION_CENTS and PROMOTION_CLICK_CENTS
+func PricingFromEnv() (Pricing, error) {
+	pricing := Pricing{Impression: defaultImpressionCost, Click: defaultClickCost}
+	for name, cost := range map[string]*int64{"PROMOTION_IMPRESSION_CENTS": &pricing.Impression, "PROMOTION_CLICK_CENTS": &pricing.Click} {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		cents, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || cents < 0 {
+			return Pricing{}, fmt.Errorf("%s must be a number of cents", name)
+		}
+		*cost = cents
+	}
+	return pricing, nil
+}
+
+// Placement is a promotion shown in a search with the deal and business it promotes
+type Placement struct {
+	Promotion *model.Promotion
+	Deal      *model.Deal
+	Business  *model.BusinessUser
+}
+
+// Service picks the promotions shown in searches and counts what they are charged
+type Service struct {
+	pricing    Pricing
+	promotions model.Collection
+	stats      model.Collection
+	clicks     model.Collection
+	deals      model.Collection
+	businesses model.Collection
+}
+
+// NewService returns a [Service] that charges pricing
+func NewService(db *database.Database, pricing Pricing) *Service {
+	return &Service{
+		pricing:    pricing,
+		promotions: db.GetPromotions(),
+		stats:      db.GetPromotionStats(),
+		clicks:     db.GetPromotionClicks(),
+		deals:      db.GetDeals(),
+		businesses: db.GetBusinesses(),
+	}
+}
+
+// Pricing returns what impressions and clicks cost
+func (s *Service) Pricing() Pricing {
+	return s.pricing
+}
+
+// FillState sets the state of each promotion from its dates and budget
+func (s *Service) FillState(promotions ...*model.Promotion) {
+	now := time.Now()
+	for _, promotion := range promotions {
+		promotion.State = promotion.CurrentState(now, s.pricing.Impression)
+	}
+}
+
+// Serve picks the sponsored results of a search at latitude, longitude and charges each one an impression.
+// It returns at most [model.MaxSponsoredResults] placements, each of a different business, with a deal
+// that is still discoverable and passes match when it is not nil
+func (s *Service) Serve(ctx context.Context, latitude float64, longitude float64, match func(*model.Deal) bool) ([]*Placement, error) {
+	now := time.Now().UTC()
+	filter := s.runningFilter(now)
+	filter["location"] = bson.M{
+		"$geoWithin": bson.M{
+			"$centerSphere": bson.A{bson.A{longitude, latitude}, model.MaxPromotionRadius / earthRadiusMiles},
+		},
+	}
+	// never shown promotions have no last_served_at and sort first
+	opts := options.Find().SetSort(bson.D{{Key: "last_served_at", Value: 1}}).SetLimit(candidatesLimit)
+	cursor, err := s.promotions.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []*model.Promotion
+	if err := cursor.All(ctx, &candidates); err != nil {
+		return nil, err
+	}
+
+	placements := []*Placement{}
+	placed := map[primitive.ObjectID]bool{}
+	for _, promotion := range candidates {
+		if len(placements) == model.MaxSponsoredResults {
+			break
+		}
+		if placed[promotion.Business_id] || !targets(promotion, latitude, longitude) {
+			continue
+		}
+		placement, err := s.place(ctx, promotion, match)
+		if err != nil {
+			return placements, err
+		}
+		if placement == nil {
+			continue
+		}
+		charged, err := s.chargeImpression(ctx, promotion, now)
+		if err != nil {
+			return placements, err
+		}
+		if charged {
+			placed[promotion.Business_id] = true
+			placements = append(placements, placement)
+		}
+	}
+	return placements, nil
+}
+
+// Click counts a click on a sponsored result by visitor. The first click of a visitor on a day is charged
+// while the promotion is running and its budget covers it, later ones are not counted
+func (s *Service) Click(ctx context.Context, promotionID primitive.ObjectID, visitor string) error {
+	promotion := new(model.Promotion)
+	err := s.promotions.FindOne(promotion, ctx, bson.M{"_id": promotionID})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrPromotionNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	_, err = s.clicks.InsertOne(ctx, &model.PromotionClick{
+		Promotion_id: promotion.ID,
+		Visitor:      visitor,
+		Day:          day(now),
+		Created_at:   now,
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	cost := s.pricing.Click
+	filter := s.runningFilter(now)
+	filter["_id"] = promotion.ID
+	filter["$expr"] = budgetCovers(cost)
+	res, err := s.promotions.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"clicks": 1, "spent": cost}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		// the click came after the promotion stopped or ran out of budget, it is counted for free
+		cost = 0
+		if _, err := s.promotions.UpdateOne(ctx, bson.M{"_id": promotion.ID}, bson.M{"$inc": bson.M{"clicks": 1}}); err != nil {
+			return err
+		}
+	}
+	return s.count(ctx, promotion.ID, now, "clicks", cost)
+}
+
+// Stats returns the daily counts of a promotion, oldest day first
+func (s *Service) Stats(ctx context.Context, promotionID primitive.ObjectID) ([]*model.PromotionStats, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "day", Value: 1}})
+	cursor, err := s.stats.Find(ctx, bson.M{"promotion_id": promotionID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	stats := []*model.PromotionStats{}
+	if err := cursor.All(ctx, &stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// place loads the deal and business of a promotion, it returns nil when either can not be shown
+func (s *Service) place(ctx context.Context, promotion *model.Promotion, match func(*model.Deal) bool) (*Placement, error) {
+	deal := new(model.Deal)
+	err := s.deals.FindOne(deal, ctx, bson.M{
+		"_id":               promotion.Deal_id,
+		"business_id":       promotion.Business_id,
+		"status":            bson.M{"$in": bson.A{model.DealLive, nil}},
+		"remaining":         bson.M{"$not": bson.M{"$lte": 0}},
+		"moderation_status": model.PubliclyVisible(),
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	deal.ComputeFields()
+	if match != nil && !match(deal) {
+		return nil, nil
+	}
+
+	business := new(model.BusinessUser)
+	err = s.businesses.FindOne(business, ctx, bson.M{
+		"_id":               promotion.Business_id,
+		"moderation_status": model.PubliclyVisible(),
+		"suspended_at":      bson.M{"$exists": false},
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Placement{Promotion: promotion, Deal: deal, Business: business}, nil
+}
+
+// chargeImpression pays for an impression of the promotion, it returns false when the promotion
+// stopped or can not pay for it anymore
+func (s *Service) chargeImpression(ctx context.Context, promotion *model.Promotion, now time.Time) (bool, error) {
+	cost := s.pricing.Impression
+	filter := s.runningFilter(now)
+	filter["_id"] = promotion.ID
+	filter["$expr"] = budgetCovers(cost)
+	res, err := s.promotions.UpdateOne(ctx, filter, bson.M{
+		"$inc": bson.M{"impressions": 1, "spent": cost},
+		"$set": bson.M{"last_served_at": now},
+	})
+	if err != nil || res.MatchedCount == 0 {
+		return false, err
+	}
+	return true, s.count(ctx, promotion.ID, now, "impressions", cost)
+}
+
+// count adds one to field and cost to the spend of the promotion on the day of now
+func (s *Service) count(ctx context.Context, promotionID primitive.ObjectID, now time.Time, field string, cost int64) error {
+	filter := bson.M{"promotion_id": promotionID, "day": day(now)}
+	update := bson.M{"$inc": bson.M{field: 1, "spent": cost}}
+	_, err := s.stats.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+	// the first count of the day raced with another, the document exists now
+	_, err = s.stats.UpdateOne(ctx, filter, update)
+	return err
+}
+
+// runningFilter matches the promotions that are active and within their dates at now
+func (s *Service) runningFilter(now time.Time) bson.M {
+	return bson.M{
+		"status":     model.PromotionActive,
+		"start_date": bson.M{"$lte": now},
+		"end_date":   bson.M{"$gt": now},
+		"$expr":      budgetCovers(s.pricing.Impression),
+	}
+}
+
+// budgetCovers is an $expr that matches promotions with cost left in their budget
+func budgetCovers(cost int64) bson.M {
+	return bson.M{"$lte": bson.A{bson.M{"$add": bson.A{"$spent", cost}}, "$budget"}}
+}
+
+// targets tells if a search at latitude, longitude is within the radius of the promotion
+func targets(promotion *model.Promotion, latitude float64, longitude float64) bool {
+	if promotion.Location == nil || len(promotion.Location.Coordinates) != 2 {
+		return false
+	}
+	distance := helpers.DistanceMiles(latitude, longitude, promotion.Location.Coordinates[1], promotion.Location.Coordinates[0])
+	return distance <= promotion.Radius
+}
+
+// day is the UTC day counts are kept for
+func day(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
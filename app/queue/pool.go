@@ -0,0 +1,135 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Handler does the work of one task type
+// returning an error retries the task later
+type Handler func(ctx context.Context, task *model.Task) error
+
+// Pool is a group of workers that run tasks from a [Queue]
+type Pool struct {
+	queue        *Queue
+	handlers     map[string]Handler
+	workers      int
+	visibility   time.Duration
+	pollInterval time.Duration
+	owner        string // the workers lease as owner-<number>
+	wg           sync.WaitGroup
+}
+
+// NewPool returns a [Pool] with the number of workers
+// each task can take up to visibility before another worker can lease it again
+func NewPool(queue *Queue, workers int, visibility time.Duration) *Pool {
+	hostname, _ := os.Hostname()
+	return &Pool{
+		queue:        queue,
+		handlers:     make(map[string]Handler),
+		workers:      workers,
+		visibility:   visibility,
+		pollInterval: 2 * time.Second,
+		owner:        fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), primitive.NewObjectID().Hex()[18:]),
+	}
+}
+
+// Handle registers the handler for a task type, must be called before [Pool.Start]
+func (p *Pool) Handle(taskType string, handler Handler) {
+	p.handlers[taskType] = handler
+}
+
+// Start runs the workers until ctx is cancelled
+func (p *Pool) Start(ctx context.Context) {
+	types := make([]string, 0, len(p.handlers))
+	for taskType := range p.handlers {
+		types = append(types, taskType)
+	}
+
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+		go p.work(ctx, fmt.Sprintf("%s-%d", p.owner, i), types)
+	}
+	log.Printf("Task queue started with %d workers for %v\n", p.workers, types)
+}
+
+// Wait blocks until every worker stopped after ctx given to [Pool.Start] was cancelled
+func (p *Pool) Wait() {
+	p.wg.Wait()
+}
+
+// work leases tasks one at a time as owner and sleeps when the queue is empty
+func (p *Pool) work(ctx context.Context, owner string, types []string) {
+	defer p.wg.Done()
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		task, err := p.queue.Lease(ctx, owner, types, p.visibility)
+		if err != nil && ctx.Err() == nil {
+			log.Println("Task queue lease failed: " + err.Error())
+		}
+
+		if task == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(p.pollInterval):
+			}
+			continue
+		}
+
+		p.run(task)
+	}
+}
+
+// run calls the handler of the task and records the result.
+// A task that already started is allowed to finish when the server is stopping
+func (p *Pool) run(task *model.Task) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.visibility)
+	defer cancel()
+
+	err := p.call(ctx, task)
+
+	// the handler may have used up the context so recording gets its own
+	recordCtx, recordCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer recordCancel()
+
+	if err == nil {
+		err = p.queue.Complete(recordCtx, task)
+		if err != nil {
+			log.Printf("Task %s (%s) finished but could not be completed: %v\n", task.ID.Hex(), task.Type, err)
+		}
+		return
+	}
+
+	log.Printf("Task %s (%s) attempt %d failed: %v\n", task.ID.Hex(), task.Type, task.Attempts, err)
+	if err := p.queue.Fail(recordCtx, task, err); err != nil {
+		log.Printf("Task %s (%s) could not be failed: %v\n", task.ID.Hex(), task.Type, err)
+	}
+}
+
+// call runs the handler turning a panic into an error so one bad task can not stop a worker
+func (p *Pool) call(ctx context.Context, task *model.Task) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	handler, ok := p.handlers[task.Type]
+	if !ok {
+		return fmt.Errorf("no handler for task type %s", task.Type)
+	}
+	return handler(ctx, task)
+}
@@ -0,0 +1,223 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// queue is a durable task queue stored in mongo.
+// Handlers enqueue slow side effects (geocoding, emails, notifications) and return right away,
+// a [Pool] of workers inside the server process picks the tasks up.
+//
+// A worker leases a task for a visibility timeout. If the worker crashes the lease runs out
+// and another worker gets the task again, so task handlers must be safe to run more than once.
+
+// DefaultMaxAttempts is how many times a task is tried before it goes to the dead letter collection
+const DefaultMaxAttempts = 5
+
+// ErrLeaseLost is returned when a task is completed or failed after its lease ran out and it was leased again
+var ErrLeaseLost = errors.New("the task was leased again")
+
+// Queue adds tasks to and takes tasks from the task collection
+type Queue struct {
+	tasks     model.Collection
+	deadTasks model.Collection
+}
+
+// EnqueueOptions change how a task is run
+type EnqueueOptions struct {
+	// IdempotencyKey makes sure the same task is only queued once,
+	// enqueueing again with the same key returns the task already queued
+	IdempotencyKey string
+	// RunAt delays the task, the zero value runs it right away
+	RunAt time.Time
+	// MaxAttempts defaults to [DefaultMaxAttempts]
+	MaxAttempts int
+}
+
+// New returns a [Queue] using the tasks collection and the dead letter collection
+func New(tasks model.Collection, deadTasks model.Collection) *Queue {
+	return &Queue{tasks: tasks, deadTasks: deadTasks}
+}
+
+// Enqueue adds a task of taskType to the queue, the payload must be able to be marshalled to bson
+func (q *Queue) Enqueue(ctx context.Context, taskType string, payload interface{}, opts EnqueueOptions) (*model.Task, error) {
+	raw, err := bson.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	task := &model.Task{
+		ID:           primitive.NewObjectID(),
+		Type:         taskType,
+		Payload:      raw,
+		Status:       model.TaskPending,
+		Max_attempts: opts.MaxAttempts,
+		Run_at:       opts.RunAt.UTC(),
+		Created_at:   now,
+		Updated_at:   now,
+	}
+	if task.Max_attempts <= 0 {
+		task.Max_attempts = DefaultMaxAttempts
+	}
+	if opts.RunAt.IsZero() {
+		task.Run_at = now
+	}
+	if opts.IdempotencyKey != "" {
+		task.Idempotency_key = &opts.IdempotencyKey
+	}
+
+	_, err = q.tasks.InsertOne(ctx, task)
+	if mongo.IsDuplicateKeyError(err) && task.Idempotency_key != nil {
+		existing := new(model.Task)
+		err = q.tasks.FindOne(existing, ctx, bson.M{"idempotency_key": *task.Idempotency_key})
+		if err != nil {
+			return nil, err
+		}
+		return existing, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return task, nil
+}
+
+// Lease takes the next task that is ready to run for the visibility timeout
+// returns nil when there is nothing to do. Only tasks of the given types are leased
+func (q *Queue) Lease(ctx context.Context, owner string, types []string, visibility time.Duration) (*model.Task, error) {
+	now := time.Now().UTC()
+	filter := bson.M{
+		"type": bson.M{"$in": types},
+		"$or": bson.A{
+			bson.M{"status": model.TaskPending, "run_at": bson.M{"$lte": now}},
+			// the worker holding this lease died or took too long
+			bson.M{"status": model.TaskLeased, "leased_until": bson.M{"$lt": now}},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"status":       model.TaskLeased,
+			"leased_until": now.Add(visibility),
+			"lease_owner":  owner,
+			"updated_at":   now,
+		},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "run_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	task := new(model.Task)
+	err := q.tasks.FindOneAndUpdate(task, ctx, filter, update, opts)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// leaseFilter matches the task only while it still has the lease it was given, every lease adds an attempt
+// so a task leased again by the same owner does not match
+func leaseFilter(task *model.Task) bson.M {
+	return bson.M{"_id": task.ID, "lease_owner": task.Lease_owner, "attempts": task.Attempts}
+}
+
+// Complete marks a leased task as done, it returns [ErrLeaseLost] when the task was leased again
+func (q *Queue) Complete(ctx context.Context, task *model.Task) error {
+	now := time.Now().UTC()
+	result, err := q.tasks.UpdateOne(ctx,
+		leaseFilter(task),
+		bson.M{
+			"$set":   bson.M{"status": model.TaskDone, "completed_at": now, "updated_at": now},
+			"$unset": bson.M{"leased_until": "", "lease_owner": ""},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// Fail gives a leased task back to the queue to be tried again after a backoff
+// once the task has used all its attempts it is moved to the dead letter collection.
+// It returns [ErrLeaseLost] when the task was leased again
+func (q *Queue) Fail(ctx context.Context, task *model.Task, taskErr error) error {
+	now := time.Now().UTC()
+	task.Last_error = taskErr.Error()
+	task.Updated_at = now
+
+	if task.Attempts >= task.Max_attempts {
+		return q.deadLetter(ctx, task)
+	}
+
+	result, err := q.tasks.UpdateOne(ctx,
+		leaseFilter(task),
+		bson.M{
+			"$set": bson.M{
+				"status":     model.TaskPending,
+				"run_at":     now.Add(Backoff(task.Attempts)),
+				"last_error": task.Last_error,
+				"updated_at": now,
+			},
+			"$unset": bson.M{"leased_until": "", "lease_owner": ""},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// deadLetter moves a task that keeps failing out of the queue so it can be looked at
+func (q *Queue) deadLetter(ctx context.Context, task *model.Task) error {
+	task.Status = model.TaskDead
+	task.Leased_until = nil
+
+	_, err := q.deadTasks.InsertOne(ctx, task)
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+
+	result, err := q.tasks.DeleteOne(ctx, leaseFilter(task))
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		// another worker has the task now, it is not dead yet
+		if _, err := q.deadTasks.DeleteOne(ctx, bson.M{"_id": task.ID}); err != nil {
+			return err
+		}
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// Backoff is how long to wait before trying a task again, it doubles with each attempt up to an hour
+func Backoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	d := time.Duration(1<<uint(attempts-1)) * 10 * time.Second
+	if d > time.Hour || d <= 0 {
+		d = time.Hour
+	}
+	return d
+}
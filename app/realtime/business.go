@@ -0,0 +1,172 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/events"
+	"github.com/CoffeeHausGames/whir-server/app/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// dashboardBuffer is how many events can wait for a slow dashboard before it is disconnected
+const dashboardBuffer = 64
+
+// BusinessEvent is an event of a business sent to its dashboards
+type BusinessEvent struct {
+	ID   primitive.ObjectID // ID of the outbox event, the client sends it back to resume
+	Data []byte             // JSON message sent to the client
+}
+
+// businessMessage is the JSON message of a [BusinessEvent]
+type businessMessage struct {
+	ID          primitive.ObjectID `json:"id"`
+	Type        string             `json:"type"`
+	Occurred_at time.Time          `json:"occurred_at"`
+	Data        interface{}        `json:"data"`
+}
+
+// BusinessClient is one dashboard connection
+type BusinessClient struct {
+	businessID primitive.ObjectID
+	events     chan *BusinessEvent
+	closed     bool
+}
+
+// Events is where the events of the business arrive. It is closed when the client could not keep up
+func (c *BusinessClient) Events() <-chan *BusinessEvent {
+	return c.events
+}
+
+// BusinessHub sends every event of a business (claims, redemptions, deal changes, ...)
+// to the dashboards of that business connected to this instance
+type BusinessHub struct {
+	tail *outboxTail
+
+	mu      sync.RWMutex
+	clients map[primitive.ObjectID]map[*BusinessClient]struct{}
+}
+
+// NewBusinessHub returns a [BusinessHub] reading events from the outbox
+func NewBusinessHub(outbox model.Collection) *BusinessHub {
+	return &BusinessHub{
+		tail:    &outboxTail{name: "Business hub", outbox: outbox},
+		clients: make(map[primitive.ObjectID]map[*BusinessClient]struct{}),
+	}
+}
+
+// Start follows the outbox until ctx is cancelled
+func (h *BusinessHub) Start(ctx context.Context) {
+	go h.tail.run(ctx, func(ctx context.Context, outboxEvent *model.DomainEvent) {
+		// most events are for businesses with no dashboard open here
+		h.mu.RLock()
+		connected := len(h.clients[outboxEvent.Business_id]) > 0
+		h.mu.RUnlock()
+		if !connected {
+			return
+		}
+
+		if event := toBusinessEvent(outboxEvent); event != nil {
+			h.broadcast(outboxEvent.Business_id, event)
+		}
+	})
+}
+
+// Subscribe adds a dashboard of the business
+func (h *BusinessHub) Subscribe(businessID primitive.ObjectID) *BusinessClient {
+	c := &BusinessClient{businessID: businessID, events: make(chan *BusinessEvent, dashboardBuffer)}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.clients[businessID] == nil {
+		h.clients[businessID] = make(map[*BusinessClient]struct{})
+	}
+	h.clients[businessID][c] = struct{}{}
+	return c
+}
+
+// Unsubscribe removes a dashboard, it is safe to call more than once
+func (h *BusinessHub) Unsubscribe(c *BusinessClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(c)
+}
+
+// Replay returns the events of the business after lastEventID, oldest first
+func (h *BusinessHub) Replay(ctx context.Context, businessID primitive.ObjectID, lastEventID primitive.ObjectID) ([]*BusinessEvent, error) {
+	outboxEvents, err := h.tail.since(ctx, lastEventID, bson.M{"business_id": businessID})
+	if err != nil {
+		return nil, err
+	}
+
+	businessEvents := make([]*BusinessEvent, 0, len(outboxEvents))
+	for _, outboxEvent := range outboxEvents {
+		if event := toBusinessEvent(outboxEvent); event != nil {
+			businessEvents = append(businessEvents, event)
+		}
+	}
+	return businessEvents, nil
+}
+
+// broadcast sends the event to the dashboards of the business,
+// one that is too slow to take it is disconnected instead of growing a backlog
+func (h *BusinessHub) broadcast(businessID primitive.ObjectID, event *BusinessEvent) {
+	var slow []*BusinessClient
+
+	h.mu.RLock()
+	for c := range h.clients[businessID] {
+		select {
+		case c.events <- event:
+		default:
+			slow = append(slow, c)
+		}
+	}
+	h.mu.RUnlock()
+
+	if len(slow) == 0 {
+		return
+	}
+	h.mu.Lock()
+	for _, c := range slow {
+		h.remove(c)
+	}
+	h.mu.Unlock()
+}
+
+// remove takes the client out of the registry and closes its channel, h.mu must be held
+func (h *BusinessHub) remove(c *BusinessClient) {
+	if c.closed {
+		return
+	}
+	c.closed = true
+	delete(h.clients[c.businessID], c)
+	if len(h.clients[c.businessID]) == 0 {
+		delete(h.clients, c.businessID)
+	}
+	close(c.events)
+}
+
+func toBusinessEvent(outboxEvent *model.DomainEvent) *BusinessEvent {
+	payload, err := events.Payload(outboxEvent)
+	if err != nil {
+		log.Println("Business hub could not decode event " + outboxEvent.ID.Hex() + ": " + err.Error())
+		return nil
+	}
+
+	data, err := json.Marshal(businessMessage{
+		ID:          outboxEvent.ID,
+		Type:        outboxEvent.Type,
+		Occurred_at: outboxEvent.Occurred_at,
+		Data:        payload,
+	})
+	if err != nil {
+		log.Println("Business hub could not encode event " + outboxEvent.ID.Hex() + ": " + err.Error())
+		return nil
+	}
+	return &BusinessEvent{ID: outboxEvent.ID, Data: data}
+}
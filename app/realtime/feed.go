@@ -0,0 +1,221 @@
+package realtime
+
+import (
+	"context"
+	"math"
+	"sync"
+
+	"github.com/CoffeeHausGames/whir-server/app/helpers"
+	"github.com/CoffeeHausGames/whir-server/app/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// realtime pushes changes to connected clients.
+//
+// Every server instance tails the outbox itself and keeps its own registry of the clients
+// connected to it, so an event reaches the clients of every instance no matter which one
+// dispatched it. Nothing is shared between instances.
+
+// Types of the events sent on the deal feed
+const (
+	FeedDealCreated = "deal.created" // a deal became live
+	FeedDealUpdated = "deal.updated" // a live deal changed
+	FeedDealEnded   = "deal.ended"   // a deal is not live anymore (expired, archived, unpublished)
+)
+
+// subscriberBuffer is how many events can wait for a slow client before it is disconnected
+const subscriberBuffer = 32
+
+// FeedEvent is a deal change sent to the clients of the deal feed
+type FeedEvent struct {
+	ID        primitive.ObjectID // ID of the outbox event, the client sends it back as Last-Event-ID
+	Type      string
+	Latitude  float64 // where the business of the deal is
+	Longitude float64
+	Data      []byte // JSON body sent to the client
+}
+
+// Area is a circle around a location, the radius is in miles like the nearby business search
+type Area struct {
+	Latitude  float64
+	Longitude float64
+	Radius    float64
+}
+
+// Contains reports whether the location is inside the area
+func (a Area) Contains(latitude, longitude float64) bool {
+	return helpers.DistanceMiles(a.Latitude, a.Longitude, latitude, longitude) <= a.Radius
+}
+
+// cell is a one degree square of latitude and longitude.
+// Subscribers are kept by the cells their area touches so an event is only checked
+// against the clients near it, not every open connection
+type cell struct {
+	lat int
+	lng int
+}
+
+func cellOf(latitude, longitude float64) cell {
+	return cell{lat: int(math.Floor(latitude)), lng: int(math.Floor(longitude))}
+}
+
+// cells returns every cell the area touches
+func (a Area) cells() []cell {
+	latSpan := a.Radius / 69.0 // about 69 miles per degree of latitude
+	lngSpan := 360.0
+	if cos := math.Cos(a.Latitude * math.Pi / 180); cos > 0.01 {
+		lngSpan = math.Min(360, latSpan/cos)
+	}
+
+	min := cellOf(math.Max(-90, a.Latitude-latSpan), math.Max(-180, a.Longitude-lngSpan))
+	max := cellOf(math.Min(89.999, a.Latitude+latSpan), math.Min(179.999, a.Longitude+lngSpan))
+
+	var cells []cell
+	for lat := min.lat; lat <= max.lat; lat++ {
+		for lng := min.lng; lng <= max.lng; lng++ {
+			cells = append(cells, cell{lat: lat, lng: lng})
+		}
+	}
+	return cells
+}
+
+// FeedSubscriber is one client of the deal feed
+type FeedSubscriber struct {
+	area   Area
+	cells  []cell
+	events chan *FeedEvent
+	closed bool
+}
+
+// Events is where the events of the subscriber arrive. It is closed when the subscriber
+// could not keep up, the client should reconnect with the last event ID it got
+func (s *FeedSubscriber) Events() <-chan *FeedEvent {
+	return s.events
+}
+
+// DealFeed sends deal changes to the clients interested in the area the deal is in
+type DealFeed struct {
+	tail      *outboxTail
+	locations *businessLocations
+
+	mu          sync.RWMutex
+	byCell      map[cell]map[*FeedSubscriber]struct{}
+	subscribers int
+}
+
+// NewDealFeed returns a [DealFeed] reading events from the outbox,
+// the businesses collection is used to find where a deal is
+func NewDealFeed(outbox model.Collection, businesses model.Collection) *DealFeed {
+	return &DealFeed{
+		tail:      &outboxTail{name: "Deal feed", outbox: outbox, types: dealEventTypes},
+		locations: &businessLocations{businesses: businesses, cache: make(map[primitive.ObjectID]*feedBusiness)},
+		byCell:    make(map[cell]map[*FeedSubscriber]struct{}),
+	}
+}
+
+// Start follows the outbox until ctx is cancelled
+func (f *DealFeed) Start(ctx context.Context) {
+	go f.tail.run(ctx, func(ctx context.Context, outboxEvent *model.DomainEvent) {
+		if event := f.locations.toFeedEvent(ctx, outboxEvent); event != nil {
+			f.broadcast(event)
+		}
+	})
+}
+
+// Subscribe adds a client interested in the area
+func (f *DealFeed) Subscribe(area Area) *FeedSubscriber {
+	s := &FeedSubscriber{
+		area:   area,
+		cells:  area.cells(),
+		events: make(chan *FeedEvent, subscriberBuffer),
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range s.cells {
+		if f.byCell[c] == nil {
+			f.byCell[c] = make(map[*FeedSubscriber]struct{})
+		}
+		f.byCell[c][s] = struct{}{}
+	}
+	f.subscribers++
+	return s
+}
+
+// Unsubscribe removes a client, it is safe to call more than once
+func (f *DealFeed) Unsubscribe(s *FeedSubscriber) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.remove(s)
+}
+
+// Subscribers is how many clients are connected to this instance
+func (f *DealFeed) Subscribers() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.subscribers
+}
+
+// Replay returns the events after lastEventID that are inside the area, oldest first,
+// so a client that reconnects does not miss anything
+func (f *DealFeed) Replay(ctx context.Context, area Area, lastEventID primitive.ObjectID) ([]*FeedEvent, error) {
+	outboxEvents, err := f.tail.since(ctx, lastEventID, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	inArea := make([]*FeedEvent, 0, len(outboxEvents))
+	for _, outboxEvent := range outboxEvents {
+		event := f.locations.toFeedEvent(ctx, outboxEvent)
+		if event != nil && area.Contains(event.Latitude, event.Longitude) {
+			inArea = append(inArea, event)
+		}
+	}
+	return inArea, nil
+}
+
+// broadcast sends the event to every subscriber whose area it is in.
+// A subscriber that is too slow to take it is disconnected instead of holding up everyone else
+func (f *DealFeed) broadcast(event *FeedEvent) {
+	var slow []*FeedSubscriber
+
+	f.mu.RLock()
+	for s := range f.byCell[cellOf(event.Latitude, event.Longitude)] {
+		if !s.area.Contains(event.Latitude, event.Longitude) {
+			continue
+		}
+		select {
+		case s.events <- event:
+		default:
+			slow = append(slow, s)
+		}
+	}
+	f.mu.RUnlock()
+
+	if len(slow) == 0 {
+		return
+	}
+	f.mu.Lock()
+	for _, s := range slow {
+		f.remove(s)
+	}
+	f.mu.Unlock()
+}
+
+// remove takes the subscriber out of the registry and closes its channel, f.mu must be held
+func (f *DealFeed) remove(s *FeedSubscriber) {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	for _, c := range s.cells {
+		delete(f.byCell[c], s)
+		if len(f.byCell[c]) == 0 {
+			delete(f.byCell, c)
+		}
+	}
+	f.subscribers--
+	close(s.events)
+}
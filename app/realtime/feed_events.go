@@ -0,0 +1,139 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// businessCacheFor is how long the location of a business is remembered
+const businessCacheFor = 5 * time.Minute
+
+// dealEventTypes are the outbox events that can change what the deal feed shows
+var dealEventTypes = bson.A{model.EventDealCreated, model.EventDealUpdated, model.EventDealStatusChanged}
+
+// feedBusiness is the part of a business sent with a deal on the feed
+type feedBusiness struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id"`
+	Business_name *string            `json:"business_name" bson:"business_name"`
+	Location      *model.Location    `json:"location" bson:"location"`
+	cachedAt      time.Time
+}
+
+// feedMessage is the JSON body of a [FeedEvent]
+type feedMessage struct {
+	ID       primitive.ObjectID `json:"id"`
+	Type     string             `json:"type"`
+	Deal     *model.Deal        `json:"deal"`
+	Business *feedBusiness      `json:"business"`
+}
+
+// businessLocations finds where businesses are, they are cached because a business
+// usually has several deals changing at once
+type businessLocations struct {
+	businesses model.Collection
+
+	mu        sync.Mutex
+	cache     map[primitive.ObjectID]*feedBusiness
+	lastPrune time.Time
+}
+
+// toFeedEvent works out what a deal event means for the feed
+// returns nil when clients do not need to know about it (e.g. a draft was edited)
+func (l *businessLocations) toFeedEvent(ctx context.Context, outboxEvent *model.DomainEvent) *FeedEvent {
+	deal := new(model.Deal)
+	feedType := ""
+
+	switch outboxEvent.Type {
+	case model.EventDealCreated, model.EventDealUpdated:
+		if err := outboxEvent.DecodeData(deal); err != nil {
+			log.Println("Deal feed could not decode event " + outboxEvent.ID.Hex() + ": " + err.Error())
+			return nil
+		}
+		if deal.CurrentStatus() != model.DealLive || !model.IsPubliclyVisible(deal.Moderation_status) {
+			return nil
+		}
+		feedType = FeedDealUpdated
+		if outboxEvent.Type == model.EventDealCreated {
+			feedType = FeedDealCreated
+		}
+	case model.EventDealStatusChanged:
+		var data model.DealStatusChangedData
+		if err := outboxEvent.DecodeData(&data); err != nil || data.Deal == nil {
+			log.Println("Deal feed could not decode event " + outboxEvent.ID.Hex())
+			return nil
+		}
+		deal = data.Deal
+		wasLive := data.Previous_status == model.DealLive
+		isLive := deal.CurrentStatus() == model.DealLive
+		if isLive && !wasLive {
+			feedType = FeedDealCreated
+		} else if wasLive && !isLive {
+			feedType = FeedDealEnded
+		} else {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	business := l.business(ctx, outboxEvent.Business_id)
+	if business == nil || business.Location == nil || len(business.Location.Coordinates) != 2 {
+		// a business that is not on the map can not be in anyone's area
+		return nil
+	}
+
+	deal.ComputeFields()
+	data, err := json.Marshal(feedMessage{ID: outboxEvent.ID, Type: feedType, Deal: deal, Business: business})
+	if err != nil {
+		log.Println("Deal feed could not encode event " + outboxEvent.ID.Hex() + ": " + err.Error())
+		return nil
+	}
+
+	return &FeedEvent{
+		ID:        outboxEvent.ID,
+		Type:      feedType,
+		Longitude: business.Location.Coordinates[0],
+		Latitude:  business.Location.Coordinates[1],
+		Data:      data,
+	}
+}
+
+func (l *businessLocations) business(ctx context.Context, id primitive.ObjectID) *feedBusiness {
+	now := time.Now()
+
+	l.mu.Lock()
+	cached, ok := l.cache[id]
+	if now.Sub(l.lastPrune) > businessCacheFor {
+		for cachedID, business := range l.cache {
+			if now.Sub(business.cachedAt) > businessCacheFor {
+				delete(l.cache, cachedID)
+			}
+		}
+		l.lastPrune = now
+	}
+	l.mu.Unlock()
+	if ok && now.Sub(cached.cachedAt) < businessCacheFor {
+		return cached
+	}
+
+	business := new(feedBusiness)
+	opts := options.FindOne().SetProjection(bson.M{"business_name": 1, "location": 1})
+	if err := l.businesses.FindOne(business, ctx, bson.M{"_id": id}, opts); err != nil {
+		return nil
+	}
+	business.cachedAt = now
+
+	l.mu.Lock()
+	l.cache[id] = business
+	l.mu.Unlock()
+	return business
+}
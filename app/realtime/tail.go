@@ -0,0 +1,100 @@
+package realtime
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	pollInterval = time.Second
+	// lookback is how far back each poll reads again. An event's ID is made before its
+	// transaction commits so it can show up in the outbox a little after newer ones
+	lookback = 10 * time.Second
+	// replayLimit is the most events sent to a client that reconnects
+	replayLimit = 500
+)
+
+// outboxTail follows the outbox for the events of some types
+type outboxTail struct {
+	name   string
+	outbox model.Collection
+	types  bson.A
+}
+
+// run polls the outbox for new events and hands them to handle until ctx is cancelled.
+// Only events made after the server started are handled, older ones are for [outboxTail.since]
+func (t *outboxTail) run(ctx context.Context, handle func(ctx context.Context, event *model.DomainEvent)) {
+	seen := make(map[primitive.ObjectID]time.Time)
+	from := time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pollInterval):
+		}
+
+		pollStart := time.Now()
+		outboxEvents, err := t.find(ctx, bson.M{
+			"_id": bson.M{"$gte": primitive.NewObjectIDFromTimestamp(from.Add(-lookback))},
+		}, 0)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Println(t.name + " could not read the outbox: " + err.Error())
+			}
+			continue
+		}
+
+		for _, outboxEvent := range outboxEvents {
+			if _, ok := seen[outboxEvent.ID]; ok {
+				continue
+			}
+			seen[outboxEvent.ID] = pollStart
+			handle(ctx, outboxEvent)
+		}
+
+		// an event can only come back while it is inside the lookback window
+		for id, at := range seen {
+			if pollStart.Sub(at) > 2*lookback {
+				delete(seen, id)
+			}
+		}
+		from = pollStart
+	}
+}
+
+// since returns the events after the event lastEventID that also match filter, oldest first
+func (t *outboxTail) since(ctx context.Context, lastEventID primitive.ObjectID, filter bson.M) ([]*model.DomainEvent, error) {
+	query := bson.M{"_id": bson.M{"$gt": lastEventID}}
+	for key, value := range filter {
+		query[key] = value
+	}
+	return t.find(ctx, query, replayLimit)
+}
+
+func (t *outboxTail) find(ctx context.Context, filter bson.M, limit int64) ([]*model.DomainEvent, error) {
+	if len(t.types) > 0 {
+		filter["type"] = bson.M{"$in": t.types}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+
+	cursor, err := t.outbox.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var outboxEvents []*model.DomainEvent
+	if err := cursor.All(ctx, &outboxEvents); err != nil {
+		return nil, err
+	}
+	return outboxEvents, nil
+}
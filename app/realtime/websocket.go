@@ -0,0 +1,299 @@
+package realtime
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A small server side WebSocket (RFC 6455) implementation, only what the dashboard needs:
+// text messages, ping/pong and close. Compression and subprotocols are not supported.
+
+// WebSocket opcodes
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xA
+)
+
+// WebSocket close codes used by the server
+const (
+	CloseNormal         = 1000
+	CloseGoingAway      = 1001
+	CloseProtocolError  = 1002
+	CloseTooBig         = 1009
+	CloseTryAgainLater  = 1013
+	CloseSessionRevoked = 4001 // application code, the client has to log in again
+)
+
+// maxMessageSize is the largest message a client can send, clients only send small control messages
+const maxMessageSize = 64 * 1024
+
+// websocketGUID is the fixed value from RFC 6455 used in the handshake
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// ErrNotWebSocket is returned by [Upgrade] for a request that is not a WebSocket handshake
+var ErrNotWebSocket = errors.New("not a websocket handshake")
+
+// CloseError is returned by [Conn.ReadMessage] when the client closed the connection
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return "websocket closed: " + e.Reason
+}
+
+// Conn is an open WebSocket connection.
+// One goroutine may read while another writes, writes are safe to make from several goroutines
+type Conn struct {
+	conn        net.Conn
+	reader      *bufio.Reader
+	readTimeout time.Duration
+
+	writeMu      sync.Mutex
+	writeTimeout time.Duration
+	closeSent    bool
+}
+
+// Upgrade does the WebSocket handshake and takes over the connection of the request.
+// Nothing else may be written to w after it returns
+func Upgrade(w http.ResponseWriter, r *http.Request, readTimeout time.Duration, writeTimeout time.Duration) (*Conn, error) {
+	if r.Method != http.MethodGet ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") {
+		return nil, ErrNotWebSocket
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, errors.New("unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, ErrNotWebSocket
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("the connection can not be taken over")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n"
+
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &Conn{
+		conn:         conn,
+		reader:       rw.Reader,
+		readTimeout:  readTimeout,
+		writeTimeout: writeTimeout,
+	}, nil
+}
+
+// SetReadTimeout changes how long [Conn.ReadMessage] waits for a frame, it must not be called while reading
+func (c *Conn) SetReadTimeout(timeout time.Duration) {
+	c.readTimeout = timeout
+}
+
+// ReadMessage returns the next text or binary message from the client.
+// Pings are answered and every frame, pongs included, extends the read timeout,
+// so a client that stops answering pings gets a timeout error
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	var message []byte
+	messageOpcode := -1
+
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch opcode {
+		case OpPing:
+			if err := c.WriteMessage(OpPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case OpPong:
+			continue
+		case OpClose:
+			closeErr := &CloseError{Code: CloseNormal}
+			if len(payload) >= 2 {
+				closeErr.Code = int(binary.BigEndian.Uint16(payload))
+				closeErr.Reason = string(payload[2:])
+			}
+			c.WriteClose(closeErr.Code, "")
+			return 0, nil, closeErr
+		case OpText, OpBinary:
+			if messageOpcode != -1 {
+				return 0, nil, c.fail(CloseProtocolError, "expected a continuation frame")
+			}
+			messageOpcode = opcode
+		case OpContinuation:
+			if messageOpcode == -1 {
+				return 0, nil, c.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+		default:
+			return 0, nil, c.fail(CloseProtocolError, "unknown opcode")
+		}
+
+		if len(message)+len(payload) > maxMessageSize {
+			return 0, nil, c.fail(CloseTooBig, "message too big")
+		}
+		message = append(message, payload...)
+		if fin {
+			return messageOpcode, message, nil
+		}
+	}
+}
+
+// readFrame reads one frame, frames from a client must be masked
+func (c *Conn) readFrame() (bool, int, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	fin := header[0]&0x80 != 0
+	opcode := int(header[0] & 0x0F)
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, "extensions are not supported")
+	}
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, "client frames must be masked")
+	}
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+
+	isControl := opcode >= OpClose
+	if isControl && (length > 125 || !fin) {
+		return false, 0, nil, c.fail(CloseProtocolError, "invalid control frame")
+	}
+	if length > maxMessageSize {
+		return false, 0, nil, c.fail(CloseTooBig, "message too big")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return fin, opcode, payload, nil
+}
+
+// WriteMessage sends one unfragmented frame, server frames are not masked
+func (c *Conn) WriteMessage(opcode int, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writeFrame(opcode, payload)
+}
+
+func (c *Conn) writeFrame(opcode int, payload []byte) error {
+	if c.closeSent {
+		return net.ErrClosed
+	}
+
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | byte(opcode)
+	switch {
+	case len(payload) < 126:
+		header[1] = byte(len(payload))
+	case len(payload) <= 0xFFFF:
+		header[1] = 126
+		header = header[:4]
+		binary.BigEndian.PutUint16(header[2:], uint16(len(payload)))
+	default:
+		header[1] = 127
+		header = header[:10]
+		binary.BigEndian.PutUint64(header[2:], uint64(len(payload)))
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	if opcode == OpClose {
+		c.closeSent = true
+	}
+	return nil
+}
+
+// WriteClose starts closing the connection with a close code and reason
+func (c *Conn) WriteClose(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	return c.WriteMessage(OpClose, append(payload, reason...))
+}
+
+// Close closes the underlying connection
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// fail closes the connection because the client broke the protocol
+func (c *Conn) fail(code int, reason string) error {
+	c.WriteClose(code, reason)
+	return &CloseError{Code: code, Reason: reason}
+}
+
+// headerContains checks a comma separated header for a token, ignoring case
+func headerContains(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
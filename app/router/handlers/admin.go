@@ -0,0 +1,454 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/CoffeeHausGames/whir-server/app/auth"
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	requests "github.com/CoffeeHausGames/whir-server/app/model/requests"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// adminPageSize is how many accounts are sent per page
+const adminPageSize = 50
+
+// ListUsers returns the customer accounts, newest first, 50 per ?page=.
+// ?q= searches email and name and ?suspended=true|false filters by suspension
+func (env *HandlerEnv) ListUsers(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	cursor, err := env.database.GetUsers().Find(ctx, accountSearch(r, "email", "first_name", "last_name"), adminPage(r))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get users")
+		return
+	}
+	var users []*model.User
+	if err := cursor.All(ctx, &users); err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get users")
+		return
+	}
+
+	accounts := make([]*model.AdminAccount, 0, len(users))
+	for _, user := range users {
+		accounts = append(accounts, model.NewAdminUser(user))
+	}
+	WriteSuccessResponse(w, r, accounts, nil, false)
+}
+
+// GetUserAccount returns the customer account :id
+func (env *HandlerEnv) GetUserAccount(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	userID, err := primitive.ObjectIDFromHex(ps.ByName("id"))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	user := new(model.User)
+	err = env.database.GetUsers().FindOne(user, ctx, bson.M{"_id": userID})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		WriteErrorResponse(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get user")
+		return
+	}
+
+	WriteSuccessResponse(w, r, model.NewAdminUser(user), nil, false)
+}
+
+// ListBusinesses returns the business accounts, newest first, 50 per ?page=.
+// ?q= searches email, name and business name and ?suspended=true|false filters by suspension
+func (env *HandlerEnv) ListBusinesses(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	cursor, err := env.database.GetBusinesses().Find(ctx, accountSearch(r, "email", "first_name", "last_name", "business_name"), adminPage(r))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get businesses")
+		return
+	}
+	var businesses []*model.BusinessUser
+	if err := cursor.All(ctx, &businesses); err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get businesses")
+		return
+	}
+
+	accounts := make([]*model.AdminAccount, 0, len(businesses))
+	for _, business := range businesses {
+		accounts = append(accounts, model.NewAdminBusiness(business))
+	}
+	WriteSuccessResponse(w, r, accounts, nil, false)
+}
+
+// GetBusinessAccount returns the business account :id with all its deals, archived ones too
+func (env *HandlerEnv) GetBusinessAccount(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	businessID, err := primitive.ObjectIDFromHex(ps.ByName("id"))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "Invalid business ID")
+		return
+	}
+	business := new(model.BusinessUser)
+	err = env.database.GetBusinesses().FindOne(business, ctx, bson.M{"_id": businessID})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		WriteErrorResponse(w, http.StatusNotFound, "Business not found")
+		return
+	}
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get business")
+		return
+	}
+
+	deals, err := findDeals(ctx, env.database.GetDeals(), bson.M{"business_id": businessID})
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	WriteSuccessResponse(w, r, map[string]interface{}{"business": model.NewAdminBusiness(business), "deals": deals}, nil, false)
+}
+
+// SuspendUser suspends the customer account :id and logs it out everywhere, e.g. {"reason": "..."}
+func (env *HandlerEnv) SuspendUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	env.suspendAccount(w, r, ps, env.database.GetUsers(), model.AuditTargetUser)
+}
+
+// ReinstateUser lifts the suspension of the customer account :id
+func (env *HandlerEnv) ReinstateUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	env.reinstateAccount(w, r, ps, env.database.GetUsers(), model.AuditTargetUser)
+}
+
+// LogoutUser ends every session of the customer account :id
+func (env *HandlerEnv) LogoutUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	env.logoutAccount(w, r, ps, env.database.GetUsers(), model.AuditTargetUser)
+}
+
+// SuspendBusiness suspends the business :id, logs it out everywhere and hides it from customers, e.g. {"reason": "..."}
+func (env *HandlerEnv) SuspendBusiness(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	env.suspendAccount(w, r, ps, env.database.GetBusinesses(), model.AuditTargetBusiness)
+}
+
+// ReinstateBusiness lifts the suspension of the business :id
+func (env *HandlerEnv) ReinstateBusiness(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	env.reinstateAccount(w, r, ps, env.database.GetBusinesses(), model.AuditTargetBusiness)
+}
+
+// LogoutBusiness ends every session of the business :id, open dashboard connections are closed too
+func (env *HandlerEnv) LogoutBusiness(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	env.logoutAccount(w, r, ps, env.database.GetBusinesses(), model.AuditTargetBusiness)
+}
+
+// ImpersonateBusiness returns a token to act as the business :id for support.
+// The token is marked with the admin, lasts an hour and can not be refreshed
+func (env *HandlerEnv) ImpersonateBusiness(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	businessID, err := primitive.ObjectIDFromHex(ps.ByName("id"))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "Invalid business ID")
+		return
+	}
+	business := new(model.BusinessUser)
+	err = env.database.GetBusinesses().FindOne(business, ctx, bson.M{"_id": businessID})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		WriteErrorResponse(w, http.StatusNotFound, "Business not found")
+		return
+	}
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get business")
+		return
+	}
+	if business.IsSuspended() {
+		WriteErrorResponse(w, http.StatusConflict, "The business is suspended, reinstate it first")
+		return
+	}
+
+	claims := r.Context().Value("claims").(*auth.SignedDetails)
+	token, err := auth.GenerateImpersonationToken(*business.GetEmail(), *business.GetFirstName(), business.ID.Hex(), claims.Uid)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to create token")
+		return
+	}
+
+	// the token is only handed out once the impersonation is on record
+	err = env.recordAudit(ctx, r, model.ActorAdmin, &model.AuditEntry{
+		Action:      model.AuditBusinessImpersonated,
+		Business_id: &business.ID,
+		Target_type: model.AuditTargetBusiness,
+		Target_id:   business.ID,
+	})
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to record impersonation")
+		return
+	}
+
+	WriteSuccessResponse(w, r, map[string]interface{}{
+		"token":      token,
+		"expires_at": time.Now().Add(auth.ImpersonationTTL).UTC(),
+	}, nil, false)
+}
+
+// EditDeal changes the fields of the deal :id that were sent, like a business does with UpdateDeal
+func (env *HandlerEnv) EditDeal(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, body, err := env.getClaimsAndBody(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	dealData, err := env.getDealDataFromBody(body)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	existing, status, err := env.findDealByParam(ctx, ps)
+	if err != nil {
+		WriteErrorResponse(w, status, err.Error())
+		return
+	}
+	dealData.ID = existing.ID
+
+	deal, err := env.saveDealUpdate(ctx, r, model.ActorAdmin, model.AuditDealEdited, existing.Business_id, dealData)
+	if errors.Is(err, errDealNotFound) {
+		WriteErrorResponse(w, http.StatusNotFound, "Deal not found")
+		return
+	}
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to update deal")
+		return
+	}
+	deal.ComputeFields()
+
+	WriteSuccessResponse(w, r, deal, nil, false)
+}
+
+// RemoveDeal archives the deal :id, the business can see it in its archive but not restore it without support
+func (env *HandlerEnv) RemoveDeal(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	existing, status, err := env.findDealByParam(ctx, ps)
+	if err != nil {
+		WriteErrorResponse(w, status, err.Error())
+		return
+	}
+
+	deal, status, err := env.changeDealStatus(ctx, r, model.ActorAdmin, model.AuditDealRemoved, existing.Business_id, existing.ID, model.DealArchived, nil)
+	if err != nil {
+		WriteErrorResponse(w, status, err.Error())
+		return
+	}
+
+	WriteSuccessResponse(w, r, deal, nil, false)
+}
+
+// suspendAccount suspends the account :id in userCollection and ends its sessions
+func (env *HandlerEnv) suspendAccount(w http.ResponseWriter, r *http.Request, ps httprouter.Params, userCollection model.Collection, accountType string) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, body, err := env.getClaimsAndBody(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	suspension := new(requests.Suspension)
+	if err := json.Unmarshal([]byte(body), suspension); err != nil {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, "There was an error with the client request")
+		return
+	}
+	if err := requests.ValidateSuspensionStruct(suspension); err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	accountID, err := primitive.ObjectIDFromHex(ps.ByName("id"))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "Invalid account ID")
+		return
+	}
+	if adminID, _ := claimsUserID(r); accountType == model.AuditTargetUser && adminID == accountID {
+		WriteErrorResponse(w, http.StatusConflict, "Admins can not suspend themselves")
+		return
+	}
+
+	reason := strings.TrimSpace(*suspension.Reason)
+	err = env.database.WithTransaction(ctx, func(ctx context.Context) error {
+		result, err := userCollection.UpdateOne(ctx,
+			bson.M{"_id": accountID, "suspended_at": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"suspended_at": time.Now().UTC(), "suspension_reason": reason}},
+		)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return mongo.ErrNoDocuments
+		}
+		if err := auth.RevokeTokens(ctx, userCollection, accountID); err != nil {
+			return err
+		}
+		return env.recordAudit(ctx, r, model.ActorAdmin, accountAuditEntry(model.AuditAccountSuspended, accountType, accountID, bson.M{"reason": reason}))
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		WriteErrorResponse(w, http.StatusNotFound, "Account not found or already suspended")
+		return
+	}
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to suspend account")
+		return
+	}
+
+	WriteSuccessResponse(w, r, "Account suspended", nil, false)
+}
+
+// reinstateAccount lifts the suspension of the account :id in userCollection
+func (env *HandlerEnv) reinstateAccount(w http.ResponseWriter, r *http.Request, ps httprouter.Params, userCollection model.Collection, accountType string) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	accountID, err := primitive.ObjectIDFromHex(ps.ByName("id"))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "Invalid account ID")
+		return
+	}
+
+	err = env.database.WithTransaction(ctx, func(ctx context.Context) error {
+		result, err := userCollection.UpdateOne(ctx,
+			bson.M{"_id": accountID, "suspended_at": bson.M{"$exists": true}},
+			bson.M{"$unset": bson.M{"suspended_at": "", "suspension_reason": ""}},
+		)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return mongo.ErrNoDocuments
+		}
+		return env.recordAudit(ctx, r, model.ActorAdmin, accountAuditEntry(model.AuditAccountReinstated, accountType, accountID, nil))
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		WriteErrorResponse(w, http.StatusNotFound, "Account not found or not suspended")
+		return
+	}
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to reinstate account")
+		return
+	}
+
+	WriteSuccessResponse(w, r, "Account reinstated", nil, false)
+}
+
+// logoutAccount ends every session of the account :id in userCollection
+func (env *HandlerEnv) logoutAccount(w http.ResponseWriter, r *http.Request, ps httprouter.Params, userCollection model.Collection, accountType string) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	accountID, err := primitive.ObjectIDFromHex(ps.ByName("id"))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "Invalid account ID")
+		return
+	}
+	count, err := userCollection.CountDocuments(ctx, bson.M{"_id": accountID})
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get account")
+		return
+	}
+	if count == 0 {
+		WriteErrorResponse(w, http.StatusNotFound, "Account not found")
+		return
+	}
+
+	err = env.database.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := auth.RevokeTokens(ctx, userCollection, accountID); err != nil {
+			return err
+		}
+		return env.recordAudit(ctx, r, model.ActorAdmin, accountAuditEntry(model.AuditAccountLoggedOut, accountType, accountID, nil))
+	})
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to revoke sessions")
+		return
+	}
+
+	WriteSuccessResponse(w, r, "Sessions revoked", nil, false)
+}
+
+// findDealByParam finds the deal :id of any business
+// returns the status code to send when it fails
+func (env *HandlerEnv) findDealByParam(ctx context.Context, ps httprouter.Params) (*model.Deal, int, error) {
+	dealID, err := primitive.ObjectIDFromHex(ps.ByName("id"))
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.New("Invalid deal ID")
+	}
+	deal := new(model.Deal)
+	err = env.database.GetDeals().FindOne(deal, ctx, bson.M{"_id": dealID})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, http.StatusNotFound, errDealNotFound
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("Failed to get deal")
+	}
+	return deal, http.StatusOK, nil
+}
+
+// accountAuditEntry is the audit entry of an admin action on an account
+func accountAuditEntry(action string, accountType string, accountID primitive.ObjectID, data interface{}) *model.AuditEntry {
+	entry := &model.AuditEntry{Action: action, Target_type: accountType, Target_id: accountID, Data: data}
+	if accountType == model.AuditTargetBusiness {
+		entry.Business_id = &accountID
+	}
+	return entry
+}
+
+// accountSearch is the filter for ?q= on the fields and ?suspended=true|false
+func accountSearch(r *http.Request, fields ...string) bson.M {
+	filter := bson.M{}
+	if q := strings.TrimSpace(r.URL.Query().Get("q")); q != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(q), Options: "i"}
+		var search bson.A
+		for _, field := range fields {
+			search = append(search, bson.M{field: pattern})
+		}
+		filter["$or"] = search
+	}
+	switch r.URL.Query().Get("suspended") {
+	case "true":
+		filter["suspended_at"] = bson.M{"$exists": true}
+	case "false":
+		filter["suspended_at"] = bson.M{"$exists": false}
+	}
+	return filter
+}
+
+// adminPage returns the ?page= (starting at 1) of accounts, newest first
+func adminPage(r *http.Request) *options.FindOptions {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	return options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64((page - 1) * adminPageSize)).
+		SetLimit(adminPageSize)
+}
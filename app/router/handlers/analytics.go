@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/CoffeeHausGames/whir-server/app/analytics"
+	"github.com/CoffeeHausGames/whir-server/app/helpers"
+	"github.com/CoffeeHausGames/whir-server/app/model"
+)
+
+// GetBusinessAnalytics reports how the deals of the authenticated business performed: a time series,
+// the top deals and breakdowns by day of the week and distance. ?granularity= is day (default) or hour,
+// ?from= and ?to= (RFC 3339 times) limit the report to a time range, by default the last 30 days (7 by the hour)
+func (env *HandlerEnv) GetBusinessAnalytics(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	businessID, err := claimsUserID(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Error parsing user ID")
+		return
+	}
+	from, to, err := timeRangeParams(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	report, err := env.analytics.Report(ctx, businessID, analytics.Query{
+		From:        from,
+		To:          to,
+		Granularity: r.URL.Query().Get("granularity"),
+	})
+	if errors.Is(err, analytics.ErrInvalidRange) {
+		WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get analytics")
+		return
+	}
+
+	WriteSuccessResponse(w, r, report, nil, false)
+}
+
+// recordImpressions records an impression of every deal in the results of a nearby search at latitude, longitude.
+// A search is answered even when this fails
+func (env *HandlerEnv) recordImpressions(ctx context.Context, latitude float64, longitude float64, results []model.BusinessUserWrapper) {
+	deals := []model.AnalyticsDeal{}
+	for _, business := range results {
+		band := model.DistanceUnknown
+		if business.Location != nil && len(business.Location.Coordinates) == 2 {
+			band = model.DistanceBandOf(helpers.DistanceMiles(latitude, longitude, business.Location.Coordinates[1], business.Location.Coordinates[0]))
+		}
+		for _, deal := range business.Deals {
+			deals = append(deals, model.AnalyticsDeal{Business_id: business.ID, Deal_id: deal.ID, Band: band})
+		}
+	}
+	if err := env.analytics.Record(ctx, model.AnalyticsImpression, deals); err != nil {
+		log.Println("analytics:", err)
+	}
+}
+
+// recordViews records a view of every deal on a business profile that was opened
+func (env *HandlerEnv) recordViews(ctx context.Context, business *model.BusinessUserWrapper) {
+	deals := make([]model.AnalyticsDeal, 0, len(business.Deals))
+	for _, deal := range business.Deals {
+		deals = append(deals, model.AnalyticsDeal{Business_id: business.ID, Deal_id: deal.ID, Band: model.DistanceUnknown})
+	}
+	if err := env.analytics.Record(ctx, model.AnalyticsView, deals); err != nil {
+		log.Println("analytics:", err)
+	}
+}
+
+// timeRangeParams reads the ?from= and ?to= RFC 3339 times of a request, nil when they are not set
+func timeRangeParams(r *http.Request) (*time.Time, *time.Time, error) {
+	times := make([]*time.Time, 2)
+	for i, param := range []string{"from", "to"} {
+		value := r.URL.Query().Get(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, nil, errors.New("Invalid " + param + ", use an RFC 3339 time")
+		}
+		t = t.UTC()
+		times[i] = &t
+	}
+	return times[0], times[1], nil
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/CoffeeHausGames/whir-server/app/auth"
+	"github.com/CoffeeHausGames/whir-server/app/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// recordAudit adds the entry to the audit log with who made the request, where from and its request ID.
+// The actor is the authenticated account unless the entry already has one (e.g. on sign up and login),
+// an admin acting as a business is recorded too
+func (env *HandlerEnv) recordAudit(ctx context.Context, r *http.Request, actorType string, entry *model.AuditEntry) error {
+	claims, _ := r.Context().Value("claims").(*auth.SignedDetails)
+	if claims != nil && entry.Actor_id.IsZero() {
+		entry.Actor_id, _ = primitive.ObjectIDFromHex(claims.Uid)
+	}
+	if claims != nil {
+		if impersonatorID, err := primitive.ObjectIDFromHex(claims.Impersonator); err == nil {
+			entry.Impersonator_id = &impersonatorID
+		}
+	}
+	entry.ID = primitive.NewObjectID()
+	entry.Actor_type = actorType
+	entry.Ip = clientIP(r)
+	entry.User_agent = r.UserAgent()
+	entry.Request_id, _ = r.Context().Value("request_id").(string)
+	entry.Created_at = time.Now().UTC()
+
+	_, err := env.database.GetAuditLog().InsertOne(ctx, entry)
+	return err
+}
+
+// logAudit records the entry for a change that already happened outside of a transaction,
+// failing to record it is logged rather than failing the request
+func (env *HandlerEnv) logAudit(r *http.Request, actorType string, entry *model.AuditEntry) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := env.recordAudit(ctx, r, actorType, entry); err != nil {
+		log.Println("Audit entry " + entry.Action + " was not recorded: " + err.Error())
+	}
+}
+
+// dealAuditEntry is the audit entry for a change to the deal
+func dealAuditEntry(action string, deal *model.Deal, changes []model.FieldChange) *model.AuditEntry {
+	return &model.AuditEntry{
+		Action:      action,
+		Business_id: &deal.Business_id,
+		Target_type: model.AuditTargetDeal,
+		Target_id:   deal.ID,
+		Changes:     changes,
+	}
+}
+
+// clientIP is the address the request came from. Behind a load balancer TRUST_PROXY_HEADERS=true
+// takes it from X-Forwarded-For, otherwise clients could send any address in that header
+func clientIP(r *http.Request) string {
+	if os.Getenv("TRUST_PROXY_HEADERS") == "true" {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// GetAuditLog returns the entries of the audit log, newest first, 50 per ?page=. ?business_id=, ?actor_id=,
+// ?target_id= and ?action= filter the entries and ?from= and ?to= (RFC 3339 times) limit them to a time range.
+// ?before= (the ID of an entry) returns the entries after it, which keeps paging steady while entries are added
+func (env *HandlerEnv) GetAuditLog(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	for _, field := range []string{"business_id", "actor_id", "target_id"} {
+		value := r.URL.Query().Get(field)
+		if value == "" {
+			continue
+		}
+		id, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			WriteErrorResponse(w, http.StatusBadRequest, "Invalid "+field)
+			return
+		}
+		filter[field] = id
+	}
+	if action := r.URL.Query().Get("action"); action != "" {
+		filter["action"] = action
+	}
+	createdAt := bson.M{}
+	for param, operator := range map[string]string{"from": "$gte", "to": "$lt"} {
+		value := r.URL.Query().Get(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			WriteErrorResponse(w, http.StatusBadRequest, "Invalid "+param+", use an RFC 3339 time")
+			return
+		}
+		createdAt[operator] = t.UTC()
+	}
+	if len(createdAt) > 0 {
+		filter["created_at"] = createdAt
+	}
+	if value := r.URL.Query().Get("before"); value != "" {
+		beforeID, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			WriteErrorResponse(w, http.StatusBadRequest, "Invalid before")
+			return
+		}
+		before := new(model.AuditEntry)
+		err = env.database.GetAuditLog().FindOne(before, ctx, bson.M{"_id": beforeID})
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			WriteErrorResponse(w, http.StatusBadRequest, "Unknown before entry")
+			return
+		}
+		if err != nil {
+			WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get audit log")
+			return
+		}
+		// the order of the pages, entries recorded at the same time are ordered by ID
+		filter["$or"] = bson.A{
+			bson.M{"created_at": bson.M{"$lt": before.Created_at}},
+			bson.M{"created_at": before.Created_at, "_id": bson.M{"$lt": before.ID}},
+		}
+	}
+
+	cursor, err := env.database.GetAuditLog().Find(ctx, filter, adminPage(r))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get audit log")
+		return
+	}
+	entries := []*model.AuditEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get audit log")
+		return
+	}
+
+	WriteSuccessResponse(w, r, entries, nil, false)
+}
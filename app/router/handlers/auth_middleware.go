@@ -6,6 +6,8 @@ import (
     "net/http"
     "fmt"
     "encoding/json"
+    "errors"
+    "time"
 
     "github.com/julienschmidt/httprouter"
     "github.com/CoffeeHausGames/whir-server/app/auth"
@@ -17,94 +19,77 @@ import (
 // Authentication validates tokens from the Authorization header or cookies
 func (env *HandlerEnv) Authentication(n httprouter.Handle) httprouter.Handle {
     return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-        var userCollection model.Collection = env.database.GetUsers()
-
-        // Try to authenticate from cookies first
-        jwtCookie, err := r.Cookie("access_token")
-        if err == nil {
-            clientToken := jwtCookie.Value
-            claims, err := auth.ValidateToken(userCollection, clientToken)
-            if err == nil {
-                // Store the claims in the request context
-                ctx := context.WithValue(r.Context(), "claims", claims)
-
-                // Create a new request with the updated context
-                r = r.WithContext(ctx)
-
-                // Call the next handler with the updated request
-                n(w, r, ps)
-                return
-            }
+        claims, err := authenticateRequest(r, env.database.GetUsers())
+        if err != nil {
+            log.Printf("Failed to authenticate user")
+            // If both methods failed, return an HTTP 401 Unauthorized error
+            WriteErrorResponse(w, http.StatusUnauthorized, "Failed to authenticate user")
+            return
         }
 
-        // If cookie authentication failed, try to authenticate from the Authorization header
-        clientToken := r.Header.Get("Authorization")
-        if clientToken != "" {
-            claims, err := auth.ValidateToken(userCollection, clientToken)
-            if err == nil {
-                log.Printf("Authenticated user from header")
-                // Store the claims in the request context
-                ctx := context.WithValue(r.Context(), "claims", claims)
-
-                // Create a new request with the updated context
-                r = r.WithContext(ctx)
-
-                // Call the next handler with the updated request
-                n(w, r, ps)
-                return
-            }
-        }
-
-        log.Printf("Failed to authenticate user")
-        // If both methods failed, return an HTTP 401 Unauthorized error
-        WriteErrorResponse(w, http.StatusUnauthorized, "Failed to authenticate user")
+        // Store the claims in the request context and call the next handler with the updated request
+        n(w, r.WithContext(context.WithValue(r.Context(), "claims", claims)), ps)
     }
 }
 
 func (env *HandlerEnv) BusinessAuthentication(n httprouter.Handle) httprouter.Handle {
     return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-        var userCollection model.Collection = env.database.GetBusinesses()
-
-        // Try to authenticate from cookies first
-        jwtCookie, err := r.Cookie("access_token")
-        if err == nil {
-            clientToken := jwtCookie.Value
-            claims, err := auth.ValidateToken(userCollection, clientToken)
-            if err == nil {
-                // Store the claims in the request context
-                ctx := context.WithValue(r.Context(), "claims", claims)
-
-                // Create a new request with the updated context
-                r = r.WithContext(ctx)
-
-                // Call the next handler with the updated request
-                n(w, r, ps)
-                return
-            }
+        claims, err := authenticateRequest(r, env.database.GetBusinesses())
+        if err != nil {
+            log.Printf("Failed to authenticate user")
+            // If both methods failed, return an HTTP 401 Unauthorized error
+            WriteErrorResponse(w, http.StatusUnauthorized, "Failed to authenticate user")
+            return
+        }
+
+        // Store the claims in the request context and call the next handler with the updated request
+        n(w, r.WithContext(context.WithValue(r.Context(), "claims", claims)), ps)
+    }
+}
+
+// AdminAuthentication only lets signed in users with the admin role through
+func (env *HandlerEnv) AdminAuthentication(n httprouter.Handle) httprouter.Handle {
+    return env.Authentication(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+        var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+        defer cancel()
+
+        user, err := env.findClaimsUser(ctx, r)
+        if err != nil {
+            WriteErrorResponse(w, http.StatusUnauthorized, "Failed to authenticate user")
+            return
+        }
+        if user.Role != model.RoleAdmin {
+            WriteErrorResponse(w, http.StatusForbidden, "Admins only")
+            return
         }
 
-        // If cookie authentication failed, try to authenticate from the Authorization header
-        clientToken := r.Header.Get("Authorization")
-        if clientToken != "" {
-            claims, err := auth.ValidateToken(userCollection, clientToken)
-            if err == nil {
-                log.Printf("Authenticated user from header")
-                // Store the claims in the request context
-                ctx := context.WithValue(r.Context(), "claims", claims)
-
-                // Create a new request with the updated context
-                r = r.WithContext(ctx)
-
-                // Call the next handler with the updated request
-                n(w, r, ps)
-                return
-            }
+        n(w, r, ps)
+    })
+}
+
+// authenticateRequest validates the token of the request against the accounts in userCollection
+// the access_token cookie is tried first, then the Authorization header
+func authenticateRequest(r *http.Request, userCollection model.Collection) (*auth.SignedDetails, error) {
+    // Try to authenticate from cookies first
+    jwtCookie, err := r.Cookie("access_token")
+    if err == nil {
+        claims, err := auth.ValidateToken(userCollection, jwtCookie.Value)
+        if err == nil && claims != nil {
+            return claims, nil
         }
+    }
 
-        log.Printf("Failed to authenticate user")
-        // If both methods failed, return an HTTP 401 Unauthorized error
-        WriteErrorResponse(w, http.StatusUnauthorized, "Failed to authenticate user")
+    // If cookie authentication failed, try to authenticate from the Authorization header
+    clientToken := r.Header.Get("Authorization")
+    if clientToken != "" {
+        claims, err := auth.ValidateToken(userCollection, clientToken)
+        if err == nil && claims != nil {
+            log.Printf("Authenticated user from header")
+            return claims, nil
+        }
     }
+
+    return nil, errors.New("Failed to authenticate user")
 }
 
 func performLogin(ctx context.Context, userCollection model.Collection, user model.UserInterface, foundUser model.UserInterface) (model.UserInterface, error) {
@@ -136,6 +121,10 @@ func performLogin(ctx context.Context, userCollection model.Collection, user mod
         return nil, fmt.Errorf("The username or password is incorrect")
     }
 
+    if foundUser.IsSuspended() {
+        return nil, auth.ErrAccountSuspended
+    }
+
     token, refreshToken, _ := auth.GenerateAllTokens(*foundUser.GetEmail(), *foundUser.GetFirstName(), foundUser.GetLastName(), foundUser.GetID().Hex())
 
     auth.UpdateAllTokens(userCollection, token, refreshToken, foundUser.GetID().Hex())
@@ -154,6 +143,10 @@ func performGoogleLogin(ctx context.Context, email string, userCollection model.
         return nil, fmt.Errorf("There was an error connecting with the server")
     }
 
+    if foundUser.IsSuspended() {
+        return nil, auth.ErrAccountSuspended
+    }
+
     token, refreshToken, _ := auth.GenerateAllTokens(*foundUser.GetEmail(), *foundUser.GetFirstName(), foundUser.GetLastName(), foundUser.GetID().Hex())
 
     auth.UpdateAllTokens(userCollection, token, refreshToken, foundUser.GetID().Hex())
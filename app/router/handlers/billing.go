@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/CoffeeHausGames/whir-server/app/billing"
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	requests "github.com/CoffeeHausGames/whir-server/app/model/requests"
+)
+
+// maxBillingEventSize is the largest webhook request of the payment provider that is read
+const maxBillingEventSize = 1 << 20 // 1 MB
+
+// GetBilling returns the plan of the authenticated business and its newest subscription, null when it never subscribed
+func (env *HandlerEnv) GetBilling(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	business, err := env.findClaimsBusiness(ctx, r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, "Business not found")
+		return
+	}
+	subscription, err := env.billing.Current(ctx, business.ID)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get subscription")
+		return
+	}
+
+	WriteSuccessResponse(w, r, map[string]interface{}{
+		"plan":         env.plans.PlanOf(business),
+		"subscription": subscription,
+	}, nil, false)
+}
+
+// GetInvoices lists the newest invoices of the authenticated business
+func (env *HandlerEnv) GetInvoices(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	businessID, err := claimsUserID(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Error parsing user ID")
+		return
+	}
+	invoices, err := env.billing.Invoices(ctx, businessID)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get invoices")
+		return
+	}
+
+	WriteSuccessResponse(w, r, invoices, nil, false)
+}
+
+// CreateCheckout starts a subscription of the authenticated business to a paid plan, e.g. {"plan": "pro"},
+// and returns the URL of the checkout page where it is paid for
+func (env *HandlerEnv) CreateCheckout(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	business, plan, ok := env.getBillingPlanRequest(ctx, w, r)
+	if !ok {
+		return
+	}
+	url, err := env.billing.Checkout(ctx, business, plan)
+	if err != nil {
+		writeBillingError(w, err)
+		return
+	}
+
+	WriteSuccessResponse(w, r, map[string]string{"url": url}, nil, false)
+}
+
+// ChangeSubscriptionPlan moves the subscription of the authenticated business to another paid plan, e.g. {"plan": "enterprise"}.
+// The plan changes once the payment provider confirms it
+func (env *HandlerEnv) ChangeSubscriptionPlan(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	business, plan, ok := env.getBillingPlanRequest(ctx, w, r)
+	if !ok {
+		return
+	}
+	if err := env.billing.ChangePlan(ctx, business, plan); err != nil {
+		writeBillingError(w, err)
+		return
+	}
+
+	WriteSuccessResponse(w, r, "Plan change requested", nil, false)
+}
+
+// CancelSubscription ends the subscription of the authenticated business when the period it paid for is over
+func (env *HandlerEnv) CancelSubscription(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	business, err := env.findClaimsBusiness(ctx, r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, "Business not found")
+		return
+	}
+	if err := env.billing.Cancel(ctx, business); err != nil {
+		writeBillingError(w, err)
+		return
+	}
+
+	WriteSuccessResponse(w, r, "Subscription canceled at the end of the period", nil, false)
+}
+
+// BillingWebhook receives the events of the payment provider.
+// Refused requests get a 400, failures a 500 so the provider sends the event again
+func (env *HandlerEnv) BillingWebhook(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	payload, err := io.ReadAll(io.LimitReader(r.Body, maxBillingEventSize))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "Error reading event")
+		return
+	}
+
+	err = env.billing.HandleWebhook(ctx, payload, r.Header)
+	if errors.Is(err, billing.ErrInvalidSignature) || errors.Is(err, billing.ErrInvalidEvent) {
+		WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		log.Println("billing webhook:", err)
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to handle event")
+		return
+	}
+
+	WriteSuccessResponse(w, r, "ok", nil, false)
+}
+
+// getBillingPlanRequest reads the plan of a billing request of the authenticated business,
+// it writes the error response and returns false when the request is not valid
+func (env *HandlerEnv) getBillingPlanRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) (*model.BusinessUser, string, bool) {
+	_, body, err := env.getClaimsAndBody(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return nil, "", false
+	}
+	change := new(requests.PlanChange)
+	if err := json.Unmarshal([]byte(body), change); err != nil {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, "There was an error with the client request")
+		return nil, "", false
+	}
+	if err := requests.ValidatePlanChangeStruct(change); err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return nil, "", false
+	}
+	business, err := env.findClaimsBusiness(ctx, r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, "Business not found")
+		return nil, "", false
+	}
+	return business, strings.TrimSpace(*change.Plan), true
+}
+
+// writeBillingError writes the response for an error of the billing service
+func writeBillingError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, billing.ErrUnknownPlan), errors.Is(err, billing.ErrDefaultPlan):
+		WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, billing.ErrNoSubscription):
+		WriteErrorResponse(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, billing.ErrAlreadySubscribed), errors.Is(err, billing.ErrSamePlan):
+		WriteErrorResponse(w, http.StatusConflict, err.Error())
+	case errors.Is(err, billing.ErrBillingOff):
+		WriteErrorResponse(w, http.StatusServiceUnavailable, err.Error())
+	default:
+		log.Println("billing:", err)
+		WriteErrorResponse(w, http.StatusInternalServerError, "Billing failed, try again later")
+	}
+}
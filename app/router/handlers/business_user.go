@@ -7,6 +7,7 @@ import (
 		"log"
 		"errors"
 		"strings"
+		"sort"
 		"fmt"
 
     "net/http"
@@ -17,7 +18,9 @@ import (
     "github.com/CoffeeHausGames/whir-server/app/auth"
 		"github.com/CoffeeHausGames/whir-server/app/model"
 		"github.com/CoffeeHausGames/whir-server/app/helpers"
+		"github.com/CoffeeHausGames/whir-server/app/moderation"
 		requests "github.com/CoffeeHausGames/whir-server/app/model/requests"
+		"github.com/CoffeeHausGames/whir-server/app/tasks"
 
 		"go.mongodb.org/mongo-driver/mongo"
     "go.mongodb.org/mongo-driver/bson"
@@ -76,21 +79,16 @@ func (env *HandlerEnv) BusinessSignUp(w http.ResponseWriter, r *http.Request, _
 	token, refreshToken, _ := auth.GenerateAllTokens(*user.GetEmail(), *user.GetFirstName(), user.GetLastName(), user.GetID().Hex())
 	user.SetToken(token)
 	user.SetRefreshToken(refreshToken)
-	lon, lat := 0.0, 0.0
-	if userRequest.Address != nil {
-		address := model.GetStreetAddress(userRequest.Address)
-		lon, lat, err = helpers.RetrieveCoordinatesFromAddress(address)
-		if err != nil {
-			log.Println("Address was not successful")
+	// an address is geocoded in the background, the business shows up in nearby searches once it has a location
+	if userRequest.Address == nil && userRequest.Longitude != nil && userRequest.Latitude != nil {
+		user.Location = &model.Location{
+			Type: "Point",
+			Coordinates: []float64{*userRequest.Longitude, *userRequest.Latitude},
 		}
-	} else if (userRequest.Longitude != nil && userRequest.Latitude != nil) {
-		lon = *userRequest.Longitude
-		lat = *userRequest.Latitude
-	}
-	user.Location = &model.Location{
-		Type: "Point",
-		Coordinates: []float64{lon, lat},
 	}
+	// a flagged business can sign in but stays out of public results until a moderator looks at it
+	var findings []string
+	user.Moderation_status, findings = env.moderation.Check("", moderation.BusinessText(user))
 
 	_, insertErr := businessCollection.InsertOne(ctx, user)
 	if insertErr != nil {
@@ -100,6 +98,24 @@ func (env *HandlerEnv) BusinessSignUp(w http.ResponseWriter, r *http.Request, _
 	}
 	defer cancel()
 
+	env.logAudit(r, model.ActorBusiness, &model.AuditEntry{
+		Action: model.AuditBusinessSignedUp, Actor_id: user.ID, Business_id: &user.ID, Target_type: model.AuditTargetBusiness, Target_id: user.ID,
+	})
+
+	if user.Moderation_status == model.ModerationPending {
+		target := moderation.Target{Type: model.ModerationTargetBusiness, ID: user.ID, Business_id: user.ID}
+		if err = env.moderation.Queue(ctx, target, findings, moderation.BusinessText(user)); err != nil {
+			log.Println("Business was not queued for moderation: " + err.Error())
+		}
+	}
+
+	if userRequest.Address != nil {
+		err = tasks.EnqueueGeocodeBusiness(ctx, env.queue, user.ID, userRequest.Address, user.Updated_at)
+		if err != nil {
+			log.Println("Geocoding the address was not queued: " + err.Error())
+		}
+	}
+
 	WriteSuccessResponse(w, r, "Account created successfully", nil, false)
 }
 
@@ -111,6 +127,10 @@ func (env *HandlerEnv) BusinessLogin(w http.ResponseWriter, r *http.Request, _ h
 	foundUser := new(model.BusinessUser)
 
 	foundUserInterface, err := performLogin(r.Context(), businessCollection, &user, foundUser)
+	if errors.Is(err, auth.ErrAccountSuspended) {
+		WriteErrorResponse(w, http.StatusForbidden, "This account is suspended")
+		return
+	}
 	if err != nil {
 		log.Println("performLogin failed")
 		WriteErrorResponse(w, 401, "There was an error logging in")
@@ -124,8 +144,19 @@ func (env *HandlerEnv) BusinessLogin(w http.ResponseWriter, r *http.Request, _ h
 		return
 	}
 
+	env.logAudit(r, model.ActorBusiness, &model.AuditEntry{
+		Action: model.AuditBusinessLoggedIn, Actor_id: foundUser.ID, Business_id: &foundUser.ID, Target_type: model.AuditTargetBusiness, Target_id: foundUser.ID,
+	})
+
 	userWrapper := model.NewBusinessAuthenticatedUser(foundUser, nil)
 
+	// the counts are nice to have on login, it still works without them
+	followers, favorites, err := env.businessAudience(r.Context(), foundUser.ID)
+	if err == nil {
+		userWrapper.Followers_count = &followers
+		userWrapper.Favorites_count = &favorites
+	}
+
 	WriteSuccessResponse(w, r, userWrapper, foundUser, true)
 }
 
@@ -143,6 +174,11 @@ func (env *HandlerEnv) BusinessTokenRefresh(w http.ResponseWriter, r *http.Reque
 			log.Panic(err)
 			return
 	}
+	// an impersonation token can not be traded for a normal session
+	if claims.Impersonator != "" {
+			WriteErrorResponse(w, http.StatusForbidden, "Impersonation tokens can not be refreshed")
+			return
+	}
 	
 	token, refreshToken, _ := auth.GenerateAllTokens(claims.Email, claims.First_name, &claims.Last_name, claims.Uid)
 
@@ -199,6 +235,9 @@ func (env *HandlerEnv) GetBusiness(w http.ResponseWriter, r *http.Request, _ htt
 						"$maxDistance": radiusMeters, // Radius in meters.
 				},
 		},
+		// businesses held back by moderation or suspended by an admin are left out
+		"moderation_status": model.PubliclyVisible(),
+		"suspended_at": bson.M{"$exists": false},
 	}
 
 	// cursor, err := businessCollection.Find(currBusiness, ctx, bson.M{"zip_code": *locationData.Zip_code})
@@ -218,16 +257,49 @@ func (env *HandlerEnv) GetBusiness(w http.ResponseWriter, r *http.Request, _ htt
 	dealCollection := env.database.GetDeals()
 	businessUserWrappers := make([]model.BusinessUserWrapper, 0, len(businesses))
 	for _, business := range businesses {
-		deals, err := GetDealForBusiness(business.ID, dealCollection, context.Background())
+		deals, err := GetDiscoverableDealsForBusiness(business.ID, dealCollection, context.Background())
 		if err != nil {
 			WriteErrorResponse(w, http.StatusUnprocessableEntity, err.Error())
 			continue
 		}
+
+		// Businesses with no deals matching the savings filter are left out of the results
+		if hasSavingsFilter(&locationData) {
+			deals = filterDealsBySavings(deals, &locationData)
+			if len(deals) == 0 {
+				continue
+			}
+		}
 		businessUserWrapper := model.NewBusinessUser(business, deals)
 
 		businessUserWrappers = append(businessUserWrappers, *businessUserWrapper)
 	}
 
+	// Results come back from mongo sorted by distance so only need to sort for savings or rating
+	if locationData.Sort_by != nil && *locationData.Sort_by == "savings" {
+		sortBusinessesBySavings(businessUserWrappers)
+	}
+	if locationData.Sort_by != nil && *locationData.Sort_by == "rating" {
+		sortBusinessesByRating(businessUserWrappers)
+	}
+	if locationData.Verified_first != nil && *locationData.Verified_first {
+		sortVerifiedBusinessesFirst(businessUserWrappers)
+	}
+
+	// sponsored results go on top of the sort, a search still works when they fail
+	var match func(*model.Deal) bool
+	if hasSavingsFilter(&locationData) {
+		match = func(deal *model.Deal) bool {
+			return len(filterDealsBySavings([]*model.Deal{deal}, &locationData)) > 0
+		}
+	}
+	placements, err := env.promotions.Serve(ctx, *locationData.Latitude, *locationData.Longitude, match)
+	if err != nil {
+		log.Println("sponsored results:", err)
+	}
+	businessUserWrappers = env.placeSponsored(ctx, businessUserWrappers, placements)
+	env.recordImpressions(ctx, *locationData.Latitude, *locationData.Longitude, businessUserWrappers)
+
 	// Return a success response to the client
 	WriteSuccessResponse(w, r, businessUserWrappers, nil, false)
 }
@@ -260,7 +332,7 @@ func (env *HandlerEnv) GetBusinessByID(w http.ResponseWriter, r *http.Request, p
 	var businessCollection model.Collection = env.database.GetBusinesses()
 
 	Id, err := primitive.ObjectIDFromHex(ps.ByName("id"))
-	err = businessCollection.FindOne(business, ctx, bson.M{"_id": Id})
+	err = businessCollection.FindOne(business, ctx, bson.M{"_id": Id, "moderation_status": model.PubliclyVisible(), "suspended_at": bson.M{"$exists": false}})
 
 	if err != nil {
 		WriteErrorResponse(w, http.StatusUnprocessableEntity, err.Error())
@@ -269,12 +341,13 @@ func (env *HandlerEnv) GetBusinessByID(w http.ResponseWriter, r *http.Request, p
 
 	dealCollection := env.database.GetDeals()
 
-	deals, err := GetDealForBusiness(business.ID, dealCollection, context.Background())
+	deals, err := GetDiscoverableDealsForBusiness(business.ID, dealCollection, context.Background())
 	if err != nil {
 		WriteErrorResponse(w, http.StatusUnprocessableEntity, err.Error())
 		return
 	}
 	businessUserWrapper := model.NewBusinessUser(business, deals)
+	env.recordViews(ctx, businessUserWrapper)
 
 	// Return a success response to the client
 	WriteSuccessResponse(w, r, businessUserWrapper, nil, false)
@@ -306,6 +379,10 @@ func (env *HandlerEnv) UpdateBusinessInfo(w http.ResponseWriter, r *http.Request
 			WriteErrorResponse(w, 422, "There was an error with the client request")
 			return 
 	}
+	if err := requests.ValidateBusinessUserUpdateStruct(&userRequest); err != nil {
+			WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+	}
 
 	update := bson.M{}
 	val := reflect.ValueOf(userRequest)
@@ -320,17 +397,81 @@ func (env *HandlerEnv) UpdateBusinessInfo(w http.ResponseWriter, r *http.Request
 			}
 	}
 
+	// support staff acting as the business can not take over the account
+	if _, ok := update["password"]; ok && claims.Impersonator != "" {
+			WriteErrorResponse(w, http.StatusForbidden, "The password can not be changed while impersonating")
+			return
+	}
+
 	updateOperation := bson.M{
 			"$set": update,
 	}
 
-	_, err = businessCollection.UpdateOne(ctx, bson.M{"_id": Id}, updateOperation)
+	// the event has the changed fields, never the password
+	changes := bson.M{}
+	for field, value := range update {
+		if field != "password" {
+			changes[field] = value
+		}
+	}
+	// every update is a new revision of the business, e.g. each address change is geocoded
+	updatedAt := time.Now().UTC()
+	update["updated_at"] = updatedAt
+
+	err = env.database.WithTransaction(ctx, func(ctx context.Context) error {
+		// both versions are read so the audit log has what each field was before and after
+		before := new(model.BusinessUser)
+		if err := businessCollection.FindOne(before, ctx, bson.M{"_id": Id}); err != nil {
+			return err
+		}
+		_, err := businessCollection.UpdateOne(ctx, bson.M{"_id": Id}, updateOperation)
+		if err != nil {
+			return err
+		}
+		if userRequest.Business_name != nil || userRequest.Description != nil {
+			if err := env.screenBusiness(ctx, Id); err != nil {
+				return err
+			}
+		}
+		if err := env.recordEvent(ctx, model.EventBusinessUpdated, Id, Id, changes); err != nil {
+			return err
+		}
+
+		after := new(model.BusinessUser)
+		if err := businessCollection.FindOne(after, ctx, bson.M{"_id": Id}); err != nil {
+			return err
+		}
+		// the evidence was for the old listing, a new name, address or phone number has to be verified again
+		if before.Verification_status != "" && listingChanged(before, after) {
+			if err := env.verification.ListingChanged(ctx, Id); err != nil {
+				return err
+			}
+			if err := businessCollection.FindOne(after, ctx, bson.M{"_id": Id}); err != nil {
+				return err
+			}
+		}
+		fieldChanges, err := model.Diff(before, after)
+		if err != nil {
+			return err
+		}
+		return env.recordAudit(ctx, r, model.ActorBusiness, &model.AuditEntry{
+			Action: model.AuditBusinessUpdated, Business_id: &Id, Target_type: model.AuditTargetBusiness, Target_id: Id, Changes: fieldChanges,
+		})
+	})
 	if err != nil {
 			log.Println(err)
 			WriteErrorResponse(w, 502, "There was an error updating the business info")
 			return
 	}
 
+	// a new address needs a new location unless the client sent one
+	if userRequest.Address != nil && userRequest.Location == nil {
+		err = tasks.EnqueueGeocodeBusiness(ctx, env.queue, Id, userRequest.Address, updatedAt)
+		if err != nil {
+			log.Println("Geocoding the address was not queued: " + err.Error())
+		}
+	}
+
 	WriteSuccessResponse(w, r, "Business info updated successfully", nil, false)
 }
 
@@ -360,10 +501,38 @@ func (env *HandlerEnv) GetLoggedInBusiness(w http.ResponseWriter, r *http.Reques
 	}
 	businessUserWrapper := model.NewBusinessUser(currBusiness, deals)
 
+	followers, favorites, err := env.businessAudience(ctx, currBusiness.ID)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	businessUserWrapper.Followers_count = &followers
+	businessUserWrapper.Favorites_count = &favorites
+
 	// Return a success response to the client
 	WriteSuccessResponse(w, r, businessUserWrapper, currBusiness, true)
 }
 
+// RevokeBusinessSessions logs the business out on every device, open dashboard connections are closed too
+func (env *HandlerEnv) RevokeBusinessSessions(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	claims := r.Context().Value("claims").(*auth.SignedDetails)
+	Id, err := primitive.ObjectIDFromHex(claims.Uid)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Error parsing user ID")
+		return
+	}
+
+	if err := auth.RevokeTokens(ctx, env.database.GetBusinesses(), Id); err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to revoke sessions")
+		return
+	}
+
+	env.Logout(w, r, ps)
+}
+
 // Function to pin deals for a business and verifies that it is that business' deal
 func (env *HandlerEnv) PinDeal(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	dealRequest := new(requests.Deal)
@@ -406,19 +575,30 @@ func (env *HandlerEnv) PinDeal(w http.ResponseWriter, r *http.Request, _ httprou
 		return
 	}
 
-	if len(currBusinessPinnedDeals) >= 3 || 
-		helpers.Contains(currBusinessPinnedDeals, &deal.ID) || 
+	if helpers.Contains(currBusinessPinnedDeals, &deal.ID) || 
+		deal.CurrentStatus() == model.DealArchived || 
 		currBusiness.ID != deal.Business_id {
 		WriteErrorResponse(w, http.StatusUnprocessableEntity, "You cannot pin this deal")
 		return
 	}
 
-	currBusiness.PinDeal(&dealRequest.ID)
-
-	filter := bson.M{"_id": currBusiness.ID}
-	update := bson.M{"$set": bson.M{"pinnedDeals": currBusiness.PinnedDeals}}
+	// the pinned deals are only saved when nobody changed them since they were read, so the limit holds
+	previous := pinnedDealsCopy(currBusiness.PinnedDeals)
+	if err := env.plans.Check(ctx, currBusiness, model.LimitPinnedDeals); err != nil {
+		writePlanError(w, err, "Failed to check the plan")
+		return
+	}
+	err = currBusiness.PinDeal(&dealRequest.ID, env.plans.PlanOf(currBusiness).Limit(model.LimitPinnedDeals))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
 
-	_, err = businessCollection.UpdateOne(ctx, filter, update)
+	err = env.savePinnedDeals(ctx, r, currBusiness, previous, model.EventDealPinned, model.AuditDealPinned, dealRequest.ID)
+	if errors.Is(err, errPinnedDealsChanged) {
+		WriteErrorResponse(w, http.StatusConflict, err.Error())
+		return
+	}
 	if err != nil {
 		WriteErrorResponse(w, http.StatusUnprocessableEntity, err.Error())
 		return
@@ -461,6 +641,7 @@ func (env *HandlerEnv) UnpinDeal(w http.ResponseWriter, r *http.Request, _ httpr
 			return
 	}
 
+	previous := pinnedDealsCopy(currBusiness.PinnedDeals)
 	err = currBusiness.UnpinDeal(&dealRequest.ID)
 	if err != nil {
 		WriteErrorResponse(w, http.StatusUnprocessableEntity, err.Error())
@@ -469,14 +650,140 @@ func (env *HandlerEnv) UnpinDeal(w http.ResponseWriter, r *http.Request, _ httpr
 
 	fmt.Println("currBusiness.PinnedDeals:", currBusiness.PinnedDeals)
 
-	filter := bson.M{"_id": currBusiness.ID}
-	update := bson.M{"$set": bson.M{"pinnedDeals": currBusiness.PinnedDeals}}
-
-	_, err = businessCollection.UpdateOne(ctx, filter, update)
+	err = env.savePinnedDeals(ctx, r, currBusiness, previous, model.EventDealUnpinned, model.AuditDealUnpinned, dealRequest.ID)
+	if errors.Is(err, errPinnedDealsChanged) {
+			WriteErrorResponse(w, http.StatusConflict, err.Error())
+			return
+	}
 	if err != nil {
 			WriteErrorResponse(w, http.StatusUnprocessableEntity, err.Error())
 			return
 	}
 
 	WriteSuccessResponse(w, r, "Deal unpinned successfully", nil, false)
-}
\ No newline at end of file
+}
+
+// errPinnedDealsChanged is returned when the pinned deals changed between reading and saving them
+var errPinnedDealsChanged = errors.New("The pinned deals changed, try again")
+
+// pinnedDealsCopy copies the pinned deals before they are changed in place, nil stays nil so it still
+// matches a business without pinned deals
+func pinnedDealsCopy(pinned []*primitive.ObjectID) []*primitive.ObjectID {
+	if pinned == nil {
+		return nil
+	}
+	return append(make([]*primitive.ObjectID, 0, len(pinned)), pinned...)
+}
+
+// savePinnedDeals stores the pinned deals of the business if they are still the previous ones
+// and records the pin or unpin event and audit entry, it returns errPinnedDealsChanged otherwise
+func (env *HandlerEnv) savePinnedDeals(ctx context.Context, r *http.Request, business *model.BusinessUser, previous []*primitive.ObjectID, eventType string, auditAction string, dealID primitive.ObjectID) error {
+	filter := bson.M{"_id": business.ID, "pinnedDeals": previous}
+	update := bson.M{"$set": bson.M{"pinnedDeals": business.PinnedDeals}}
+
+	return env.database.WithTransaction(ctx, func(ctx context.Context) error {
+		result, err := env.database.GetBusinesses().UpdateOne(ctx, filter, update)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return errPinnedDealsChanged
+		}
+		if err := env.recordEvent(ctx, eventType, business.ID, dealID, model.DealPinData{Deal_id: dealID, Pinned_deals: business.PinnedDeals}); err != nil {
+			return err
+		}
+		return env.recordAudit(ctx, r, model.ActorBusiness, &model.AuditEntry{
+			Action: auditAction, Business_id: &business.ID, Target_type: model.AuditTargetDeal, Target_id: dealID,
+			Data: model.DealPinData{Deal_id: dealID, Pinned_deals: business.PinnedDeals},
+		})
+	})
+}
+
+// hasSavingsFilter checks if the nearby search asked to only see deals with certain savings
+func hasSavingsFilter(locationData *requests.Location) bool {
+	return locationData.Min_savings_percent != nil || locationData.Currency != nil
+}
+
+// filterDealsBySavings removes the deals that do not match the savings filters of the nearby search
+func filterDealsBySavings(deals []*model.Deal, locationData *requests.Location) []*model.Deal {
+	filtered := make([]*model.Deal, 0, len(deals))
+	for _, deal := range deals {
+		if locationData.Currency != nil && (deal.Savings == nil || !strings.EqualFold(deal.Savings.Currency, *locationData.Currency)) {
+			continue
+		}
+		if locationData.Min_savings_percent != nil && deal.SavingsPercent() < *locationData.Min_savings_percent {
+			continue
+		}
+		filtered = append(filtered, deal)
+	}
+	return filtered
+}
+
+// sortBusinessesBySavings orders businesses by their best deal and the deals of each business from best to worst.
+// Savings are compared by percent so deals in different currencies can be ranked together
+func sortBusinessesBySavings(businesses []model.BusinessUserWrapper) {
+	bestSavings := make(map[primitive.ObjectID]float64, len(businesses))
+	for _, business := range businesses {
+		sort.SliceStable(business.Deals, func(i, j int) bool {
+			return business.Deals[i].SavingsPercent() > business.Deals[j].SavingsPercent()
+		})
+		if len(business.Deals) > 0 {
+			bestSavings[business.ID] = business.Deals[0].SavingsPercent()
+		}
+	}
+
+	sort.SliceStable(businesses, func(i, j int) bool {
+		return bestSavings[businesses[i].ID] > bestSavings[businesses[j].ID]
+	})
+}
+
+// sortBusinessesByRating orders businesses by their average rating, more reviews win a tie.
+// Businesses without reviews go last and stay in distance order
+func sortBusinessesByRating(businesses []model.BusinessUserWrapper) {
+	sort.SliceStable(businesses, func(i, j int) bool {
+		a, b := businesses[i].Rating, businesses[j].Rating
+		switch {
+		case a == nil || b == nil:
+			return a != nil && b == nil
+		case a.Average != b.Average:
+			return a.Average > b.Average
+		}
+		return a.Count > b.Count
+	})
+}
+
+// sortVerifiedBusinessesFirst moves verified businesses in front of the others without changing the order within each group
+func sortVerifiedBusinessesFirst(businesses []model.BusinessUserWrapper) {
+	sort.SliceStable(businesses, func(i, j int) bool {
+		return businesses[i].Verified && !businesses[j].Verified
+	})
+}
+
+// listingChanged tells if the name, address or phone number of the business changed
+func listingChanged(before *model.BusinessUser, after *model.BusinessUser) bool {
+	return !reflect.DeepEqual(before.Business_name, after.Business_name) || !reflect.DeepEqual(before.Address, after.Address) ||
+		!reflect.DeepEqual(before.Phone_number, after.Phone_number)
+}
+
+// screenBusiness screens the text of a business again after it changed,
+// a business held back by a moderator goes back to the queue
+func (env *HandlerEnv) screenBusiness(ctx context.Context, businessID primitive.ObjectID) error {
+	businessCollection := env.database.GetBusinesses()
+	business := new(model.BusinessUser)
+	if err := businessCollection.FindOne(business, ctx, bson.M{"_id": businessID}); err != nil {
+		return err
+	}
+
+	status, findings := env.moderation.Check(business.Moderation_status, moderation.BusinessText(business))
+	if status != business.Moderation_status {
+		_, err := businessCollection.UpdateOne(ctx, bson.M{"_id": businessID}, bson.M{"$set": bson.M{"moderation_status": status}})
+		if err != nil {
+			return err
+		}
+	}
+	if status != model.ModerationPending {
+		return nil
+	}
+	target := moderation.Target{Type: model.ModerationTargetBusiness, ID: businessID, Business_id: businessID}
+	return env.moderation.Queue(ctx, target, findings, moderation.BusinessText(business))
+}
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/CoffeeHausGames/whir-server/app/auth"
+	"github.com/CoffeeHausGames/whir-server/app/realtime"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// dashboardPingInterval is how often the server pings, a client that does not answer
+	// within dashboardReadTimeout is disconnected
+	dashboardPingInterval = 30 * time.Second
+	dashboardReadTimeout  = 75 * time.Second
+	dashboardWriteTimeout = 10 * time.Second
+	// dashboardSessionCheck is how often the session is checked so a revoked one is closed
+	dashboardSessionCheck = 30 * time.Second
+	// reconnect tokens are valid for reconnectTokenFor and a new one is sent before it runs out
+	reconnectTokenFor     = 10 * time.Minute
+	reconnectTokenRefresh = 5 * time.Minute
+	// dashboardResumeTimeout is how long a socket that is not logged in waits for the reconnect token
+	dashboardResumeTimeout = 10 * time.Second
+)
+
+// errNoResume is returned when the first message of a socket that is not logged in is not a resume
+var errNoResume = errors.New("expected a resume message")
+
+// dashboardSession is what the client is sent when it connects and when its reconnect token is renewed
+type dashboardSession struct {
+	Type            string    `json:"type"`
+	Reconnect_token string    `json:"reconnect_token"`
+	Expires_at      time.Time `json:"expires_at"`
+}
+
+// BusinessDashboardSocket is a WebSocket that pushes the events of the authenticated business
+// (deals claimed or redeemed, deal changes, ...) to its dashboard as JSON messages.
+//
+// The business logs in with the access_token cookie or the Authorization header like every other
+// business route. Without them the first message has to be {"type":"resume","reconnect_token":".."} with the
+// token it was sent on a previous connection, it is not taken from the URL where it would end up in logs.
+// last_event_id resumes after the last event the client got.
+// The server pings every 30 seconds, clients can also send {"type":"ping"} and get {"type":"pong"}
+func (env *HandlerEnv) BusinessDashboardSocket(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	// browsers send cookies with cross site WebSocket requests so the origin has to be checked here
+	if !allowedOrigin(r) {
+		WriteErrorResponse(w, http.StatusForbidden, "Origin not allowed")
+		return
+	}
+
+	if r.URL.Query().Has("reconnect_token") {
+		WriteErrorResponse(w, http.StatusBadRequest, "Send the reconnect_token in a resume message after connecting")
+		return
+	}
+
+	var lastEventID *primitive.ObjectID
+	if id := r.URL.Query().Get("last_event_id"); id != "" {
+		parsed, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			WriteErrorResponse(w, http.StatusBadRequest, "Invalid last_event_id")
+			return
+		}
+		lastEventID = &parsed
+	}
+
+	conn, err := realtime.Upgrade(w, r, dashboardReadTimeout, dashboardWriteTimeout)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer conn.Close()
+
+	session, err := env.authenticateDashboard(r, conn)
+	if err != nil {
+		conn.WriteClose(realtime.CloseSessionRevoked, "failed to authenticate")
+		return
+	}
+	businessID, err := primitive.ObjectIDFromHex(session.Uid)
+	if err != nil {
+		conn.WriteClose(realtime.CloseProtocolError, "invalid login")
+		return
+	}
+
+	// subscribe before replaying so nothing that happens in between is missed
+	client := env.dashboard.Subscribe(businessID)
+	defer env.dashboard.Unsubscribe(client)
+
+	if err := sendDashboardSession(conn, session); err != nil {
+		return
+	}
+
+	replayed := make(map[primitive.ObjectID]bool)
+	if lastEventID != nil {
+		var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+		missed, err := env.dashboard.Replay(ctx, businessID, *lastEventID)
+		cancel()
+		if err != nil {
+			log.Println("Dashboard could not replay events: " + err.Error())
+		}
+		for _, event := range missed {
+			if conn.WriteMessage(realtime.OpText, event.Data) != nil {
+				return
+			}
+			replayed[event.ID] = true
+		}
+	}
+
+	// the reader answers pings and notices when the client goes away
+	closed := make(chan error, 1)
+	go func() {
+		closed <- readDashboardMessages(conn)
+	}()
+
+	ping := time.NewTicker(dashboardPingInterval)
+	defer ping.Stop()
+	sessionCheck := time.NewTicker(dashboardSessionCheck)
+	defer sessionCheck.Stop()
+	refresh := time.NewTicker(reconnectTokenRefresh)
+	defer refresh.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case event, ok := <-client.Events():
+			if !ok {
+				// the client fell behind, it can reconnect with its reconnect token and last event ID
+				conn.WriteClose(realtime.CloseTryAgainLater, "too slow, reconnect")
+				return
+			}
+			if replayed[event.ID] {
+				continue
+			}
+			if conn.WriteMessage(realtime.OpText, event.Data) != nil {
+				return
+			}
+		case <-ping.C:
+			if conn.WriteMessage(realtime.OpPing, nil) != nil {
+				return
+			}
+		case <-sessionCheck.C:
+			// the connection ends with the login it was opened with
+			if session.Expired(time.Now()) {
+				conn.WriteClose(realtime.CloseSessionRevoked, "session expired")
+				return
+			}
+			var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+			err := env.checkDashboardSession(ctx, session)
+			cancel()
+			if errors.Is(err, auth.ErrTokenRevoked) || errors.Is(err, auth.ErrAccountSuspended) ||
+				errors.Is(err, auth.ErrImpersonationEnded) || errors.Is(err, mongo.ErrNoDocuments) {
+				conn.WriteClose(realtime.CloseSessionRevoked, "session revoked")
+				return
+			}
+		case <-refresh.C:
+			if sendDashboardSession(conn, session) != nil {
+				return
+			}
+		}
+	}
+}
+
+// authenticateDashboard logs the business in like [HandlerEnv.BusinessAuthentication]
+// or with the reconnect token of the first message, returns the login of the business
+func (env *HandlerEnv) authenticateDashboard(r *http.Request, conn *realtime.Conn) (auth.ReconnectSession, error) {
+	var session auth.ReconnectSession
+	claims, err := authenticateRequest(r, env.database.GetBusinesses())
+	if err == nil {
+		session = auth.NewReconnectSession(claims)
+	} else {
+		token, err := readDashboardResume(conn)
+		if err != nil {
+			return session, err
+		}
+		session, err = auth.VerifyReconnectToken(token)
+		if err != nil {
+			return session, err
+		}
+	}
+
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := env.checkDashboardSession(ctx, session); err != nil {
+		return auth.ReconnectSession{}, err
+	}
+	return session, nil
+}
+
+// checkDashboardSession makes sure the login of a dashboard was not revoked
+// and that the admin of an impersonation login can still act as the business
+func (env *HandlerEnv) checkDashboardSession(ctx context.Context, session auth.ReconnectSession) error {
+	if err := auth.CheckSession(ctx, env.database.GetBusinesses(), session.Uid, session.IssuedAt); err != nil {
+		return err
+	}
+	if session.Impersonator != "" {
+		return auth.CheckImpersonator(ctx, env.database.GetUsers(), session.Impersonator, session.IssuedAt)
+	}
+	return nil
+}
+
+// readDashboardResume reads the reconnect token of the resume message a socket that is not logged in starts with
+func readDashboardResume(conn *realtime.Conn) (string, error) {
+	conn.SetReadTimeout(dashboardResumeTimeout)
+	defer conn.SetReadTimeout(dashboardReadTimeout)
+
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return "", err
+	}
+	var message struct {
+		Type            string `json:"type"`
+		Reconnect_token string `json:"reconnect_token"`
+	}
+	if json.Unmarshal(data, &message) != nil || message.Type != "resume" || message.Reconnect_token == "" {
+		return "", errNoResume
+	}
+	return message.Reconnect_token, nil
+}
+
+// readDashboardMessages reads until the connection closes, the only message clients send is a ping
+func readDashboardMessages(conn *realtime.Conn) error {
+	pong, _ := json.Marshal(map[string]string{"type": "pong"})
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		var message struct {
+			Type string `json:"type"`
+		}
+		if json.Unmarshal(data, &message) == nil && message.Type == "ping" {
+			if err := conn.WriteMessage(realtime.OpText, pong); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// sendDashboardSession sends a new reconnect token, it never outlives the login of the session
+func sendDashboardSession(conn *realtime.Conn, session auth.ReconnectSession) error {
+	token, expires := auth.SignReconnectToken(session, time.Now().Add(reconnectTokenFor))
+	data, err := json.Marshal(dashboardSession{
+		Type:            "session",
+		Reconnect_token: token,
+		Expires_at:      expires,
+	})
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(realtime.OpText, data)
+}
+
+// allowedOrigin accepts requests without an Origin (not from a browser), from the same host
+// and from the [AllowedOrigins]
+func allowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range AllowedOrigins {
+		if origin == allowed {
+			return true
+		}
+	}
+	parsed, err := url.Parse(origin)
+	return err == nil && parsed.Host == r.Host
+}
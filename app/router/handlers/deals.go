@@ -5,6 +5,8 @@ import (
     "context"
 		"log"
 		"fmt"
+		"errors"
+		"strings"
 
     "net/http"
 		"time"
@@ -13,14 +15,18 @@ import (
 
     "github.com/CoffeeHausGames/whir-server/app/auth"
 		"github.com/CoffeeHausGames/whir-server/app/model"
+		"github.com/CoffeeHausGames/whir-server/app/moderation"
 		requests "github.com/CoffeeHausGames/whir-server/app/model/requests"
 
 		"go.mongodb.org/mongo-driver/mongo"
+		"go.mongodb.org/mongo-driver/mongo/options"
     "go.mongodb.org/mongo-driver/bson"
     "go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 
+var errDealNotFound = errors.New("Deal not found")
+
 // Function to add a deal for the authenticated business user
 func (env *HandlerEnv) AddDeal(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
@@ -47,15 +53,56 @@ func (env *HandlerEnv) AddDeal(w http.ResponseWriter, r *http.Request, _ httprou
 
 		dealData.Business_id = objectID
 		deal := requests.NewDeal(dealData)
+		// a limited quantity deal starts with everything available
+		deal.Remaining = deal.Quantity
+
+		// deals are published right away unless the business asks for a draft or a publish time
+		err = setInitialDealStatus(deal, dealData, time.Now().UTC())
+		if err != nil {
+				WriteErrorResponse(w, http.StatusUnprocessableEntity, err.Error())
+				return
+		}
+		if dealCountsAsActive(deal.Status) {
+				reservation, err := env.reservePlanLimit(ctx, objectID, model.LimitActiveDeals)
+				if err != nil {
+						writePlanError(w, err, "Failed to check the plan")
+						return
+				}
+				defer reservation.Release()
+		}
+
+		// flagged deals are saved but stay out of public results until a moderator looks at them
+		var findings []string
+		deal.Moderation_status, findings = env.moderation.Check("", moderation.DealText(deal))
 
     dealCollection := env.database.GetDeals()
-    InsertedID, err := dealCollection.InsertOne(ctx, deal)
+    err = env.database.WithTransaction(ctx, func(ctx context.Context) error {
+        InsertedID, err := dealCollection.InsertOne(ctx, deal)
+        if err != nil {
+            return err
+        }
+        deal.ID = InsertedID
+        if deal.Moderation_status == model.ModerationPending {
+            target := moderation.Target{Type: model.ModerationTargetDeal, ID: deal.ID, Business_id: deal.Business_id}
+            if err := env.moderation.Queue(ctx, target, findings, moderation.DealText(deal)); err != nil {
+                return err
+            }
+        }
+        changes, err := model.Diff(nil, deal)
+        if err != nil {
+            return err
+        }
+        if err := env.recordAudit(ctx, r, model.ActorBusiness, dealAuditEntry(model.AuditDealCreated, deal, changes)); err != nil {
+            return err
+        }
+        return env.recordEvent(ctx, model.EventDealCreated, deal.Business_id, deal.ID, deal)
+    })
     if err != nil {
         log.Println(err)
         WriteErrorResponse(w, http.StatusInternalServerError, "Failed to insert deal")
         return
     }
-		deal.ID = InsertedID
+		deal.ComputeFields()
 
     WriteSuccessResponse(w, r, deal, nil, false)
 }
@@ -74,7 +121,21 @@ func (env *HandlerEnv) GetSignedInBusinessDeals(w http.ResponseWriter, r *http.R
 
 	dealCollection := env.database.GetDeals()
 
-	deals, err := GetDealForBusiness(userID, dealCollection, ctx)
+	// ?status=draft,scheduled filters the deals, archived deals are only returned when asked for
+	var deals []*model.Deal
+	if statuses := r.URL.Query().Get("status"); statuses != "" {
+		statusList := strings.Split(statuses, ",")
+		for i, status := range statusList {
+			statusList[i] = strings.TrimSpace(status)
+			if !model.IsDealStatus(statusList[i]) {
+				WriteErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Unknown deal status %q", statusList[i]))
+				return
+			}
+		}
+		deals, err = GetDealForBusinessByStatus(userID, statusList, dealCollection, ctx)
+	} else {
+		deals, err = GetDealForBusiness(userID, dealCollection, ctx)
+	}
 	if err != nil {
 			WriteErrorResponse(w, http.StatusInternalServerError, "Error finding deals")
 			return
@@ -106,21 +167,16 @@ func (env *HandlerEnv) UpdateDeal(w http.ResponseWriter, r *http.Request, _ http
 			return
 	}
 
-	dealCollection := env.database.GetDeals()
-	deal := requests.NewDeal(dealData)
-	deal.Business_id = userID
-
-	query := bson.M{"_id": deal.ID, "business_id": userID}
-
-	update := bson.M{
-			"$set": deal,
+	deal, err := env.saveDealUpdate(ctx, r, model.ActorBusiness, model.AuditDealUpdated, userID, dealData)
+	if errors.Is(err, errDealNotFound) {
+			WriteErrorResponse(w, http.StatusNotFound, "Deal not found")
+			return
 	}
-
-	_, err = dealCollection.UpdateOne(ctx, query, update)
 	if err != nil {
 			WriteErrorResponse(w, http.StatusInternalServerError, "Failed to update deal")
 			return
 	}
+	deal.ComputeFields()
 
 	WriteSuccessResponse(w, r, deal, nil, false)
 }
@@ -148,19 +204,14 @@ func (env *HandlerEnv) DeleteDeal(w http.ResponseWriter, r *http.Request, _ http
 			return
 	}
 
-	dealCollection := env.database.GetDeals()
-	deal := requests.NewDeal(dealData)
-	deal.Business_id = userID
-
-	query := bson.M{"_id": deal.ID, "business_id": userID}
-
-	deleted, err := dealCollection.DeleteOne(ctx, query)
+	// deleting a deal archives it so it can be restored
+	deal, status, err := env.changeDealStatus(ctx, r, model.ActorBusiness, model.AuditDealDeleted, userID, dealData.ID, model.DealArchived, nil)
 	if err != nil {
-			WriteErrorResponse(w, http.StatusInternalServerError, "Failed to delete deal")
+			WriteErrorResponse(w, status, err.Error())
 			return
 	}
 
-	WriteSuccessResponse(w, r, deleted, nil, false)
+	WriteSuccessResponse(w, r, deal, nil, false)
 }
 
 func (env *HandlerEnv) DeleteMultipleDeals(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
@@ -198,15 +249,378 @@ func (env *HandlerEnv) DeleteMultipleDeals(w http.ResponseWriter, r *http.Reques
 		dealIDs = append(dealIDs, dealID)
 	}
 
-	query := bson.M{"_id": bson.M{"$in": dealIDs}, "business_id": userID}
+	query := bson.M{"_id": bson.M{"$in": dealIDs}, "business_id": userID, "status": bson.M{"$ne": model.DealArchived}}
+
+	// deleting deals archives them, the pipeline keeps the status each deal had so it can be restored
+	now := time.Now().UTC()
+	archive := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"archived_from": bson.M{"$ifNull": bson.A{"$status", model.DealLive}},
+			"status":        model.DealArchived,
+			"archived_at":   now,
+		}}},
+	}
+
+	var archived *mongo.UpdateResult
+	err = env.database.WithTransaction(ctx, func(ctx context.Context) error {
+		// the deals are read in the transaction so there is an event for each deal that is archived
+		deals, err := findDeals(ctx, dealCollection, query)
+		if err != nil {
+			return err
+		}
+
+		archived, err = dealCollection.UpdateMany(ctx, query, archive)
+		if err != nil {
+			return err
+		}
+		if err := env.unpinDeals(ctx, userID, dealIDs); err != nil {
+			return err
+		}
 
-	deleted, err := dealCollection.DeleteMany(ctx, query)
+		for _, deal := range deals {
+			before := *deal
+			previousStatus := deal.CurrentStatus()
+			deal.Archived_from = previousStatus
+			deal.Status = model.DealArchived
+			deal.Archived_at = &now
+			changes, err := model.Diff(&before, deal)
+			if err != nil {
+				return err
+			}
+			if err := env.recordAudit(ctx, r, model.ActorBusiness, dealAuditEntry(model.AuditDealDeleted, deal, changes)); err != nil {
+				return err
+			}
+			err = env.recordEvent(ctx, model.EventDealStatusChanged, userID, deal.ID, model.DealStatusChangedData{Deal: deal, Previous_status: previousStatus})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
 		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to delete deals")
 		return
 	}
 
-	WriteSuccessResponse(w, r, deleted, nil, false)
+	WriteSuccessResponse(w, r, archived, nil, false)
+}
+
+// ChangeDealStatus moves a deal of the authenticated business along its lifecycle
+// e.g. publishing a draft or scheduling it with publish_at
+func (env *HandlerEnv) ChangeDealStatus(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	claims, body, err := env.getClaimsAndBody(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var statusChange requests.DealStatusChange
+	err = json.Unmarshal([]byte(body), &statusChange)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, "There was an error with the client request")
+		return
+	}
+
+	err = requests.ValidateDealStatusChangeStruct(&statusChange)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userID, err := primitive.ObjectIDFromHex(claims.Uid)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Error parsing user ID")
+		return
+	}
+
+	deal, status, err := env.changeDealStatus(ctx, r, model.ActorBusiness, model.AuditDealStatusChanged, userID, statusChange.ID, statusChange.Status, statusChange.Publish_at)
+	if err != nil {
+		WriteErrorResponse(w, status, err.Error())
+		return
+	}
+
+	WriteSuccessResponse(w, r, deal, nil, false)
+}
+
+// RestoreDeal brings back a deleted (archived) deal of the authenticated business
+func (env *HandlerEnv) RestoreDeal(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	claims, body, err := env.getClaimsAndBody(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var dealData requests.Deal
+	err = json.Unmarshal([]byte(body), &dealData)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, "There was an error with the client request")
+		return
+	}
+
+	userID, err := primitive.ObjectIDFromHex(claims.Uid)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Error parsing user ID")
+		return
+	}
+
+	dealCollection := env.database.GetDeals()
+	deal := new(model.Deal)
+	err = dealCollection.FindOne(deal, ctx, bson.M{"_id": dealData.ID, "business_id": userID})
+	if err != nil {
+		WriteErrorResponse(w, http.StatusNotFound, "Deal not found")
+		return
+	}
+
+	before := *deal
+	previousStatus := deal.CurrentStatus()
+	if err := deal.Restore(time.Now().UTC()); err != nil {
+		WriteErrorResponse(w, http.StatusConflict, err.Error())
+		return
+	}
+	if dealCountsAsActive(deal.CurrentStatus()) {
+		reservation, err := env.reservePlanLimit(ctx, userID, model.LimitActiveDeals)
+		if err != nil {
+			writePlanError(w, err, "Failed to check the plan")
+			return
+		}
+		defer reservation.Release()
+	}
+
+	status := http.StatusOK
+	err = env.database.WithTransaction(ctx, func(ctx context.Context) error {
+		status, err = saveDealStatus(ctx, dealCollection, deal, previousStatus)
+		if err != nil {
+			return err
+		}
+		changes, err := model.Diff(&before, deal)
+		if err != nil {
+			return err
+		}
+		if err := env.recordAudit(ctx, r, model.ActorBusiness, dealAuditEntry(model.AuditDealRestored, deal, changes)); err != nil {
+			return err
+		}
+		return env.recordEvent(ctx, model.EventDealStatusChanged, userID, deal.ID, model.DealStatusChangedData{Deal: deal, Previous_status: previousStatus})
+	})
+	if err != nil {
+		if status == http.StatusOK {
+			status, err = http.StatusInternalServerError, errors.New("Failed to update deal")
+		}
+		WriteErrorResponse(w, status, err.Error())
+		return
+	}
+	deal.ComputeFields()
+
+	WriteSuccessResponse(w, r, deal, nil, false)
+}
+
+// saveDealUpdate changes the fields of a deal of the business that were sent and records auditAction with what changed
+// returns errDealNotFound for unknown and archived deals
+func (env *HandlerEnv) saveDealUpdate(ctx context.Context, r *http.Request, actorType string, auditAction string, userID primitive.ObjectID, dealData requests.Deal) (*model.Deal, error) {
+	dealCollection := env.database.GetDeals()
+	deal := requests.NewDeal(dealData)
+	deal.Business_id = userID
+	// the status is changed through ChangeDealStatus so the lifecycle rules are followed
+	deal.Status = ""
+	deal.Publish_at = nil
+
+	query := bson.M{"_id": deal.ID, "business_id": userID, "status": bson.M{"$ne": model.DealArchived}}
+
+	update := bson.M{
+			"$set": deal,
+	}
+
+	err := env.database.WithTransaction(ctx, func(ctx context.Context) error {
+		before := new(model.Deal)
+		if err := dealCollection.FindOne(before, ctx, query); err != nil {
+			return errDealNotFound
+		}
+
+		// changing the quantity keeps the claims already made so only the difference is added to what remains
+		if deal.Quantity != nil {
+			_, err := dealCollection.UpdateOne(ctx, query, remainingAfterQuantityChange(*deal.Quantity))
+			if err != nil {
+				return err
+			}
+		}
+
+		result, err := dealCollection.UpdateOne(ctx, query, update)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return errDealNotFound
+		}
+
+		// the event has the whole deal, not only the fields that were sent
+		updated := new(model.Deal)
+		if err := dealCollection.FindOne(updated, ctx, bson.M{"_id": deal.ID}); err != nil {
+			return err
+		}
+
+		// new text is screened again, a deal held back by a moderator goes back to the queue when its text changes
+		if deal.Name != nil || deal.Description != nil {
+			status, findings := env.moderation.Check(updated.Moderation_status, moderation.DealText(updated))
+			if status != updated.Moderation_status {
+				_, err := dealCollection.UpdateOne(ctx, bson.M{"_id": deal.ID}, bson.M{"$set": bson.M{"moderation_status": status}})
+				if err != nil {
+					return err
+				}
+				updated.Moderation_status = status
+			}
+			if status == model.ModerationPending {
+				target := moderation.Target{Type: model.ModerationTargetDeal, ID: deal.ID, Business_id: userID}
+				if err := env.moderation.Queue(ctx, target, findings, moderation.DealText(updated)); err != nil {
+					return err
+				}
+			}
+			deal.Moderation_status = updated.Moderation_status
+		}
+
+		changes, err := model.Diff(before, updated)
+		if err != nil {
+			return err
+		}
+		if err := env.recordAudit(ctx, r, actorType, dealAuditEntry(auditAction, updated, changes)); err != nil {
+			return err
+		}
+		return env.recordEvent(ctx, model.EventDealUpdated, userID, deal.ID, updated)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deal, nil
+}
+
+// changeDealStatus loads a deal of the business, moves it to status, saves it and records auditAction
+// returns the status code to send when it fails
+func (env *HandlerEnv) changeDealStatus(ctx context.Context, r *http.Request, actorType string, auditAction string, businessID primitive.ObjectID, dealID primitive.ObjectID, status string, publishAt *time.Time) (*model.Deal, int, error) {
+	dealCollection := env.database.GetDeals()
+	deal := new(model.Deal)
+	err := dealCollection.FindOne(deal, ctx, bson.M{"_id": dealID, "business_id": businessID})
+	if err != nil {
+		return nil, http.StatusNotFound, errors.New("Deal not found")
+	}
+
+	before := *deal
+	previousStatus := deal.CurrentStatus()
+	if err := deal.TransitionTo(status, publishAt, time.Now().UTC()); err != nil {
+		return nil, http.StatusConflict, err
+	}
+	if !dealCountsAsActive(previousStatus) && dealCountsAsActive(deal.CurrentStatus()) {
+		reservation, err := env.reservePlanLimit(ctx, businessID, model.LimitActiveDeals)
+		if err != nil {
+			code, err := planError(err, "Failed to check the plan")
+			return nil, code, err
+		}
+		defer reservation.Release()
+	}
+
+	code := http.StatusOK
+	err = env.database.WithTransaction(ctx, func(ctx context.Context) error {
+		code, err = saveDealStatus(ctx, dealCollection, deal, previousStatus)
+		if err != nil {
+			return err
+		}
+
+		// a deal that is not live anymore can not stay pinned
+		if deal.Status == model.DealArchived || deal.Status == model.DealDraft {
+			if err := env.unpinDeals(ctx, businessID, []primitive.ObjectID{deal.ID}); err != nil {
+				return err
+			}
+		}
+
+		changes, err := model.Diff(&before, deal)
+		if err != nil {
+			return err
+		}
+		if err := env.recordAudit(ctx, r, actorType, dealAuditEntry(auditAction, deal, changes)); err != nil {
+			return err
+		}
+		return env.recordEvent(ctx, model.EventDealStatusChanged, businessID, deal.ID, model.DealStatusChangedData{Deal: deal, Previous_status: previousStatus})
+	})
+	if err != nil {
+		if code == http.StatusOK {
+			return nil, http.StatusInternalServerError, errors.New("Failed to update deal")
+		}
+		return nil, code, err
+	}
+
+	deal.ComputeFields()
+	return deal, http.StatusOK, nil
+}
+
+// saveDealStatus stores the lifecycle fields of the deal
+// the update only applies if nobody else changed the status since it was read
+func saveDealStatus(ctx context.Context, dealCollection model.Collection, deal *model.Deal, previousStatus string) (int, error) {
+	set := bson.M{"status": deal.Status}
+	unset := bson.M{}
+	setOrUnset := func(field string, value *time.Time) {
+		if value != nil {
+			set[field] = *value
+		} else {
+			unset[field] = ""
+		}
+	}
+	setOrUnset("publish_at", deal.Publish_at)
+	setOrUnset("published_at", deal.Published_at)
+	setOrUnset("archived_at", deal.Archived_at)
+	if deal.Archived_from != "" {
+		set["archived_from"] = deal.Archived_from
+	} else {
+		unset["archived_from"] = ""
+	}
+
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	filter := bson.M{"_id": deal.ID, "status": previousStatus}
+	if previousStatus == model.DealLive {
+		// deals from before there were statuses do not have one stored
+		filter["status"] = bson.M{"$in": bson.A{model.DealLive, nil}}
+	}
+
+	result, err := dealCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("Failed to update deal")
+	}
+	if result.MatchedCount == 0 {
+		return http.StatusConflict, errors.New("The deal was changed by someone else, try again")
+	}
+	return http.StatusOK, nil
+}
+
+// unpinDeals removes deals from the pinned deals of a business
+func (env *HandlerEnv) unpinDeals(ctx context.Context, businessID primitive.ObjectID, dealIDs []primitive.ObjectID) error {
+	_, err := env.database.GetBusinesses().UpdateOne(ctx,
+		bson.M{"_id": businessID},
+		bson.M{"$pull": bson.M{"pinnedDeals": bson.M{"$in": dealIDs}}},
+	)
+	return err
+}
+
+// setInitialDealStatus works out the status of a new deal from the request
+func setInitialDealStatus(deal *model.Deal, dealData requests.Deal, now time.Time) error {
+	target := model.DealLive
+	if dealData.Status != nil {
+		target = *dealData.Status
+	} else if dealData.Publish_at != nil && dealData.Publish_at.After(now) {
+		target = model.DealScheduled
+	}
+
+	deal.Status = model.DealDraft
+	deal.Publish_at = nil
+	if target == model.DealDraft {
+		return nil
+	}
+	return deal.TransitionTo(target, dealData.Publish_at, now)
 }
 
 
@@ -250,13 +664,59 @@ func (env *HandlerEnv) getDealDataFromBody(body string) (requests.Deal, error) {
 	return dealData, nil
 }
 
+// remainingAfterQuantityChange is an update pipeline that sets the remaining claims of a deal for a new quantity
+// it runs inside mongo so claims made at the same time are not lost
+func remainingAfterQuantityChange(quantity int) mongo.Pipeline {
+	claimed := bson.M{"$subtract": bson.A{
+		bson.M{"$ifNull": bson.A{"$quantity", 0}},
+		bson.M{"$ifNull": bson.A{"$remaining", 0}},
+	}}
+
+	return mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"remaining": bson.M{"$max": bson.A{0, bson.M{"$subtract": bson.A{quantity, claimed}}}},
+		}}},
+	}
+}
+
+// GetDealForBusiness gets the deals of a business that are not archived, used when the business is looking at its own deals
 func GetDealForBusiness(businessId primitive.ObjectID, dealCollection model.Collection, ctx context.Context) ([]*model.Deal, error){
+	return findDeals(ctx, dealCollection, bson.M{"business_id": businessId, "status": bson.M{"$ne": model.DealArchived}})
+}
+
+// GetDealForBusinessByStatus gets the deals of a business that have one of the statuses
+func GetDealForBusinessByStatus(businessId primitive.ObjectID, statuses []string, dealCollection model.Collection, ctx context.Context) ([]*model.Deal, error){
+	filter := bson.A{}
+	for _, status := range statuses {
+		status = strings.TrimSpace(status)
+		if !model.IsDealStatus(status) {
+			return nil, fmt.Errorf("unknown deal status %q", status)
+		}
+		filter = append(filter, status)
+		if status == model.DealLive {
+			// deals from before there were statuses are live
+			filter = append(filter, nil)
+		}
+	}
 
-	// Create a query to find deals with the current business ID
-	dealQuery := bson.M{"business_id": businessId}
+	return findDeals(ctx, dealCollection, bson.M{"business_id": businessId, "status": bson.M{"$in": filter}})
+}
+
+// GetDiscoverableDealsForBusiness gets the deals of a business that customers should see
+// only live deals that are not sold out or held back by moderation are returned
+func GetDiscoverableDealsForBusiness(businessId primitive.ObjectID, dealCollection model.Collection, ctx context.Context) ([]*model.Deal, error){
+	return findDeals(ctx, dealCollection, bson.M{
+		"business_id": businessId,
+		"status": bson.M{"$in": bson.A{model.DealLive, nil}},
+		"remaining": bson.M{"$not": bson.M{"$lte": 0}},
+		"moderation_status": model.PubliclyVisible(),
+	})
+}
+
+func findDeals(ctx context.Context, dealCollection model.Collection, dealQuery bson.M, opts ...*options.FindOptions) ([]*model.Deal, error){
 
 	// Execute the query
-	dealCursor, err := dealCollection.Find(ctx, dealQuery)
+	dealCursor, err := dealCollection.Find(ctx, dealQuery, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to get deals: %v", err)
 	}
@@ -267,5 +727,9 @@ func GetDealForBusiness(businessId primitive.ObjectID, dealCollection model.Coll
 			return nil, fmt.Errorf("Failed to decode deals: %v", err)
 	}
 
+	for _, deal := range deals {
+		deal.ComputeFields()
+	}
+
 	return deals, nil
 }
\ No newline at end of file
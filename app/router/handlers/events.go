@@ -0,0 +1,16 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/CoffeeHausGames/whir-server/app/events"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// recordEvent writes a domain event to the outbox
+// call it with the ctx of the transaction that makes the change so both are saved or neither is
+func (env *HandlerEnv) recordEvent(ctx context.Context, eventType string, businessID primitive.ObjectID, aggregateID primitive.ObjectID, data interface{}) error {
+	_, err := events.Record(ctx, env.database.GetOutbox(), eventType, businessID, aggregateID, data)
+	return err
+}
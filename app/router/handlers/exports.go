@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/CoffeeHausGames/whir-server/app/export"
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	requests "github.com/CoffeeHausGames/whir-server/app/model/requests"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// exportsLimit is how many exports are listed
+const exportsLimit = 100
+
+// ExportBusinessData downloads the :dataset rows of the authenticated business: deals, businesses (its own
+// listing), redemptions, analytics_hourly or analytics_daily. ?format= is csv (default) or parquet,
+// ?from= and ?to= (RFC 3339 times) limit it to a time range. The file is streamed while it is written
+func (env *HandlerEnv) ExportBusinessData(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	businessID, err := claimsUserID(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Error parsing user ID")
+		return
+	}
+	from, to, err := timeRangeParams(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	query := export.Query{
+		Dataset:     ps.ByName("dataset"),
+		Format:      r.URL.Query().Get("format"),
+		Business_id: &businessID,
+		From:        from,
+		To:          to,
+	}
+	if query.Format == "" {
+		query.Format = export.FormatCSV
+	}
+	if err := query.Validate(); err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", export.ContentType(query.Format))
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+query.FileName(time.Now())+"\"")
+	w.Header().Set("Cache-Control", "private, no-store")
+	// the status was sent with the first row, a failure can only cut the file short
+	if _, err := env.exports.Write(ctx, w, query); err != nil {
+		log.Println("Export of " + query.Dataset + " for business " + businessID.Hex() + " failed: " + err.Error())
+	}
+}
+
+// CreateExport exports a dataset to storage in the background,
+// e.g. {"dataset": "redemptions", "format": "parquet", "business_id": "...", "from": "2024-05-01T00:00:00Z"}
+func (env *HandlerEnv) CreateExport(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	adminID, err := claimsUserID(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Error parsing user ID")
+		return
+	}
+	_, body, err := env.getClaimsAndBody(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	exportRequest := new(requests.Export)
+	if err := json.Unmarshal([]byte(body), exportRequest); err != nil {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, "There was an error with the client request")
+		return
+	}
+	if err := requests.ValidateExportStruct(exportRequest); err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	query := export.Query{
+		Dataset:     *exportRequest.Dataset,
+		Format:      export.FormatCSV,
+		Business_id: exportRequest.Business_id,
+		From:        exportRequest.From,
+		To:          exportRequest.To,
+	}
+	if exportRequest.Format != nil {
+		query.Format = *exportRequest.Format
+	}
+
+	exported, err := env.exports.Start(ctx, query, adminID)
+	if errors.Is(err, export.ErrInvalidQuery) {
+		WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to create export")
+		return
+	}
+	env.logAudit(r, model.ActorAdmin, &model.AuditEntry{
+		Action:      model.AuditDataExported,
+		Business_id: exported.Business_id,
+		Target_type: model.AuditTargetExport,
+		Target_id:   exported.ID,
+		Data:        bson.M{"dataset": exported.Dataset, "format": exported.Format},
+	})
+
+	WriteSuccessResponse(w, r, exported, nil, false)
+}
+
+// GetExports lists the newest exports
+func (env *HandlerEnv) GetExports(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	exports, err := env.exports.List(ctx, exportsLimit)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get exports")
+		return
+	}
+
+	WriteSuccessResponse(w, r, exports, nil, false)
+}
+
+// GetExport returns the export :id, the file can be downloaded once it is completed
+func (env *HandlerEnv) GetExport(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	exported, ok := env.findExport(ctx, w, ps)
+	if !ok {
+		return
+	}
+
+	WriteSuccessResponse(w, r, exported, nil, false)
+}
+
+// DownloadExport downloads the file of the completed export :id
+func (env *HandlerEnv) DownloadExport(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	exported, ok := env.findExport(r.Context(), w, ps)
+	if !ok {
+		return
+	}
+	file, err := env.exports.Open(r.Context(), exported)
+	if errors.Is(err, export.ErrNotReady) {
+		WriteErrorResponse(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to read export")
+		return
+	}
+	defer file.Close()
+
+	query := export.Query{Dataset: exported.Dataset, Format: exported.Format}
+	w.Header().Set("Content-Type", export.ContentType(exported.Format))
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+query.FileName(exported.Created_at)+"\"")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, no-store")
+	io.Copy(w, file)
+}
+
+// findExport finds the export :id, writing the error response when it can not
+func (env *HandlerEnv) findExport(ctx context.Context, w http.ResponseWriter, ps httprouter.Params) (*model.Export, bool) {
+	exportID, err := primitive.ObjectIDFromHex(ps.ByName("id"))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "Invalid export ID")
+		return nil, false
+	}
+	exported, err := env.exports.Get(ctx, exportID)
+	if errors.Is(err, export.ErrExportNotFound) {
+		WriteErrorResponse(w, http.StatusNotFound, err.Error())
+		return nil, false
+	}
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get export")
+		return nil, false
+	}
+	return exported, true
+}
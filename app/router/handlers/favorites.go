@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/CoffeeHausGames/whir-server/app/auth"
+	"github.com/CoffeeHausGames/whir-server/app/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// followingFeedLimit is the most deals sent in the following feed
+const followingFeedLimit = 100
+
+// GetFavorites returns the deals the authenticated user favorited, newest favorite first.
+// Deals the business deleted are left out
+func (env *HandlerEnv) GetFavorites(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	userID, err := claimsUserID(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Error parsing user ID")
+		return
+	}
+
+	var favorites []*model.Favorite
+	if err := findAll(ctx, env.database.GetFavorites(), bson.M{"user_id": userID}, &favorites); err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get favorites")
+		return
+	}
+
+	dealIDs := bson.A{}
+	for _, favorite := range favorites {
+		dealIDs = append(dealIDs, favorite.Deal_id)
+	}
+	deals, err := findDeals(ctx, env.database.GetDeals(), bson.M{"_id": bson.M{"$in": dealIDs}, "status": bson.M{"$ne": model.DealArchived}})
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	dealsByID := make(map[primitive.ObjectID]*model.Deal, len(deals))
+	for _, deal := range deals {
+		dealsByID[deal.ID] = deal
+	}
+
+	favoriteDeals := make([]*model.FavoriteDeal, 0, len(favorites))
+	for _, favorite := range favorites {
+		if deal, ok := dealsByID[favorite.Deal_id]; ok {
+			favoriteDeals = append(favoriteDeals, &model.FavoriteDeal{Favorited_at: favorite.Created_at, Deal: deal})
+		}
+	}
+
+	WriteSuccessResponse(w, r, favoriteDeals, nil, false)
+}
+
+// AddFavorite saves the deal :id for the authenticated user, favoriting a deal twice does nothing
+func (env *HandlerEnv) AddFavorite(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	userID, err := claimsUserID(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Error parsing user ID")
+		return
+	}
+	dealID, err := primitive.ObjectIDFromHex(ps.ByName("id"))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "Invalid deal ID")
+		return
+	}
+
+	deal := new(model.Deal)
+	err = env.database.GetDeals().FindOne(deal, ctx, bson.M{"_id": dealID, "status": bson.M{"$ne": model.DealArchived}})
+	if err != nil {
+		WriteErrorResponse(w, http.StatusNotFound, "Deal not found")
+		return
+	}
+
+	err = saveOnce(ctx, env.database.GetFavorites(),
+		bson.M{"user_id": userID, "deal_id": dealID},
+		bson.M{"business_id": deal.Business_id},
+	)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to save favorite")
+		return
+	}
+
+	WriteSuccessResponse(w, r, "Deal favorited", nil, false)
+}
+
+// RemoveFavorite removes the deal :id from the favorites of the authenticated user
+func (env *HandlerEnv) RemoveFavorite(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	userID, err := claimsUserID(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Error parsing user ID")
+		return
+	}
+	dealID, err := primitive.ObjectIDFromHex(ps.ByName("id"))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "Invalid deal ID")
+		return
+	}
+
+	if _, err := env.database.GetFavorites().DeleteOne(ctx, bson.M{"user_id": userID, "deal_id": dealID}); err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to remove favorite")
+		return
+	}
+
+	WriteSuccessResponse(w, r, "Favorite removed", nil, false)
+}
+
+// GetFollowing returns the businesses the authenticated user follows, newest follow first
+func (env *HandlerEnv) GetFollowing(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	userID, err := claimsUserID(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Error parsing user ID")
+		return
+	}
+
+	follows, err := env.findFollows(ctx, userID)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get followed businesses")
+		return
+	}
+
+	businessIDs := bson.A{}
+	for _, follow := range follows {
+		businessIDs = append(businessIDs, follow.Business_id)
+	}
+	var businesses []*model.BusinessUser
+	if err := findAll(ctx, env.database.GetBusinesses(), bson.M{"_id": bson.M{"$in": businessIDs}}, &businesses); err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get followed businesses")
+		return
+	}
+	businessesByID := make(map[primitive.ObjectID]*model.BusinessUser, len(businesses))
+	for _, business := range businesses {
+		businessesByID[business.ID] = business
+	}
+
+	followed := make([]*model.FollowedBusiness, 0, len(follows))
+	for _, follow := range follows {
+		if business, ok := businessesByID[follow.Business_id]; ok {
+			followed = append(followed, &model.FollowedBusiness{
+				Followed_at: follow.Created_at,
+				Business:    model.NewBusinessUser(business, nil),
+			})
+		}
+	}
+
+	WriteSuccessResponse(w, r, followed, nil, false)
+}
+
+// FollowBusiness makes the authenticated user follow the business :id, following twice does nothing
+func (env *HandlerEnv) FollowBusiness(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	userID, err := claimsUserID(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Error parsing user ID")
+		return
+	}
+	businessID, err := primitive.ObjectIDFromHex(ps.ByName("id"))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "Invalid business ID")
+		return
+	}
+
+	count, err := env.database.GetBusinesses().CountDocuments(ctx, bson.M{"_id": businessID})
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if count == 0 {
+		WriteErrorResponse(w, http.StatusNotFound, "Business not found")
+		return
+	}
+
+	if err := saveOnce(ctx, env.database.GetFollows(), bson.M{"user_id": userID, "business_id": businessID}, bson.M{}); err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to follow business")
+		return
+	}
+
+	WriteSuccessResponse(w, r, "Business followed", nil, false)
+}
+
+// UnfollowBusiness makes the authenticated user stop following the business :id
+func (env *HandlerEnv) UnfollowBusiness(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	userID, err := claimsUserID(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Error parsing user ID")
+		return
+	}
+	businessID, err := primitive.ObjectIDFromHex(ps.ByName("id"))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "Invalid business ID")
+		return
+	}
+
+	if _, err := env.database.GetFollows().DeleteOne(ctx, bson.M{"user_id": userID, "business_id": businessID}); err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to unfollow business")
+		return
+	}
+
+	WriteSuccessResponse(w, r, "Business unfollowed", nil, false)
+}
+
+// GetFollowingFeed returns the live deals of the businesses the authenticated user follows,
+// ordered by when they start
+func (env *HandlerEnv) GetFollowingFeed(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	userID, err := claimsUserID(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Error parsing user ID")
+		return
+	}
+
+	follows, err := env.findFollows(ctx, userID)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get followed businesses")
+		return
+	}
+	if len(follows) == 0 {
+		WriteSuccessResponse(w, r, []*model.Deal{}, nil, false)
+		return
+	}
+
+	businessIDs := bson.A{}
+	for _, follow := range follows {
+		businessIDs = append(businessIDs, follow.Business_id)
+	}
+
+	// same deals customers see on the business profile, see GetDiscoverableDealsForBusiness
+	deals, err := findDeals(ctx, env.database.GetDeals(), bson.M{
+		"business_id":       bson.M{"$in": businessIDs},
+		"status":            bson.M{"$in": bson.A{model.DealLive, nil}},
+		"remaining":         bson.M{"$not": bson.M{"$lte": 0}},
+		"moderation_status": model.PubliclyVisible(),
+	}, options.Find().
+		SetSort(bson.D{{Key: "start_date", Value: 1}, {Key: "start_time", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(followingFeedLimit))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if deals == nil {
+		deals = []*model.Deal{}
+	}
+
+	WriteSuccessResponse(w, r, deals, nil, false)
+}
+
+// businessAudience counts the followers of the business and the favorites of its deals
+func (env *HandlerEnv) businessAudience(ctx context.Context, businessID primitive.ObjectID) (int64, int64, error) {
+	followers, err := env.database.GetFollows().CountDocuments(ctx, bson.M{"business_id": businessID})
+	if err != nil {
+		return 0, 0, err
+	}
+	favorites, err := env.database.GetFavorites().CountDocuments(ctx, bson.M{"business_id": businessID})
+	if err != nil {
+		return 0, 0, err
+	}
+	return followers, favorites, nil
+}
+
+func (env *HandlerEnv) findFollows(ctx context.Context, userID primitive.ObjectID) ([]*model.Follow, error) {
+	var follows []*model.Follow
+	err := findAll(ctx, env.database.GetFollows(), bson.M{"user_id": userID}, &follows)
+	return follows, err
+}
+
+// saveOnce inserts the document identified by key unless it already exists, fields are only set on insert
+func saveOnce(ctx context.Context, collection model.Collection, key bson.M, fields bson.M) error {
+	insert := bson.M{"_id": primitive.NewObjectID(), "created_at": time.Now().UTC()}
+	for field, value := range fields {
+		insert[field] = value
+	}
+
+	_, err := collection.UpdateOne(ctx, key, bson.M{"$setOnInsert": insert}, options.Update().SetUpsert(true))
+	// two requests at once can both try to insert, the unique index lets one win
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+// findAll decodes every document matching filter into results, newest first
+func findAll(ctx context.Context, collection model.Collection, filter bson.M, results interface{}) error {
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return err
+	}
+	if err := cursor.All(ctx, results); err != nil {
+		return fmt.Errorf("failed to decode: %v", err)
+	}
+	return nil
+}
+
+// claimsUserID is the ID of the account the request was authenticated as
+func claimsUserID(r *http.Request) (primitive.ObjectID, error) {
+	claims, ok := r.Context().Value("claims").(*auth.SignedDetails)
+	if !ok {
+		return primitive.NilObjectID, errors.New("request is not authenticated")
+	}
+	return primitive.ObjectIDFromHex(claims.Uid)
+}
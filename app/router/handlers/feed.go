@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	requests "github.com/CoffeeHausGames/whir-server/app/model/requests"
+	"github.com/CoffeeHausGames/whir-server/app/realtime"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// feedHeartbeat is how often an idle stream sends a comment so proxies and load balancers keep it open
+	feedHeartbeat = 25 * time.Second
+	// feedMaxRadius keeps one client from asking for the whole country, in miles
+	feedMaxRadius = 100.0
+)
+
+// DealFeed streams deals created, updated and ended near a location as Server-Sent Events
+// e.g. GET /v1/deals/feed?latitude=..&longitude=..&radius=20
+//
+// A client that reconnects sends the Last-Event-ID header (or last_event_id, EventSource can not
+// set headers on the first connection) and gets the events it missed
+func (env *HandlerEnv) DealFeed(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Streaming is not supported")
+		return
+	}
+
+	area, err := feedAreaFromQuery(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+
+	// subscribe before replaying so nothing that happens in between is missed
+	subscriber := env.feed.Subscribe(area)
+	defer env.feed.Unsubscribe(subscriber)
+
+	var replay []*realtime.FeedEvent
+	if lastEventID != "" {
+		lastID, err := primitive.ObjectIDFromHex(lastEventID)
+		if err != nil {
+			WriteErrorResponse(w, http.StatusBadRequest, "Invalid Last-Event-ID")
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+		replay, err = env.feed.Replay(ctx, area, lastID)
+		cancel()
+		if err != nil {
+			WriteErrorResponse(w, http.StatusBadGateway, "Failed to get missed events")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // stop nginx from buffering the stream
+	w.WriteHeader(http.StatusOK)
+
+	// tell EventSource how long to wait before reconnecting
+	fmt.Fprint(w, "retry: 5000\n\n")
+
+	replayed := make(map[primitive.ObjectID]bool, len(replay))
+	for _, event := range replay {
+		if writeFeedEvent(w, event) != nil {
+			return
+		}
+		replayed[event.ID] = true
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(feedHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-subscriber.Events():
+			if !ok {
+				// the client fell behind, it reconnects with its Last-Event-ID and catches up
+				return
+			}
+			if replayed[event.ID] {
+				continue
+			}
+			if writeFeedEvent(w, event) != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeFeedEvent(w http.ResponseWriter, event *realtime.FeedEvent) error {
+	_, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID.Hex(), event.Type, event.Data)
+	return err
+}
+
+// feedAreaFromQuery reads the location and radius of the feed, validated like the nearby business search
+func feedAreaFromQuery(r *http.Request) (realtime.Area, error) {
+	query := r.URL.Query()
+	parse := func(name string) (*float64, error) {
+		value := query.Get(name)
+		if value == "" {
+			return nil, nil
+		}
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a number", name)
+		}
+		return &number, nil
+	}
+
+	var locationData requests.Location
+	var err error
+	if locationData.Latitude, err = parse("latitude"); err != nil {
+		return realtime.Area{}, err
+	}
+	if locationData.Longitude, err = parse("longitude"); err != nil {
+		return realtime.Area{}, err
+	}
+	if locationData.Radius, err = parse("radius"); err != nil {
+		return realtime.Area{}, err
+	}
+	if err := requests.ValidateLocationStruct(&locationData); err != nil {
+		return realtime.Area{}, err
+	}
+
+	area := realtime.Area{Latitude: *locationData.Latitude, Longitude: *locationData.Longitude, Radius: 20}
+	if locationData.Radius != nil {
+		if *locationData.Radius <= 0 || *locationData.Radius > feedMaxRadius {
+			return realtime.Area{}, fmt.Errorf("radius must be greater than 0 and at most %v miles", feedMaxRadius)
+		}
+		area.Radius = *locationData.Radius
+	}
+	return area, nil
+}
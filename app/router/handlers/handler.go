@@ -6,19 +6,74 @@ import (
 	"net/http"
 	"errors"
 
+	"github.com/CoffeeHausGames/whir-server/app/analytics"
+	"github.com/CoffeeHausGames/whir-server/app/billing"
+	"github.com/CoffeeHausGames/whir-server/app/export"
 	"github.com/CoffeeHausGames/whir-server/app/server/database"
 	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/app/moderation"
+	"github.com/CoffeeHausGames/whir-server/app/notifications"
+	"github.com/CoffeeHausGames/whir-server/app/plans"
+	"github.com/CoffeeHausGames/whir-server/app/promotions"
+	"github.com/CoffeeHausGames/whir-server/app/queue"
+	"github.com/CoffeeHausGames/whir-server/app/realtime"
+	"github.com/CoffeeHausGames/whir-server/app/storage"
+	"github.com/CoffeeHausGames/whir-server/app/verification"
 )
 
+// AllowedOrigins are the web apps allowed to call the API from a browser
+var AllowedOrigins = []string{"http://localhost:3000", "http://localhost:8081", "http://192.168.1.29:4444", "http://10.8.1.245:4444"}
+
 // HandlerEnv is a wrapper for the genral request handling and contains a database instance
+// and the services the handlers use
 type HandlerEnv struct {
-	database *database.Database
+	database      *database.Database
+	storage       storage.Storage
+	queue         *queue.Queue
+	feed          *realtime.DealFeed
+	dashboard     *realtime.BusinessHub
+	notifications *notifications.Service
+	moderation    *moderation.Service
+	verification  *verification.Service
+	plans         *plans.Service
+	billing       *billing.Service
+	promotions    *promotions.Service
+	analytics     *analytics.Service
+	exports       *export.Service
+}
+
+// Services are the parts of the server besides the database that handlers need
+type Services struct {
+	Storage       storage.Storage        // blob storage used for uploads
+	Queue         *queue.Queue           // task queue for slow side effects
+	Feed          *realtime.DealFeed     // clients of the real-time deal feed on this instance
+	Dashboard     *realtime.BusinessHub  // business dashboards connected to this instance
+	Notifications *notifications.Service // push notifications
+	Moderation    *moderation.Service    // content pre-screen and moderation queue
+	Verification  *verification.Service  // business verification workflow
+	Plans         *plans.Service         // plan catalog and plan limits
+	Billing       *billing.Service       // subscriptions to paid plans
+	Promotions    *promotions.Service    // sponsored deals in nearby searches
+	Analytics     *analytics.Service     // deal impressions, views and claims
+	Exports       *export.Service        // CSV and Parquet exports of datasets
 }
 
-// NewHandlerEnv returns a new [HandlerEnv] with the specified database
-func NewHandlerEnv(db *database.Database) *HandlerEnv {
+// NewHandlerEnv returns a new [HandlerEnv] with the specified database and services
+func NewHandlerEnv(db *database.Database, services Services) *HandlerEnv {
 	return &HandlerEnv{
-		database: db,
+		database:      db,
+		storage:       services.Storage,
+		queue:         services.Queue,
+		feed:          services.Feed,
+		dashboard:     services.Dashboard,
+		notifications: services.Notifications,
+		moderation:    services.Moderation,
+		verification:  services.Verification,
+		plans:         services.Plans,
+		billing:       services.Billing,
+		promotions:    services.Promotions,
+		analytics:     services.Analytics,
+		exports:       services.Exports,
 	}
 }
 
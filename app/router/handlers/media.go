@@ -0,0 +1,322 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/CoffeeHausGames/whir-server/app/auth"
+	"github.com/CoffeeHausGames/whir-server/app/export"
+	"github.com/CoffeeHausGames/whir-server/app/helpers"
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/app/storage"
+	"github.com/CoffeeHausGames/whir-server/app/verification"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Size limits for each kind of upload
+const (
+	maxLogoSize      = 2 << 20 // 2 MB
+	maxCoverSize     = 8 << 20 // 8 MB
+	maxDealImageSize = 8 << 20 // 8 MB
+)
+
+// UploadBusinessLogo replaces the logo of the authenticated business
+// expects a multipart form with the image in the "image" field
+func (env *HandlerEnv) UploadBusinessLogo(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	env.uploadBusinessImage(w, r, "logo", maxLogoSize)
+}
+
+// UploadBusinessCoverPhoto replaces the cover photo of the authenticated business
+// expects a multipart form with the image in the "image" field
+func (env *HandlerEnv) UploadBusinessCoverPhoto(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	env.uploadBusinessImage(w, r, "cover_photo", maxCoverSize)
+}
+
+// DeleteBusinessLogo removes the logo of the authenticated business
+func (env *HandlerEnv) DeleteBusinessLogo(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	env.deleteBusinessImage(w, r, "logo")
+}
+
+// DeleteBusinessCoverPhoto removes the cover photo of the authenticated business
+func (env *HandlerEnv) DeleteBusinessCoverPhoto(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	env.deleteBusinessImage(w, r, "cover_photo")
+}
+
+func (env *HandlerEnv) uploadBusinessImage(w http.ResponseWriter, r *http.Request, field string, maxSize int64) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	claims := r.Context().Value("claims").(*auth.SignedDetails)
+	businessID, err := primitive.ObjectIDFromHex(claims.Uid)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Error parsing user ID")
+		return
+	}
+
+	var businessCollection model.Collection = env.database.GetBusinesses()
+	business := new(model.BusinessUser)
+	err = businessCollection.FindOne(business, ctx, bson.M{"_id": businessID})
+	if err != nil {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	image, status, err := env.storePlanImage(ctx, w, r, business, "businesses/"+businessID.Hex()+"/"+field, maxSize)
+	if err != nil {
+		WriteErrorResponse(w, status, err.Error())
+		return
+	}
+
+	_, err = businessCollection.UpdateOne(ctx, bson.M{"_id": businessID}, bson.M{"$set": bson.M{field: image}})
+	if err != nil {
+		env.removeImage(ctx, image)
+		env.releaseImageUpload(ctx, businessID)
+		WriteErrorResponse(w, http.StatusBadGateway, "There was an error saving the image")
+		return
+	}
+
+	if field == "logo" {
+		env.removeImage(ctx, business.Logo)
+	} else {
+		env.removeImage(ctx, business.Cover_photo)
+	}
+
+	WriteSuccessResponse(w, r, image, nil, false)
+}
+
+func (env *HandlerEnv) deleteBusinessImage(w http.ResponseWriter, r *http.Request, field string) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	claims := r.Context().Value("claims").(*auth.SignedDetails)
+	businessID, err := primitive.ObjectIDFromHex(claims.Uid)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Error parsing user ID")
+		return
+	}
+
+	var businessCollection model.Collection = env.database.GetBusinesses()
+	business := new(model.BusinessUser)
+	err = businessCollection.FindOne(business, ctx, bson.M{"_id": businessID})
+	if err != nil {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	_, err = businessCollection.UpdateOne(ctx, bson.M{"_id": businessID}, bson.M{"$unset": bson.M{field: ""}})
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadGateway, "There was an error removing the image")
+		return
+	}
+
+	if field == "logo" {
+		env.removeImage(ctx, business.Logo)
+	} else {
+		env.removeImage(ctx, business.Cover_photo)
+	}
+
+	WriteSuccessResponse(w, r, "Image removed successfully", nil, false)
+}
+
+// UploadDealImage replaces the image of one of the authenticated business' deals
+// expects a multipart form with the image in the "image" field
+func (env *HandlerEnv) UploadDealImage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	deal, status, err := env.findOwnedDeal(ctx, r, ps.ByName("id"))
+	if err != nil {
+		WriteErrorResponse(w, status, err.Error())
+		return
+	}
+
+	business, err := env.findClaimsBusiness(ctx, r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, "Business not found")
+		return
+	}
+
+	image, status, err := env.storePlanImage(ctx, w, r, business, "deals/"+deal.ID.Hex()+"/image", maxDealImageSize)
+	if err != nil {
+		WriteErrorResponse(w, status, err.Error())
+		return
+	}
+
+	dealCollection := env.database.GetDeals()
+	_, err = dealCollection.UpdateOne(ctx, bson.M{"_id": deal.ID}, bson.M{"$set": bson.M{"image": image}})
+	if err != nil {
+		env.removeImage(ctx, image)
+		env.releaseImageUpload(ctx, business.ID)
+		WriteErrorResponse(w, http.StatusBadGateway, "There was an error saving the image")
+		return
+	}
+	env.removeImage(ctx, deal.Image)
+
+	WriteSuccessResponse(w, r, image, nil, false)
+}
+
+// DeleteDealImage removes the image of one of the authenticated business' deals
+func (env *HandlerEnv) DeleteDealImage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	deal, status, err := env.findOwnedDeal(ctx, r, ps.ByName("id"))
+	if err != nil {
+		WriteErrorResponse(w, status, err.Error())
+		return
+	}
+
+	dealCollection := env.database.GetDeals()
+	_, err = dealCollection.UpdateOne(ctx, bson.M{"_id": deal.ID}, bson.M{"$unset": bson.M{"image": ""}})
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadGateway, "There was an error removing the image")
+		return
+	}
+	env.removeImage(ctx, deal.Image)
+
+	WriteSuccessResponse(w, r, "Image removed successfully", nil, false)
+}
+
+// ServeMedia serves files kept in storage, used when the files are stored locally
+func (env *HandlerEnv) ServeMedia(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	key := ps.ByName("filepath")
+	// verification documents and exports are only for admins, see GetVerificationDocument and DownloadExport.
+	// They are kept in private storage, these are the ones stored here before it existed. The key is cleaned
+	// the way storage reads it so //private/.. or a/../private/.. can not get around the check
+	cleaned := strings.TrimPrefix(path.Clean("/"+key), "/")
+	if strings.HasPrefix(cleaned, verification.DocumentKeyPrefix) || strings.HasPrefix(cleaned, export.KeyPrefix) {
+		WriteErrorResponse(w, http.StatusNotFound, "File not found")
+		return
+	}
+
+	file, err := env.storage.Get(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		WriteErrorResponse(w, http.StatusNotFound, "File not found")
+		return
+	}
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "Error reading file")
+		return
+	}
+	defer file.Close()
+
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// every upload gets a new key so files never change
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	io.Copy(w, file)
+}
+
+// findOwnedDeal finds the deal with the hex id and makes sure it belongs to the authenticated business
+// returns the status code to send when it fails
+func (env *HandlerEnv) findOwnedDeal(ctx context.Context, r *http.Request, id string) (*model.Deal, int, error) {
+	claims := r.Context().Value("claims").(*auth.SignedDetails)
+	businessID, err := primitive.ObjectIDFromHex(claims.Uid)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("Error parsing user ID")
+	}
+
+	dealID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.New("Invalid deal ID")
+	}
+
+	deal := new(model.Deal)
+	err = env.database.GetDeals().FindOne(deal, ctx, bson.M{"_id": dealID, "business_id": businessID})
+	if err != nil {
+		return nil, http.StatusNotFound, errors.New("Deal not found")
+	}
+
+	return deal, http.StatusOK, nil
+}
+
+// storeUploadedImage reads the "image" field of a multipart upload, cleans the image and stores it with a thumbnail
+// keyPrefix is where in storage the image is kept, a unique id is added so every upload has its own URL
+func (env *HandlerEnv) storeUploadedImage(ctx context.Context, w http.ResponseWriter, r *http.Request, keyPrefix string, maxSize int64) (*model.Image, int, error) {
+	// leave some room for the rest of the multipart form
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+(64<<10))
+	if err := r.ParseMultipartForm(maxSize); err != nil {
+		return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("Image must be a multipart upload smaller than %d MB", maxSize>>20)
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("image")
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.New("Missing image field")
+	}
+	defer file.Close()
+
+	if header.Size > maxSize {
+		return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("Image must be smaller than %d MB", maxSize>>20)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(file, maxSize+1))
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.New("Error reading image")
+	}
+
+	processed, err := helpers.ProcessImage(data)
+	if err != nil {
+		log.Println(err)
+		return nil, http.StatusUnsupportedMediaType, err
+	}
+
+	uploadID := primitive.NewObjectID().Hex()
+	image := &model.Image{
+		Key:           keyPrefix + "-" + uploadID + "." + processed.Extension,
+		Thumbnail_key: keyPrefix + "-" + uploadID + "-thumb." + processed.Extension,
+		Content_type:  processed.ContentType,
+		Width:         processed.Width,
+		Height:        processed.Height,
+		Size:          int64(len(processed.Data)),
+		Uploaded_at:   time.Now().UTC(),
+	}
+
+	err = env.storage.Put(ctx, image.Key, bytes.NewReader(processed.Data), int64(len(processed.Data)), processed.ContentType)
+	if err != nil {
+		log.Println(err)
+		return nil, http.StatusBadGateway, errors.New("There was an error storing the image")
+	}
+	err = env.storage.Put(ctx, image.Thumbnail_key, bytes.NewReader(processed.Thumbnail), int64(len(processed.Thumbnail)), processed.ContentType)
+	if err != nil {
+		log.Println(err)
+		env.storage.Delete(ctx, image.Key)
+		return nil, http.StatusBadGateway, errors.New("There was an error storing the image")
+	}
+
+	image.Url = env.storage.URL(image.Key)
+	image.Thumbnail_url = env.storage.URL(image.Thumbnail_key)
+
+	return image, http.StatusOK, nil
+}
+
+// removeImage deletes an image that is no longer used from storage
+// failures are only logged since the image is already unreachable
+func (env *HandlerEnv) removeImage(ctx context.Context, image *model.Image) {
+	if image == nil {
+		return
+	}
+	if err := env.storage.Delete(ctx, image.Key); err != nil {
+		log.Println(err)
+	}
+	if err := env.storage.Delete(ctx, image.Thumbnail_key); err != nil {
+		log.Println(err)
+	}
+}
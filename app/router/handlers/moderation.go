@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	requests "github.com/CoffeeHausGames/whir-server/app/model/requests"
+	"github.com/CoffeeHausGames/whir-server/app/moderation"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// moderationCasesLimit is the most cases sent at once
+const moderationCasesLimit = 100
+
+// ReportContent reports a deal, business or review to the moderators as the authenticated user
+// e.g. {"target_type": "review", "target_id": "...", "reason": "spam", "details": "..."}
+func (env *HandlerEnv) ReportContent(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, body, err := env.getClaimsAndBody(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	reportRequest := new(requests.Report)
+	if err := json.Unmarshal([]byte(body), reportRequest); err != nil {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, "There was an error with the client request")
+		return
+	}
+	if err := requests.ValidateReportStruct(reportRequest); err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userID, err := claimsUserID(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Error parsing user ID")
+		return
+	}
+	targetID, _ := primitive.ObjectIDFromHex(*reportRequest.Target_id)
+	var details string
+	if reportRequest.Details != nil {
+		details = strings.TrimSpace(*reportRequest.Details)
+	}
+
+	report, err := env.moderation.Report(ctx, userID, *reportRequest.Target_type, targetID, *reportRequest.Reason, details)
+	if errors.Is(err, moderation.ErrTargetNotFound) {
+		WriteErrorResponse(w, http.StatusNotFound, "The reported "+*reportRequest.Target_type+" was not found")
+		return
+	}
+	if errors.Is(err, moderation.ErrAlreadyReported) {
+		WriteErrorResponse(w, http.StatusConflict, "You already reported this "+*reportRequest.Target_type)
+		return
+	}
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to save report")
+		return
+	}
+
+	WriteSuccessResponse(w, r, report, nil, false)
+}
+
+// GetModerationCases returns the moderation queue, oldest first so nothing waits forever.
+// ?status= filters by case status (open by default) and ?target_type= by deal, business or review
+func (env *HandlerEnv) GetModerationCases(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	filter := bson.M{"status": model.CaseOpen}
+	if status := r.URL.Query().Get("status"); status != "" {
+		filter["status"] = status
+	}
+	if targetType := r.URL.Query().Get("target_type"); targetType != "" {
+		filter["target_type"] = targetType
+	}
+
+	cursor, err := env.database.GetModerationCases().Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}).SetLimit(moderationCasesLimit))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get moderation cases")
+		return
+	}
+	cases := []*model.ModerationCase{}
+	if err := cursor.All(ctx, &cases); err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get moderation cases")
+		return
+	}
+
+	WriteSuccessResponse(w, r, cases, nil, false)
+}
+
+// GetModerationCase returns the moderation case :id with the reports of users
+func (env *HandlerEnv) GetModerationCase(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	caseID, err := primitive.ObjectIDFromHex(ps.ByName("id"))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "Invalid case ID")
+		return
+	}
+
+	moderationCase := new(model.ModerationCase)
+	err = env.database.GetModerationCases().FindOne(moderationCase, ctx, bson.M{"_id": caseID})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		WriteErrorResponse(w, http.StatusNotFound, "Moderation case not found")
+		return
+	}
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get moderation case")
+		return
+	}
+
+	reports := []*model.Report{}
+	if err := findAll(ctx, env.database.GetReports(), bson.M{"case_id": caseID}, &reports); err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get reports")
+		return
+	}
+
+	WriteSuccessResponse(w, r, map[string]interface{}{"case": moderationCase, "reports": reports}, nil, false)
+}
+
+// ApproveModerationCase makes the content of case :id public and closes the case, e.g. {"note": "..."}
+func (env *HandlerEnv) ApproveModerationCase(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	env.resolveModerationCase(w, r, ps, moderation.ActionApprove)
+}
+
+// RejectModerationCase takes the content of case :id down and closes the case, e.g. {"note": "..."}
+func (env *HandlerEnv) RejectModerationCase(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	env.resolveModerationCase(w, r, ps, moderation.ActionReject)
+}
+
+// HideModerationCase takes the content of case :id down and keeps the case open, e.g. {"note": "..."}
+func (env *HandlerEnv) HideModerationCase(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	env.resolveModerationCase(w, r, ps, moderation.ActionHide)
+}
+
+// resolveModerationCase applies the decision of the authenticated moderator to the case :id
+func (env *HandlerEnv) resolveModerationCase(w http.ResponseWriter, r *http.Request, ps httprouter.Params, action string) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, body, err := env.getClaimsAndBody(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	decision := new(requests.ModerationDecision)
+	if strings.TrimSpace(body) != "" {
+		if err := json.Unmarshal([]byte(body), decision); err != nil {
+			WriteErrorResponse(w, http.StatusUnprocessableEntity, "There was an error with the client request")
+			return
+		}
+	}
+	if err := requests.ValidateModerationDecisionStruct(decision); err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	caseID, err := primitive.ObjectIDFromHex(ps.ByName("id"))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "Invalid case ID")
+		return
+	}
+	moderatorID, err := claimsUserID(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Error parsing user ID")
+		return
+	}
+	var note string
+	if decision.Note != nil {
+		note = strings.TrimSpace(*decision.Note)
+	}
+
+	moderationCase, err := env.moderation.Resolve(ctx, caseID, action, moderatorID, note)
+	if errors.Is(err, moderation.ErrCaseNotFound) {
+		WriteErrorResponse(w, http.StatusNotFound, "Moderation case not found")
+		return
+	}
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to update moderation case")
+		return
+	}
+
+	WriteSuccessResponse(w, r, moderationCase, nil, false)
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	requests "github.com/CoffeeHausGames/whir-server/app/model/requests"
+	"github.com/CoffeeHausGames/whir-server/app/notifications"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RegisterDevice registers a device token of the authenticated user for push notifications.
+// Apps should call it on every launch, a token registered by another user moves to this one
+func (env *HandlerEnv) RegisterDevice(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	userID, deviceRequest, ok := env.getDeviceRequest(w, r)
+	if !ok {
+		return
+	}
+
+	now := time.Now().UTC()
+	device := new(model.Device)
+	err := env.database.GetDevices().FindOneAndUpdate(device, ctx,
+		bson.M{"token": *deviceRequest.Token},
+		bson.M{
+			"$set":         bson.M{"user_id": userID, "platform": *deviceRequest.Platform, "updated_at": now},
+			"$setOnInsert": bson.M{"_id": primitive.NewObjectID(), "created_at": now},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		// registered by a request at the same time, the token exists now
+		err = env.database.GetDevices().FindOne(device, ctx, bson.M{"token": *deviceRequest.Token})
+	}
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to register device")
+		return
+	}
+
+	WriteSuccessResponse(w, r, device, nil, false)
+}
+
+// UnregisterDevice stops push notifications to a device of the authenticated user, e.g. when logging out
+func (env *HandlerEnv) UnregisterDevice(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	userID, deviceRequest, ok := env.getDeviceRequest(w, r)
+	if !ok {
+		return
+	}
+
+	_, err := env.database.GetDevices().DeleteOne(ctx, bson.M{"token": *deviceRequest.Token, "user_id": userID})
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to unregister device")
+		return
+	}
+
+	WriteSuccessResponse(w, r, "Device unregistered", nil, false)
+}
+
+// GetNotificationPreferences returns the push notification preferences of the authenticated user
+func (env *HandlerEnv) GetNotificationPreferences(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	user, err := env.findClaimsUser(ctx, r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	WriteSuccessResponse(w, r, user.GetNotificationPreferences(), nil, false)
+}
+
+// UpdateNotificationPreferences changes the push notification preferences of the authenticated user
+// e.g. {"new_deals": false, "quiet_hours": {"start": "22:00", "end": "07:00", "time_zone": "America/Chicago"}}
+func (env *HandlerEnv) UpdateNotificationPreferences(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, body, err := env.getClaimsAndBody(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	preferencesRequest := new(requests.NotificationPreferences)
+	if err := json.Unmarshal([]byte(body), preferencesRequest); err != nil {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, "There was an error with the client request")
+		return
+	}
+
+	user, err := env.findClaimsUser(ctx, r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	preferences := user.GetNotificationPreferences()
+	if err := preferencesRequest.Apply(preferences); err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	_, err = env.database.GetUsers().UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"notification_preferences": preferences, "updated_at": time.Now().UTC()}},
+	)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to save notification preferences")
+		return
+	}
+
+	WriteSuccessResponse(w, r, preferences, nil, false)
+}
+
+// ReportLocation looks for live deals near the authenticated user and sends a nearby alert for each.
+// Only users who turned nearby_alerts on can report, the location is not saved
+func (env *HandlerEnv) ReportLocation(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	claims, body, err := env.getClaimsAndBody(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	userID, err := primitive.ObjectIDFromHex(claims.Uid)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Error parsing user ID")
+		return
+	}
+
+	report := new(requests.LocationReport)
+	if err := json.Unmarshal([]byte(body), report); err != nil {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, "There was an error with the client request")
+		return
+	}
+	if err := requests.ValidateLocationReportStruct(report); err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	_, err = env.notifications.CheckNearby(ctx, userID, *report.Latitude, *report.Longitude)
+	if errors.Is(err, notifications.ErrNearbyAlertsOff) {
+		WriteErrorResponse(w, http.StatusForbidden, "Nearby alerts are turned off")
+		return
+	}
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to check for nearby deals")
+		return
+	}
+
+	// alerts arrive as push notifications, the response does not say where deals are
+	WriteSuccessResponse(w, r, "Location received", nil, false)
+}
+
+// getDeviceRequest reads the user and the device from the request, writes the error response when it fails
+func (env *HandlerEnv) getDeviceRequest(w http.ResponseWriter, r *http.Request) (primitive.ObjectID, *requests.Device, bool) {
+	claims, body, err := env.getClaimsAndBody(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return primitive.NilObjectID, nil, false
+	}
+	userID, err := primitive.ObjectIDFromHex(claims.Uid)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Error parsing user ID")
+		return primitive.NilObjectID, nil, false
+	}
+
+	deviceRequest := new(requests.Device)
+	if err := json.Unmarshal([]byte(body), deviceRequest); err != nil {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, "There was an error with the client request")
+		return primitive.NilObjectID, nil, false
+	}
+	if err := requests.ValidateDeviceStruct(deviceRequest); err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return primitive.NilObjectID, nil, false
+	}
+
+	return userID, deviceRequest, true
+}
+
+// findClaimsUser reads the user the request was authenticated as
+func (env *HandlerEnv) findClaimsUser(ctx context.Context, r *http.Request) (*model.User, error) {
+	userID, err := claimsUserID(r)
+	if err != nil {
+		return nil, err
+	}
+	user := new(model.User)
+	if err := env.database.GetUsers().FindOne(user, ctx, bson.M{"_id": userID}); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -5,6 +5,7 @@ import (
 	// "golang.org/x/oauth2/google"
 	// "io/ioutil"
 	"context"
+	"errors"
 	"google.golang.org/api/idtoken"
 	"net/http"
 	"os"
@@ -93,6 +94,10 @@ func (env *HandlerEnv) GoogleAuthentication(w http.ResponseWriter, r *http.Reque
 	
 			fmt.Println("User is already signed up")
 			foundUserInterface, err := performGoogleLogin(r.Context(), email, userCollection, foundUser)
+			if errors.Is(err, auth.ErrAccountSuspended) {
+					WriteErrorResponse(w, http.StatusForbidden, "This account is suspended")
+					return
+			}
 			if err != nil {
 					fmt.Println("performLogin failed")
 					WriteErrorResponse(w, 401, "There was an error logging in")
@@ -106,6 +111,10 @@ func (env *HandlerEnv) GoogleAuthentication(w http.ResponseWriter, r *http.Reque
 					return
 			}
 	
+			env.logAudit(r, model.ActorUser, &model.AuditEntry{
+					Action: model.AuditUserLoggedIn, Actor_id: foundUser.ID, Target_type: model.AuditTargetUser, Target_id: foundUser.ID, Data: bson.M{"method": "google"},
+			})
+
 			// Retrieve the tokens from the found user
 			token = foundUser.Token
 			refreshToken = foundUser.Refresh_token
@@ -129,6 +138,9 @@ func (env *HandlerEnv) GoogleAuthentication(w http.ResponseWriter, r *http.Reque
 					http.Error(w, "Failed to create user", http.StatusInternalServerError)
 					return
 			}
+			env.logAudit(r, model.ActorUser, &model.AuditEntry{
+					Action: model.AuditUserSignedUp, Actor_id: user.ID, Target_type: model.AuditTargetUser, Target_id: user.ID, Data: bson.M{"method": "google"},
+			})
 	}
 
 	// Create cookies for the access token, refresh token, and user ID
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	requests "github.com/CoffeeHausGames/whir-server/app/model/requests"
+	"github.com/CoffeeHausGames/whir-server/app/plans"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// GetPlans lists the plans businesses can be on, from the cheapest to the most expensive
+func (env *HandlerEnv) GetPlans(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	WriteSuccessResponse(w, r, env.plans.Catalog(), nil, false)
+}
+
+// GetBusinessPlan returns the plan of the authenticated business with how much of each limit it uses
+func (env *HandlerEnv) GetBusinessPlan(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	business, err := env.findClaimsBusiness(ctx, r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, "Business not found")
+		return
+	}
+	usage, err := env.plans.Usage(ctx, business)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get plan usage")
+		return
+	}
+
+	WriteSuccessResponse(w, r, map[string]interface{}{
+		"plan":  env.plans.PlanOf(business),
+		"usage": usage,
+	}, nil, false)
+}
+
+// SetBusinessPlan moves the business :id to another plan of the catalog, e.g. {"plan": "pro"}.
+// A business over the limits of its new plan keeps what it has but can not add more
+func (env *HandlerEnv) SetBusinessPlan(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, body, err := env.getClaimsAndBody(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	change := new(requests.PlanChange)
+	if err := json.Unmarshal([]byte(body), change); err != nil {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, "There was an error with the client request")
+		return
+	}
+	if err := requests.ValidatePlanChangeStruct(change); err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	plan, ok := env.plans.Catalog().Get(strings.TrimSpace(*change.Plan))
+	if !ok {
+		WriteErrorResponse(w, http.StatusBadRequest, "Unknown plan")
+		return
+	}
+
+	businessID, err := primitive.ObjectIDFromHex(ps.ByName("id"))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "Invalid business ID")
+		return
+	}
+
+	businessCollection := env.database.GetBusinesses()
+	err = env.database.WithTransaction(ctx, func(ctx context.Context) error {
+		business := new(model.BusinessUser)
+		if err := businessCollection.FindOne(business, ctx, bson.M{"_id": businessID}); err != nil {
+			return err
+		}
+		previous := env.plans.PlanOf(business)
+		if _, err := businessCollection.UpdateOne(ctx, bson.M{"_id": businessID}, bson.M{"$set": bson.M{"plan": plan.ID}}); err != nil {
+			return err
+		}
+		entry := accountAuditEntry(model.AuditPlanChanged, model.AuditTargetBusiness, businessID, nil)
+		entry.Changes = []model.FieldChange{{Field: "plan", Before: previous.ID, After: plan.ID}}
+		return env.recordAudit(ctx, r, model.ActorAdmin, entry)
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		WriteErrorResponse(w, http.StatusNotFound, "Business not found")
+		return
+	}
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to change plan")
+		return
+	}
+
+	WriteSuccessResponse(w, r, plan, nil, false)
+}
+
+// reservePlanLimit holds limit of the business until the reservation is released, release it once the
+// addition is saved. It returns a [*plans.LimitError] when the business can not add one more of limit
+func (env *HandlerEnv) reservePlanLimit(ctx context.Context, businessID primitive.ObjectID, limit string) (*plans.Reservation, error) {
+	business := new(model.BusinessUser)
+	if err := env.database.GetBusinesses().FindOne(business, ctx, bson.M{"_id": businessID}); err != nil {
+		return nil, err
+	}
+	return env.plans.Reserve(ctx, business, limit, 1)
+}
+
+// storePlanImage stores an image uploaded by the business like storeUploadedImage
+// and counts it against the monthly image uploads of its plan
+// the upload is given back with releaseImageUpload when the image is not used after all
+func (env *HandlerEnv) storePlanImage(ctx context.Context, w http.ResponseWriter, r *http.Request, business *model.BusinessUser, keyPrefix string, maxSize int64) (*model.Image, int, error) {
+	if err := env.plans.ConsumeImageUpload(ctx, business); err != nil {
+		status, err := planError(err, "Failed to count the upload")
+		return nil, status, err
+	}
+	image, status, err := env.storeUploadedImage(ctx, w, r, keyPrefix, maxSize)
+	if err != nil {
+		env.releaseImageUpload(ctx, business.ID)
+		return nil, status, err
+	}
+	return image, http.StatusOK, nil
+}
+
+// releaseImageUpload gives back an image upload of the business, failures are only logged
+func (env *HandlerEnv) releaseImageUpload(ctx context.Context, businessID primitive.ObjectID) {
+	if err := env.plans.ReleaseImageUpload(ctx, businessID); err != nil {
+		log.Println("release image upload:", err)
+	}
+}
+
+// dealCountsAsActive tells if a deal with status counts against the active deals of a plan
+func dealCountsAsActive(status string) bool {
+	return status == model.DealLive || status == model.DealScheduled
+}
+
+// planError returns the status code and error to send for err:
+// 402 when a more expensive plan allows more, 403 when no plan does and 500 with msg for any other error
+func planError(err error, msg string) (int, error) {
+	var limitErr *plans.LimitError
+	if !errors.As(err, &limitErr) {
+		log.Println(err)
+		return http.StatusInternalServerError, errors.New(msg)
+	}
+	if limitErr.Upgrade != nil {
+		return http.StatusPaymentRequired, limitErr
+	}
+	return http.StatusForbidden, limitErr
+}
+
+// writePlanError writes the response for an error from a plan check, see planError
+func writePlanError(w http.ResponseWriter, err error, msg string) {
+	status, err := planError(err, msg)
+	WriteErrorResponse(w, status, err.Error())
+}
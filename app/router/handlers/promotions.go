@@ -0,0 +1,366 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	requests "github.com/CoffeeHausGames/whir-server/app/model/requests"
+	"github.com/CoffeeHausGames/whir-server/app/promotions"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreatePromotion promotes a deal of the authenticated business in nearby searches,
+// e.g. {"deal_id": "...", "budget": 5000, "radius": 3, "end_date": "2024-06-01T00:00:00Z"}
+func (env *HandlerEnv) CreatePromotion(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	promotionRequest, ok := env.getPromotionRequest(w, r)
+	if !ok {
+		return
+	}
+	if promotionRequest.Deal_id == nil || promotionRequest.Budget == nil || promotionRequest.End_date == nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "deal_id, budget and end_date are required")
+		return
+	}
+	now := time.Now().UTC()
+	if !promotionRequest.End_date.After(now) {
+		WriteErrorResponse(w, http.StatusBadRequest, "end_date must be in the future")
+		return
+	}
+
+	business, err := env.findClaimsBusiness(ctx, r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, "Business not found")
+		return
+	}
+	if promotionRequest.Latitude == nil && business.Location == nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "The business has no location, send the latitude and longitude to target")
+		return
+	}
+	deal := new(model.Deal)
+	err = env.database.GetDeals().FindOne(deal, ctx, bson.M{
+		"_id":         *promotionRequest.Deal_id,
+		"business_id": business.ID,
+		"status":      bson.M{"$ne": model.DealArchived},
+	})
+	if err != nil {
+		WriteErrorResponse(w, http.StatusNotFound, "Deal not found")
+		return
+	}
+
+	promotion := requests.NewPromotion(*promotionRequest, business, deal, now)
+	if !promotion.End_date.After(promotion.Start_date) {
+		WriteErrorResponse(w, http.StatusBadRequest, "end_date must be after start_date")
+		return
+	}
+	reservation, err := env.plans.Reserve(ctx, business, model.LimitPromotionBudget, promotion.Budget)
+	if err != nil {
+		writePlanError(w, err, "Failed to create promotion")
+		return
+	}
+	defer reservation.Release()
+	if _, err := env.database.GetPromotions().InsertOne(ctx, promotion); err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to create promotion")
+		return
+	}
+	env.promotions.FillState(promotion)
+
+	WriteSuccessResponse(w, r, promotion, nil, false)
+}
+
+// GetPromotions lists the promotions of the authenticated business, newest first
+func (env *HandlerEnv) GetPromotions(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	businessID, err := claimsUserID(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Error parsing user ID")
+		return
+	}
+
+	promotionList := []*model.Promotion{}
+	if err := findAll(ctx, env.database.GetPromotions(), bson.M{"business_id": businessID}, &promotionList); err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get promotions")
+		return
+	}
+	env.promotions.FillState(promotionList...)
+
+	WriteSuccessResponse(w, r, promotionList, nil, false)
+}
+
+// GetPromotion returns the promotion :id of the authenticated business with its impressions, clicks and spend by day
+func (env *HandlerEnv) GetPromotion(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	promotion, status, err := env.findOwnedPromotion(ctx, r, ps.ByName("id"))
+	if err != nil {
+		WriteErrorResponse(w, status, err.Error())
+		return
+	}
+	stats, err := env.promotions.Stats(ctx, promotion.ID)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get promotion stats")
+		return
+	}
+
+	WriteSuccessResponse(w, r, map[string]interface{}{
+		"promotion": promotion,
+		"stats":     stats,
+	}, nil, false)
+}
+
+// UpdatePromotion changes the budget, targeting, dates or status of the promotion :id.
+// The budget can not go below what was already spent and canceled promotions can not be changed
+func (env *HandlerEnv) UpdatePromotion(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	promotionRequest, ok := env.getPromotionRequest(w, r)
+	if !ok {
+		return
+	}
+	if promotionRequest.Deal_id != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "The deal of a promotion can not be changed, create a new promotion instead")
+		return
+	}
+	promotion, status, err := env.findOwnedPromotion(ctx, r, ps.ByName("id"))
+	if err != nil {
+		WriteErrorResponse(w, status, err.Error())
+		return
+	}
+	if promotion.Status == model.PromotionCanceled {
+		WriteErrorResponse(w, http.StatusConflict, "The promotion was canceled")
+		return
+	}
+
+	filter := bson.M{"_id": promotion.ID, "status": bson.M{"$ne": model.PromotionCanceled}}
+	update := bson.M{"updated_at": time.Now().UTC()}
+	if promotionRequest.Budget != nil {
+		if *promotionRequest.Budget < promotion.Spent {
+			WriteErrorResponse(w, http.StatusBadRequest, "The budget can not be less than what was already spent")
+			return
+		}
+		update["budget"] = *promotionRequest.Budget
+		// impressions served while the request was read could have spent more
+		filter["spent"] = bson.M{"$lte": *promotionRequest.Budget}
+	}
+	if promotionRequest.Latitude != nil {
+		update["location"] = &model.Location{Type: "Point", Coordinates: []float64{*promotionRequest.Longitude, *promotionRequest.Latitude}}
+	}
+	if promotionRequest.Radius != nil {
+		update["radius"] = *promotionRequest.Radius
+	}
+	start, end := promotion.Start_date, promotion.End_date
+	if promotionRequest.Start_date != nil {
+		start = promotionRequest.Start_date.UTC()
+		update["start_date"] = start
+	}
+	if promotionRequest.End_date != nil {
+		end = promotionRequest.End_date.UTC()
+		update["end_date"] = end
+	}
+	if !end.After(start) {
+		WriteErrorResponse(w, http.StatusBadRequest, "end_date must be after start_date")
+		return
+	}
+	if promotionRequest.Status != nil {
+		update["status"] = *promotionRequest.Status
+	}
+	if more := addedPromotionBudget(promotion, promotionRequest.Budget, end, time.Now().UTC()); more > 0 {
+		business, err := env.findClaimsBusiness(ctx, r)
+		if err != nil {
+			WriteErrorResponse(w, http.StatusUnprocessableEntity, "Business not found")
+			return
+		}
+		reservation, err := env.plans.Reserve(ctx, business, model.LimitPromotionBudget, more)
+		if err != nil {
+			writePlanError(w, err, "Failed to update promotion")
+			return
+		}
+		defer reservation.Release()
+	}
+
+	err = env.database.GetPromotions().FindOneAndUpdate(promotion, ctx, filter, bson.M{"$set": update},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		WriteErrorResponse(w, http.StatusConflict, "The promotion changed, try again")
+		return
+	}
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to update promotion")
+		return
+	}
+	env.promotions.FillState(promotion)
+
+	WriteSuccessResponse(w, r, promotion, nil, false)
+}
+
+// addedPromotionBudget is how much the change adds to the promotion budget the plan of the business caps,
+// a promotion counts with its whole budget until it ends
+func addedPromotionBudget(promotion *model.Promotion, budget *int64, end time.Time, now time.Time) int64 {
+	if !end.After(now) {
+		return 0
+	}
+	newBudget := promotion.Budget
+	if budget != nil {
+		newBudget = *budget
+	}
+	if !promotion.End_date.After(now) {
+		// an ended promotion is started again
+		return newBudget
+	}
+	return newBudget - promotion.Budget
+}
+
+// CancelPromotion stops the promotion :id for good, its counts are kept
+func (env *HandlerEnv) CancelPromotion(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	promotion, status, err := env.findOwnedPromotion(ctx, r, ps.ByName("id"))
+	if err != nil {
+		WriteErrorResponse(w, status, err.Error())
+		return
+	}
+	_, err = env.database.GetPromotions().UpdateOne(ctx, bson.M{"_id": promotion.ID}, bson.M{"$set": bson.M{
+		"status":     model.PromotionCanceled,
+		"updated_at": time.Now().UTC(),
+	}})
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to cancel promotion")
+		return
+	}
+
+	WriteSuccessResponse(w, r, "Promotion canceled", nil, false)
+}
+
+// ClickPromotion counts a click on the sponsored result of promotion :id,
+// clients call it when a sponsored deal is opened with the promotion_id it came with
+func (env *HandlerEnv) ClickPromotion(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	promotionID, err := primitive.ObjectIDFromHex(ps.ByName("id"))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "Invalid promotion ID")
+		return
+	}
+
+	err = env.promotions.Click(ctx, promotionID, promotionVisitor(r))
+	if errors.Is(err, promotions.ErrPromotionNotFound) {
+		WriteErrorResponse(w, http.StatusNotFound, "Promotion not found")
+		return
+	}
+	if err != nil {
+		log.Println("promotion click:", err)
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to count click")
+		return
+	}
+
+	WriteSuccessResponse(w, r, "ok", nil, false)
+}
+
+// placeSponsored puts the sponsored results at the top of a nearby search. A sponsored business that
+// is already in the results is moved up, the promoted deal is labeled and carries its promotion ID
+func (env *HandlerEnv) placeSponsored(ctx context.Context, results []model.BusinessUserWrapper, placements []*promotions.Placement) []model.BusinessUserWrapper {
+	if len(placements) == 0 {
+		return results
+	}
+	sponsored := make([]model.BusinessUserWrapper, 0, len(placements))
+	for _, placement := range placements {
+		var wrapper *model.BusinessUserWrapper
+		for i := range results {
+			if results[i].ID == placement.Business.ID {
+				existing := results[i]
+				wrapper = &existing
+				results = append(results[:i:i], results[i+1:]...)
+				break
+			}
+		}
+		if wrapper == nil {
+			deals, err := GetDiscoverableDealsForBusiness(placement.Business.ID, env.database.GetDeals(), ctx)
+			if err != nil {
+				log.Println("sponsored results:", err)
+				deals = []*model.Deal{placement.Deal}
+			}
+			wrapper = model.NewBusinessUser(placement.Business, deals)
+		}
+
+		wrapper.Sponsored = true
+		promotionID := placement.Promotion.ID
+		found := false
+		for _, deal := range wrapper.Deals {
+			if deal.ID == placement.Deal.ID {
+				deal.Sponsored, deal.Promotion_id, found = true, &promotionID, true
+			}
+		}
+		if !found {
+			placement.Deal.Sponsored, placement.Deal.Promotion_id = true, &promotionID
+			wrapper.Deals = append([]*model.Deal{placement.Deal}, wrapper.Deals...)
+		}
+		sponsored = append(sponsored, *wrapper)
+	}
+	return append(sponsored, results...)
+}
+
+// getPromotionRequest reads and validates a promotion request, it writes the error response and returns
+// false when the request is not valid
+func (env *HandlerEnv) getPromotionRequest(w http.ResponseWriter, r *http.Request) (*requests.Promotion, bool) {
+	_, body, err := env.getClaimsAndBody(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+	promotionRequest := new(requests.Promotion)
+	if err := json.Unmarshal([]byte(body), promotionRequest); err != nil {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, "There was an error with the client request")
+		return nil, false
+	}
+	if err := requests.ValidatePromotionStruct(promotionRequest); err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	return promotionRequest, true
+}
+
+func (env *HandlerEnv) findOwnedPromotion(ctx context.Context, r *http.Request, id string) (*model.Promotion, int, error) {
+	businessID, err := claimsUserID(r)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("Error parsing user ID")
+	}
+	promotionID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.New("Invalid promotion ID")
+	}
+
+	promotion := new(model.Promotion)
+	err = env.database.GetPromotions().FindOne(promotion, ctx, bson.M{"_id": promotionID, "business_id": businessID})
+	if err != nil {
+		return nil, http.StatusNotFound, errors.New("Promotion not found")
+	}
+	env.promotions.FillState(promotion)
+	return promotion, http.StatusOK, nil
+}
+
+// promotionVisitor tells visitors apart for click counting without storing their address
+func promotionVisitor(r *http.Request) string {
+	sum := sha256.Sum256([]byte(clientIP(r) + "|" + r.UserAgent()))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,429 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/CoffeeHausGames/whir-server/app/auth"
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	requests "github.com/CoffeeHausGames/whir-server/app/model/requests"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// how long a claimed code can be used at the counter
+const redemptionValidFor = 24 * time.Hour
+
+var errAlreadyRedeemed = errors.New("This code has already been redeemed")
+
+// ClaimDeal lets the authenticated user claim a deal which creates a redemption code
+// to show at the business
+func (env *HandlerEnv) ClaimDeal(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	claims, body, err := env.getClaimsAndBody(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var claimRequest requests.Claim
+	err = json.Unmarshal([]byte(body), &claimRequest)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, "There was an error with the client request")
+		return
+	}
+
+	err = requests.ValidateClaimStruct(&claimRequest)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userID, err := primitive.ObjectIDFromHex(claims.Uid)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Error parsing user ID")
+		return
+	}
+
+	// a deal held back by moderation can not be claimed and is not told apart from a missing one
+	deal := new(model.Deal)
+	err = env.database.GetDeals().FindOne(deal, ctx, bson.M{"_id": claimRequest.Deal_id, "moderation_status": model.PubliclyVisible()})
+	if err != nil {
+		WriteErrorResponse(w, http.StatusNotFound, "Deal not found")
+		return
+	}
+
+	// the deals of a suspended business or one held back by moderation are not shown and can not be claimed
+	if err := findActiveBusiness(ctx, env.database.GetBusinesses(), deal.Business_id); err != nil {
+		WriteErrorResponse(w, http.StatusNotFound, "Deal not found")
+		return
+	}
+
+	now := time.Now().UTC()
+	if deal.CurrentStatus() != model.DealLive {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, "This deal is not available")
+		return
+	}
+	if deal.Start_date != nil && now.Before(*deal.Start_date) {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, "This deal has not started yet")
+		return
+	}
+	if deal.End_date != nil && now.After(*deal.End_date) {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, "This deal has ended")
+		return
+	}
+
+	if deal.IsSoldOut() {
+		WriteErrorResponse(w, http.StatusConflict, "This deal is sold out")
+		return
+	}
+
+	// count the claim against the daily limit before anything else is taken so concurrent claims can never go over it
+	counterCollection := env.database.GetClaimCounters()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	dailyLimit := deal.Claim_limits != nil && deal.Claim_limits.Per_user_per_day != nil
+	if dailyLimit {
+		status, err := takeDailyClaim(ctx, counterCollection, deal.ID, userID, day, *deal.Claim_limits.Per_user_per_day)
+		if err != nil {
+			WriteErrorResponse(w, status, err.Error())
+			return
+		}
+	}
+
+	// take one from the inventory before creating the code so concurrent claims can never oversell
+	dealCollection := env.database.GetDeals()
+	if deal.Quantity != nil {
+		status, err := takeFromInventory(ctx, dealCollection, deal.ID)
+		if err != nil {
+			if dailyLimit {
+				returnDailyClaim(counterCollection, deal.ID, userID, day)
+			}
+			WriteErrorResponse(w, status, err.Error())
+			return
+		}
+	}
+
+	redemption := &model.Redemption{
+		Deal_id:     deal.ID,
+		Business_id: deal.Business_id,
+		User_id:     userID,
+		Status:      model.RedemptionClaimed,
+		Claimed_at:  now,
+		Expires_at:  now.Add(redemptionValidFor),
+		Deal_name:   deal.Name,
+	}
+	if deal.End_date != nil && deal.End_date.Before(redemption.Expires_at) {
+		redemption.Expires_at = *deal.End_date
+	}
+
+	// codes are unique per business so try again if a random code is already taken
+	redemptionCollection := env.database.GetRedemptions()
+	for attempt := 0; ; attempt++ {
+		redemption.ID = primitive.NewObjectID()
+		redemption.Code, err = auth.GenerateRedemptionCode()
+		if err != nil {
+			WriteErrorResponse(w, http.StatusInternalServerError, "Failed to create redemption code")
+			return
+		}
+
+		err = env.database.WithTransaction(ctx, func(ctx context.Context) error {
+			if _, err := redemptionCollection.InsertOne(ctx, redemption); err != nil {
+				return err
+			}
+			return env.recordEvent(ctx, model.EventDealClaimed, redemption.Business_id, redemption.ID, redemption)
+		})
+		if err == nil {
+			break
+		}
+		if !mongo.IsDuplicateKeyError(err) || attempt == 2 {
+			log.Println(err)
+			if deal.Quantity != nil {
+				returnToInventory(dealCollection, deal.ID)
+			}
+			if dailyLimit {
+				returnDailyClaim(counterCollection, deal.ID, userID, day)
+			}
+			WriteErrorResponse(w, http.StatusBadGateway, "Failed to claim deal")
+			return
+		}
+	}
+
+	redemption.Qr_payload = auth.SignRedemption(redemption.ID, redemption.Code)
+
+	WriteSuccessResponse(w, r, redemption, nil, false)
+}
+
+// takeDailyClaim atomically counts a claim of the deal by the user on day against the per user per day limit
+// the filter only matches while the counter is below the limit, once it is reached the upsert collides with the
+// counter on its unique index. Returns the status code to send when the limit is reached
+func takeDailyClaim(ctx context.Context, counterCollection model.Collection, dealID primitive.ObjectID, userID primitive.ObjectID, day time.Time, limit int) (int, error) {
+	filter := bson.M{"deal_id": dealID, "user_id": userID, "day": day, "count": bson.M{"$lt": limit}}
+	// the first two claims of a day can both create the counter, the one that loses tries again
+	for attempt := 0; attempt < 2; attempt++ {
+		_, err := counterCollection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"count": 1}}, options.Update().SetUpsert(true))
+		if err == nil {
+			return http.StatusOK, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return http.StatusBadGateway, errors.New("There was an error connecting with the server")
+		}
+	}
+	return http.StatusTooManyRequests, errors.New("You have already claimed this deal today")
+}
+
+// returnDailyClaim gives back a claim counted by [takeDailyClaim] when the claim could not be finished
+func returnDailyClaim(counterCollection model.Collection, dealID primitive.ObjectID, userID primitive.ObjectID, day time.Time) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := counterCollection.UpdateOne(ctx,
+		bson.M{"deal_id": dealID, "user_id": userID, "day": day, "count": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"count": -1}},
+	)
+	if err != nil {
+		log.Println("Failed to return daily claim of deal " + dealID.Hex() + ": " + err.Error())
+	}
+}
+
+// takeFromInventory atomically takes one claim from a limited quantity deal
+// the filter only matches while there is something left so the count can never go below zero
+func takeFromInventory(ctx context.Context, dealCollection model.Collection, dealID primitive.ObjectID) (int, error) {
+	result, err := dealCollection.UpdateOne(ctx,
+		bson.M{"_id": dealID, "remaining": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"remaining": -1}},
+	)
+	if err != nil {
+		return http.StatusBadGateway, errors.New("There was an error connecting with the server")
+	}
+	if result.ModifiedCount == 0 {
+		return http.StatusConflict, errors.New("This deal is sold out")
+	}
+	return http.StatusOK, nil
+}
+
+// returnToInventory gives back a claim taken by [takeFromInventory] when the claim could not be finished
+func returnToInventory(dealCollection model.Collection, dealID primitive.ObjectID) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := dealCollection.UpdateOne(ctx, bson.M{"_id": dealID}, bson.M{"$inc": bson.M{"remaining": 1}})
+	if err != nil {
+		log.Println("Failed to return claim to inventory of deal " + dealID.Hex() + ": " + err.Error())
+	}
+}
+
+// GetUserRedemptions returns the deals the authenticated user has claimed, newest first
+func (env *HandlerEnv) GetUserRedemptions(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	claims := r.Context().Value("claims").(*auth.SignedDetails)
+	userID, err := primitive.ObjectIDFromHex(claims.Uid)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Error parsing user ID")
+		return
+	}
+
+	redemptions, err := findRedemptions(ctx, env.database.GetRedemptions(), bson.M{"user_id": userID})
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// the user needs the QR code for anything that can still be redeemed
+	now := time.Now().UTC()
+	for _, redemption := range redemptions {
+		if redemption.Status == model.RedemptionClaimed && !redemption.IsExpired(now) {
+			redemption.Qr_payload = auth.SignRedemption(redemption.ID, redemption.Code)
+		}
+	}
+
+	WriteSuccessResponse(w, r, redemptions, nil, false)
+}
+
+// GetBusinessRedemptions returns the claims of the authenticated business' deals, newest first
+// can be filtered with the status query parameter
+func (env *HandlerEnv) GetBusinessRedemptions(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	claims := r.Context().Value("claims").(*auth.SignedDetails)
+	businessID, err := primitive.ObjectIDFromHex(claims.Uid)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Error parsing user ID")
+		return
+	}
+
+	filter := bson.M{"business_id": businessID}
+	if status := r.URL.Query().Get("status"); status != "" {
+		filter["status"] = status
+	}
+
+	redemptions, err := findRedemptions(ctx, env.database.GetRedemptions(), filter)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	WriteSuccessResponse(w, r, redemptions, nil, false)
+}
+
+// VerifyRedemption lets a business check a code before redeeming it
+func (env *HandlerEnv) VerifyRedemption(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	redemption, status, err := env.findRedemptionForBusiness(ctx, r)
+	if err != nil {
+		WriteErrorResponse(w, status, err.Error())
+		return
+	}
+
+	if err := redeemable(redemption, time.Now().UTC()); err != nil {
+		WriteErrorResponse(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	WriteSuccessResponse(w, r, redemption, nil, false)
+}
+
+// RedeemRedemption marks a code as used by the authenticated business
+// a code can only ever be redeemed once
+func (env *HandlerEnv) RedeemRedemption(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	redemption, status, err := env.findRedemptionForBusiness(ctx, r)
+	if err != nil {
+		WriteErrorResponse(w, status, err.Error())
+		return
+	}
+
+	now := time.Now().UTC()
+	if err := redeemable(redemption, now); err != nil {
+		WriteErrorResponse(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	err = env.database.WithTransaction(ctx, func(ctx context.Context) error {
+		// the status is part of the filter so two staff scanning at once can not both redeem it
+		result, err := env.database.GetRedemptions().UpdateOne(ctx,
+			bson.M{"_id": redemption.ID, "status": model.RedemptionClaimed, "expires_at": bson.M{"$gt": now}},
+			bson.M{"$set": bson.M{"status": model.RedemptionRedeemed, "redeemed_at": now}},
+		)
+		if err != nil {
+			return err
+		}
+		if result.ModifiedCount == 0 {
+			return errAlreadyRedeemed
+		}
+
+		redemption.Status = model.RedemptionRedeemed
+		redemption.Redeemed_at = &now
+		return env.recordEvent(ctx, model.EventDealRedeemed, redemption.Business_id, redemption.ID, redemption)
+	})
+	if errors.Is(err, errAlreadyRedeemed) {
+		WriteErrorResponse(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadGateway, "Failed to redeem code")
+		return
+	}
+
+	WriteSuccessResponse(w, r, redemption, nil, false)
+}
+
+// findRedemptionForBusiness finds the redemption from the code or QR payload in the body
+// a business can only see redemptions of its own deals
+func (env *HandlerEnv) findRedemptionForBusiness(ctx context.Context, r *http.Request) (*model.Redemption, int, error) {
+	claims, body, err := env.getClaimsAndBody(r)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	businessID, err := primitive.ObjectIDFromHex(claims.Uid)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("Error parsing user ID")
+	}
+
+	if err := findActiveBusiness(ctx, env.database.GetBusinesses(), businessID); err != nil {
+		return nil, http.StatusNotFound, errors.New("Business not found")
+	}
+
+	var redeemRequest requests.Redeem
+	err = json.Unmarshal([]byte(body), &redeemRequest)
+	if err != nil {
+		return nil, http.StatusUnprocessableEntity, errors.New("There was an error with the client request")
+	}
+
+	err = requests.ValidateRedeemStruct(&redeemRequest)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	filter := bson.M{"business_id": businessID}
+	if redeemRequest.Qr_payload != nil && *redeemRequest.Qr_payload != "" {
+		redemptionID, code, err := auth.VerifyRedemptionPayload(*redeemRequest.Qr_payload)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		filter["_id"] = redemptionID
+		filter["code"] = code
+	} else {
+		filter["code"] = auth.NormalizeRedemptionCode(*redeemRequest.Code)
+	}
+
+	redemption := new(model.Redemption)
+	err = env.database.GetRedemptions().FindOne(redemption, ctx, filter)
+	if err != nil {
+		return nil, http.StatusNotFound, errors.New("Redemption code not found")
+	}
+
+	return redemption, http.StatusOK, nil
+}
+
+// findActiveBusiness returns an error when the business is suspended, held back by moderation or does not exist
+func findActiveBusiness(ctx context.Context, businessCollection model.Collection, businessID primitive.ObjectID) error {
+	business := new(model.BusinessUser)
+	return businessCollection.FindOne(business, ctx,
+		bson.M{"_id": businessID, "moderation_status": model.PubliclyVisible(), "suspended_at": bson.M{"$exists": false}},
+		options.FindOne().SetProjection(bson.M{"_id": 1}))
+}
+
+// redeemable explains why a redemption can not be redeemed
+func redeemable(redemption *model.Redemption, now time.Time) error {
+	if redemption.Status == model.RedemptionRedeemed {
+		return errors.New("This code has already been redeemed")
+	}
+	if redemption.IsExpired(now) {
+		return errors.New("This code has expired")
+	}
+	return nil
+}
+
+func findRedemptions(ctx context.Context, redemptionCollection model.Collection, filter bson.M) ([]*model.Redemption, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "claimed_at", Value: -1}}).SetLimit(200)
+	cursor, err := redemptionCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, errors.New("Failed to get redemptions")
+	}
+
+	redemptions := make([]*model.Redemption, 0)
+	if err := cursor.All(ctx, &redemptions); err != nil {
+		return nil, errors.New("Failed to decode redemptions")
+	}
+
+	return redemptions, nil
+}
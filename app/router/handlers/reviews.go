@@ -0,0 +1,539 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	requests "github.com/CoffeeHausGames/whir-server/app/model/requests"
+	"github.com/CoffeeHausGames/whir-server/app/moderation"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// reviewsLimit is the most reviews sent at once
+	reviewsLimit = 100
+	// maxReviewPhotoSize is the largest photo a review can have
+	maxReviewPhotoSize = 8 << 20 // 8 MB
+)
+
+var errReviewNotFound = errors.New("Review not found")
+
+// CreateReview reviews a business or a deal as the authenticated user.
+// Only users who redeemed a deal of the business (or that deal) can review, a user reviews each target once
+// e.g. {"target_type": "deal", "target_id": "...", "rating": 5, "text": "Great coffee"}
+func (env *HandlerEnv) CreateReview(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, body, err := env.getClaimsAndBody(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	reviewRequest := new(requests.Review)
+	if err := json.Unmarshal([]byte(body), reviewRequest); err != nil {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, "There was an error with the client request")
+		return
+	}
+	if err := requests.ValidateReviewStruct(reviewRequest); err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	targetID, _ := primitive.ObjectIDFromHex(*reviewRequest.Target_id)
+
+	user, err := env.findClaimsUser(ctx, r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	businessID, err := env.reviewTargetBusiness(ctx, *reviewRequest.Target_type, targetID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		WriteErrorResponse(w, http.StatusNotFound, "The "+*reviewRequest.Target_type+" to review was not found")
+		return
+	}
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to save review")
+		return
+	}
+
+	// a verified customer redeemed a deal of the business, or the deal itself for a deal review
+	redeemed := bson.M{"user_id": user.ID, "business_id": businessID, "status": model.RedemptionRedeemed}
+	if *reviewRequest.Target_type == model.ReviewTargetDeal {
+		redeemed["deal_id"] = targetID
+	}
+	redemptions, err := env.database.GetRedemptions().CountDocuments(ctx, redeemed)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to save review")
+		return
+	}
+	if redemptions == 0 {
+		WriteErrorResponse(w, http.StatusForbidden, "Only customers who redeemed a deal can review it")
+		return
+	}
+
+	now := time.Now().UTC()
+	review := &model.Review{
+		ID:          primitive.NewObjectID(),
+		User_id:     user.ID,
+		Author_name: reviewAuthorName(user),
+		Business_id: businessID,
+		Target_type: *reviewRequest.Target_type,
+		Target_id:   targetID,
+		Rating:      *reviewRequest.Rating,
+		Created_at:  now,
+		Updated_at:  now,
+	}
+	if reviewRequest.Text != nil {
+		review.Text = strings.TrimSpace(*reviewRequest.Text)
+	}
+	// a flagged review is held back and does not count towards the rating until a moderator approves it
+	var findings []string
+	review.Moderation_status, findings = env.moderation.Check("", review.Text)
+
+	err = env.database.WithTransaction(ctx, func(ctx context.Context) error {
+		if _, err := env.database.GetReviews().InsertOne(ctx, review); err != nil {
+			return err
+		}
+		sum, count := review.RatingContribution()
+		if err := env.changeRating(ctx, review, sum, count); err != nil {
+			return err
+		}
+		if review.Moderation_status == model.ModerationPending {
+			if err := env.moderation.Queue(ctx, reviewModerationTarget(review), findings, review.Text); err != nil {
+				return err
+			}
+		}
+		return env.recordEvent(ctx, model.EventReviewCreated, businessID, review.ID, review)
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		WriteErrorResponse(w, http.StatusConflict, "You already reviewed this "+review.Target_type+", edit that review instead")
+		return
+	}
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to save review")
+		return
+	}
+
+	WriteSuccessResponse(w, r, review, nil, false)
+}
+
+// UpdateReview changes the review :id of the authenticated user, the earlier version is kept in its history
+func (env *HandlerEnv) UpdateReview(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, body, err := env.getClaimsAndBody(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	edit := new(requests.ReviewEdit)
+	if err := json.Unmarshal([]byte(body), edit); err != nil {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, "There was an error with the client request")
+		return
+	}
+	if err := requests.ValidateReviewEditStruct(edit); err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	review, status, err := env.findOwnReview(ctx, r, ps)
+	if err != nil {
+		WriteErrorResponse(w, status, err.Error())
+		return
+	}
+
+	now := time.Now().UTC()
+	revision := review.Revision(now)
+	previousStatus := review.Moderation_status
+	previousSum, previousCount := review.RatingContribution()
+	if edit.Rating != nil {
+		review.Rating = *edit.Rating
+	}
+	if edit.Text != nil {
+		review.Text = strings.TrimSpace(*edit.Text)
+	}
+	if review.Rating == revision.Rating && review.Text == revision.Text {
+		WriteSuccessResponse(w, r, review, nil, false)
+		return
+	}
+	review.Updated_at = now
+
+	set := bson.M{"rating": review.Rating, "text": review.Text, "updated_at": now}
+	var findings []string
+	if review.Text != revision.Text {
+		review.Moderation_status, findings = env.moderation.Check(previousStatus, review.Text)
+		set["moderation_status"] = review.Moderation_status
+	}
+	sum, count := review.RatingContribution()
+
+	err = env.database.WithTransaction(ctx, func(ctx context.Context) error {
+		// the old rating and status have to match so two changes at once can not both change the business rating
+		result, err := env.database.GetReviews().UpdateOne(ctx,
+			bson.M{"_id": review.ID, "rating": revision.Rating, "moderation_status": moderationStatusFilter(previousStatus)},
+			bson.M{
+				"$set":  set,
+				"$push": bson.M{"history": bson.M{"$each": bson.A{revision}, "$slice": -model.MaxReviewHistory}},
+			},
+		)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return errReviewNotFound
+		}
+		if review.Moderation_status == model.ModerationPending && review.Text != revision.Text {
+			if err := env.moderation.Queue(ctx, reviewModerationTarget(review), findings, review.Text); err != nil {
+				return err
+			}
+		}
+		return env.changeRating(ctx, review, sum-previousSum, count-previousCount)
+	})
+	if errors.Is(err, errReviewNotFound) {
+		WriteErrorResponse(w, http.StatusConflict, "The review was changed at the same time, try again")
+		return
+	}
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to update review")
+		return
+	}
+	review.History = append(review.History, revision)
+
+	WriteSuccessResponse(w, r, review, nil, false)
+}
+
+// DeleteReview deletes the review :id of the authenticated user
+func (env *HandlerEnv) DeleteReview(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	review, status, err := env.findOwnReview(ctx, r, ps)
+	if err != nil {
+		WriteErrorResponse(w, status, err.Error())
+		return
+	}
+
+	err = env.database.WithTransaction(ctx, func(ctx context.Context) error {
+		result, err := env.database.GetReviews().DeleteOne(ctx,
+			bson.M{"_id": review.ID, "rating": review.Rating, "moderation_status": moderationStatusFilter(review.Moderation_status)})
+		if err != nil {
+			return err
+		}
+		if result.DeletedCount == 0 {
+			return errReviewNotFound
+		}
+		sum, count := review.RatingContribution()
+		return env.changeRating(ctx, review, -sum, -count)
+	})
+	if errors.Is(err, errReviewNotFound) {
+		WriteErrorResponse(w, http.StatusConflict, "The review was changed at the same time, try again")
+		return
+	}
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to delete review")
+		return
+	}
+	env.removeImage(ctx, review.Photo)
+
+	WriteSuccessResponse(w, r, "Review deleted", nil, false)
+}
+
+// UploadReviewPhoto adds or replaces the photo of the review :id of the authenticated user
+// expects a multipart form with the image in the "image" field
+func (env *HandlerEnv) UploadReviewPhoto(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	review, status, err := env.findOwnReview(ctx, r, ps)
+	if err != nil {
+		WriteErrorResponse(w, status, err.Error())
+		return
+	}
+
+	image, status, err := env.storeUploadedImage(ctx, w, r, "reviews/"+review.ID.Hex()+"/photo", maxReviewPhotoSize)
+	if err != nil {
+		WriteErrorResponse(w, status, err.Error())
+		return
+	}
+
+	_, err = env.database.GetReviews().UpdateOne(ctx, bson.M{"_id": review.ID}, bson.M{"$set": bson.M{"photo": image}})
+	if err != nil {
+		env.removeImage(ctx, image)
+		WriteErrorResponse(w, http.StatusBadGateway, "There was an error saving the image")
+		return
+	}
+	env.removeImage(ctx, review.Photo)
+
+	WriteSuccessResponse(w, r, image, nil, false)
+}
+
+// DeleteReviewPhoto removes the photo of the review :id of the authenticated user
+func (env *HandlerEnv) DeleteReviewPhoto(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	review, status, err := env.findOwnReview(ctx, r, ps)
+	if err != nil {
+		WriteErrorResponse(w, status, err.Error())
+		return
+	}
+
+	_, err = env.database.GetReviews().UpdateOne(ctx, bson.M{"_id": review.ID}, bson.M{"$unset": bson.M{"photo": ""}})
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadGateway, "There was an error removing the image")
+		return
+	}
+	env.removeImage(ctx, review.Photo)
+
+	WriteSuccessResponse(w, r, "Image removed successfully", nil, false)
+}
+
+// GetUserReviews returns the reviews the authenticated user wrote, newest first
+func (env *HandlerEnv) GetUserReviews(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	userID, err := claimsUserID(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Error parsing user ID")
+		return
+	}
+
+	env.writeReviews(ctx, w, r, bson.M{"user_id": userID})
+}
+
+// GetBusinessReviews returns the reviews of the business :id, newest first
+func (env *HandlerEnv) GetBusinessReviews(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	env.getTargetReviews(w, r, ps, model.ReviewTargetBusiness)
+}
+
+// GetDealReviews returns the reviews of the deal :id, newest first
+func (env *HandlerEnv) GetDealReviews(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	env.getTargetReviews(w, r, ps, model.ReviewTargetDeal)
+}
+
+// GetReviewsOfSignedInBusiness returns the reviews of the authenticated business and its deals, newest first
+func (env *HandlerEnv) GetReviewsOfSignedInBusiness(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	businessID, err := claimsUserID(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Error parsing user ID")
+		return
+	}
+
+	env.writeReviews(ctx, w, r, bson.M{"business_id": businessID, "moderation_status": model.PubliclyVisible()})
+}
+
+// ReplyToReview answers the review :id of the authenticated business or changes the answer
+// e.g. {"text": "Thanks for coming by!"}
+func (env *HandlerEnv) ReplyToReview(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	claims, body, err := env.getClaimsAndBody(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	businessID, err := primitive.ObjectIDFromHex(claims.Uid)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Error parsing user ID")
+		return
+	}
+	reviewID, err := primitive.ObjectIDFromHex(ps.ByName("id"))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "Invalid review ID")
+		return
+	}
+
+	replyRequest := new(requests.ReviewReply)
+	if err := json.Unmarshal([]byte(body), replyRequest); err != nil {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, "There was an error with the client request")
+		return
+	}
+	if err := requests.ValidateReviewReplyStruct(replyRequest); err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	now := time.Now().UTC()
+	// a pipeline update so the reply keeps when it was first written
+	review := new(model.Review)
+	err = env.database.GetReviews().FindOneAndUpdate(review, ctx,
+		bson.M{"_id": reviewID, "business_id": businessID},
+		bson.A{bson.M{"$set": bson.M{"reply": bson.M{
+			"text":       bson.M{"$literal": strings.TrimSpace(*replyRequest.Text)},
+			"created_at": bson.M{"$ifNull": bson.A{"$reply.created_at", now}},
+			"updated_at": now,
+		}}}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		WriteErrorResponse(w, http.StatusNotFound, "Review not found")
+		return
+	}
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to save reply")
+		return
+	}
+
+	WriteSuccessResponse(w, r, review, nil, false)
+}
+
+// DeleteReviewReply removes the answer of the authenticated business to the review :id
+func (env *HandlerEnv) DeleteReviewReply(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	businessID, err := claimsUserID(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Error parsing user ID")
+		return
+	}
+	reviewID, err := primitive.ObjectIDFromHex(ps.ByName("id"))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "Invalid review ID")
+		return
+	}
+
+	result, err := env.database.GetReviews().UpdateOne(ctx,
+		bson.M{"_id": reviewID, "business_id": businessID},
+		bson.M{"$unset": bson.M{"reply": ""}},
+	)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to remove reply")
+		return
+	}
+	if result.MatchedCount == 0 {
+		WriteErrorResponse(w, http.StatusNotFound, "Review not found")
+		return
+	}
+
+	WriteSuccessResponse(w, r, "Reply removed", nil, false)
+}
+
+// getTargetReviews writes the reviews of the business or deal :id
+func (env *HandlerEnv) getTargetReviews(w http.ResponseWriter, r *http.Request, ps httprouter.Params, targetType string) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	targetID, err := primitive.ObjectIDFromHex(ps.ByName("id"))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "Invalid "+targetType+" ID")
+		return
+	}
+
+	env.writeReviews(ctx, w, r, bson.M{"target_type": targetType, "target_id": targetID, "moderation_status": model.PubliclyVisible()})
+}
+
+// writeReviews sends the newest reviews matching filter
+func (env *HandlerEnv) writeReviews(ctx context.Context, w http.ResponseWriter, r *http.Request, filter bson.M) {
+	cursor, err := env.database.GetReviews().Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(reviewsLimit))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get reviews")
+		return
+	}
+	reviews := []*model.Review{}
+	if err := cursor.All(ctx, &reviews); err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get reviews")
+		return
+	}
+
+	WriteSuccessResponse(w, r, reviews, nil, false)
+}
+
+// findOwnReview finds the review :id and makes sure the authenticated user wrote it
+// returns the status code to send when it fails
+func (env *HandlerEnv) findOwnReview(ctx context.Context, r *http.Request, ps httprouter.Params) (*model.Review, int, error) {
+	userID, err := claimsUserID(r)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("Error parsing user ID")
+	}
+	reviewID, err := primitive.ObjectIDFromHex(ps.ByName("id"))
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.New("Invalid review ID")
+	}
+
+	review := new(model.Review)
+	err = env.database.GetReviews().FindOne(review, ctx, bson.M{"_id": reviewID, "user_id": userID})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, http.StatusNotFound, errReviewNotFound
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("Failed to get review")
+	}
+	return review, http.StatusOK, nil
+}
+
+// reviewTargetBusiness returns the business a review of the target is about
+func (env *HandlerEnv) reviewTargetBusiness(ctx context.Context, targetType string, targetID primitive.ObjectID) (primitive.ObjectID, error) {
+	if targetType == model.ReviewTargetDeal {
+		deal := new(model.Deal)
+		err := env.database.GetDeals().FindOne(deal, ctx, bson.M{"_id": targetID, "status": bson.M{"$ne": model.DealArchived}})
+		return deal.Business_id, err
+	}
+
+	count, err := env.database.GetBusinesses().CountDocuments(ctx, bson.M{"_id": targetID})
+	if err == nil && count == 0 {
+		err = mongo.ErrNoDocuments
+	}
+	return targetID, err
+}
+
+// reviewModerationTarget is the review as content in the moderation queue
+func reviewModerationTarget(review *model.Review) moderation.Target {
+	return moderation.Target{Type: model.ModerationTargetReview, ID: review.ID, Business_id: review.Business_id}
+}
+
+// moderationStatusFilter matches documents with the moderation status, content that was never flagged has none
+func moderationStatusFilter(status string) interface{} {
+	if status == "" {
+		return nil
+	}
+	return status
+}
+
+// changeRating adds to the rating totals of what the review is about, the business or the deal
+func (env *HandlerEnv) changeRating(ctx context.Context, review *model.Review, sum int64, count int64) error {
+	collection := env.database.GetBusinesses()
+	if review.Target_type == model.ReviewTargetDeal {
+		collection = env.database.GetDeals()
+	}
+	_, err := collection.UpdateOne(ctx,
+		bson.M{"_id": review.Target_id},
+		bson.M{"$inc": bson.M{"rating.sum": sum, "rating.count": count}},
+	)
+	return err
+}
+
+// reviewAuthorName is how the author of a review is shown, the first name and the initial of the last name
+func reviewAuthorName(user *model.User) string {
+	name := ""
+	if user.First_name != nil {
+		name = strings.TrimSpace(*user.First_name)
+	}
+	if user.Last_name != nil {
+		if last := strings.TrimSpace(*user.Last_name); last != "" {
+			name += " " + string([]rune(last)[:1]) + "."
+		}
+	}
+	if name == "" {
+		return "Customer"
+	}
+	return strings.TrimSpace(name)
+}
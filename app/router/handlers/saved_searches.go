@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	requests "github.com/CoffeeHausGames/whir-server/app/model/requests"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// savedSearchMatchesLimit is the most matches sent for a saved search
+const savedSearchMatchesLimit = 100
+
+// CreateSavedSearch saves a search for the authenticated user, new deals matching it are alerted about
+// e.g. {"name": "Coffee near work", "latitude": 41.88, "longitude": -87.63, "radius": 1, "categories": ["coffee"], "channels": ["push", "email"]}
+func (env *HandlerEnv) CreateSavedSearch(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	claims, body, err := env.getClaimsAndBody(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	userID, err := primitive.ObjectIDFromHex(claims.Uid)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Error parsing user ID")
+		return
+	}
+
+	searchRequest := new(requests.SavedSearch)
+	if err := json.Unmarshal([]byte(body), searchRequest); err != nil {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, "There was an error with the client request")
+		return
+	}
+	if err := requests.ValidateSavedSearchStruct(searchRequest); err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	savedSearches := env.database.GetSavedSearches()
+	count, err := savedSearches.CountDocuments(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to save search")
+		return
+	}
+	if count >= model.MaxSavedSearches {
+		WriteErrorResponse(w, http.StatusConflict, fmt.Sprintf("A user can save at most %d searches", model.MaxSavedSearches))
+		return
+	}
+
+	search := requests.NewSavedSearch(*searchRequest, userID, time.Now().UTC())
+	if _, err := savedSearches.InsertOne(ctx, search); err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to save search")
+		return
+	}
+
+	WriteSuccessResponse(w, r, search, nil, false)
+}
+
+// GetSavedSearches returns the saved searches of the authenticated user, newest first
+func (env *HandlerEnv) GetSavedSearches(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	userID, err := claimsUserID(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Error parsing user ID")
+		return
+	}
+
+	searches := []*model.SavedSearch{}
+	if err := findAll(ctx, env.database.GetSavedSearches(), bson.M{"user_id": userID}, &searches); err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get saved searches")
+		return
+	}
+
+	WriteSuccessResponse(w, r, searches, nil, false)
+}
+
+// PauseSavedSearch stops alerts for the saved search :id, deals that go live while it is paused are never alerted about
+func (env *HandlerEnv) PauseSavedSearch(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	env.setSavedSearchPaused(w, r, ps, true)
+}
+
+// ResumeSavedSearch starts the alerts for the saved search :id again
+func (env *HandlerEnv) ResumeSavedSearch(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	env.setSavedSearchPaused(w, r, ps, false)
+}
+
+// DeleteSavedSearch deletes the saved search :id of the authenticated user and its matches
+func (env *HandlerEnv) DeleteSavedSearch(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	userID, searchID, ok := savedSearchIDs(w, r, ps)
+	if !ok {
+		return
+	}
+
+	err := env.database.WithTransaction(ctx, func(ctx context.Context) error {
+		result, err := env.database.GetSavedSearches().DeleteOne(ctx, bson.M{"_id": searchID, "user_id": userID})
+		if err != nil {
+			return err
+		}
+		if result.DeletedCount == 0 {
+			return mongo.ErrNoDocuments
+		}
+		_, err = env.database.GetSavedSearchMatches().DeleteMany(ctx, bson.M{"search_id": searchID})
+		return err
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		WriteErrorResponse(w, http.StatusNotFound, "Saved search not found")
+		return
+	}
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to delete saved search")
+		return
+	}
+
+	WriteSuccessResponse(w, r, "Saved search deleted", nil, false)
+}
+
+// GetSavedSearchMatches returns the deals that matched the saved search :id, newest match first.
+// Deals the business deleted or archived are left out
+func (env *HandlerEnv) GetSavedSearchMatches(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	userID, searchID, ok := savedSearchIDs(w, r, ps)
+	if !ok {
+		return
+	}
+
+	search := new(model.SavedSearch)
+	err := env.database.GetSavedSearches().FindOne(search, ctx, bson.M{"_id": searchID, "user_id": userID})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		WriteErrorResponse(w, http.StatusNotFound, "Saved search not found")
+		return
+	}
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get saved search")
+		return
+	}
+
+	cursor, err := env.database.GetSavedSearchMatches().Find(ctx, bson.M{"search_id": searchID},
+		options.Find().SetSort(bson.D{{Key: "matched_at", Value: -1}}).SetLimit(savedSearchMatchesLimit))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get matches")
+		return
+	}
+	var matches []*model.SavedSearchMatch
+	if err := cursor.All(ctx, &matches); err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get matches")
+		return
+	}
+
+	dealIDs := bson.A{}
+	for _, match := range matches {
+		dealIDs = append(dealIDs, match.Deal_id)
+	}
+	deals, err := findDeals(ctx, env.database.GetDeals(), bson.M{"_id": bson.M{"$in": dealIDs}, "status": bson.M{"$ne": model.DealArchived}})
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	dealsByID := make(map[primitive.ObjectID]*model.Deal, len(deals))
+	for _, deal := range deals {
+		dealsByID[deal.ID] = deal
+	}
+
+	matchDeals := make([]*model.SavedSearchMatchDeal, 0, len(matches))
+	for _, match := range matches {
+		if deal, ok := dealsByID[match.Deal_id]; ok {
+			matchDeals = append(matchDeals, &model.SavedSearchMatchDeal{Matched_at: match.Matched_at, Deal: deal})
+		}
+	}
+
+	WriteSuccessResponse(w, r, matchDeals, nil, false)
+}
+
+// setSavedSearchPaused pauses or resumes the saved search :id of the authenticated user
+func (env *HandlerEnv) setSavedSearchPaused(w http.ResponseWriter, r *http.Request, ps httprouter.Params, paused bool) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	userID, searchID, ok := savedSearchIDs(w, r, ps)
+	if !ok {
+		return
+	}
+
+	search := new(model.SavedSearch)
+	err := env.database.GetSavedSearches().FindOneAndUpdate(search, ctx,
+		bson.M{"_id": searchID, "user_id": userID},
+		bson.M{"$set": bson.M{"paused": paused, "updated_at": time.Now().UTC()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		WriteErrorResponse(w, http.StatusNotFound, "Saved search not found")
+		return
+	}
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to update saved search")
+		return
+	}
+
+	WriteSuccessResponse(w, r, search, nil, false)
+}
+
+// savedSearchIDs reads the user and the saved search :id of the request, writes the error response when it fails
+func savedSearchIDs(w http.ResponseWriter, r *http.Request, ps httprouter.Params) (primitive.ObjectID, primitive.ObjectID, bool) {
+	userID, err := claimsUserID(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Error parsing user ID")
+		return primitive.NilObjectID, primitive.NilObjectID, false
+	}
+	searchID, err := primitive.ObjectIDFromHex(ps.ByName("id"))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "Invalid saved search ID")
+		return primitive.NilObjectID, primitive.NilObjectID, false
+	}
+	return userID, searchID, true
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 		"encoding/json"
     "context"
+    "errors"
     "fmt"
 		"log"
 
@@ -105,6 +106,7 @@ func (env *HandlerEnv) SignUp(w http.ResponseWriter, r *http.Request, _ httprout
 			return
 	}
 	defer cancel()
+	env.logAudit(r, model.ActorUser, &model.AuditEntry{Action: model.AuditUserSignedUp, Actor_id: user.ID, Target_type: model.AuditTargetUser, Target_id: user.ID})
 
 	WriteSuccessResponse(w, r, "Account created successfully", nil, false)
 }
@@ -116,6 +118,10 @@ func (env *HandlerEnv) UserLogin(w http.ResponseWriter, r *http.Request, _ httpr
 	foundUser := new(model.User)
 
 	foundUserInterface, err := performLogin(r.Context(), userCollection, &user, foundUser)
+	if errors.Is(err, auth.ErrAccountSuspended) {
+		WriteErrorResponse(w, http.StatusForbidden, "This account is suspended")
+		return
+	}
 	if err != nil {
 		log.Println("performLogin failed")
 		WriteErrorResponse(w, 401, "There was an error logging in")
@@ -129,6 +135,8 @@ func (env *HandlerEnv) UserLogin(w http.ResponseWriter, r *http.Request, _ httpr
 		return
 	}
 
+	env.logAudit(r, model.ActorUser, &model.AuditEntry{Action: model.AuditUserLoggedIn, Actor_id: foundUser.ID, Target_type: model.AuditTargetUser, Target_id: foundUser.ID})
+
 	userWrapper := model.NewUser(foundUser)
 
 	WriteSuccessResponse(w, r, userWrapper, foundUser, true)
@@ -0,0 +1,361 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	requests "github.com/CoffeeHausGames/whir-server/app/model/requests"
+	"github.com/CoffeeHausGames/whir-server/app/verification"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// maxVerificationDocumentSize is the largest document a business can upload
+	maxVerificationDocumentSize = 10 << 20 // 10 MB
+	// verificationRequestsLimit is the most verification requests sent at once
+	verificationRequestsLimit = 100
+)
+
+// GetVerification returns the verification status of the authenticated business and its newest request
+func (env *HandlerEnv) GetVerification(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	business, err := env.findClaimsBusiness(ctx, r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, "Business not found")
+		return
+	}
+	request, err := env.verification.Latest(ctx, business.ID)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get verification")
+		return
+	}
+
+	WriteSuccessResponse(w, r, map[string]interface{}{
+		"status":      business.VerificationStatus(),
+		"verified_at": business.Verified_at,
+		"request":     request,
+	}, nil, false)
+}
+
+// SubmitVerificationDocument sends a document proving the authenticated business runs the listing to the admins
+// expects a multipart form with a PDF, JPEG or PNG in the "document" field
+func (env *HandlerEnv) SubmitVerificationDocument(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	business, err := env.findClaimsBusiness(ctx, r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, "Business not found")
+		return
+	}
+
+	// leave some room for the rest of the multipart form
+	r.Body = http.MaxBytesReader(w, r.Body, maxVerificationDocumentSize+(64<<10))
+	if err := r.ParseMultipartForm(maxVerificationDocumentSize); err != nil {
+		WriteErrorResponse(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Document must be a multipart upload smaller than %d MB", maxVerificationDocumentSize>>20))
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("document")
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "Missing document field")
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxVerificationDocumentSize+1))
+	if err != nil || len(data) > maxVerificationDocumentSize {
+		WriteErrorResponse(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Document must be smaller than %d MB", maxVerificationDocumentSize>>20))
+		return
+	}
+
+	request, err := env.verification.SubmitDocument(ctx, business, path.Base(header.Filename), data, env.verificationRecorder(r, model.ActorBusiness, model.AuditVerificationSubmitted))
+	if err != nil {
+		writeVerificationError(w, err)
+		return
+	}
+
+	WriteSuccessResponse(w, r, request, nil, false)
+}
+
+// RequestPhoneVerification calls the phone number on the listing of the authenticated business with a code
+func (env *HandlerEnv) RequestPhoneVerification(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	business, err := env.findClaimsBusiness(ctx, r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, "Business not found")
+		return
+	}
+	request, err := env.verification.RequestPhoneCode(ctx, business)
+	if err != nil {
+		writeVerificationError(w, err)
+		return
+	}
+
+	WriteSuccessResponse(w, r, request, nil, false)
+}
+
+// RequestPostcardVerification mails a postcard with a code to the address of the authenticated business
+func (env *HandlerEnv) RequestPostcardVerification(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	business, err := env.findClaimsBusiness(ctx, r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, "Business not found")
+		return
+	}
+	request, err := env.verification.RequestPostcardCode(ctx, business)
+	if err != nil {
+		writeVerificationError(w, err)
+		return
+	}
+
+	WriteSuccessResponse(w, r, request, nil, false)
+}
+
+// ConfirmVerificationCode checks the code the authenticated business got by phone or postcard, e.g. {"code": "123456"}
+func (env *HandlerEnv) ConfirmVerificationCode(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, body, err := env.getClaimsAndBody(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	code := new(requests.VerificationCode)
+	if err := json.Unmarshal([]byte(body), code); err != nil {
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, "There was an error with the client request")
+		return
+	}
+	if err := requests.ValidateVerificationCodeStruct(code); err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	businessID, err := claimsUserID(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Error parsing user ID")
+		return
+	}
+	request, err := env.verification.ConfirmCode(ctx, businessID, *code.Code, env.verificationRecorder(r, model.ActorBusiness, model.AuditVerificationSubmitted))
+	if err != nil {
+		writeVerificationError(w, err)
+		return
+	}
+
+	WriteSuccessResponse(w, r, request, nil, false)
+}
+
+// GetVerificationRequests returns the verification requests waiting for an admin, oldest first.
+// ?status= filters by another request status and ?business_id= by business
+func (env *HandlerEnv) GetVerificationRequests(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	filter := bson.M{"status": model.VerificationSubmitted}
+	if status := r.URL.Query().Get("status"); status != "" {
+		filter["status"] = status
+	}
+	if value := r.URL.Query().Get("business_id"); value != "" {
+		businessID, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			WriteErrorResponse(w, http.StatusBadRequest, "Invalid business_id")
+			return
+		}
+		filter["business_id"] = businessID
+	}
+
+	cursor, err := env.database.GetVerificationRequests().Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}).SetLimit(verificationRequestsLimit))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get verification requests")
+		return
+	}
+	verificationRequests := []*model.VerificationRequest{}
+	if err := cursor.All(ctx, &verificationRequests); err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get verification requests")
+		return
+	}
+
+	WriteSuccessResponse(w, r, verificationRequests, nil, false)
+}
+
+// GetVerificationRequest returns the verification request :id with the business it is for
+func (env *HandlerEnv) GetVerificationRequest(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	requestID, err := primitive.ObjectIDFromHex(ps.ByName("id"))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "Invalid verification request ID")
+		return
+	}
+	request := new(model.VerificationRequest)
+	err = env.database.GetVerificationRequests().FindOne(request, ctx, bson.M{"_id": requestID})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		WriteErrorResponse(w, http.StatusNotFound, "Verification request not found")
+		return
+	}
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get verification request")
+		return
+	}
+	business := new(model.BusinessUser)
+	if err := env.database.GetBusinesses().FindOne(business, ctx, bson.M{"_id": request.Business_id}); err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get business")
+		return
+	}
+
+	WriteSuccessResponse(w, r, map[string]interface{}{
+		"request":  request,
+		"business": model.NewAdminBusiness(business),
+		"address":  business.Address,
+	}, nil, false)
+}
+
+// GetVerificationDocument downloads the document of the verification request :id
+func (env *HandlerEnv) GetVerificationDocument(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	requestID, err := primitive.ObjectIDFromHex(ps.ByName("id"))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "Invalid verification request ID")
+		return
+	}
+	file, document, err := env.verification.Document(r.Context(), requestID)
+	if err != nil {
+		writeVerificationError(w, err)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", document.Content_type)
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+strings.ReplaceAll(document.File_name, "\"", "")+"\"")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, no-store")
+	io.Copy(w, file)
+}
+
+// ApproveVerification verifies the business of the submitted request :id, e.g. {"note": "..."}
+func (env *HandlerEnv) ApproveVerification(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	env.reviewVerification(w, r, ps, verification.ActionApprove, model.AuditBusinessVerified)
+}
+
+// RejectVerification turns down the submitted request :id, the business is unverified again, e.g. {"note": "..."}
+func (env *HandlerEnv) RejectVerification(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	env.reviewVerification(w, r, ps, verification.ActionReject, model.AuditVerificationRejected)
+}
+
+// reviewVerification applies the decision of the authenticated admin to the verification request :id
+func (env *HandlerEnv) reviewVerification(w http.ResponseWriter, r *http.Request, ps httprouter.Params, action string, auditAction string) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, body, err := env.getClaimsAndBody(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	decision := new(requests.VerificationDecision)
+	if strings.TrimSpace(body) != "" {
+		if err := json.Unmarshal([]byte(body), decision); err != nil {
+			WriteErrorResponse(w, http.StatusUnprocessableEntity, "There was an error with the client request")
+			return
+		}
+	}
+	if err := requests.ValidateVerificationDecisionStruct(decision); err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	requestID, err := primitive.ObjectIDFromHex(ps.ByName("id"))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "Invalid verification request ID")
+		return
+	}
+	adminID, err := claimsUserID(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Error parsing user ID")
+		return
+	}
+	var note string
+	if decision.Note != nil {
+		note = strings.TrimSpace(*decision.Note)
+	}
+
+	request, err := env.verification.Review(ctx, requestID, action, adminID, note, env.verificationRecorder(r, model.ActorAdmin, auditAction))
+	if err != nil {
+		writeVerificationError(w, err)
+		return
+	}
+
+	WriteSuccessResponse(w, r, request, nil, false)
+}
+
+// writeVerificationError sends the status code that fits an error of the verification service
+func writeVerificationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, verification.ErrRequestNotFound), errors.Is(err, verification.ErrNoCodePending):
+		WriteErrorResponse(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, verification.ErrAlreadyVerified), errors.Is(err, verification.ErrNotReviewable):
+		WriteErrorResponse(w, http.StatusConflict, err.Error())
+	case errors.Is(err, verification.ErrWrongCode), errors.Is(err, verification.ErrCodeExpired):
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, verification.ErrNoAddress), errors.Is(err, verification.ErrNoPhoneNumber):
+		WriteErrorResponse(w, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, verification.ErrUnsupportedDocument):
+		WriteErrorResponse(w, http.StatusUnsupportedMediaType, err.Error())
+	case errors.Is(err, verification.ErrTooManyCodes):
+		WriteErrorResponse(w, http.StatusTooManyRequests, err.Error())
+	default:
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to update verification")
+	}
+}
+
+// verificationRecorder adds a change to the verification of a business to the audit log
+func (env *HandlerEnv) verificationRecorder(r *http.Request, actorType string, action string) verification.Recorder {
+	return func(ctx context.Context, request *model.VerificationRequest) error {
+		data := bson.M{"request_id": request.ID, "method": request.Method}
+		if request.Note != "" {
+			data["note"] = request.Note
+		}
+		return env.recordAudit(ctx, r, actorType, &model.AuditEntry{
+			Action:      action,
+			Business_id: &request.Business_id,
+			Target_type: model.AuditTargetBusiness,
+			Target_id:   request.Business_id,
+			Data:        data,
+		})
+	}
+}
+
+// findClaimsBusiness loads the business the request was authenticated as
+func (env *HandlerEnv) findClaimsBusiness(ctx context.Context, r *http.Request) (*model.BusinessUser, error) {
+	businessID, err := claimsUserID(r)
+	if err != nil {
+		return nil, err
+	}
+	business := new(model.BusinessUser)
+	if err := env.database.GetBusinesses().FindOne(business, ctx, bson.M{"_id": businessID}); err != nil {
+		return nil, err
+	}
+	return business, nil
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/CoffeeHausGames/whir-server/app/auth"
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	requests "github.com/CoffeeHausGames/whir-server/app/model/requests"
+	"github.com/CoffeeHausGames/whir-server/app/webhooks"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateWebhook adds a webhook subscription for the authenticated business
+// the signing secret is only returned here
+func (env *HandlerEnv) CreateWebhook(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	businessID, webhookRequest, status, err := env.getWebhookRequest(r)
+	if err != nil {
+		WriteErrorResponse(w, status, err.Error())
+		return
+	}
+	if webhookRequest.Url == nil || len(webhookRequest.Events) == 0 {
+		WriteErrorResponse(w, http.StatusBadRequest, "url and events are required")
+		return
+	}
+	reservation, err := env.reservePlanLimit(ctx, businessID, model.LimitWebhooks)
+	if err != nil {
+		writePlanError(w, err, "Failed to check the plan")
+		return
+	}
+	defer reservation.Release()
+
+	secret, err := webhooks.GenerateSecret()
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to create webhook secret")
+		return
+	}
+
+	now := time.Now().UTC()
+	subscription := &model.WebhookSubscription{
+		ID:          primitive.NewObjectID(),
+		Business_id: businessID,
+		Url:         *webhookRequest.Url,
+		Events:      webhookRequest.Events,
+		Secret:      secret,
+		Active:      true,
+		Created_at:  now,
+		Updated_at:  now,
+	}
+
+	_, err = env.database.GetWebhooks().InsertOne(ctx, subscription)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadGateway, "Failed to create webhook")
+		return
+	}
+
+	WriteSuccessResponse(w, r, subscription, nil, false)
+}
+
+// GetWebhooks lists the webhook subscriptions of the authenticated business
+func (env *HandlerEnv) GetWebhooks(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	claims := r.Context().Value("claims").(*auth.SignedDetails)
+	businessID, err := primitive.ObjectIDFromHex(claims.Uid)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Error parsing user ID")
+		return
+	}
+
+	cursor, err := env.database.GetWebhooks().Find(ctx, bson.M{"business_id": businessID})
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get webhooks")
+		return
+	}
+
+	subscriptions := make([]*model.WebhookSubscription, 0)
+	if err := cursor.All(ctx, &subscriptions); err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to decode webhooks")
+		return
+	}
+	for _, subscription := range subscriptions {
+		subscription.Secret = ""
+	}
+
+	WriteSuccessResponse(w, r, subscriptions, nil, false)
+}
+
+// UpdateWebhook changes the URL, events or active flag of a webhook subscription
+// turning a disabled subscription back on clears its failures
+func (env *HandlerEnv) UpdateWebhook(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	businessID, webhookRequest, status, err := env.getWebhookRequest(r)
+	if err != nil {
+		WriteErrorResponse(w, status, err.Error())
+		return
+	}
+
+	webhookID, err := primitive.ObjectIDFromHex(ps.ByName("id"))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	update := bson.M{"updated_at": time.Now().UTC()}
+	if webhookRequest.Url != nil {
+		update["url"] = *webhookRequest.Url
+	}
+	if len(webhookRequest.Events) > 0 {
+		update["events"] = webhookRequest.Events
+	}
+	if webhookRequest.Active != nil {
+		update["active"] = *webhookRequest.Active
+		if *webhookRequest.Active {
+			update["consecutive_failures"] = 0
+			update["disabled_reason"] = ""
+		}
+	}
+
+	subscription := new(model.WebhookSubscription)
+	err = env.database.GetWebhooks().FindOneAndUpdate(subscription, ctx,
+		bson.M{"_id": webhookID, "business_id": businessID},
+		bson.M{"$set": update},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusNotFound, "Webhook not found")
+		return
+	}
+	subscription.Secret = ""
+
+	WriteSuccessResponse(w, r, subscription, nil, false)
+}
+
+// DeleteWebhook removes a webhook subscription of the authenticated business
+// deliveries still in the queue are cancelled when they run
+func (env *HandlerEnv) DeleteWebhook(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	subscription, status, err := env.findOwnedWebhook(ctx, r, ps.ByName("id"))
+	if err != nil {
+		WriteErrorResponse(w, status, err.Error())
+		return
+	}
+
+	_, err = env.database.GetWebhooks().DeleteOne(ctx, bson.M{"_id": subscription.ID})
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadGateway, "Failed to delete webhook")
+		return
+	}
+
+	WriteSuccessResponse(w, r, "Webhook deleted successfully", nil, false)
+}
+
+// GetWebhookDeliveries returns the delivery log of a webhook subscription, newest first
+func (env *HandlerEnv) GetWebhookDeliveries(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	subscription, status, err := env.findOwnedWebhook(ctx, r, ps.ByName("id"))
+	if err != nil {
+		WriteErrorResponse(w, status, err.Error())
+		return
+	}
+
+	filter := bson.M{"subscription_id": subscription.ID}
+	if status := r.URL.Query().Get("status"); status != "" {
+		filter["status"] = status
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(100)
+	cursor, err := env.database.GetWebhookDeliveries().Find(ctx, filter, opts)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to get deliveries")
+		return
+	}
+
+	deliveries := make([]*model.WebhookDelivery, 0)
+	if err := cursor.All(ctx, &deliveries); err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "Failed to decode deliveries")
+		return
+	}
+
+	WriteSuccessResponse(w, r, deliveries, nil, false)
+}
+
+// TestWebhook sends a ping event to a webhook subscription so a business can check its receiver
+func (env *HandlerEnv) TestWebhook(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	subscription, status, err := env.findOwnedWebhook(ctx, r, ps.ByName("id"))
+	if err != nil {
+		WriteErrorResponse(w, status, err.Error())
+		return
+	}
+
+	eventID := model.WebhookPing + ":" + primitive.NewObjectID().Hex()
+	err = env.webhookPublisher().Send(ctx, subscription, model.WebhookPing, eventID, map[string]string{"message": "Hello from whir"})
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadGateway, "Failed to send test event")
+		return
+	}
+
+	WriteSuccessResponse(w, r, "Test event queued", nil, false)
+}
+
+func (env *HandlerEnv) webhookPublisher() *webhooks.Publisher {
+	return webhooks.NewPublisher(env.database.GetWebhooks(), env.database.GetWebhookDeliveries(), env.queue)
+}
+
+func (env *HandlerEnv) getWebhookRequest(r *http.Request) (primitive.ObjectID, *requests.Webhook, int, error) {
+	claims, body, err := env.getClaimsAndBody(r)
+	if err != nil {
+		return primitive.NilObjectID, nil, http.StatusInternalServerError, err
+	}
+
+	businessID, err := primitive.ObjectIDFromHex(claims.Uid)
+	if err != nil {
+		return primitive.NilObjectID, nil, http.StatusInternalServerError, errors.New("Error parsing user ID")
+	}
+
+	webhookRequest := new(requests.Webhook)
+	if err := json.Unmarshal([]byte(body), webhookRequest); err != nil {
+		return primitive.NilObjectID, nil, http.StatusUnprocessableEntity, errors.New("There was an error with the client request")
+	}
+	if err := requests.ValidateWebhookStruct(webhookRequest); err != nil {
+		return primitive.NilObjectID, nil, http.StatusBadRequest, err
+	}
+
+	return businessID, webhookRequest, http.StatusOK, nil
+}
+
+func (env *HandlerEnv) findOwnedWebhook(ctx context.Context, r *http.Request, id string) (*model.WebhookSubscription, int, error) {
+	claims := r.Context().Value("claims").(*auth.SignedDetails)
+	businessID, err := primitive.ObjectIDFromHex(claims.Uid)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("Error parsing user ID")
+	}
+
+	webhookID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.New("Invalid webhook ID")
+	}
+
+	subscription := new(model.WebhookSubscription)
+	err = env.database.GetWebhooks().FindOne(subscription, ctx, bson.M{"_id": webhookID, "business_id": businessID})
+	if err != nil {
+		return nil, http.StatusNotFound, errors.New("Webhook not found")
+	}
+
+	return subscription, http.StatusOK, nil
+}
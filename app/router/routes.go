@@ -9,8 +9,8 @@ import (
 	"net/http"
 )
 
-func GetRouter(db *database.Database) http.Handler {
-	EnvHandler := handlers.NewHandlerEnv(db)
+func GetRouter(db *database.Database, services handlers.Services) http.Handler {
+	EnvHandler := handlers.NewHandlerEnv(db, services)
 	router := httprouter.New()
 
 	version := "/v1" // Define version prefix here
@@ -20,6 +20,31 @@ func GetRouter(db *database.Database) http.Handler {
 	router.POST(version+"/users/signup", middleware.UrlDecode(EnvHandler.SignUp))
 	router.POST(version+"/users/login", middleware.UrlDecode(EnvHandler.UserLogin))
 	router.POST(version+"/users/logout", EnvHandler.Logout)
+	router.POST(version+"/user/redemptions", EnvHandler.Authentication(middleware.UrlDecode(EnvHandler.ClaimDeal)))
+	router.GET(version+"/user/redemptions", EnvHandler.Authentication(EnvHandler.GetUserRedemptions))
+	router.GET(version+"/user/favorites", EnvHandler.Authentication(EnvHandler.GetFavorites))
+	router.PUT(version+"/user/favorites/:id", EnvHandler.Authentication(EnvHandler.AddFavorite))
+	router.DELETE(version+"/user/favorites/:id", EnvHandler.Authentication(EnvHandler.RemoveFavorite))
+	router.GET(version+"/user/following", EnvHandler.Authentication(EnvHandler.GetFollowing))
+	router.GET(version+"/user/following/deals", EnvHandler.Authentication(EnvHandler.GetFollowingFeed))
+	router.PUT(version+"/user/following/:id", EnvHandler.Authentication(EnvHandler.FollowBusiness))
+	router.DELETE(version+"/user/following/:id", EnvHandler.Authentication(EnvHandler.UnfollowBusiness))
+	router.POST(version+"/user/devices", EnvHandler.Authentication(middleware.UrlDecode(EnvHandler.RegisterDevice)))
+	router.DELETE(version+"/user/devices", EnvHandler.Authentication(middleware.UrlDecode(EnvHandler.UnregisterDevice)))
+	router.GET(version+"/user/notifications/preferences", EnvHandler.Authentication(EnvHandler.GetNotificationPreferences))
+	router.PUT(version+"/user/notifications/preferences", EnvHandler.Authentication(middleware.UrlDecode(EnvHandler.UpdateNotificationPreferences)))
+	router.POST(version+"/user/location", EnvHandler.Authentication(middleware.UrlDecode(EnvHandler.ReportLocation)))
+	router.POST(version+"/user/searches", EnvHandler.Authentication(middleware.UrlDecode(EnvHandler.CreateSavedSearch)))
+	router.GET(version+"/user/searches", EnvHandler.Authentication(EnvHandler.GetSavedSearches))
+	router.GET(version+"/user/searches/:id/matches", EnvHandler.Authentication(EnvHandler.GetSavedSearchMatches))
+	router.PUT(version+"/user/searches/:id/pause", EnvHandler.Authentication(EnvHandler.PauseSavedSearch))
+	router.PUT(version+"/user/searches/:id/resume", EnvHandler.Authentication(EnvHandler.ResumeSavedSearch))
+	router.DELETE(version+"/user/searches/:id", EnvHandler.Authentication(EnvHandler.DeleteSavedSearch))
+	router.POST(version+"/user/reviews", EnvHandler.Authentication(middleware.UrlDecode(EnvHandler.CreateReview)))
+	router.GET(version+"/user/reviews", EnvHandler.Authentication(EnvHandler.GetUserReviews))
+	router.PUT(version+"/user/reviews/:id", EnvHandler.Authentication(middleware.UrlDecode(EnvHandler.UpdateReview)))
+	router.DELETE(version+"/user/reviews/:id", EnvHandler.Authentication(EnvHandler.DeleteReview))
+	router.POST(version+"/user/reports", EnvHandler.Authentication(middleware.UrlDecode(EnvHandler.ReportContent)))
 
 
 	// Business routes
@@ -36,18 +61,107 @@ func GetRouter(db *database.Database) http.Handler {
 	router.PUT(version+"/business/deal/pin", EnvHandler.BusinessAuthentication(middleware.UrlDecode(EnvHandler.PinDeal)))
 	router.PUT(version+"/business/deal/unpin", EnvHandler.BusinessAuthentication(middleware.UrlDecode(EnvHandler.UnpinDeal)))
 	router.DELETE(version+"/business/deal", EnvHandler.BusinessAuthentication(middleware.UrlDecode(EnvHandler.DeleteDeal)))
+	router.PUT(version+"/business/deal/status", EnvHandler.BusinessAuthentication(middleware.UrlDecode(EnvHandler.ChangeDealStatus)))
+	router.PUT(version+"/business/deal/restore", EnvHandler.BusinessAuthentication(middleware.UrlDecode(EnvHandler.RestoreDeal)))
 	router.DELETE(version+"/business/deals", EnvHandler.BusinessAuthentication(middleware.UrlDecode(EnvHandler.DeleteMultipleDeals)))
+	router.GET(version+"/business/redemptions", EnvHandler.BusinessAuthentication(EnvHandler.GetBusinessRedemptions))
+	router.POST(version+"/business/redemptions/verify", EnvHandler.BusinessAuthentication(middleware.UrlDecode(EnvHandler.VerifyRedemption)))
+	router.POST(version+"/business/sessions/revoke", EnvHandler.BusinessAuthentication(EnvHandler.RevokeBusinessSessions))
+	router.POST(version+"/business/redemptions/redeem", EnvHandler.BusinessAuthentication(middleware.UrlDecode(EnvHandler.RedeemRedemption)))
+	router.GET(version+"/business/reviews", EnvHandler.BusinessAuthentication(EnvHandler.GetReviewsOfSignedInBusiness))
+	router.PUT(version+"/business/reviews/:id/reply", EnvHandler.BusinessAuthentication(middleware.UrlDecode(EnvHandler.ReplyToReview)))
+	router.DELETE(version+"/business/reviews/:id/reply", EnvHandler.BusinessAuthentication(EnvHandler.DeleteReviewReply))
+	router.GET(version+"/business/verification", EnvHandler.BusinessAuthentication(EnvHandler.GetVerification))
+	router.POST(version+"/business/verification/phone", EnvHandler.BusinessAuthentication(EnvHandler.RequestPhoneVerification))
+	router.POST(version+"/business/verification/postcard", EnvHandler.BusinessAuthentication(EnvHandler.RequestPostcardVerification))
+	router.POST(version+"/business/verification/code", EnvHandler.BusinessAuthentication(middleware.UrlDecode(EnvHandler.ConfirmVerificationCode)))
+	router.GET(version+"/business/plan", EnvHandler.BusinessAuthentication(EnvHandler.GetBusinessPlan))
+	router.GET(version+"/plans", EnvHandler.GetPlans)
+	router.GET(version+"/business/billing", EnvHandler.BusinessAuthentication(EnvHandler.GetBilling))
+	router.GET(version+"/business/billing/invoices", EnvHandler.BusinessAuthentication(EnvHandler.GetInvoices))
+	router.POST(version+"/business/billing/checkout", EnvHandler.BusinessAuthentication(middleware.UrlDecode(EnvHandler.CreateCheckout)))
+	router.PUT(version+"/business/billing/subscription", EnvHandler.BusinessAuthentication(middleware.UrlDecode(EnvHandler.ChangeSubscriptionPlan)))
+	router.DELETE(version+"/business/billing/subscription", EnvHandler.BusinessAuthentication(EnvHandler.CancelSubscription))
+	// the payment provider signs its events, the body is read as sent
+	router.POST(version+"/billing/webhook", EnvHandler.BillingWebhook)
+	router.POST(version+"/business/promotions", EnvHandler.BusinessAuthentication(middleware.UrlDecode(EnvHandler.CreatePromotion)))
+	router.GET(version+"/business/promotions", EnvHandler.BusinessAuthentication(EnvHandler.GetPromotions))
+	router.GET(version+"/business/promotions/:id", EnvHandler.BusinessAuthentication(EnvHandler.GetPromotion))
+	router.PUT(version+"/business/promotions/:id", EnvHandler.BusinessAuthentication(middleware.UrlDecode(EnvHandler.UpdatePromotion)))
+	router.DELETE(version+"/business/promotions/:id", EnvHandler.BusinessAuthentication(EnvHandler.CancelPromotion))
+	router.POST(version+"/promotions/:id/click", EnvHandler.ClickPromotion)
+	router.GET(version+"/business/analytics", EnvHandler.BusinessAuthentication(EnvHandler.GetBusinessAnalytics))
+	router.GET(version+"/business/exports/:dataset", EnvHandler.BusinessAuthentication(EnvHandler.ExportBusinessData))
+
+	// Review routes, anyone can read reviews
+	router.GET(version+"/reviews/business/:id", EnvHandler.GetBusinessReviews)
+	router.GET(version+"/reviews/deal/:id", EnvHandler.GetDealReviews)
+
+	// Admin routes, only for users with the admin role
+	router.GET(version+"/admin/users", EnvHandler.AdminAuthentication(EnvHandler.ListUsers))
+	router.GET(version+"/admin/users/:id", EnvHandler.AdminAuthentication(EnvHandler.GetUserAccount))
+	router.PUT(version+"/admin/users/:id/suspend", EnvHandler.AdminAuthentication(middleware.UrlDecode(EnvHandler.SuspendUser)))
+	router.PUT(version+"/admin/users/:id/reinstate", EnvHandler.AdminAuthentication(EnvHandler.ReinstateUser))
+	router.POST(version+"/admin/users/:id/logout", EnvHandler.AdminAuthentication(EnvHandler.LogoutUser))
+	router.GET(version+"/admin/businesses", EnvHandler.AdminAuthentication(EnvHandler.ListBusinesses))
+	router.GET(version+"/admin/businesses/:id", EnvHandler.AdminAuthentication(EnvHandler.GetBusinessAccount))
+	router.PUT(version+"/admin/businesses/:id/suspend", EnvHandler.AdminAuthentication(middleware.UrlDecode(EnvHandler.SuspendBusiness)))
+	router.PUT(version+"/admin/businesses/:id/reinstate", EnvHandler.AdminAuthentication(EnvHandler.ReinstateBusiness))
+	router.PUT(version+"/admin/businesses/:id/plan", EnvHandler.AdminAuthentication(middleware.UrlDecode(EnvHandler.SetBusinessPlan)))
+	router.POST(version+"/admin/businesses/:id/logout", EnvHandler.AdminAuthentication(EnvHandler.LogoutBusiness))
+	router.POST(version+"/admin/businesses/:id/impersonate", EnvHandler.AdminAuthentication(EnvHandler.ImpersonateBusiness))
+	router.PUT(version+"/admin/deals/:id", EnvHandler.AdminAuthentication(middleware.UrlDecode(EnvHandler.EditDeal)))
+	router.DELETE(version+"/admin/deals/:id", EnvHandler.AdminAuthentication(EnvHandler.RemoveDeal))
+	router.GET(version+"/admin/audit", EnvHandler.AdminAuthentication(EnvHandler.GetAuditLog))
+	router.GET(version+"/admin/moderation/cases", EnvHandler.AdminAuthentication(EnvHandler.GetModerationCases))
+	router.GET(version+"/admin/moderation/cases/:id", EnvHandler.AdminAuthentication(EnvHandler.GetModerationCase))
+	router.PUT(version+"/admin/moderation/cases/:id/approve", EnvHandler.AdminAuthentication(middleware.UrlDecode(EnvHandler.ApproveModerationCase)))
+	router.PUT(version+"/admin/moderation/cases/:id/reject", EnvHandler.AdminAuthentication(middleware.UrlDecode(EnvHandler.RejectModerationCase)))
+	router.PUT(version+"/admin/moderation/cases/:id/hide", EnvHandler.AdminAuthentication(middleware.UrlDecode(EnvHandler.HideModerationCase)))
+	router.GET(version+"/admin/verifications", EnvHandler.AdminAuthentication(EnvHandler.GetVerificationRequests))
+	router.GET(version+"/admin/verifications/:id", EnvHandler.AdminAuthentication(EnvHandler.GetVerificationRequest))
+	router.GET(version+"/admin/verifications/:id/document", EnvHandler.AdminAuthentication(EnvHandler.GetVerificationDocument))
+	router.PUT(version+"/admin/verifications/:id/approve", EnvHandler.AdminAuthentication(middleware.UrlDecode(EnvHandler.ApproveVerification)))
+	router.PUT(version+"/admin/verifications/:id/reject", EnvHandler.AdminAuthentication(middleware.UrlDecode(EnvHandler.RejectVerification)))
+	router.POST(version+"/admin/exports", EnvHandler.AdminAuthentication(middleware.UrlDecode(EnvHandler.CreateExport)))
+	router.GET(version+"/admin/exports", EnvHandler.AdminAuthentication(EnvHandler.GetExports))
+	router.GET(version+"/admin/exports/:id", EnvHandler.AdminAuthentication(EnvHandler.GetExport))
+	router.GET(version+"/admin/exports/:id/download", EnvHandler.AdminAuthentication(EnvHandler.DownloadExport))
+
+	// Real-time routes
+	router.GET(version+"/deals/feed", EnvHandler.DealFeed)
+	router.GET(version+"/business/dashboard/ws", EnvHandler.BusinessDashboardSocket) // authenticates itself, see the handler
+
+	// Webhook routes
+	router.POST(version+"/business/webhooks", EnvHandler.BusinessAuthentication(middleware.UrlDecode(EnvHandler.CreateWebhook)))
+	router.GET(version+"/business/webhooks", EnvHandler.BusinessAuthentication(EnvHandler.GetWebhooks))
+	router.PUT(version+"/business/webhooks/:id", EnvHandler.BusinessAuthentication(middleware.UrlDecode(EnvHandler.UpdateWebhook)))
+	router.DELETE(version+"/business/webhooks/:id", EnvHandler.BusinessAuthentication(EnvHandler.DeleteWebhook))
+	router.GET(version+"/business/webhooks/:id/deliveries", EnvHandler.BusinessAuthentication(EnvHandler.GetWebhookDeliveries))
+	router.POST(version+"/business/webhooks/:id/test", EnvHandler.BusinessAuthentication(EnvHandler.TestWebhook))
+
+	// Media routes (multipart uploads so no UrlDecode)
+	router.PUT(version+"/business/profile/logo", EnvHandler.BusinessAuthentication(EnvHandler.UploadBusinessLogo))
+	router.DELETE(version+"/business/profile/logo", EnvHandler.BusinessAuthentication(EnvHandler.DeleteBusinessLogo))
+	router.PUT(version+"/business/profile/cover", EnvHandler.BusinessAuthentication(EnvHandler.UploadBusinessCoverPhoto))
+	router.DELETE(version+"/business/profile/cover", EnvHandler.BusinessAuthentication(EnvHandler.DeleteBusinessCoverPhoto))
+	router.PUT(version+"/business/deal/image/:id", EnvHandler.BusinessAuthentication(EnvHandler.UploadDealImage))
+	router.DELETE(version+"/business/deal/image/:id", EnvHandler.BusinessAuthentication(EnvHandler.DeleteDealImage))
+	router.PUT(version+"/user/reviews/:id/photo", EnvHandler.Authentication(EnvHandler.UploadReviewPhoto))
+	router.DELETE(version+"/user/reviews/:id/photo", EnvHandler.Authentication(EnvHandler.DeleteReviewPhoto))
+	router.POST(version+"/business/verification/document", EnvHandler.BusinessAuthentication(EnvHandler.SubmitVerificationDocument))
+	router.GET(version+"/media/*filepath", EnvHandler.ServeMedia)
 
 	// Token routes
 	router.GET(version+"/token", EnvHandler.TokenRefresh)
 
 	c := cors.New(cors.Options{
-			AllowedOrigins: []string{"http://localhost:3000", "http://localhost:8081", "http://192.168.1.29:4444", "http://10.8.1.245:4444"}, 
+			AllowedOrigins: handlers.AllowedOrigins,
 			AllowedMethods: []string{"GET", "POST", "PUT", "DELETE"},
-			AllowedHeaders: []string{"Authorization", "Content-Type", "Cookie-Consent"},
-			ExposedHeaders: []string{"X-Auth-Token", "X-Refresh-Token"},
+			AllowedHeaders: []string{"Authorization", "Content-Type", "Cookie-Consent", "Last-Event-ID", "X-Request-Id"},
+			ExposedHeaders: []string{"X-Auth-Token", "X-Refresh-Token", "X-Request-Id"},
 			AllowCredentials: true,
 	})
 
-	return c.Handler(router)
+	return c.Handler(middleware.RequestID(router))
 }
\ No newline at end of file
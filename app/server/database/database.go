@@ -23,6 +23,7 @@ type Database struct {
 	client 	*mongo.Client
 	databaseName string
 	url     string
+	transactions bool // false on a standalone server, see [Database.WithTransaction]
 }
 
 // Datastore represents a store for the data (Database session)
@@ -40,21 +41,42 @@ func Connect() (*Database, error){
 
 	fmt.Print("Connecting to Host: ", mongoHost + "\n")
 
-	client, err := mongo.NewClient(options.Client().ApplyURI(mongoHost))
+	db, err := ConnectTo(mongoHost, databaseName)
 	if err != nil {
 		log.Fatal(err)
 		return nil, err
 	}
 
+	return db, nil
+}
+
+// ConnectTo connects to the mongodb server at url and uses the database with databaseName
+// returns a Database struct or an error
+func ConnectTo(url string, databaseName string) (*Database, error){
+	client, err := mongo.NewClient(options.Client().ApplyURI(url))
+	if err != nil {
+		return nil, err
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	err = client.Connect(ctx)
 	if err != nil {
-		log.Fatal(err)
 		return nil, err
 	}
 
-	return &Database{client: client, databaseName: databaseName}, nil
+	db := &Database{client: client, databaseName: databaseName}
+	db.transactions = db.supportsTransactions(ctx)
+	if !db.transactions {
+		log.Println("MongoDB does not support transactions (not a replica set), changes and their events are written without one")
+	}
+
+	return db, nil
+}
+
+// Drop deletes the database with all its collections, used to clean up after tests
+func (d *Database) Drop(ctx context.Context) error {
+	return d.client.Database(d.databaseName).Drop(ctx)
 }
 
 // Close will disconnect the database client connection
@@ -92,4 +114,242 @@ func (d *Database) GetBusinesses() model.Collection{
 func (d *Database) GetDeals() model.Collection{
 	log.Println("Retrieving Deals collection")
 	return GetMongoCollection(d.client.Database(d.databaseName).Collection("deals"))
-}
\ No newline at end of file
+}
+
+//	GetRedemptions gets the redemptions collection from the mongo database
+//	returns the redemptions collection
+func (d *Database) GetRedemptions() model.Collection{
+	log.Println("Retrieving Redemptions collection")
+	return GetMongoCollection(d.client.Database(d.databaseName).Collection("redemptions"))
+}
+
+//	GetJobLeases gets the job leases collection from the mongo database
+//	returns the job leases collection
+func (d *Database) GetJobLeases() model.Collection{
+	log.Println("Retrieving Job Leases collection")
+	return GetMongoCollection(d.client.Database(d.databaseName).Collection("job_leases"))
+}
+
+//	GetJobRuns gets the job runs collection from the mongo database
+//	returns the job runs collection
+func (d *Database) GetJobRuns() model.Collection{
+	log.Println("Retrieving Job Runs collection")
+	return GetMongoCollection(d.client.Database(d.databaseName).Collection("job_runs"))
+}
+
+//	GetTasks gets the task queue collection from the mongo database
+//	returns the tasks collection
+func (d *Database) GetTasks() model.Collection{
+	log.Println("Retrieving Tasks collection")
+	return GetMongoCollection(d.client.Database(d.databaseName).Collection("tasks"))
+}
+
+//	GetDeadTasks gets the dead letter collection of the task queue from the mongo database
+//	returns the dead tasks collection
+func (d *Database) GetDeadTasks() model.Collection{
+	log.Println("Retrieving Dead Tasks collection")
+	return GetMongoCollection(d.client.Database(d.databaseName).Collection("dead_tasks"))
+}
+
+//	GetWebhooks gets the webhook subscriptions collection from the mongo database
+//	returns the webhooks collection
+func (d *Database) GetWebhooks() model.Collection{
+	log.Println("Retrieving Webhooks collection")
+	return GetMongoCollection(d.client.Database(d.databaseName).Collection("webhooks"))
+}
+
+//	GetWebhookDeliveries gets the webhook delivery log collection from the mongo database
+//	returns the webhook deliveries collection
+func (d *Database) GetWebhookDeliveries() model.Collection{
+	log.Println("Retrieving Webhook Deliveries collection")
+	return GetMongoCollection(d.client.Database(d.databaseName).Collection("webhook_deliveries"))
+}
+
+//	GetOutbox gets the domain event outbox collection from the mongo database
+//	returns the outbox collection
+func (d *Database) GetOutbox() model.Collection{
+	log.Println("Retrieving Outbox collection")
+	return GetMongoCollection(d.client.Database(d.databaseName).Collection("outbox"))
+}
+
+//	GetFavorites gets the favorite deals collection from the mongo database
+//	returns the favorites collection
+func (d *Database) GetFavorites() model.Collection{
+	log.Println("Retrieving Favorites collection")
+	return GetMongoCollection(d.client.Database(d.databaseName).Collection("favorites"))
+}
+
+//	GetFollows gets the followed businesses collection from the mongo database
+//	returns the follows collection
+func (d *Database) GetFollows() model.Collection{
+	log.Println("Retrieving Follows collection")
+	return GetMongoCollection(d.client.Database(d.databaseName).Collection("follows"))
+}
+
+//	GetDevices gets the push notification devices collection from the mongo database
+//	returns the devices collection
+func (d *Database) GetDevices() model.Collection{
+	log.Println("Retrieving Devices collection")
+	return GetMongoCollection(d.client.Database(d.databaseName).Collection("devices"))
+}
+
+//	GetNotifications gets the push notifications collection from the mongo database
+//	returns the notifications collection
+func (d *Database) GetNotifications() model.Collection{
+	log.Println("Retrieving Notifications collection")
+	return GetMongoCollection(d.client.Database(d.databaseName).Collection("notifications"))
+}
+
+//	GetSavedSearches gets the saved searches collection from the mongo database
+//	returns the saved searches collection
+func (d *Database) GetSavedSearches() model.Collection{
+	log.Println("Retrieving Saved Searches collection")
+	return GetMongoCollection(d.client.Database(d.databaseName).Collection("saved_searches"))
+}
+
+//	GetSavedSearchMatches gets the deals that matched saved searches from the mongo database
+//	returns the saved search matches collection
+func (d *Database) GetSavedSearchMatches() model.Collection{
+	log.Println("Retrieving Saved Search Matches collection")
+	return GetMongoCollection(d.client.Database(d.databaseName).Collection("saved_search_matches"))
+}
+
+//	GetReviews gets the reviews collection from the mongo database
+//	returns the reviews collection
+func (d *Database) GetReviews() model.Collection{
+	log.Println("Retrieving Reviews collection")
+	return GetMongoCollection(d.client.Database(d.databaseName).Collection("reviews"))
+}
+
+//	GetModerationCases gets the moderation queue from the mongo database
+//	returns the moderation cases collection
+func (d *Database) GetModerationCases() model.Collection{
+	log.Println("Retrieving Moderation Cases collection")
+	return GetMongoCollection(d.client.Database(d.databaseName).Collection("moderation_cases"))
+}
+
+//	GetReports gets the content reports of users from the mongo database
+//	returns the reports collection
+func (d *Database) GetReports() model.Collection{
+	log.Println("Retrieving Reports collection")
+	return GetMongoCollection(d.client.Database(d.databaseName).Collection("reports"))
+}
+
+//	GetAuditLog gets the audit log from the mongo database
+//	returns the audit log collection
+func (d *Database) GetAuditLog() model.Collection{
+	log.Println("Retrieving Audit Log collection")
+	return GetMongoCollection(d.client.Database(d.databaseName).Collection("audit_log"))
+}
+
+//	GetVerificationRequests gets the business verification requests from the mongo database
+//	returns the verification requests collection
+func (d *Database) GetVerificationRequests() model.Collection{
+	log.Println("Retrieving Verification Requests collection")
+	return GetMongoCollection(d.client.Database(d.databaseName).Collection("verification_requests"))
+}
+
+//	GetPlanUsage gets the monthly plan usage of businesses from the mongo database
+//	returns the plan usage collection
+func (d *Database) GetPlanUsage() model.Collection{
+	log.Println("Retrieving Plan Usage collection")
+	return GetMongoCollection(d.client.Database(d.databaseName).Collection("plan_usage"))
+}
+
+//	GetPlanReservations gets the plan limits businesses are adding to right now from the mongo database
+//	returns the plan reservations collection
+func (d *Database) GetPlanReservations() model.Collection{
+	log.Println("Retrieving Plan Reservations collection")
+	return GetMongoCollection(d.client.Database(d.databaseName).Collection("plan_reservations"))
+}
+
+//	GetBillingCustomers gets the payment provider customers of businesses from the mongo database
+//	returns the billing customers collection
+func (d *Database) GetBillingCustomers() model.Collection{
+	log.Println("Retrieving Billing Customers collection")
+	return GetMongoCollection(d.client.Database(d.databaseName).Collection("billing_customers"))
+}
+
+//	GetSubscriptions gets the plan subscriptions of businesses from the mongo database
+//	returns the subscriptions collection
+func (d *Database) GetSubscriptions() model.Collection{
+	log.Println("Retrieving Subscriptions collection")
+	return GetMongoCollection(d.client.Database(d.databaseName).Collection("subscriptions"))
+}
+
+//	GetInvoices gets the invoices of subscriptions from the mongo database
+//	returns the invoices collection
+func (d *Database) GetInvoices() model.Collection{
+	log.Println("Retrieving Invoices collection")
+	return GetMongoCollection(d.client.Database(d.databaseName).Collection("invoices"))
+}
+
+//	GetBillingEvents gets the handled payment provider events from the mongo database
+//	returns the billing events collection
+func (d *Database) GetBillingEvents() model.Collection{
+	log.Println("Retrieving Billing Events collection")
+	return GetMongoCollection(d.client.Database(d.databaseName).Collection("billing_events"))
+}
+
+//	GetPromotions gets the sponsored deal promotions of businesses from the mongo database
+//	returns the promotions collection
+func (d *Database) GetPromotions() model.Collection{
+	log.Println("Retrieving Promotions collection")
+	return GetMongoCollection(d.client.Database(d.databaseName).Collection("promotions"))
+}
+
+//	GetPromotionStats gets the daily impressions, clicks and spend of promotions from the mongo database
+//	returns the promotion stats collection
+func (d *Database) GetPromotionStats() model.Collection{
+	log.Println("Retrieving Promotion Stats collection")
+	return GetMongoCollection(d.client.Database(d.databaseName).Collection("promotion_stats"))
+}
+
+//	GetPromotionClicks gets the clicks on promotions charged today from the mongo database
+//	returns the promotion clicks collection
+func (d *Database) GetPromotionClicks() model.Collection{
+	log.Println("Retrieving Promotion Clicks collection")
+	return GetMongoCollection(d.client.Database(d.databaseName).Collection("promotion_clicks"))
+}
+
+//	GetAnalyticsEvents gets the deal analytics events that are rolled up by a job from the mongo database
+//	returns the analytics events collection
+func (d *Database) GetAnalyticsEvents() model.Collection{
+	log.Println("Retrieving Analytics Events collection")
+	return GetMongoCollection(d.client.Database(d.databaseName).Collection("analytics_events"))
+}
+
+//	GetAnalyticsHourly gets the hourly deal analytics rollups from the mongo database
+//	returns the hourly analytics collection
+func (d *Database) GetAnalyticsHourly() model.Collection{
+	log.Println("Retrieving Analytics Hourly collection")
+	return GetMongoCollection(d.client.Database(d.databaseName).Collection("analytics_hourly"))
+}
+
+//	GetAnalyticsDaily gets the daily deal analytics rollups from the mongo database
+//	returns the daily analytics collection
+func (d *Database) GetAnalyticsDaily() model.Collection{
+	log.Println("Retrieving Analytics Daily collection")
+	return GetMongoCollection(d.client.Database(d.databaseName).Collection("analytics_daily"))
+}
+
+//	GetExports gets the export jobs of the admins from the mongo database
+//	returns the exports collection
+func (d *Database) GetExports() model.Collection{
+	log.Println("Retrieving Exports collection")
+	return GetMongoCollection(d.client.Database(d.databaseName).Collection("exports"))
+}
+
+//	GetClaimCounters gets the counters of the claims a user made of a deal each day from the mongo database
+//	returns the claim counters collection
+func (d *Database) GetClaimCounters() model.Collection{
+	log.Println("Retrieving Claim Counters collection")
+	return GetMongoCollection(d.client.Database(d.databaseName).Collection("claim_counters"))
+}
+
+//	GetMigrations gets the migrations that ran once from the mongo database
+//	returns the migrations collection
+func (d *Database) GetMigrations() model.Collection{
+	log.Println("Retrieving Migrations collection")
+	return GetMongoCollection(d.client.Database(d.databaseName).Collection("migrations"))
+}
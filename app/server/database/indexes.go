@@ -0,0 +1,212 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// indexes lists the indexes each collection needs
+// creating an index that already exists does nothing so this is safe to run on every start
+var indexes = map[string][]mongo.IndexModel{
+	"businesses": {
+		{Keys: bson.D{{Key: "location", Value: "2dsphere"}}},
+	},
+	"deals": {
+		{Keys: bson.D{{Key: "business_id", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "end_date", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "publish_at", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "published_at", Value: 1}}},
+	},
+	"redemptions": {
+		{Keys: bson.D{{Key: "business_id", Value: 1}, {Key: "code", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "deal_id", Value: 1}, {Key: "claimed_at", Value: -1}}},
+		// exports of the redemptions of a business in a date range
+		{Keys: bson.D{{Key: "business_id", Value: 1}, {Key: "claimed_at", Value: 1}}},
+	},
+	"claim_counters": {
+		// a user has one counter of their claims of a deal a day
+		{Keys: bson.D{{Key: "deal_id", Value: 1}, {Key: "user_id", Value: 1}, {Key: "day", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "day", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(2 * 24 * 60 * 60)},
+	},
+	"favorites": {
+		// a user favorites a deal once
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "deal_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "business_id", Value: 1}}},
+	},
+	"follows": {
+		// a user follows a business once
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "business_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "business_id", Value: 1}}},
+	},
+	"devices": {
+		{Keys: bson.D{{Key: "token", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+	},
+	"notifications": {
+		// a user gets each notification once
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "dedup_key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		// notifications are kept for 30 days
+		{Keys: bson.D{{Key: "created_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(30 * 24 * 60 * 60)},
+	},
+	"saved_searches": {
+		{Keys: bson.D{{Key: "location", Value: "2dsphere"}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	},
+	"saved_search_matches": {
+		// a deal matches a search once
+		{Keys: bson.D{{Key: "search_id", Value: 1}, {Key: "deal_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "search_id", Value: 1}, {Key: "matched_at", Value: -1}}},
+		{Keys: bson.D{{Key: "notified", Value: 1}}},
+	},
+	"reviews": {
+		// a user reviews a business or a deal once
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "target_type", Value: 1}, {Key: "target_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "target_type", Value: 1}, {Key: "target_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "business_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	},
+	"moderation_cases": {
+		// content has at most one open case
+		{Keys: bson.D{{Key: "target_type", Value: 1}, {Key: "target_id", Value: 1}}, Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"status": "open"})},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}}},
+	},
+	"reports": {
+		// a user reports a piece of content once
+		{Keys: bson.D{{Key: "reporter_id", Value: 1}, {Key: "target_type", Value: 1}, {Key: "target_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "case_id", Value: 1}}},
+	},
+	"audit_log": {
+		// time ranges of the whole log and pruning old entries
+		{Keys: bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "business_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "actor_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "target_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	},
+	"verification_requests": {
+		{Keys: bson.D{{Key: "business_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		// the admin queue
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}}},
+	},
+	"plan_usage": {
+		// a business has one usage document a month
+		{Keys: bson.D{{Key: "business_id", Value: 1}, {Key: "period", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+	"billing_customers": {
+		// a business is one customer at each provider
+		{Keys: bson.D{{Key: "business_id", Value: 1}, {Key: "provider", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "provider", Value: 1}, {Key: "provider_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+	"subscriptions": {
+		{Keys: bson.D{{Key: "provider_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "business_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		// past due subscriptions whose grace period runs out
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "grace_until", Value: 1}}},
+	},
+	"invoices": {
+		{Keys: bson.D{{Key: "provider_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "business_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	},
+	"billing_events": {
+		{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		// handled events are remembered for 30 days, providers stop redelivering long before that
+		{Keys: bson.D{{Key: "processed_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(30 * 24 * 60 * 60)},
+	},
+	"promotions": {
+		{Keys: bson.D{{Key: "location", Value: "2dsphere"}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "last_served_at", Value: 1}}},
+		{Keys: bson.D{{Key: "business_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	},
+	"promotion_stats": {
+		// a promotion has one stats document a day
+		{Keys: bson.D{{Key: "promotion_id", Value: 1}, {Key: "day", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+	"promotion_clicks": {
+		// a visitor is charged for one click on a promotion a day
+		{Keys: bson.D{{Key: "promotion_id", Value: 1}, {Key: "visitor", Value: 1}, {Key: "day", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "created_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(2 * 24 * 60 * 60)},
+	},
+	"analytics_events": {
+		{Keys: bson.D{{Key: "rolled_up", Value: 1}, {Key: "_id", Value: 1}}},
+		// claims and redemptions are recorded once per domain event
+		{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+		// events are rolled up within minutes, they are kept for a week
+		{Keys: bson.D{{Key: "occurred_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(7 * 24 * 60 * 60)},
+	},
+	"analytics_hourly": {
+		{Keys: bson.D{{Key: "business_id", Value: 1}, {Key: "deal_id", Value: 1}, {Key: "start", Value: 1}, {Key: "band", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "business_id", Value: 1}, {Key: "start", Value: 1}}},
+		// hourly rollups are kept for 40 days, the daily ones for good
+		{Keys: bson.D{{Key: "start", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(40 * 24 * 60 * 60)},
+	},
+	"analytics_daily": {
+		{Keys: bson.D{{Key: "business_id", Value: 1}, {Key: "deal_id", Value: 1}, {Key: "start", Value: 1}, {Key: "band", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "business_id", Value: 1}, {Key: "start", Value: 1}}},
+	},
+	"exports": {
+		{Keys: bson.D{{Key: "created_at", Value: -1}}},
+	},
+	"tasks": {
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "run_at", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "leased_until", Value: 1}}},
+		{Keys: bson.D{{Key: "idempotency_key", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+		// finished tasks are kept for a week
+		{Keys: bson.D{{Key: "completed_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(7 * 24 * 60 * 60)},
+	},
+	"webhooks": {
+		{Keys: bson.D{{Key: "business_id", Value: 1}}},
+	},
+	"webhook_deliveries": {
+		{Keys: bson.D{{Key: "subscription_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		// an event is only delivered once to each subscription
+		{Keys: bson.D{{Key: "subscription_id", Value: 1}, {Key: "event_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		// delivery logs are kept for 30 days
+		{Keys: bson.D{{Key: "created_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(30 * 24 * 60 * 60)},
+	},
+	"outbox": {
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "available_at", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "leased_until", Value: 1}}},
+		// dispatched events are kept for a week
+		{Keys: bson.D{{Key: "dispatched_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(7 * 24 * 60 * 60)},
+	},
+	"job_runs": {
+		{Keys: bson.D{{Key: "job", Value: 1}, {Key: "started_at", Value: -1}}},
+		// job history is kept for 30 days
+		{Keys: bson.D{{Key: "started_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(30 * 24 * 60 * 60)},
+	},
+}
+
+// EnsureIndexes creates the indexes the server relies on
+func (d *Database) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// one index that can not be created does not keep the others from being created
+	var firstErr error
+	failed := 0
+	for collection, models := range indexes {
+		for _, model := range models {
+			_, err := d.client.Database(d.databaseName).Collection(collection).Indexes().CreateOne(ctx, model)
+			if err != nil {
+				log.Println("Index on " + collection + " was not created: " + err.Error())
+				if firstErr == nil {
+					firstErr = err
+				}
+				failed++
+			}
+		}
+	}
+	if firstErr != nil {
+		return fmt.Errorf("%d indexes were not created, the first error: %w", failed, firstErr)
+	}
+
+	log.Println("Database indexes are ready")
+	return nil
+}
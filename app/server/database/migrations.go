@@ -0,0 +1,168 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+)
+
+// migrations change documents stored in an older shape into the current one
+// a migration only matches documents that still have the old shape so this is safe to run on every start,
+// unless it is run once: those can not tell old documents from new ones and are recorded when they ran
+var migrations = []struct {
+	name string
+	once bool
+	run  func(ctx context.Context, d *Database) (int, error)
+}{
+	{"claim limit totals to deal quantities", false, migrateClaimLimitTotals},
+	{"deal reviews out of business ratings", true, migrateDealRatings},
+}
+
+// Migrate runs the migrations, it is run after [Database.EnsureIndexes]
+func (d *Database) Migrate() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	applied := d.GetMigrations()
+	for _, migration := range migrations {
+		if migration.once {
+			err := applied.FindOne(&bson.M{}, ctx, bson.M{"_id": migration.name})
+			if err == nil {
+				continue
+			}
+			if !errors.Is(err, mongo.ErrNoDocuments) {
+				return err
+			}
+		}
+		changed, err := migration.run(ctx, d)
+		if err != nil {
+			return fmt.Errorf("migration of %s failed: %w", migration.name, err)
+		}
+		if changed > 0 {
+			log.Printf("Migrated %d documents: %s", changed, migration.name)
+		}
+		if migration.once {
+			if _, err := applied.InsertOne(ctx, bson.M{"_id": migration.name, "applied_at": time.Now().UTC()}); err != nil && !mongo.IsDuplicateKeyError(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// migrateClaimLimitTotals moves the total claim limit of deals into the quantity of the deal. What remains is the
+// total less the claims already made, a deal that also has a quantity keeps its quantity
+func migrateClaimLimitTotals(ctx context.Context, d *Database) (int, error) {
+	deals := d.GetDeals()
+	cursor, err := deals.Find(ctx, bson.M{"claim_limits.total": bson.M{"$exists": true}})
+	if err != nil {
+		return 0, err
+	}
+	var legacy []struct {
+		ID           primitive.ObjectID `bson:"_id"`
+		Quantity     *int               `bson:"quantity"`
+		Claim_limits struct {
+			Total            int  `bson:"total"`
+			Per_user_per_day *int `bson:"per_user_per_day"`
+		} `bson:"claim_limits"`
+	}
+	if err := cursor.All(ctx, &legacy); err != nil {
+		return 0, err
+	}
+
+	redemptions := d.GetRedemptions()
+	changed := 0
+	for _, deal := range legacy {
+		if deal.Quantity == nil {
+			claimed, err := redemptions.CountDocuments(ctx, bson.M{"deal_id": deal.ID})
+			if err != nil {
+				return changed, err
+			}
+			remaining := deal.Claim_limits.Total - int(claimed)
+			if remaining < 0 {
+				remaining = 0
+			}
+			_, err = deals.UpdateOne(ctx,
+				bson.M{"_id": deal.ID, "quantity": bson.M{"$exists": false}},
+				bson.M{"$set": bson.M{"quantity": deal.Claim_limits.Total, "remaining": remaining}},
+			)
+			if err != nil {
+				return changed, err
+			}
+		}
+
+		unset := bson.M{"claim_limits.total": ""}
+		if deal.Claim_limits.Per_user_per_day == nil {
+			unset = bson.M{"claim_limits": ""}
+		}
+		if _, err := deals.UpdateOne(ctx, bson.M{"_id": deal.ID}, bson.M{"$unset": unset}); err != nil {
+			return changed, err
+		}
+		changed++
+	}
+	return changed, nil
+}
+
+// migrateDealRatings counts the reviews of deals towards the rating of the deal instead of the rating of its
+// business, the ratings of businesses are counted again from the public reviews of the business alone
+func migrateDealRatings(ctx context.Context, d *Database) (int, error) {
+	cursor, err := d.GetReviews().Find(ctx, bson.M{})
+	if err != nil {
+		return 0, err
+	}
+	var reviews []*model.Review
+	if err := cursor.All(ctx, &reviews); err != nil {
+		return 0, err
+	}
+	businessRatings := map[primitive.ObjectID]*model.BusinessRating{}
+	dealRatings := map[primitive.ObjectID]*model.BusinessRating{}
+	for _, review := range reviews {
+		ratings := businessRatings
+		if review.Target_type == model.ReviewTargetDeal {
+			ratings = dealRatings
+		}
+		sum, count := review.RatingContribution()
+		if count == 0 {
+			continue
+		}
+		if ratings[review.Target_id] == nil {
+			ratings[review.Target_id] = &model.BusinessRating{}
+		}
+		ratings[review.Target_id].Sum += sum
+		ratings[review.Target_id].Count += count
+	}
+
+	changed := 0
+	for _, set := range []struct {
+		collection model.Collection
+		ratings    map[primitive.ObjectID]*model.BusinessRating
+	}{{d.GetBusinesses(), businessRatings}, {d.GetDeals(), dealRatings}} {
+		rated := bson.A{}
+		for id, rating := range set.ratings {
+			result, err := set.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"rating": rating}})
+			if err != nil {
+				return changed, err
+			}
+			changed += int(result.ModifiedCount)
+			rated = append(rated, id)
+		}
+		// what is left only had reviews of its deals
+		result, err := set.collection.UpdateMany(ctx,
+			bson.M{"_id": bson.M{"$nin": rated}, "rating": bson.M{"$exists": true}},
+			bson.M{"$unset": bson.M{"rating": ""}},
+		)
+		if err != nil {
+			return changed, err
+		}
+		changed += int(result.ModifiedCount)
+	}
+	return changed, nil
+}
@@ -26,13 +26,21 @@ func (c MongoCollection) UpdateOne(ctx context.Context, filter interface{}, upda
 	return c.Collection.UpdateOne(ctx, filter, update, opts...)
 }
 
+// Makes a call to mongodb to update many documents
+func (c MongoCollection) UpdateMany(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions)(*mongo.UpdateResult, error){
+	return c.Collection.UpdateMany(ctx, filter, update, opts...)
+}
+
 // Makes a call to mongodb to insert one document
 func (c MongoCollection) InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (primitive.ObjectID, error){
 	result, err := c.Collection.InsertOne(ctx, document, opts...)
-	// TODO check error and return if there is one here
-	newID := result.InsertedID
+	// there is no result on a write error, e.g. a duplicate key
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
 	// If this ever changes in mongo to not be an objectID this will break
-	return newID.(primitive.ObjectID), err
+	newID, _ := result.InsertedID.(primitive.ObjectID)
+	return newID, nil
 }
 
 // Makes a call to mongodb to find one document
@@ -41,9 +49,15 @@ func (c MongoCollection) FindOne(doc interface{}, ctx context.Context, filter in
 	return err
 }
 
+// Makes a call to mongodb to find one document and update it atomically
+func (c MongoCollection) FindOneAndUpdate(doc interface{}, ctx context.Context, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) error{
+	err := c.Collection.FindOneAndUpdate(ctx, filter, update, opts...).Decode(doc)
+	return err
+}
+
 // Makes a call to mongodb to find documents
-func (c MongoCollection) Find(ctx context.Context, filter interface{},opts ...*options.FindOneOptions) (*mongo.Cursor, error){
-	cursor, err := c.Collection.Find(ctx, filter)
+func (c MongoCollection) Find(ctx context.Context, filter interface{},opts ...*options.FindOptions) (*mongo.Cursor, error){
+	cursor, err := c.Collection.Find(ctx, filter, opts...)
 	return cursor, err
 }
 
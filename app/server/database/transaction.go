@@ -0,0 +1,45 @@
+package database
+
+import (
+	"context"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// supportsTransactions asks the server if it is part of a replica set or a sharded cluster.
+// A standalone mongod (the usual local install) can not run transactions
+func (d *Database) supportsTransactions(ctx context.Context) bool {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := d.client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		log.Println("Could not check if transactions are supported: " + err.Error())
+		return false
+	}
+	return hello.SetName != "" || hello.Msg == "isdbgrid"
+}
+
+// WithTransaction runs fn in a transaction, every collection call made with the ctx given to fn is part of it.
+// fn may be run more than once when the transaction hits a transient error so it must not have side effects outside the database.
+//
+// On a standalone server fn runs without a transaction, this is only meant for local development
+func (d *Database) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !d.transactions {
+		return fn(ctx)
+	}
+
+	session, err := d.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(context.Background())
+
+	_, err = session.WithTransaction(ctx, func(sessionCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessionCtx)
+	})
+	return err
+}
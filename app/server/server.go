@@ -1,11 +1,29 @@
 package server
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"strconv"
+	"time"
+	"github.com/CoffeeHausGames/whir-server/app/analytics"
+	"github.com/CoffeeHausGames/whir-server/app/billing"
+	"github.com/CoffeeHausGames/whir-server/app/events"
+	"github.com/CoffeeHausGames/whir-server/app/export"
+	"github.com/CoffeeHausGames/whir-server/app/jobs"
+	"github.com/CoffeeHausGames/whir-server/app/moderation"
+	"github.com/CoffeeHausGames/whir-server/app/notifications"
+	"github.com/CoffeeHausGames/whir-server/app/plans"
+	"github.com/CoffeeHausGames/whir-server/app/promotions"
+	"github.com/CoffeeHausGames/whir-server/app/queue"
+	"github.com/CoffeeHausGames/whir-server/app/realtime"
 	"github.com/CoffeeHausGames/whir-server/app/router"
+	"github.com/CoffeeHausGames/whir-server/app/router/handlers"
 	"github.com/CoffeeHausGames/whir-server/app/server/database"
+	"github.com/CoffeeHausGames/whir-server/app/storage"
+	"github.com/CoffeeHausGames/whir-server/app/tasks"
+	"github.com/CoffeeHausGames/whir-server/app/verification"
+	"github.com/CoffeeHausGames/whir-server/app/webhooks"
 )
 
 /**TODO:
@@ -36,7 +54,7 @@ type Server struct {
 
 // Start fires a listener and starts the server on the specified port
 // using HTTPS if [Server.UseHTTPS] is true else it uses HTTP
-// It creates the database connection
+// It creates the database connection and starts the background jobs
 func (s *Server) Start() {
 	var db *database.Database
 	var err error
@@ -47,9 +65,140 @@ func (s *Server) Start() {
 		log.Fatal(err) //TODO: panic and recover
 	}
 	defer db.Close()
+
+	if err = db.EnsureIndexes(); err != nil {
+		log.Println(err)
+	}
+	if err = db.Migrate(); err != nil {
+		log.Println(err)
+	}
+
+	// background work stops when the server does
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	taskQueue := queue.New(db.GetTasks(), db.GetDeadTasks())
+
+	store, err := storage.NewFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// verification documents and exports are kept where the media route can not reach them
+	privateStore, err := storage.NewPrivateFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	notifier, err := notifications.NewFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	mailer, err := notifications.NewMailerFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	pushNotifications := notifications.NewService(db, taskQueue, notifier, mailer)
+
+	screener, err := moderation.NewScreenerFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	contentModeration := moderation.NewService(db, screener)
+
+	catalog, err := plans.CatalogFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	businessPlans := plans.NewService(db, catalog)
+
+	paymentProvider, err := billing.NewProviderFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	billingConfig, err := billing.ConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	subscriptions := billing.NewService(db, paymentProvider, catalog, billingConfig)
+
+	promotionPricing, err := promotions.PricingFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	sponsoredDeals := promotions.NewService(db, promotionPricing)
+	dealAnalytics := analytics.NewService(db)
+	dataExports := export.NewService(db, privateStore, taskQueue)
+
+	scheduler := jobs.NewScheduler(db.GetJobLeases(), db.GetJobRuns())
+	if err = jobs.RegisterDealJobs(scheduler, db); err != nil {
+		log.Fatal(err)
+	}
+	if err = jobs.RegisterNotificationJobs(scheduler, pushNotifications); err != nil {
+		log.Fatal(err)
+	}
+	auditRetention, err := jobs.AuditRetentionFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = jobs.RegisterAuditJobs(scheduler, db, auditRetention); err != nil {
+		log.Fatal(err)
+	}
+	if err = jobs.RegisterBillingJobs(scheduler, subscriptions); err != nil {
+		log.Fatal(err)
+	}
+	if err = jobs.RegisterAnalyticsJobs(scheduler, dealAnalytics); err != nil {
+		log.Fatal(err)
+	}
+	if err = jobs.RegisterExportJobs(scheduler, dataExports); err != nil {
+		log.Fatal(err)
+	}
+	scheduler.Start(ctx)
+
+	workers := queue.NewPool(taskQueue, 4, 2*time.Minute)
+	tasks.Register(workers, db)
+	pushNotifications.Register(workers)
+	workers.Start(ctx)
+
+	// exports can take a while so they get a worker of their own with a longer lease
+	exportWorkers := queue.NewPool(taskQueue, 1, 30*time.Minute)
+	dataExports.Register(exportWorkers)
+	exportWorkers.Start(ctx)
+
+	// domain events recorded by the handlers are fanned out from the outbox
+	dispatcher := events.NewDispatcher(db.GetOutbox())
+	webhookPublisher := webhooks.NewPublisher(db.GetWebhooks(), db.GetWebhookDeliveries(), taskQueue)
+	dispatcher.Subscribe("webhooks", events.AllEvents, webhookPublisher.HandleEvent)
+	dispatcher.Subscribe("notifications", events.AllEvents, pushNotifications.HandleEvent)
+	dispatcher.Subscribe("analytics", events.AllEvents, dealAnalytics.HandleEvent)
+	dispatcher.Start(ctx)
+
+	// every instance follows the outbox for the clients connected to it
+	dealFeed := realtime.NewDealFeed(db.GetOutbox(), db.GetBusinesses())
+	dealFeed.Start(ctx)
+	dashboardHub := realtime.NewBusinessHub(db.GetOutbox())
+	dashboardHub.Start(ctx)
+
+	caller, postcards, err := verification.NewSendersFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	businessVerification := verification.NewService(db, privateStore, caller, postcards)
 	
 	if s.Handler == nil {
-		s.Handler = router.GetRouter(db)
+		s.Handler = router.GetRouter(db, handlers.Services{
+			Storage:       store,
+			Queue:         taskQueue,
+			Feed:          dealFeed,
+			Dashboard:     dashboardHub,
+			Notifications: pushNotifications,
+			Moderation:    contentModeration,
+			Verification:  businessVerification,
+			Plans:         businessPlans,
+			Billing:       subscriptions,
+			Promotions:    sponsoredDeals,
+			Analytics:     dealAnalytics,
+			Exports:       dataExports,
+		})
 	}
 
 	if s.UseHTTPS {
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// storage is used to keep uploaded files (images) outside of the database
+// the Storage interface lets us use the local filesystem for development and
+// any S3 compatible service when deployed
+
+// ErrNotFound is returned when there is no object stored for a key
+var ErrNotFound = errors.New("object not found")
+
+// Storage represents a place to store blobs of data by a key
+type Storage interface {
+	// Put stores the data read from r under key replacing anything already there
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens the object stored under key, the caller must close it
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object stored under key, deleting a missing key is not an error
+	Delete(ctx context.Context, key string) error
+	// URL is the public URL a client can use to download the object
+	URL(key string) string
+}
+
+// NewFromEnv creates the [Storage] selected by the STORAGE_DRIVER env variable
+// defaults to local storage when nothing is set
+func NewFromEnv() (Storage, error) {
+	driver := strings.ToLower(os.Getenv("STORAGE_DRIVER"))
+
+	switch driver {
+	case "s3":
+		log.Println("Using S3 storage")
+		return NewS3Storage(S3Config{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Region:          os.Getenv("S3_REGION"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			PublicURL:       os.Getenv("S3_PUBLIC_URL"),
+		})
+	case "", "local":
+		root := os.Getenv("STORAGE_LOCAL_PATH")
+		if root == "" {
+			root = "uploads"
+		}
+		publicURL := os.Getenv("STORAGE_PUBLIC_URL")
+		if publicURL == "" {
+			publicURL = "http://localhost:4444/v1/media"
+		}
+		log.Println("Using local storage at " + root)
+		return NewLocalStorage(root, publicURL)
+	default:
+		return nil, errors.New("unknown STORAGE_DRIVER " + driver)
+	}
+}
+
+// NewPrivateFromEnv creates the [Storage] for files that are never served publicly, e.g. verification documents.
+// It uses the driver of [NewFromEnv] with its own place: STORAGE_PRIVATE_PATH (default private-uploads) for local
+// storage, which the /v1/media route does not serve, and S3_PRIVATE_BUCKET for S3, a bucket that must not be public
+func NewPrivateFromEnv() (Storage, error) {
+	driver := strings.ToLower(os.Getenv("STORAGE_DRIVER"))
+
+	switch driver {
+	case "s3":
+		bucket := os.Getenv("S3_PRIVATE_BUCKET")
+		if bucket == "" || bucket == os.Getenv("S3_BUCKET") {
+			return nil, errors.New("S3_PRIVATE_BUCKET must be set to a bucket other than S3_BUCKET")
+		}
+		log.Println("Using S3 private storage")
+		return NewS3Storage(S3Config{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Region:          os.Getenv("S3_REGION"),
+			Bucket:          bucket,
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		})
+	case "", "local":
+		root := os.Getenv("STORAGE_PRIVATE_PATH")
+		if root == "" {
+			root = "private-uploads"
+		}
+		public := os.Getenv("STORAGE_LOCAL_PATH")
+		if public == "" {
+			public = "uploads"
+		}
+		if within(root, public) {
+			return nil, errors.New("STORAGE_PRIVATE_PATH must not be inside STORAGE_LOCAL_PATH")
+		}
+		log.Println("Using local private storage at " + root)
+		// the files have no public URL
+		return NewLocalStorage(root, "")
+	default:
+		return nil, errors.New("unknown STORAGE_DRIVER " + driver)
+	}
+}
+
+// within checks if the directory dir is root or inside it
+func within(dir string, root string) bool {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return false
+	}
+	relative, err := filepath.Rel(root, dir)
+	return err == nil && relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator))
+}
+
+// cleanKey makes sure a key can not be used to escape the storage root
+func cleanKey(key string) (string, error) {
+	key = strings.TrimPrefix(key, "/")
+	if key == "" || strings.Contains(key, "..") || strings.Contains(key, "\\") {
+		return "", errors.New("invalid storage key")
+	}
+	return key, nil
+}
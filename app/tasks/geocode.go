@@ -0,0 +1,49 @@
+package tasks
+
+import (
+	"context"
+	"log"
+
+	"github.com/CoffeeHausGames/whir-server/app/helpers"
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/app/queue"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// geocodeBusiness looks up the coordinates of a business address and saves them as its location
+// so the business shows up in nearby searches
+func geocodeBusiness(businessCollection model.Collection) queue.Handler {
+	return func(ctx context.Context, task *model.Task) error {
+		var payload GeocodeBusinessPayload
+		if err := task.DecodePayload(&payload); err != nil {
+			return err
+		}
+
+		business := new(model.BusinessUser)
+		err := businessCollection.FindOne(business, ctx, bson.M{"_id": payload.Business_id})
+		if err != nil {
+			return err
+		}
+		if business.Address == nil {
+			return nil
+		}
+
+		lon, lat, err := helpers.RetrieveCoordinatesFromAddress(model.GetStreetAddress(business.Address))
+		if err != nil {
+			return err
+		}
+		if lon == 0 && lat == 0 {
+			// the address could not be found, trying again will not help
+			log.Println("No location found for business " + business.ID.Hex())
+			return nil
+		}
+
+		location := &model.Location{
+			Type:        "Point",
+			Coordinates: []float64{lon, lat},
+		}
+		_, err = businessCollection.UpdateOne(ctx, bson.M{"_id": business.ID}, bson.M{"$set": bson.M{"location": location}})
+		return err
+	}
+}
@@ -0,0 +1,47 @@
+package tasks
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/app/queue"
+	"github.com/CoffeeHausGames/whir-server/app/server/database"
+	"github.com/CoffeeHausGames/whir-server/app/webhooks"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// tasks holds the background work handlers hand off to the task queue
+
+// Task types
+const (
+	GeocodeBusiness = "geocode_business"
+)
+
+// Register adds the handler of every task type to the worker pool
+func Register(pool *queue.Pool, db *database.Database) {
+	pool.Handle(GeocodeBusiness, geocodeBusiness(db.GetBusinesses()))
+	pool.Handle(webhooks.DeliverTask, webhooks.NewDeliverer(db.GetWebhooks(), db.GetWebhookDeliveries()).Handler())
+}
+
+// GeocodeBusinessPayload is the payload of a [GeocodeBusiness] task
+type GeocodeBusinessPayload struct {
+	Business_id primitive.ObjectID `bson:"business_id"`
+}
+
+// EnqueueGeocodeBusiness queues finding the location of a business from its address.
+// The address is looked up once per change of the business, updatedAt is when it changed.
+// Keying on the address alone would skip changing back to an address that was looked up before
+func EnqueueGeocodeBusiness(ctx context.Context, q *queue.Queue, businessID primitive.ObjectID, address *model.Address, updatedAt time.Time) error {
+	sum := sha256.Sum256([]byte(model.GetStreetAddress(address)))
+	key := GeocodeBusiness + ":" + businessID.Hex() + ":" + hex.EncodeToString(sum[:8]) + ":" + strconv.FormatInt(updatedAt.UnixNano(), 10)
+
+	_, err := q.Enqueue(ctx, GeocodeBusiness, GeocodeBusinessPayload{Business_id: businessID}, queue.EnqueueOptions{
+		IdempotencyKey: key,
+	})
+	return err
+}
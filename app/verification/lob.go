@@ -0,0 +1,75 @@
+package verification
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+)
+
+const lobPostcardsURL = "https://api.lob.com/v1/postcards"
+
+// Lob mails verification postcards with the Lob print and mail API
+type Lob struct {
+	apiKey      string
+	fromAddress string // ID of a Lob address, optional for postcards within the US
+	client      *http.Client
+}
+
+// NewLob returns a [Lob] postcard sender
+func NewLob(apiKey string, fromAddress string) *Lob {
+	return &Lob{
+		apiKey:      apiKey,
+		fromAddress: fromAddress,
+		client:      &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// SendPostcard orders a postcard with the code to the address
+func (l *Lob) SendPostcard(ctx context.Context, businessName string, address *model.Address, code string) error {
+	postcard := map[string]interface{}{
+		"description": "Whir business verification",
+		"to": map[string]string{
+			"name":            businessName,
+			"address_line1":   address.Street,
+			"address_city":    address.City,
+			"address_state":   address.State,
+			"address_zip":     address.PostalCode,
+			"address_country": address.Country,
+		},
+		"front": "<html><body><h1>Whir</h1><p>Verify " + html.EscapeString(businessName) + "</p></body></html>",
+		"back": "<html><body><p>Your verification code is</p><h1>" + html.EscapeString(code) + "</h1>" +
+			"<p>Enter it in the Whir business app to verify your listing.</p></body></html>",
+	}
+	if l.fromAddress != "" {
+		postcard["from"] = l.fromAddress
+	}
+	body, err := json.Marshal(postcard)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, lobPostcardsURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(l.apiKey, "")
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	responseBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("Lob responded with %d: %s", resp.StatusCode, responseBody)
+}
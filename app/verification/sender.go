@@ -0,0 +1,79 @@
+package verification
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+)
+
+// Caller calls a phone number and reads out a verification code
+type Caller interface {
+	CallWithCode(ctx context.Context, phoneNumber string, code string) error
+}
+
+// PostcardSender mails a verification code to the address of a business
+type PostcardSender interface {
+	SendPostcard(ctx context.Context, businessName string, address *model.Address, code string) error
+}
+
+// LogSender writes codes to the server log instead of sending them, for development
+type LogSender struct{}
+
+// CallWithCode logs the call
+func (LogSender) CallWithCode(ctx context.Context, phoneNumber string, code string) error {
+	log.Printf("Verification call to %s: code %s\n", phoneNumber, code)
+	return nil
+}
+
+// SendPostcard logs the postcard
+func (LogSender) SendPostcard(ctx context.Context, businessName string, address *model.Address, code string) error {
+	log.Printf("Verification postcard to %s, %s: code %s\n", businessName, model.GetStreetAddress(address), code)
+	return nil
+}
+
+// NewSendersFromEnv returns the caller and postcard sender configured by the environment
+//
+//   - VERIFICATION_DRIVER - `log` (default) writes codes to the server log, `live` sends them
+//   - calls: TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN and TWILIO_FROM_NUMBER
+//   - postcards: LOB_API_KEY, LOB_FROM_ADDRESS is an optional Lob address ID for the return address
+//
+// With the live driver a channel that is not configured is logged instead
+func NewSendersFromEnv() (Caller, PostcardSender, error) {
+	driver := strings.ToLower(os.Getenv("VERIFICATION_DRIVER"))
+	switch driver {
+	case "", "log":
+		log.Println("Verification codes are written to the log")
+		return LogSender{}, LogSender{}, nil
+	case "live":
+	default:
+		return nil, nil, errors.New("unknown VERIFICATION_DRIVER " + driver)
+	}
+
+	var caller Caller = LogSender{}
+	if accountSID := os.Getenv("TWILIO_ACCOUNT_SID"); accountSID != "" {
+		twilio, err := NewTwilio(TwilioConfig{
+			AccountSID: accountSID,
+			AuthToken:  os.Getenv("TWILIO_AUTH_TOKEN"),
+			From:       os.Getenv("TWILIO_FROM_NUMBER"),
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		caller = twilio
+	} else {
+		log.Println("TWILIO_ACCOUNT_SID is not set, verification calls are written to the log")
+	}
+
+	var postcards PostcardSender = LogSender{}
+	if apiKey := os.Getenv("LOB_API_KEY"); apiKey != "" {
+		postcards = NewLob(apiKey, os.Getenv("LOB_FROM_ADDRESS"))
+	} else {
+		log.Println("LOB_API_KEY is not set, verification postcards are written to the log")
+	}
+
+	return caller, postcards, nil
+}
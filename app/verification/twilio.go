@@ -0,0 +1,64 @@
+package verification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TwilioConfig is the configuration of the Twilio account that places verification calls
+type TwilioConfig struct {
+	AccountSID string
+	AuthToken  string
+	From       string // the Twilio number calls come from, in E.164 format
+}
+
+// Twilio places verification calls with the Twilio Voice API
+type Twilio struct {
+	config TwilioConfig
+	url    string
+	client *http.Client
+}
+
+// NewTwilio returns a [Twilio] caller
+func NewTwilio(config TwilioConfig) (*Twilio, error) {
+	if config.AuthToken == "" || config.From == "" {
+		return nil, errors.New("Twilio needs TWILIO_AUTH_TOKEN and TWILIO_FROM_NUMBER")
+	}
+	return &Twilio{
+		config: config,
+		url:    "https://api.twilio.com/2010-04-01/Accounts/" + url.PathEscape(config.AccountSID) + "/Calls.json",
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// CallWithCode calls the number and reads the code out twice, digit by digit
+func (t *Twilio) CallWithCode(ctx context.Context, phoneNumber string, code string) error {
+	spoken := strings.Join(strings.Split(code, ""), ", ")
+	twiml := "<Response><Say>Your Whir verification code is " + spoken + ".</Say><Pause length=\"1\"/>" +
+		"<Say>Again, your code is " + spoken + ".</Say></Response>"
+
+	form := url.Values{"To": {phoneNumber}, "From": {t.config.From}, "Twiml": {twiml}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(t.config.AccountSID, t.config.AuthToken)
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusCreated || resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("Twilio responded with %d: %s", resp.StatusCode, body)
+}
@@ -0,0 +1,425 @@
+package verification
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/app/server/database"
+	"github.com/CoffeeHausGames/whir-server/app/storage"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// verification lets a business show that it is real and runs the listing it signed up with.
+//
+// A business sends evidence: a document, or a code it got in a call to its phone or on a postcard
+// mailed to its address. Once it sent evidence the business is pending until an admin approves it.
+// Approved businesses get the verified badge, changing the name or address of the listing takes it away.
+
+const (
+	// CodeLength is how many digits a phone or postcard code has
+	CodeLength = 6
+	// PhoneCodeTTL is how long a code read out in a call can be entered
+	PhoneCodeTTL = 15 * time.Minute
+	// PostcardCodeTTL is how long a mailed code can be entered, postcards take a while
+	PostcardCodeTTL = 30 * 24 * time.Hour
+	// MaxCodeAttempts is how often a wrong code can be entered before a new code is needed
+	MaxCodeAttempts = 5
+	// MaxCodesPerDay is how many calls and postcards a business can ask for in a day, both cost money
+	MaxCodesPerDay = 3
+	// DocumentKeyPrefix is where documents are kept in private storage
+	DocumentKeyPrefix = "private/verification/"
+)
+
+// Admin decisions on a verification request
+const (
+	ActionApprove = "approve"
+	ActionReject  = "reject"
+)
+
+var (
+	// ErrAlreadyVerified is returned when a verified business asks to be verified again
+	ErrAlreadyVerified = errors.New("the business is already verified")
+	// ErrTooManyCodes is returned when a business asked for more than [MaxCodesPerDay] codes
+	ErrTooManyCodes = errors.New("too many codes requested, try again tomorrow")
+	// ErrNoAddress is returned when a postcard is asked for by a business without a full address
+	ErrNoAddress = errors.New("the business needs a street, city and postal code to get a postcard")
+	// ErrNoPhoneNumber is returned when a call is asked for by a business without a phone number on its listing
+	ErrNoPhoneNumber = errors.New("the business needs a phone number on its listing to get a call")
+	// ErrUnsupportedDocument is returned for documents that are not a PDF, JPEG or PNG
+	ErrUnsupportedDocument = errors.New("documents must be a PDF, JPEG or PNG")
+	// ErrNoCodePending is returned when a code is entered but none was sent
+	ErrNoCodePending = errors.New("no verification code was sent")
+	// ErrWrongCode is returned when the code does not match
+	ErrWrongCode = errors.New("the verification code is wrong")
+	// ErrCodeExpired is returned when the code expired or was entered wrong too often
+	ErrCodeExpired = errors.New("the verification code expired, request a new one")
+	// ErrRequestNotFound is returned for an unknown verification request
+	ErrRequestNotFound = errors.New("verification request not found")
+	// ErrNotReviewable is returned when an admin decides on a request that is not waiting for a decision
+	ErrNotReviewable = errors.New("the verification request is not waiting for a decision")
+)
+
+// documentTypes are the documents accepted and the extension they are stored with
+var documentTypes = map[string]string{
+	"application/pdf": ".pdf",
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+}
+
+// openStatuses are the requests a business is still working on or an admin has to look at
+var openStatuses = bson.A{model.VerificationAwaitingCode, model.VerificationSubmitted}
+
+// Service runs the verification workflow
+type Service struct {
+	db         *database.Database
+	store      storage.Storage
+	caller     Caller
+	postcards  PostcardSender
+	requests   model.Collection
+	businesses model.Collection
+}
+
+// NewService returns a [Service] keeping documents in store and sending codes with caller and postcards
+func NewService(db *database.Database, store storage.Storage, caller Caller, postcards PostcardSender) *Service {
+	return &Service{
+		db:         db,
+		store:      store,
+		caller:     caller,
+		postcards:  postcards,
+		requests:   db.GetVerificationRequests(),
+		businesses: db.GetBusinesses(),
+	}
+}
+
+// Latest returns the newest verification request of the business, nil when it never sent one
+func (s *Service) Latest(ctx context.Context, businessID primitive.ObjectID) (*model.VerificationRequest, error) {
+	request := new(model.VerificationRequest)
+	err := s.requests.FindOne(request, ctx, bson.M{"business_id": businessID},
+		options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	return request, err
+}
+
+// Recorder runs in the transaction of a change to a verification request, e.g. to add it to the audit log
+type Recorder func(ctx context.Context, request *model.VerificationRequest) error
+
+// SubmitDocument stores the document and sends it to the admins, the business is pending from then on
+func (s *Service) SubmitDocument(ctx context.Context, business *model.BusinessUser, fileName string, data []byte, record Recorder) (*model.VerificationRequest, error) {
+	if business.IsVerified() {
+		return nil, ErrAlreadyVerified
+	}
+	contentType := http.DetectContentType(data)
+	extension, ok := documentTypes[contentType]
+	if !ok {
+		return nil, ErrUnsupportedDocument
+	}
+
+	now := time.Now().UTC()
+	request := &model.VerificationRequest{
+		ID:          primitive.NewObjectID(),
+		Business_id: business.ID,
+		Method:      model.EvidenceDocument,
+		Status:      model.VerificationSubmitted,
+		Document: &model.VerificationDocument{
+			Key:          DocumentKeyPrefix + business.ID.Hex() + "/" + primitive.NewObjectID().Hex() + extension,
+			File_name:    fileName,
+			Content_type: contentType,
+			Size:         int64(len(data)),
+			Uploaded_at:  now,
+		},
+		Created_at: now,
+		Updated_at: now,
+	}
+
+	err := s.store.Put(ctx, request.Document.Key, bytes.NewReader(data), int64(len(data)), contentType)
+	if err != nil {
+		return nil, err
+	}
+	err = s.db.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := s.replaceOpenRequest(ctx, request, model.BusinessVerificationPending); err != nil {
+			return err
+		}
+		return record(ctx, request)
+	})
+	if err != nil {
+		s.store.Delete(ctx, request.Document.Key)
+		return nil, err
+	}
+	return request, nil
+}
+
+// RequestPhoneCode calls the phone number on the listing of the business with a code it has to enter within
+// [PhoneCodeTTL]. Only the listing number is called, the code shows the business answers the phone customers see
+func (s *Service) RequestPhoneCode(ctx context.Context, business *model.BusinessUser) (*model.VerificationRequest, error) {
+	if business.Phone_number == nil || *business.Phone_number == "" {
+		return nil, ErrNoPhoneNumber
+	}
+	phoneNumber := *business.Phone_number
+	request := &model.VerificationRequest{Method: model.EvidencePhone, Phone_number: phoneNumber}
+	return s.sendCode(ctx, business, request, PhoneCodeTTL, func(code string) error {
+		return s.caller.CallWithCode(ctx, phoneNumber, code)
+	})
+}
+
+// RequestPostcardCode mails a code to the address of the business it has to enter within [PostcardCodeTTL]
+func (s *Service) RequestPostcardCode(ctx context.Context, business *model.BusinessUser) (*model.VerificationRequest, error) {
+	address := business.Address
+	if address == nil || address.Street == "" || address.City == "" || address.PostalCode == "" {
+		return nil, ErrNoAddress
+	}
+	name := ""
+	if business.Business_name != nil {
+		name = *business.Business_name
+	}
+
+	request := &model.VerificationRequest{Method: model.EvidencePostcard, Address: address}
+	return s.sendCode(ctx, business, request, PostcardCodeTTL, func(code string) error {
+		return s.postcards.SendPostcard(ctx, name, address, code)
+	})
+}
+
+// sendCode saves the request waiting for a new code and sends the code with send. The request is saved first
+// so a call or postcard that went out always counts towards [MaxCodesPerDay], one that failed is canceled
+func (s *Service) sendCode(ctx context.Context, business *model.BusinessUser, request *model.VerificationRequest, ttl time.Duration, send func(code string) error) (*model.VerificationRequest, error) {
+	if business.IsVerified() {
+		return nil, ErrAlreadyVerified
+	}
+	now := time.Now().UTC()
+	sent, err := s.requests.CountDocuments(ctx, bson.M{
+		"business_id": business.ID,
+		"method":      bson.M{"$in": bson.A{model.EvidencePhone, model.EvidencePostcard}},
+		"created_at":  bson.M{"$gte": now.Add(-24 * time.Hour)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if sent >= MaxCodesPerDay {
+		return nil, ErrTooManyCodes
+	}
+
+	code, err := newCode()
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := now.Add(ttl)
+	request.ID = primitive.NewObjectID()
+	request.Business_id = business.ID
+	request.Status = model.VerificationAwaitingCode
+	request.Code_hash = hashCode(request.ID, code)
+	request.Code_expires_at = &expiresAt
+	request.Created_at = now
+	request.Updated_at = now
+
+	err = s.db.WithTransaction(ctx, func(ctx context.Context) error {
+		return s.replaceOpenRequest(ctx, request, "")
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := send(code); err != nil {
+		// the context may be used up by now
+		cancelCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.requests.UpdateOne(cancelCtx, bson.M{"_id": request.ID, "status": model.VerificationAwaitingCode},
+			bson.M{"$set": bson.M{"status": model.VerificationCanceled, "updated_at": time.Now().UTC()}})
+		return nil, fmt.Errorf("sending the verification code failed: %v", err)
+	}
+	return request, nil
+}
+
+// ConfirmCode checks the code the business entered, a right code sends the request to the admins
+func (s *Service) ConfirmCode(ctx context.Context, businessID primitive.ObjectID, code string, record Recorder) (*model.VerificationRequest, error) {
+	request := new(model.VerificationRequest)
+	err := s.requests.FindOne(request, ctx, bson.M{"business_id": businessID, "status": model.VerificationAwaitingCode},
+		options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNoCodePending
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if request.Code_expires_at == nil || now.After(*request.Code_expires_at) {
+		return nil, s.expire(ctx, request.ID)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashCode(request.ID, code)), []byte(request.Code_hash)) != 1 {
+		// the attempt only counts while there are attempts left so two wrong codes at once can not go over
+		result, err := s.requests.UpdateOne(ctx,
+			bson.M{"_id": request.ID, "status": model.VerificationAwaitingCode, "code_attempts": bson.M{"$lt": MaxCodeAttempts - 1}},
+			bson.M{"$inc": bson.M{"code_attempts": 1}, "$set": bson.M{"updated_at": now}})
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 0 {
+			return nil, s.expire(ctx, request.ID)
+		}
+		return nil, ErrWrongCode
+	}
+
+	err = s.db.WithTransaction(ctx, func(ctx context.Context) error {
+		err := s.requests.FindOneAndUpdate(request, ctx,
+			bson.M{"_id": request.ID, "status": model.VerificationAwaitingCode},
+			bson.M{"$set": bson.M{"status": model.VerificationSubmitted, "code_confirmed_at": now, "updated_at": now}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After))
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrNoCodePending
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.setBusinessStatus(ctx, businessID, model.BusinessVerificationPending); err != nil {
+			return err
+		}
+		return record(ctx, request)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// Review applies the decision of an admin to a submitted request. Approving verifies the business, rejecting
+// makes it unverified again
+func (s *Service) Review(ctx context.Context, requestID primitive.ObjectID, action string, adminID primitive.ObjectID, note string, record Recorder) (*model.VerificationRequest, error) {
+	var requestStatus, businessStatus string
+	switch action {
+	case ActionApprove:
+		requestStatus, businessStatus = model.VerificationApproved, model.BusinessVerified
+	case ActionReject:
+		requestStatus, businessStatus = model.VerificationRejected, ""
+	default:
+		return nil, errors.New("unknown verification action " + action)
+	}
+
+	now := time.Now().UTC()
+	request := new(model.VerificationRequest)
+	err := s.db.WithTransaction(ctx, func(ctx context.Context) error {
+		err := s.requests.FindOneAndUpdate(request, ctx,
+			bson.M{"_id": requestID, "status": model.VerificationSubmitted},
+			bson.M{"$set": bson.M{"status": requestStatus, "note": note, "reviewed_by": adminID, "reviewed_at": now, "updated_at": now}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After))
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			count, err := s.requests.CountDocuments(ctx, bson.M{"_id": requestID})
+			if err == nil && count == 0 {
+				return ErrRequestNotFound
+			}
+			return ErrNotReviewable
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.setBusinessStatus(ctx, request.Business_id, businessStatus); err != nil {
+			return err
+		}
+		return record(ctx, request)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// Document opens the document of a request, the caller must close it
+func (s *Service) Document(ctx context.Context, requestID primitive.ObjectID) (io.ReadCloser, *model.VerificationDocument, error) {
+	request := new(model.VerificationRequest)
+	err := s.requests.FindOne(request, ctx, bson.M{"_id": requestID})
+	if errors.Is(err, mongo.ErrNoDocuments) || (err == nil && request.Document == nil) {
+		return nil, nil, ErrRequestNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := s.store.Get(ctx, request.Document.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, request.Document, nil
+}
+
+// ListingChanged takes the verification away from a business whose name or address changed,
+// open requests are canceled since their evidence was for the old listing. Must run in a transaction
+func (s *Service) ListingChanged(ctx context.Context, businessID primitive.ObjectID) error {
+	if err := s.cancelOpenRequests(ctx, businessID); err != nil {
+		return err
+	}
+	return s.setBusinessStatus(ctx, businessID, "")
+}
+
+// replaceOpenRequest cancels the open requests of the business, saves the new one and sets the business status
+func (s *Service) replaceOpenRequest(ctx context.Context, request *model.VerificationRequest, businessStatus string) error {
+	if err := s.cancelOpenRequests(ctx, request.Business_id); err != nil {
+		return err
+	}
+	if _, err := s.requests.InsertOne(ctx, request); err != nil {
+		return err
+	}
+	return s.setBusinessStatus(ctx, request.Business_id, businessStatus)
+}
+
+func (s *Service) cancelOpenRequests(ctx context.Context, businessID primitive.ObjectID) error {
+	_, err := s.requests.UpdateMany(ctx,
+		bson.M{"business_id": businessID, "status": bson.M{"$in": openStatuses}},
+		bson.M{"$set": bson.M{"status": model.VerificationCanceled, "updated_at": time.Now().UTC()}})
+	return err
+}
+
+// setBusinessStatus changes the verification status of the business, an empty status makes it unverified
+func (s *Service) setBusinessStatus(ctx context.Context, businessID primitive.ObjectID, status string) error {
+	update := bson.M{"$unset": bson.M{"verification_status": "", "verified_at": ""}}
+	switch status {
+	case model.BusinessVerified:
+		update = bson.M{"$set": bson.M{"verification_status": status, "verified_at": time.Now().UTC()}}
+	case model.BusinessVerificationPending:
+		update = bson.M{"$set": bson.M{"verification_status": status}, "$unset": bson.M{"verified_at": ""}}
+	}
+	_, err := s.businesses.UpdateOne(ctx, bson.M{"_id": businessID}, update)
+	return err
+}
+
+// expire ends a request whose code can not be entered anymore and returns [ErrCodeExpired]
+func (s *Service) expire(ctx context.Context, requestID primitive.ObjectID) error {
+	_, err := s.requests.UpdateOne(ctx, bson.M{"_id": requestID, "status": model.VerificationAwaitingCode},
+		bson.M{"$set": bson.M{"status": model.VerificationExpired, "updated_at": time.Now().UTC()}})
+	if err != nil {
+		return err
+	}
+	return ErrCodeExpired
+}
+
+// newCode returns a random code of [CodeLength] digits
+func newCode() (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < CodeLength; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", CodeLength, n), nil
+}
+
+// hashCode is what is stored of a code, the request ID keeps the same code of two requests from looking the same
+func hashCode(requestID primitive.ObjectID, code string) string {
+	sum := sha256.Sum256([]byte(requestID.Hex() + ":" + code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,140 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/app/queue"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// a subscription is turned off after this many failed attempts in a row
+const disableAfterFailures = 20
+
+// Deliverer sends queued deliveries
+type Deliverer struct {
+	subscriptions model.Collection
+	deliveries    model.Collection
+	client        *http.Client
+}
+
+// NewDeliverer returns a [Deliverer] using an HTTP client that refuses private network addresses
+// unless WEBHOOK_ALLOW_PRIVATE_NETWORKS is true (for testing against a local receiver)
+func NewDeliverer(subscriptions model.Collection, deliveries model.Collection) *Deliverer {
+	return &Deliverer{
+		subscriptions: subscriptions,
+		deliveries:    deliveries,
+		client:        newClient(),
+	}
+}
+
+// Handler is the task queue handler for [DeliverTask]
+// returning an error makes the queue try again later with a backoff
+func (d *Deliverer) Handler() queue.Handler {
+	return func(ctx context.Context, task *model.Task) error {
+		var payload DeliverPayload
+		if err := task.DecodePayload(&payload); err != nil {
+			return err
+		}
+
+		delivery := new(model.WebhookDelivery)
+		if err := d.deliveries.FindOne(delivery, ctx, bson.M{"_id": payload.Delivery_id}); err != nil {
+			return err
+		}
+		if delivery.Status != model.DeliveryPending {
+			return nil
+		}
+
+		subscription := new(model.WebhookSubscription)
+		err := d.subscriptions.FindOne(subscription, ctx, bson.M{"_id": delivery.Subscription_id})
+		if err != nil || !subscription.Active {
+			// deleted or disabled since the event happened
+			return d.finish(ctx, delivery, model.DeliveryCancelled, nil)
+		}
+
+		attempt := d.send(ctx, subscription, delivery)
+		if attempt.Error == "" {
+			d.recordSuccess(ctx, subscription)
+			return d.finish(ctx, delivery, model.DeliverySucceeded, &attempt)
+		}
+
+		d.recordFailure(ctx, subscription)
+		if task.Attempts >= task.Max_attempts {
+			return d.finish(ctx, delivery, model.DeliveryFailed, &attempt)
+		}
+		if err := d.finish(ctx, delivery, model.DeliveryPending, &attempt); err != nil {
+			return err
+		}
+		return fmt.Errorf("webhook delivery %s failed: %s", delivery.ID.Hex(), attempt.Error)
+	}
+}
+
+// send POSTs the delivery and records what happened
+func (d *Deliverer) send(ctx context.Context, subscription *model.WebhookSubscription, delivery *model.WebhookDelivery) model.WebhookAttempt {
+	start := time.Now().UTC()
+	attempt := model.WebhookAttempt{Attempted_at: start}
+	body := []byte(delivery.Payload)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.Url, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "whir-webhooks/1.0")
+	req.Header.Set("Whir-Event", delivery.Event)
+	req.Header.Set("Whir-Delivery", delivery.ID.Hex())
+	req.Header.Set("Whir-Signature", Sign(subscription.Secret, start, body))
+
+	resp, err := d.client.Do(req)
+	attempt.Duration_ms = time.Since(start).Milliseconds()
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+
+	responseBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	attempt.Response_status = resp.StatusCode
+	attempt.Response_body = string(responseBody)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		attempt.Error = fmt.Sprintf("receiver responded with %d", resp.StatusCode)
+	}
+	return attempt
+}
+
+func (d *Deliverer) finish(ctx context.Context, delivery *model.WebhookDelivery, status string, attempt *model.WebhookAttempt) error {
+	update := bson.M{"$set": bson.M{"status": status, "updated_at": time.Now().UTC()}}
+	if attempt != nil {
+		update["$push"] = bson.M{"attempts": attempt}
+	}
+	_, err := d.deliveries.UpdateOne(ctx, bson.M{"_id": delivery.ID}, update)
+	return err
+}
+
+func (d *Deliverer) recordSuccess(ctx context.Context, subscription *model.WebhookSubscription) {
+	if subscription.Consecutive_failures == 0 {
+		return
+	}
+	d.subscriptions.UpdateOne(ctx, bson.M{"_id": subscription.ID}, bson.M{"$set": bson.M{"consecutive_failures": 0}})
+}
+
+// recordFailure counts the failure and disables the subscription once it keeps failing
+func (d *Deliverer) recordFailure(ctx context.Context, subscription *model.WebhookSubscription) {
+	d.subscriptions.UpdateOne(ctx, bson.M{"_id": subscription.ID}, bson.M{"$inc": bson.M{"consecutive_failures": 1}})
+
+	d.subscriptions.UpdateOne(ctx,
+		bson.M{"_id": subscription.ID, "active": true, "consecutive_failures": bson.M{"$gte": disableAfterFailures}},
+		bson.M{"$set": bson.M{
+			"active":          false,
+			"disabled_reason": fmt.Sprintf("disabled after %d failed deliveries in a row", disableAfterFailures),
+			"updated_at":      time.Now().UTC(),
+		}},
+	)
+}
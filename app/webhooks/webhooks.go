@@ -0,0 +1,171 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/events"
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/app/queue"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// webhooks sends events to the URLs businesses subscribe with (e.g. their POS system).
+//
+// Every request is a POST with a JSON body and these headers:
+//
+//	Whir-Event: deal.created
+//	Whir-Delivery: <delivery id>
+//	Whir-Signature: t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>" with the subscription secret>
+//
+// Receivers should check the signature and reject timestamps that are too old.
+
+// DeliverTask is the task type that sends one delivery
+const DeliverTask = "deliver_webhook"
+
+// maxAttempts is how many times a delivery is tried, with the queue backoff that is about 20 minutes
+const maxAttempts = 8
+
+// Event is the body POSTed to a webhook
+type Event struct {
+	ID          string             `json:"id"`
+	Type        string             `json:"type"`
+	Created_at  time.Time          `json:"created_at"`
+	Business_id primitive.ObjectID `json:"business_id"`
+	Data        interface{}        `json:"data"`
+}
+
+// DeliverPayload is the payload of a [DeliverTask]
+type DeliverPayload struct {
+	Delivery_id primitive.ObjectID `bson:"delivery_id"`
+}
+
+// Publisher creates deliveries for an event and hands them to the task queue
+type Publisher struct {
+	subscriptions model.Collection
+	deliveries    model.Collection
+	queue         *queue.Queue
+}
+
+// NewPublisher returns a [Publisher]
+func NewPublisher(subscriptions model.Collection, deliveries model.Collection, q *queue.Queue) *Publisher {
+	return &Publisher{subscriptions: subscriptions, deliveries: deliveries, queue: q}
+}
+
+// Publish sends the event to every active subscription of the business that wants it
+// eventID must be unique per event so a retried publish does not send it twice
+func (p *Publisher) Publish(ctx context.Context, businessID primitive.ObjectID, eventType string, eventID string, data interface{}) error {
+	cursor, err := p.subscriptions.Find(ctx, bson.M{"business_id": businessID, "active": true})
+	if err != nil {
+		return err
+	}
+
+	var subscriptions []*model.WebhookSubscription
+	if err := cursor.All(ctx, &subscriptions); err != nil {
+		return err
+	}
+
+	for _, subscription := range subscriptions {
+		if !subscription.WantsEvent(eventType) {
+			continue
+		}
+		if err := p.Send(ctx, subscription, eventType, eventID, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// webhookEvents maps the domain events that are sent to webhooks to their webhook event type
+var webhookEvents = map[string]string{
+	model.EventDealCreated:  model.WebhookDealCreated,
+	model.EventDealUpdated:  model.WebhookDealUpdated,
+	model.EventDealClaimed:  model.WebhookDealClaimed,
+	model.EventDealRedeemed: model.WebhookDealRedeemed,
+}
+
+// HandleEvent is the event dispatcher subscriber that publishes domain events to webhooks
+// the ID of the domain event is the webhook event ID so dispatching an event twice only delivers it once
+func (p *Publisher) HandleEvent(ctx context.Context, event *model.DomainEvent) error {
+	webhookType, ok := webhookEvents[event.Type]
+	if !ok {
+		return nil
+	}
+
+	data, err := events.Payload(event)
+	if err != nil {
+		return err
+	}
+
+	return p.Publish(ctx, event.Business_id, webhookType, event.ID.Hex(), data)
+}
+
+// Send queues one event for one subscription, used directly for test pings
+func (p *Publisher) Send(ctx context.Context, subscription *model.WebhookSubscription, eventType string, eventID string, data interface{}) error {
+	now := time.Now().UTC()
+	body, err := json.Marshal(Event{
+		ID:          eventID,
+		Type:        eventType,
+		Created_at:  now,
+		Business_id: subscription.Business_id,
+		Data:        data,
+	})
+	if err != nil {
+		return err
+	}
+
+	delivery := &model.WebhookDelivery{
+		ID:              primitive.NewObjectID(),
+		Subscription_id: subscription.ID,
+		Business_id:     subscription.Business_id,
+		Event:           eventType,
+		Event_id:        eventID,
+		Payload:         string(body),
+		Status:          model.DeliveryPending,
+		Attempts:        []model.WebhookAttempt{},
+		Created_at:      now,
+		Updated_at:      now,
+	}
+	_, err = p.deliveries.InsertOne(ctx, delivery)
+	if mongo.IsDuplicateKeyError(err) {
+		// the event was published before, make sure its delivery is queued
+		err = p.deliveries.FindOne(delivery, ctx, bson.M{"subscription_id": subscription.ID, "event_id": eventID})
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = p.queue.Enqueue(ctx, DeliverTask, DeliverPayload{Delivery_id: delivery.ID}, queue.EnqueueOptions{
+		IdempotencyKey: DeliverTask + ":" + subscription.ID.Hex() + ":" + eventID,
+		MaxAttempts:    maxAttempts,
+	})
+	return err
+}
+
+// GenerateSecret creates the signing secret of a new subscription
+func GenerateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Sign creates the Whir-Signature header value for a body sent at time t
+func Sign(secret string, t time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
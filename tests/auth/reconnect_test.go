@@ -0,0 +1,67 @@
+package auth_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/auth"
+)
+
+func TestReconnectTokenCarriesTheLogin(t *testing.T) {
+	now := time.Now()
+	session := auth.ReconnectSession{
+		Uid:          "64b7f0c2a1b2c3d4e5f60718",
+		IssuedAt:     now.Add(-time.Minute).Unix(),
+		ExpiresAt:    now.Add(time.Hour).Unix(),
+		Impersonator: "64b7f0c2a1b2c3d4e5f60719",
+	}
+	token, expires := auth.SignReconnectToken(session, now.Add(10*time.Minute))
+	if expires.Unix() != now.Add(10*time.Minute).Unix() {
+		t.Errorf("token expires at %s, want in 10 minutes", expires)
+	}
+
+	resumed, err := auth.VerifyReconnectToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed != session {
+		t.Errorf("token resumes %+v, want %+v", resumed, session)
+	}
+}
+
+func TestReconnectTokenNeverOutlivesTheLogin(t *testing.T) {
+	now := time.Now()
+	session := auth.ReconnectSession{Uid: "64b7f0c2a1b2c3d4e5f60718", IssuedAt: now.Add(-time.Hour).Unix(), ExpiresAt: now.Add(2 * time.Minute).Unix()}
+
+	_, expires := auth.SignReconnectToken(session, now.Add(10*time.Minute))
+	if expires.Unix() != session.ExpiresAt {
+		t.Errorf("token expires at %s, want when the login does at %s", expires, time.Unix(session.ExpiresAt, 0))
+	}
+
+	// renewing a token of a login that ran out does not bring it back
+	session.ExpiresAt = now.Add(-time.Second).Unix()
+	token, _ := auth.SignReconnectToken(session, now.Add(10*time.Minute))
+	if _, err := auth.VerifyReconnectToken(token); err != auth.ErrInvalidReconnectToken {
+		t.Errorf("token of an expired login returned %v, want %v", err, auth.ErrInvalidReconnectToken)
+	}
+	// neither does a login without an expiry
+	session.ExpiresAt = 0
+	token, _ = auth.SignReconnectToken(session, now.Add(10*time.Minute))
+	if _, err := auth.VerifyReconnectToken(token); err != auth.ErrInvalidReconnectToken {
+		t.Errorf("token of a login without an expiry returned %v, want %v", err, auth.ErrInvalidReconnectToken)
+	}
+}
+
+func TestTamperedReconnectTokenIsRejected(t *testing.T) {
+	now := time.Now()
+	session := auth.ReconnectSession{Uid: "64b7f0c2a1b2c3d4e5f60718", IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix()}
+	token, _ := auth.SignReconnectToken(session, now.Add(10*time.Minute))
+
+	// pushing the login expiry out
+	parts := strings.Split(token, ".")
+	parts[2] = "99999999999"
+	if _, err := auth.VerifyReconnectToken(strings.Join(parts, ".")); err != auth.ErrInvalidReconnectToken {
+		t.Errorf("tampered token returned %v, want %v", err, auth.ErrInvalidReconnectToken)
+	}
+}
@@ -0,0 +1,41 @@
+package auth_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/CoffeeHausGames/whir-server/app/auth"
+)
+
+const codeAlphabet = "23456789ABCDEFGHJKMNPQRSTUVWXYZ"
+
+func TestRedemptionCodesAreUnbiased(t *testing.T) {
+	format := regexp.MustCompile(`^[` + codeAlphabet + `]{4}-[` + codeAlphabet + `]{4}$`)
+	counts := make(map[rune]int)
+	for i := 0; i < 20000; i++ {
+		code, err := auth.GenerateRedemptionCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !format.MatchString(code) {
+			t.Fatalf("code %q is not 4 and 4 characters of the alphabet", code)
+		}
+		for _, c := range strings.ReplaceAll(code, "-", "") {
+			counts[c]++
+		}
+	}
+
+	// with byte % 31 the first 8 characters of the alphabet come up 9 times in 256 instead of 8, 12.5% more
+	var first, rest float64
+	for i, c := range codeAlphabet {
+		if i < 8 {
+			first += float64(counts[c]) / 8
+		} else {
+			rest += float64(counts[c]) / float64(len(codeAlphabet)-8)
+		}
+	}
+	if ratio := first / rest; ratio > 1.05 || ratio < 0.95 {
+		t.Errorf("the first characters of the alphabet are %.3f times as frequent as the others", ratio)
+	}
+}
@@ -0,0 +1,246 @@
+package billing_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/billing"
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/app/plans"
+	"github.com/CoffeeHausGames/whir-server/app/server/database"
+	"github.com/CoffeeHausGames/whir-server/tests/testdb"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// delivery is a webhook request of the fake provider
+type delivery struct {
+	payload []byte
+	header  http.Header
+}
+
+// newService returns a service billing through a fake provider, the requests it sent to the webhook are
+// returned by the func so they can be sent again
+func newService(t *testing.T, db *database.Database) (*billing.Service, *billing.Fake, func() []delivery) {
+	fake, err := billing.NewFake()
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := billing.NewService(db, fake, plans.DefaultCatalog(), billing.Config{GracePeriod: 7 * 24 * time.Hour})
+
+	var mu sync.Mutex
+	var delivered []delivery
+	handle := fake.Deliver
+	fake.Deliver = func(ctx context.Context, payload []byte, header http.Header) error {
+		mu.Lock()
+		delivered = append(delivered, delivery{payload, header})
+		mu.Unlock()
+		return handle(ctx, payload, header)
+	}
+	return service, fake, func() []delivery {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]delivery(nil), delivered...)
+	}
+}
+
+func newBusiness(t *testing.T, db *database.Database) *model.BusinessUser {
+	business := &model.BusinessUser{ID: primitive.NewObjectID()}
+	if _, err := db.GetBusinesses().InsertOne(testdb.Context(t), business); err != nil {
+		t.Fatal(err)
+	}
+	return business
+}
+
+func planOf(t *testing.T, db *database.Database, businessID primitive.ObjectID) string {
+	business := new(model.BusinessUser)
+	if err := db.GetBusinesses().FindOne(business, testdb.Context(t), bson.M{"_id": businessID}); err != nil {
+		t.Fatal(err)
+	}
+	return plans.DefaultCatalog().PlanOf(business).ID
+}
+
+// subscribe checks out the pro plan and returns the subscription
+func subscribe(t *testing.T, service *billing.Service, db *database.Database, business *model.BusinessUser) *model.Subscription {
+	ctx := testdb.Context(t)
+	if _, err := service.Checkout(ctx, business, "pro"); err != nil {
+		t.Fatal(err)
+	}
+	subscription, err := service.Current(ctx, business.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subscription == nil || subscription.Status != model.SubscriptionActive || subscription.Plan != "pro" {
+		t.Fatalf("subscription after the checkout = %+v, want an active pro subscription", subscription)
+	}
+	return subscription
+}
+
+func TestCheckoutActivatesThePlan(t *testing.T) {
+	db := testdb.Connect(t)
+	ctx := testdb.Context(t)
+	service, _, _ := newService(t, db)
+	business := newBusiness(t, db)
+
+	subscribe(t, service, db, business)
+	if plan := planOf(t, db, business.ID); plan != "pro" {
+		t.Errorf("plan = %s, want pro", plan)
+	}
+	invoices, err := service.Invoices(ctx, business.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invoices) != 1 || invoices[0].Status != model.InvoicePaid {
+		t.Errorf("invoices = %+v, want one paid invoice", invoices)
+	}
+	if _, err := service.Checkout(ctx, business, "enterprise"); !errors.Is(err, billing.ErrAlreadySubscribed) {
+		t.Errorf("second checkout: error %v, want %v", err, billing.ErrAlreadySubscribed)
+	}
+}
+
+func TestFailedPaymentDowngradesAfterTheGracePeriod(t *testing.T) {
+	db := testdb.Connect(t)
+	ctx := testdb.Context(t)
+	service, fake, _ := newService(t, db)
+	business := newBusiness(t, db)
+	subscription := subscribe(t, service, db, business)
+
+	if err := fake.FailPayment(ctx, subscription.Provider_id); err != nil {
+		t.Fatal(err)
+	}
+	subscription, err := service.Current(ctx, business.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subscription.Status != model.SubscriptionPastDue || subscription.Grace_until == nil {
+		t.Fatalf("subscription after the failed payment = %+v, want past due with a grace period", subscription)
+	}
+	if plan := planOf(t, db, business.ID); plan != "pro" {
+		t.Errorf("plan during the grace period = %s, want pro", plan)
+	}
+	expired, err := service.ExpireGracePeriods(ctx, time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expired != 0 {
+		t.Errorf("%d grace periods expired before they ran out", expired)
+	}
+
+	// the grace period runs out
+	_, err = db.GetSubscriptions().UpdateOne(ctx, bson.M{"_id": subscription.ID},
+		bson.M{"$set": bson.M{"grace_until": time.Now().UTC().Add(-time.Minute)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err = service.ExpireGracePeriods(ctx, time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expired != 1 {
+		t.Errorf("%d grace periods expired, want 1", expired)
+	}
+	if plan := planOf(t, db, business.ID); plan != "free" {
+		t.Errorf("plan after the grace period = %s, want free", plan)
+	}
+	changes, err := db.GetAuditLog().CountDocuments(ctx, bson.M{"business_id": business.ID, "action": model.AuditPlanChanged})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes != 2 {
+		t.Errorf("%d plan changes in the audit log, want the upgrade and the downgrade", changes)
+	}
+}
+
+func TestDuplicateEventsAreHandledOnce(t *testing.T) {
+	db := testdb.Connect(t)
+	ctx := testdb.Context(t)
+	service, fake, delivered := newService(t, db)
+	business := newBusiness(t, db)
+	subscription := subscribe(t, service, db, business)
+	if err := fake.FailPayment(ctx, subscription.Provider_id); err != nil {
+		t.Fatal(err)
+	}
+	failed, err := service.Current(ctx, business.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the provider sends every event again, the checkout events would make the subscription active again
+	for _, d := range delivered() {
+		if err := service.HandleWebhook(ctx, d.payload, d.header); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	again, err := service.Current(ctx, business.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Status != model.SubscriptionPastDue || !again.Grace_until.Equal(*failed.Grace_until) {
+		t.Errorf("subscription after the events were sent again = %+v, want it past due with the same grace period", again)
+	}
+	events, err := db.GetBillingEvents().CountDocuments(ctx, bson.M{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events != int64(len(delivered())) {
+		t.Errorf("%d events handled, want %d", events, len(delivered()))
+	}
+	invoices, err := service.Invoices(ctx, business.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invoices) != 2 {
+		t.Errorf("%d invoices, want the paid and the failed one", len(invoices))
+	}
+}
+
+func TestProviderFromEnv(t *testing.T) {
+	tests := []struct {
+		driver     string
+		allowFake  string
+		wantErr    bool
+		wantDriver string
+	}{
+		{"", "", true, ""},
+		{"fake", "", true, ""},
+		{"fake", "true", false, "fake"},
+		{"off", "", false, "off"},
+		{"paypal", "", true, ""},
+	}
+	for _, test := range tests {
+		t.Setenv("BILLING_DRIVER", test.driver)
+		t.Setenv("BILLING_ALLOW_FAKE", test.allowFake)
+		provider, err := billing.NewProviderFromEnv()
+		if (err != nil) != test.wantErr {
+			t.Errorf("BILLING_DRIVER=%q BILLING_ALLOW_FAKE=%q: error %v, want an error: %v", test.driver, test.allowFake, err, test.wantErr)
+			continue
+		}
+		if err == nil && provider.Name() != test.wantDriver {
+			t.Errorf("BILLING_DRIVER=%q: provider %s, want %s", test.driver, provider.Name(), test.wantDriver)
+		}
+	}
+}
+
+func TestNothingIsSoldWhileBillingIsOff(t *testing.T) {
+	db := testdb.Connect(t)
+	ctx := testdb.Context(t)
+	service := billing.NewService(db, billing.Off{}, plans.DefaultCatalog(), billing.Config{})
+	business := newBusiness(t, db)
+
+	if _, err := service.Checkout(ctx, business, "pro"); !errors.Is(err, billing.ErrBillingOff) {
+		t.Errorf("checkout: error %v, want %v", err, billing.ErrBillingOff)
+	}
+	payload := []byte(`{"id": "evt_1", "type": "subscription.updated", "customer": "cus_1"}`)
+	if err := service.HandleWebhook(ctx, payload, http.Header{}); !errors.Is(err, billing.ErrInvalidSignature) {
+		t.Errorf("webhook: error %v, want %v", err, billing.ErrInvalidSignature)
+	}
+	if plan := planOf(t, db, business.ID); plan != "free" {
+		t.Errorf("plan = %s, want free", plan)
+	}
+}
@@ -0,0 +1,379 @@
+package export_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/export"
+)
+
+var parquetColumns = []export.Column{
+	{Name: "name", Type: export.TypeString},
+	{Name: "count", Type: export.TypeInt},
+	{Name: "price", Type: export.TypeFloat},
+	{Name: "active", Type: export.TypeBool},
+	{Name: "created_at", Type: export.TypeTime},
+}
+
+// parquetRow is row i of the test file, every column is null in a different pattern and the last rows
+// are all null
+func parquetRow(i int, rows int) []interface{} {
+	if i >= rows-3 {
+		return make([]interface{}, len(parquetColumns))
+	}
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	row := []interface{}{
+		fmt.Sprintf("row %d", i),
+		int64(i) - 100,
+		float64(i) / 4,
+		i%3 == 0,
+		start.Add(time.Duration(i)*time.Minute + time.Duration(i%1000)*time.Millisecond),
+	}
+	for column := range row {
+		if i%(column+3) == 0 {
+			row[column] = nil
+		}
+	}
+	return row
+}
+
+func TestParquetRoundTrip(t *testing.T) {
+	for _, rows := range []int{0, 7, 25003} {
+		t.Run(fmt.Sprintf("%d rows", rows), func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := export.NewWriter(export.FormatParquet, &buf, parquetColumns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < rows; i++ {
+				if err := w.Write(parquetRow(i, rows)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			file, err := readParquet(buf.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(file.columns, parquetColumns) {
+				t.Errorf("schema = %+v, want %+v", file.columns, parquetColumns)
+			}
+			if wantGroups := (rows + 9999) / 10000; file.rowGroups != wantGroups {
+				t.Errorf("%d row groups, want %d", file.rowGroups, wantGroups)
+			}
+			if len(file.rows) != rows || file.numRows != int64(rows) {
+				t.Fatalf("read %d rows, the footer has %d, want %d", len(file.rows), file.numRows, rows)
+			}
+			for i, row := range file.rows {
+				if want := parquetRow(i, rows); !reflect.DeepEqual(row, want) {
+					t.Fatalf("row %d = %v, want %v", i, row, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParquetRejectsValuesOfTheWrongType(t *testing.T) {
+	w, err := export.NewWriter(export.FormatParquet, io.Discard, parquetColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write([]interface{}{"name", "1", nil, nil, nil}); err == nil {
+		t.Error("a string was written to an int column")
+	}
+	if err := w.Write([]interface{}{"name"}); err == nil {
+		t.Error("a row with too few values was written")
+	}
+}
+
+// parquetFile is what readParquet read
+type parquetFile struct {
+	columns   []export.Column
+	numRows   int64
+	rowGroups int
+	rows      [][]interface{}
+}
+
+// readParquet reads the flat files of optional columns the export writes, following the format
+// specification rather than the writer so the test does not share its mistakes
+func readParquet(data []byte) (*parquetFile, error) {
+	if len(data) < 12 || string(data[:4]) != "PAR1" || string(data[len(data)-4:]) != "PAR1" {
+		return nil, errors.New("the file does not start and end with PAR1")
+	}
+	footerLength := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	if footerLength > len(data)-12 {
+		return nil, errors.New("the footer is longer than the file")
+	}
+	meta, err := newThriftReader(data[len(data)-8-footerLength : len(data)-8]).readStruct()
+	if err != nil {
+		return nil, fmt.Errorf("footer: %w", err)
+	}
+
+	file := &parquetFile{numRows: meta[3].(int64)}
+	schema := meta[2].([]interface{})
+	if root := schema[0].(map[int16]interface{}); root[5].(int64) != int64(len(schema)-1) {
+		return nil, fmt.Errorf("the schema root has %d children for %d columns", root[5], len(schema)-1)
+	}
+	var converted []int64
+	for _, element := range schema[1:] {
+		element := element.(map[int16]interface{})
+		if element[3].(int64) != 1 {
+			return nil, fmt.Errorf("column %s is not optional", element[4])
+		}
+		columnType, ok := columnTypes[[2]int64{element[1].(int64), convertedType(element)}]
+		if !ok {
+			return nil, fmt.Errorf("column %s has an unknown type", element[4])
+		}
+		file.columns = append(file.columns, export.Column{Name: element[4].(string), Type: columnType})
+		converted = append(converted, convertedType(element))
+	}
+
+	groups, _ := meta[4].([]interface{})
+	file.rowGroups = len(groups)
+	for _, group := range groups {
+		group := group.(map[int16]interface{})
+		numRows := int(group[3].(int64))
+		rows := make([][]interface{}, numRows)
+		for i := range rows {
+			rows[i] = make([]interface{}, len(file.columns))
+		}
+		for c, chunk := range group[1].([]interface{}) {
+			columnMeta := chunk.(map[int16]interface{})[3].(map[int16]interface{})
+			offset, size := columnMeta[9].(int64), columnMeta[7].(int64)
+			values, err := readPage(data[offset:offset+size], columnMeta[1].(int64), converted[c] == 9)
+			if err != nil {
+				return nil, fmt.Errorf("column %s: %w", file.columns[c].Name, err)
+			}
+			if len(values) != numRows {
+				return nil, fmt.Errorf("column %s has %d values in a row group of %d rows", file.columns[c].Name, len(values), numRows)
+			}
+			for i, value := range values {
+				rows[i][c] = value
+			}
+		}
+		file.rows = append(file.rows, rows...)
+	}
+	return file, nil
+}
+
+// columnTypes maps the physical and converted type of a column to its type, -1 is no converted type
+var columnTypes = map[[2]int64]export.ColumnType{
+	{6, 0}:  export.TypeString,
+	{2, -1}: export.TypeInt,
+	{5, -1}: export.TypeFloat,
+	{0, -1}: export.TypeBool,
+	{2, 9}:  export.TypeTime,
+}
+
+func convertedType(element map[int16]interface{}) int64 {
+	if converted, ok := element[6].(int64); ok {
+		return converted
+	}
+	return -1
+}
+
+// readPage reads an uncompressed PLAIN data page of a column, null values are nil
+func readPage(chunk []byte, physical int64, timestamp bool) ([]interface{}, error) {
+	t := newThriftReader(chunk)
+	header, err := t.readStruct()
+	if err != nil {
+		return nil, err
+	}
+	if header[1].(int64) != 0 {
+		return nil, errors.New("not a data page")
+	}
+	dataHeader := header[5].(map[int16]interface{})
+	if dataHeader[2].(int64) != 0 || dataHeader[3].(int64) != 3 {
+		return nil, errors.New("the values are not PLAIN or the definition levels not RLE")
+	}
+	body := make([]byte, header[3].(int64))
+	if _, err := io.ReadFull(t.r, body); err != nil {
+		return nil, err
+	}
+	numValues := int(dataHeader[1].(int64))
+
+	levelsLength := int(binary.LittleEndian.Uint32(body))
+	levels, err := definitionLevels(body[4:4+levelsLength], numValues)
+	if err != nil {
+		return nil, err
+	}
+	r := bytes.NewReader(body[4+levelsLength:])
+	values := make([]interface{}, numValues)
+	var bit int
+	var bits byte
+	for i, present := range levels {
+		if !present {
+			continue
+		}
+		switch physical {
+		case 0:
+			if bit%8 == 0 {
+				if bits, err = r.ReadByte(); err != nil {
+					return nil, err
+				}
+			}
+			values[i] = bits&(1<<(bit%8)) != 0
+			bit++
+		case 2:
+			var v int64
+			err = binary.Read(r, binary.LittleEndian, &v)
+			values[i] = v
+			if timestamp {
+				values[i] = time.UnixMilli(v).UTC()
+			}
+		case 5:
+			var v uint64
+			err = binary.Read(r, binary.LittleEndian, &v)
+			values[i] = math.Float64frombits(v)
+		case 6:
+			var length uint32
+			if err = binary.Read(r, binary.LittleEndian, &length); err == nil {
+				v := make([]byte, length)
+				_, err = io.ReadFull(r, v)
+				values[i] = string(v)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%d bytes after the values", r.Len())
+	}
+	return values, nil
+}
+
+// definitionLevels decodes the RLE / bit-packed hybrid levels of bit width 1, true is a value
+func definitionLevels(data []byte, count int) ([]bool, error) {
+	r := bytes.NewReader(data)
+	var levels []bool
+	for len(levels) < count {
+		header, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if header&1 == 0 {
+			level, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			for i := uint64(0); i < header>>1; i++ {
+				levels = append(levels, level == 1)
+			}
+			continue
+		}
+		for group := uint64(0); group < header>>1; group++ {
+			bits, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			for i := 0; i < 8; i++ {
+				levels = append(levels, bits&(1<<i) != 0)
+			}
+		}
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%d bytes after the definition levels", r.Len())
+	}
+	return levels[:count], nil
+}
+
+// thriftReader reads the thrift compact protocol, structs are read as maps of field IDs to values
+type thriftReader struct {
+	r *bytes.Reader
+}
+
+func newThriftReader(data []byte) *thriftReader {
+	return &thriftReader{r: bytes.NewReader(data)}
+}
+
+func (t *thriftReader) readStruct() (map[int16]interface{}, error) {
+	fields := make(map[int16]interface{})
+	var lastID int16
+	for {
+		b, err := t.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == 0 {
+			return fields, nil
+		}
+		id := lastID + int16(b>>4)
+		if b>>4 == 0 {
+			v, err := binary.ReadUvarint(t.r)
+			if err != nil {
+				return nil, err
+			}
+			id = int16(unzigzag(v))
+		}
+		lastID = id
+		switch fieldType := b & 0x0f; fieldType {
+		case 1, 2:
+			fields[id] = fieldType == 1
+		default:
+			if fields[id], err = t.readValue(fieldType); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func (t *thriftReader) readValue(valueType byte) (interface{}, error) {
+	switch valueType {
+	case 3:
+		b, err := t.r.ReadByte()
+		return int64(int8(b)), err
+	case 4, 5, 6:
+		v, err := binary.ReadUvarint(t.r)
+		return unzigzag(v), err
+	case 7:
+		var v uint64
+		err := binary.Read(t.r, binary.LittleEndian, &v)
+		return math.Float64frombits(v), err
+	case 8:
+		length, err := binary.ReadUvarint(t.r)
+		if err != nil {
+			return nil, err
+		}
+		v := make([]byte, length)
+		_, err = io.ReadFull(t.r, v)
+		return string(v), err
+	case 9, 10:
+		b, err := t.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		size := uint64(b >> 4)
+		if size == 15 {
+			if size, err = binary.ReadUvarint(t.r); err != nil {
+				return nil, err
+			}
+		}
+		list := make([]interface{}, 0, size)
+		for i := uint64(0); i < size; i++ {
+			v, err := t.readValue(b & 0x0f)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case 12:
+		return t.readStruct()
+	}
+	return nil, fmt.Errorf("unexpected thrift type %d", valueType)
+}
+
+func unzigzag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
@@ -0,0 +1,52 @@
+package model_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	requests "github.com/CoffeeHausGames/whir-server/app/model/requests"
+)
+
+func TestClaimLimitTotalIsTheQuantity(t *testing.T) {
+	tests := []struct {
+		body     string
+		quantity int
+		daily    bool
+	}{
+		{`{"name": "Coffee", "claim_limits": {"total": 20, "per_user_per_day": 1}}`, 20, true},
+		{`{"name": "Coffee", "claim_limits": {"total": 20}}`, 20, false},
+		// the quantity wins over the old total
+		{`{"name": "Coffee", "quantity": 5, "claim_limits": {"total": 20}}`, 5, false},
+	}
+	for _, test := range tests {
+		var dealData requests.Deal
+		if err := json.Unmarshal([]byte(test.body), &dealData); err != nil {
+			t.Fatal(err)
+		}
+		if err := requests.ValidateDealStruct(&dealData); err != nil {
+			t.Fatal(err)
+		}
+		deal := requests.NewDeal(dealData)
+		if deal.Quantity == nil || *deal.Quantity != test.quantity {
+			t.Errorf("%s: quantity %v, want %d", test.body, deal.Quantity, test.quantity)
+		}
+		if daily := deal.Claim_limits != nil && deal.Claim_limits.Per_user_per_day != nil; daily != test.daily {
+			t.Errorf("%s: daily limit %v, want %v", test.body, daily, test.daily)
+		}
+	}
+}
+
+func TestClaimLimitsAreValidated(t *testing.T) {
+	for _, body := range []string{
+		`{"claim_limits": {"total": 0}}`,
+		`{"claim_limits": {"per_user_per_day": 0}}`,
+	} {
+		var dealData requests.Deal
+		if err := json.Unmarshal([]byte(body), &dealData); err != nil {
+			t.Fatal(err)
+		}
+		if err := requests.ValidateDealStruct(&dealData); err == nil {
+			t.Errorf("%s is valid, want an error", body)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package notifications_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/tests/testdb"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestNearbyAlertsMoveOnToDealsNotAlertedYet(t *testing.T) {
+	db := testdb.Connect(t)
+	ctx := testdb.Context(t)
+	service := newService(t, db)
+	userID := newUser(t, db)
+
+	// a business around the corner with more deals than one check alerts about and one across town
+	near, far := primitive.NewObjectID(), primitive.NewObjectID()
+	for id, coordinates := range map[primitive.ObjectID][]float64{near: {-87.6298, 41.8781}, far: {-87.7, 41.95}} {
+		_, err := db.GetBusinesses().InsertOne(ctx, bson.M{"_id": id, "location": bson.M{"type": "Point", "coordinates": coordinates}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now().UTC().Add(-time.Hour)
+	var nearDeals []primitive.ObjectID
+	for i := 0; i < 7; i++ {
+		published := start.Add(time.Duration(i) * time.Minute)
+		deal := &model.Deal{ID: primitive.NewObjectID(), Business_id: near, Status: model.DealLive, Published_at: &published}
+		if _, err := db.GetDeals().InsertOne(ctx, deal); err != nil {
+			t.Fatal(err)
+		}
+		nearDeals = append(nearDeals, deal.ID)
+	}
+	farDeal := &model.Deal{ID: primitive.NewObjectID(), Business_id: far, Status: model.DealLive, Published_at: &start}
+	if _, err := db.GetDeals().InsertOne(ctx, farDeal); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func() int {
+		// a check 30 seconds later
+		if _, err := db.GetUsers().UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$unset": bson.M{"nearby_checked_at": ""}}); err != nil {
+			t.Fatal(err)
+		}
+		found, err := service.CheckNearby(ctx, userID, 41.8781, -87.6298)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return found
+	}
+	alerted := func(dealID primitive.ObjectID) bool {
+		count, err := db.GetNotifications().CountDocuments(ctx, bson.M{"user_id": userID, "dedup_key": model.NotificationNearbyDeal + ":" + dealID.Hex()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return count == 1
+	}
+
+	if found := check(); found != 5 {
+		t.Fatalf("first check found %d deals, want 5", found)
+	}
+	// the newest deals are alerted first
+	for i, dealID := range nearDeals {
+		if want := i >= 2; alerted(dealID) != want {
+			t.Errorf("deal %d alerted %v after the first check, want %v", i, !want, want)
+		}
+	}
+
+	if found := check(); found != 2 {
+		t.Fatalf("second check found %d deals, want the 2 not alerted yet", found)
+	}
+	for i, dealID := range nearDeals {
+		if !alerted(dealID) {
+			t.Errorf("deal %d was not alerted after the second check", i)
+		}
+	}
+
+	if found := check(); found != 0 {
+		t.Errorf("third check found %d deals, want none", found)
+	}
+	if alerted(farDeal.ID) {
+		t.Error("the deal across town was alerted")
+	}
+}
@@ -0,0 +1,42 @@
+package notifications_test
+
+import (
+	"testing"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/app/notifications"
+	"github.com/CoffeeHausGames/whir-server/app/queue"
+	"github.com/CoffeeHausGames/whir-server/app/server/database"
+	"github.com/CoffeeHausGames/whir-server/tests/testdb"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newService(t *testing.T, db *database.Database) *notifications.Service {
+	notifier, err := notifications.NewLogNotifier("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return notifications.NewService(db, queue.New(db.GetTasks(), db.GetDeadTasks()), notifier, notifications.LogMailer{})
+}
+
+// newUser adds a user with a device who wants every notification
+func newUser(t *testing.T, db *database.Database) primitive.ObjectID {
+	ctx := testdb.Context(t)
+	userID := primitive.NewObjectID()
+	_, err := db.GetUsers().InsertOne(ctx, bson.M{
+		"_id": userID,
+		"notification_preferences": bson.M{
+			"enabled": true, "new_deals": true, "deal_reminders": true, "nearby_alerts": true, "nearby_radius": 500,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	device := &model.Device{ID: primitive.NewObjectID(), User_id: userID, Token: "token-" + userID.Hex(), Platform: "ios"}
+	if _, err := db.GetDevices().InsertOne(ctx, device); err != nil {
+		t.Fatal(err)
+	}
+	return userID
+}
@@ -0,0 +1,77 @@
+package notifications_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/tests/testdb"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestOverlappingSavedSearchRunsAlertOnce(t *testing.T) {
+	db := testdb.Connect(t)
+	ctx := testdb.Context(t)
+	service := newService(t, db)
+	userID := newUser(t, db)
+
+	now := time.Now().UTC()
+	search := &model.SavedSearch{
+		ID:         primitive.NewObjectID(),
+		User_id:    userID,
+		Name:       "Coffee",
+		Location:   &model.Location{Type: "Point", Coordinates: []float64{-87.6298, 41.8781}},
+		Radius:     1,
+		Query:      "coffee",
+		Channels:   []string{model.SearchChannelPush},
+		Created_at: now.Add(-time.Hour),
+		Updated_at: now.Add(-time.Hour),
+	}
+	if _, err := db.GetSavedSearches().InsertOne(ctx, search); err != nil {
+		t.Fatal(err)
+	}
+	businessID := primitive.NewObjectID()
+	_, err := db.GetBusinesses().InsertOne(ctx, bson.M{"_id": businessID, "location": bson.M{"type": "Point", "coordinates": []float64{-87.6299, 41.8782}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := "Coffee for a dollar"
+	published := now.Add(-time.Minute)
+	deal := &model.Deal{ID: primitive.NewObjectID(), Business_id: businessID, Name: &name, Status: model.DealLive, Published_at: &published}
+	if _, err := db.GetDeals().InsertOne(ctx, deal); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := service.EvaluateSavedSearches(ctx, now.Add(-5*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != 1 {
+		t.Fatalf("first run found %d matches, want 1", found)
+	}
+	// the next run looks back over the deal again
+	found, err = service.EvaluateSavedSearches(ctx, now.Add(-5*time.Minute))
+	if err != nil {
+		t.Fatalf("a run over a deal that was already matched failed: %v", err)
+	}
+	if found != 0 {
+		t.Errorf("second run found %d new matches, want none", found)
+	}
+
+	matches, err := db.GetSavedSearchMatches().CountDocuments(ctx, bson.M{"search_id": search.ID, "deal_id": deal.ID, "notified": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matches != 1 {
+		t.Errorf("%d notified matches saved, want 1", matches)
+	}
+	alerts, err := db.GetNotifications().CountDocuments(ctx, bson.M{"user_id": userID, "kind": model.NotificationSavedSearch})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alerts != 1 {
+		t.Errorf("user was alerted %d times, want once", alerts)
+	}
+}
@@ -0,0 +1,153 @@
+package queue_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/app/queue"
+	"github.com/CoffeeHausGames/whir-server/tests/testdb"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type payload struct {
+	N int `bson:"n"`
+}
+
+func TestEnqueueWithIdempotencyKeyReturnsTheQueuedTask(t *testing.T) {
+	db := testdb.Connect(t)
+	ctx := testdb.Context(t)
+	q := queue.New(db.GetTasks(), db.GetDeadTasks())
+
+	first, err := q.Enqueue(ctx, "test", payload{N: 1}, queue.EnqueueOptions{IdempotencyKey: "test:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := q.Enqueue(ctx, "test", payload{N: 2}, queue.EnqueueOptions{IdempotencyKey: "test:1"})
+	if err != nil {
+		t.Fatalf("enqueueing a duplicate failed: %v", err)
+	}
+	if second.ID != first.ID {
+		t.Errorf("duplicate got task %s, want the queued task %s", second.ID.Hex(), first.ID.Hex())
+	}
+	count, err := db.GetTasks().CountDocuments(ctx, bson.M{"type": "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("%d tasks queued, want 1", count)
+	}
+}
+
+func TestFailedTaskIsRetriedAfterBackoffThenDeadLettered(t *testing.T) {
+	db := testdb.Connect(t)
+	ctx := testdb.Context(t)
+	q := queue.New(db.GetTasks(), db.GetDeadTasks())
+
+	task, err := q.Enqueue(ctx, "test", payload{N: 1}, queue.EnqueueOptions{MaxAttempts: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leased, err := q.Lease(ctx, "worker", []string{"test"}, time.Minute)
+	if err != nil || leased == nil || leased.ID != task.ID {
+		t.Fatalf("lease returned %v, %v, want the task", leased, err)
+	}
+	before := time.Now().UTC()
+	if err := q.Fail(ctx, leased, errors.New("boom")); err != nil {
+		t.Fatal(err)
+	}
+	retried := new(model.Task)
+	if err := db.GetTasks().FindOne(retried, ctx, bson.M{"_id": task.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if retried.Status != model.TaskPending || retried.Last_error != "boom" {
+		t.Errorf("failed task is %s with error %q, want pending with the error", retried.Status, retried.Last_error)
+	}
+	if retried.Run_at.Before(before.Add(queue.Backoff(1) - time.Second)) {
+		t.Errorf("failed task runs again at %s, want a backoff of %s", retried.Run_at, queue.Backoff(1))
+	}
+	if again, err := q.Lease(ctx, "worker", []string{"test"}, time.Minute); err != nil || again != nil {
+		t.Fatalf("task was leased again during its backoff: %v, %v", again, err)
+	}
+
+	// the backoff is over
+	_, err = db.GetTasks().UpdateOne(ctx, bson.M{"_id": task.ID}, bson.M{"$set": bson.M{"run_at": before}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leased, err = q.Lease(ctx, "worker", []string{"test"}, time.Minute)
+	if err != nil || leased == nil {
+		t.Fatalf("lease after the backoff returned %v, %v", leased, err)
+	}
+	if err := q.Fail(ctx, leased, errors.New("boom again")); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := db.GetTasks().CountDocuments(ctx, bson.M{"_id": task.ID}); count != 0 {
+		t.Errorf("task that used all attempts is still queued")
+	}
+	dead := new(model.Task)
+	if err := db.GetDeadTasks().FindOne(dead, ctx, bson.M{"_id": task.ID}); err != nil {
+		t.Fatalf("task is not dead lettered: %v", err)
+	}
+	if dead.Status != model.TaskDead || dead.Last_error != "boom again" {
+		t.Errorf("dead task is %s with error %q", dead.Status, dead.Last_error)
+	}
+}
+
+func TestExpiredLeaseCanNotFinishTheTaskOfTheNextLease(t *testing.T) {
+	db := testdb.Connect(t)
+	ctx := testdb.Context(t)
+	q := queue.New(db.GetTasks(), db.GetDeadTasks())
+
+	task, err := q.Enqueue(ctx, "test", payload{N: 1}, queue.EnqueueOptions{MaxAttempts: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// both leases have the same owner, like two workers of one pool
+	first, err := q.Lease(ctx, "worker", []string{"test"}, time.Millisecond)
+	if err != nil || first == nil {
+		t.Fatalf("lease returned %v, %v", first, err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	second, err := q.Lease(ctx, "worker", []string{"test"}, time.Minute)
+	if err != nil || second == nil || second.ID != task.ID {
+		t.Fatalf("lease after the first one ran out returned %v, %v", second, err)
+	}
+
+	if err := q.Complete(ctx, first); !errors.Is(err, queue.ErrLeaseLost) {
+		t.Errorf("completing the expired lease: error %v, want %v", err, queue.ErrLeaseLost)
+	}
+	// the first lease used the only attempt, failing it would dead letter the task
+	if err := q.Fail(ctx, first, errors.New("boom")); !errors.Is(err, queue.ErrLeaseLost) {
+		t.Errorf("failing the expired lease: error %v, want %v", err, queue.ErrLeaseLost)
+	}
+	current := new(model.Task)
+	if err := db.GetTasks().FindOne(current, ctx, bson.M{"_id": task.ID}); err != nil {
+		t.Fatalf("the task is gone: %v", err)
+	}
+	if current.Status != model.TaskLeased || current.Attempts != second.Attempts {
+		t.Errorf("task is %s after %d attempts, want the second lease", current.Status, current.Attempts)
+	}
+	if count, _ := db.GetDeadTasks().CountDocuments(ctx, bson.M{"_id": task.ID}); count != 0 {
+		t.Errorf("the task of the second lease is dead lettered")
+	}
+
+	if err := q.Complete(ctx, second); err != nil {
+		t.Fatalf("completing the second lease: %v", err)
+	}
+	if err := db.GetTasks().FindOne(current, ctx, bson.M{"_id": task.ID}); err != nil || current.Status != model.TaskDone {
+		t.Errorf("task is %s after the second lease completed, %v", current.Status, err)
+	}
+}
+
+func TestBackoffDoublesUpToAnHour(t *testing.T) {
+	if queue.Backoff(1) != 10*time.Second || queue.Backoff(2) != 20*time.Second || queue.Backoff(3) != 40*time.Second {
+		t.Errorf("backoff is %s, %s, %s", queue.Backoff(1), queue.Backoff(2), queue.Backoff(3))
+	}
+	if queue.Backoff(30) != time.Hour || queue.Backoff(100) != time.Hour {
+		t.Errorf("backoff is not capped at an hour: %s, %s", queue.Backoff(30), queue.Backoff(100))
+	}
+}
@@ -0,0 +1,100 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/app/router/handlers"
+	"github.com/CoffeeHausGames/whir-server/tests/testdb"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestAuditLogPagesThroughATimeRange(t *testing.T) {
+	db := testdb.Connect(t)
+	ctx := testdb.Context(t)
+	env := handlers.NewHandlerEnv(db, handlers.Services{})
+
+	// 130 entries a minute apart, the first 10 are before the range; pairs are recorded at the same time
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	var inRange []primitive.ObjectID
+	for i := 0; i < 130; i++ {
+		entry := &model.AuditEntry{
+			ID:         primitive.NewObjectID(),
+			Action:     model.AuditPlanChanged,
+			Created_at: start.Add(time.Duration(i/2) * time.Minute),
+		}
+		if _, err := db.GetAuditLog().InsertOne(ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+		if i >= 10 {
+			inRange = append([]primitive.ObjectID{entry.ID}, inRange...)
+		}
+	}
+
+	get := func(query url.Values) []primitive.ObjectID {
+		t.Helper()
+		w := httptest.NewRecorder()
+		env.GetAuditLog(w, request(http.MethodGet, "/v1/admin/audit?"+query.Encode(), "", primitive.NewObjectID()), nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("?%s got %d: %s", query.Encode(), w.Code, w.Body.String())
+		}
+		var response struct {
+			Data []model.AuditEntry `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		ids := []primitive.ObjectID{}
+		for _, entry := range response.Data {
+			ids = append(ids, entry.ID)
+		}
+		return ids
+	}
+	equal := func(got []primitive.ObjectID, want []primitive.ObjectID) bool {
+		if len(got) != len(want) {
+			return false
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	from := start.Add(5 * time.Minute).Format(time.RFC3339)
+	var paged []primitive.ObjectID
+	for page := 1; page <= 3; page++ {
+		paged = append(paged, get(url.Values{"from": {from}, "page": {strconv.Itoa(page)}})...)
+	}
+	if !equal(paged, inRange) {
+		t.Errorf("three pages have %d entries, want the %d in the range newest first", len(paged), len(inRange))
+	}
+
+	var followed []primitive.ObjectID
+	query := url.Values{"from": {from}}
+	for i := 0; i < 4; i++ {
+		ids := get(query)
+		followed = append(followed, ids...)
+		if len(ids) < 50 {
+			break
+		}
+		query.Set("before", ids[len(ids)-1].Hex())
+	}
+	if !equal(followed, inRange) {
+		t.Errorf("following ?before= gave %d entries, want the %d in the range newest first", len(followed), len(inRange))
+	}
+
+	w := httptest.NewRecorder()
+	env.GetAuditLog(w, request(http.MethodGet, "/v1/admin/audit?before=nope", "", primitive.NewObjectID()), nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("an invalid ?before= got %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
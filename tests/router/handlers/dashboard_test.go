@@ -0,0 +1,165 @@
+package handlers_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/auth"
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/app/realtime"
+	"github.com/CoffeeHausGames/whir-server/app/router/handlers"
+	"github.com/CoffeeHausGames/whir-server/tests/testdb"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type dashboardSession struct {
+	Type            string    `json:"type"`
+	Reconnect_token string    `json:"reconnect_token"`
+	Expires_at      time.Time `json:"expires_at"`
+}
+
+// dialDashboard opens the dashboard socket and resumes with a reconnect token, it returns the session
+// message or the close code the server answered with
+func dialDashboard(t *testing.T, server *httptest.Server, token string) (*dashboardSession, int) {
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	request := "GET /v1/business/dashboard/ws HTTP/1.1\r\n" +
+		"Host: " + server.Listener.Addr().String() + "\r\n" +
+		"Connection: Upgrade\r\nUpgrade: websocket\r\n" +
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n"
+	if _, err := io.WriteString(conn, request); err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("opening the socket got %d", response.StatusCode)
+	}
+
+	// frames from a client are masked, a zero mask leaves the payload as it is
+	resume, _ := json.Marshal(map[string]string{"type": "resume", "reconnect_token": token})
+	frame := append([]byte{0x80 | realtime.OpText, 0x80 | 126, 0, 0, 0, 0, 0, 0}, resume...)
+	binary.BigEndian.PutUint16(frame[2:], uint16(len(resume)))
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first frame is the session or the close, frames from the server are not masked
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		t.Fatal(err)
+	}
+	length := int(header[1] & 0x7f)
+	if length == 126 {
+		extended := make([]byte, 2)
+		if _, err := io.ReadFull(reader, extended); err != nil {
+			t.Fatal(err)
+		}
+		length = int(binary.BigEndian.Uint16(extended))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		t.Fatal(err)
+	}
+	if header[0]&0x0f == realtime.OpClose {
+		return nil, int(binary.BigEndian.Uint16(payload))
+	}
+	session := new(dashboardSession)
+	if err := json.Unmarshal(payload, session); err != nil {
+		t.Fatal(err)
+	}
+	return session, 0
+}
+
+func TestDashboardResumesOnlyTheLogin(t *testing.T) {
+	db := testdb.Connect(t)
+	ctx := testdb.Context(t)
+	env := handlers.NewHandlerEnv(db, handlers.Services{Dashboard: realtime.NewBusinessHub(db.GetOutbox())})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		env.BusinessDashboardSocket(w, r, nil)
+	}))
+	t.Cleanup(server.Close)
+
+	businessID, adminID := primitive.NewObjectID(), primitive.NewObjectID()
+	if _, err := db.GetBusinesses().InsertOne(ctx, bson.M{"_id": businessID}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetUsers().InsertOne(ctx, bson.M{"_id": adminID, "role": model.RoleAdmin}); err != nil {
+		t.Fatal(err)
+	}
+
+	// an impersonation login with two minutes left
+	now := time.Now()
+	login := auth.ReconnectSession{
+		Uid:          businessID.Hex(),
+		IssuedAt:     now.Add(-58 * time.Minute).Unix(),
+		ExpiresAt:    now.Add(2 * time.Minute).Unix(),
+		Impersonator: adminID.Hex(),
+	}
+	token, _ := auth.SignReconnectToken(login, now.Add(time.Minute))
+
+	session, closed := dialDashboard(t, server, token)
+	if session == nil {
+		t.Fatalf("reconnecting was closed with %d, want the session", closed)
+	}
+	if session.Expires_at.Unix() != login.ExpiresAt {
+		t.Errorf("renewed token expires at %s, want when the login does at %s", session.Expires_at, time.Unix(login.ExpiresAt, 0))
+	}
+	renewed, err := auth.VerifyReconnectToken(session.Reconnect_token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed != login {
+		t.Errorf("renewed token resumes %+v, want the login %+v", renewed, login)
+	}
+
+	// the admin is no longer an admin
+	if _, err := db.GetUsers().UpdateOne(ctx, bson.M{"_id": adminID}, bson.M{"$unset": bson.M{"role": ""}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, closed := dialDashboard(t, server, session.Reconnect_token); closed != realtime.CloseSessionRevoked {
+		t.Errorf("reconnecting after the impersonation ended was closed with %d, want %d", closed, realtime.CloseSessionRevoked)
+	}
+
+	// a login that ran out
+	login.Impersonator = ""
+	login.ExpiresAt = now.Add(-time.Second).Unix()
+	token, _ = auth.SignReconnectToken(login, now.Add(time.Minute))
+	if _, closed := dialDashboard(t, server, token); closed != realtime.CloseSessionRevoked {
+		t.Errorf("reconnecting after the login expired was closed with %d, want %d", closed, realtime.CloseSessionRevoked)
+	}
+}
+
+func TestDashboardRefusesTheReconnectTokenInTheURL(t *testing.T) {
+	env := handlers.NewHandlerEnv(nil, handlers.Services{})
+	token, _ := auth.SignReconnectToken(auth.ReconnectSession{Uid: primitive.NewObjectID().Hex()}, time.Now().Add(time.Minute))
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/business/dashboard/ws?reconnect_token="+url.QueryEscape(token), nil)
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Sec-WebSocket-Version", "13")
+	r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	w := httptest.NewRecorder()
+	env.BusinessDashboardSocket(w, r, nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("reconnect token in the URL got %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,38 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/CoffeeHausGames/whir-server/app/router/handlers"
+	"github.com/CoffeeHausGames/whir-server/tests/testdb"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestUnknownDealStatusIsABadRequest(t *testing.T) {
+	db := testdb.Connect(t)
+	env := handlers.NewHandlerEnv(db, handlers.Services{})
+	businessID := primitive.NewObjectID()
+
+	tests := []struct {
+		status string
+		want   int
+	}{
+		{"draft,%20live", http.StatusOK},
+		{"live,expried", http.StatusBadRequest},
+		{"", http.StatusOK},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		env.GetSignedInBusinessDeals(w, request(http.MethodGet, "/v1/business/deals?status="+test.status, "", businessID), nil)
+		if w.Code != test.want {
+			t.Errorf("?status=%s: status %d, want %d: %s", test.status, w.Code, test.want, w.Body.String())
+		}
+		if test.want == http.StatusBadRequest && !strings.Contains(w.Body.String(), "expried") {
+			t.Errorf("?status=%s: the error %s does not name the unknown status", test.status, w.Body.String())
+		}
+	}
+}
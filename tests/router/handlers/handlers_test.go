@@ -0,0 +1,20 @@
+package handlers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/CoffeeHausGames/whir-server/app/auth"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// request builds a request the way the authentication and body middleware hand it to a handler
+func request(method string, target string, body string, uid primitive.ObjectID) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	ctx := context.WithValue(r.Context(), "claims", &auth.SignedDetails{Uid: uid.Hex()})
+	ctx = context.WithValue(ctx, "body", body)
+	return r.WithContext(ctx)
+}
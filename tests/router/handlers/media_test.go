@@ -0,0 +1,46 @@
+package handlers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/CoffeeHausGames/whir-server/app/router/handlers"
+	"github.com/CoffeeHausGames/whir-server/app/storage"
+)
+
+func TestServeMediaRefusesPrivateFiles(t *testing.T) {
+	store, err := storage.NewLocalStorage(t.TempDir(), "http://localhost:4444/v1/media")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"private/verification/doc.pdf", "businesses/logo.png"} {
+		if err := store.Put(context.Background(), key, strings.NewReader("file"), 4, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	env := handlers.NewHandlerEnv(nil, handlers.Services{Storage: store})
+
+	tests := []struct {
+		filepath string
+		want     int
+	}{
+		{"/businesses/logo.png", http.StatusOK},
+		{"/private/verification/doc.pdf", http.StatusNotFound},
+		{"//private/verification/doc.pdf", http.StatusNotFound},
+		{"/./private/verification/doc.pdf", http.StatusNotFound},
+		{"/businesses/../private/verification/doc.pdf", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		ps := httprouter.Params{{Key: "filepath", Value: test.filepath}}
+		env.ServeMedia(w, httptest.NewRequest(http.MethodGet, "/v1/media"+test.filepath, nil), ps)
+		if w.Code != test.want {
+			t.Errorf("%s: status %d, want %d", test.filepath, w.Code, test.want)
+		}
+	}
+}
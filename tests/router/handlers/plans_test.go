@@ -0,0 +1,109 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/app/plans"
+	"github.com/CoffeeHausGames/whir-server/app/router/handlers"
+	"github.com/CoffeeHausGames/whir-server/tests/testdb"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// concurrently calls handler n times at once and returns how many answered with each status
+func concurrently(n int, handler func() int) map[int]int {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	statuses := make(map[int]int)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status := handler()
+			mu.Lock()
+			statuses[status]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return statuses
+}
+
+func TestConcurrentWebhooksStayWithinThePlan(t *testing.T) {
+	db := testdb.Connect(t)
+	ctx := testdb.Context(t)
+	env := handlers.NewHandlerEnv(db, handlers.Services{Plans: plans.NewService(db, plans.DefaultCatalog())})
+
+	businessID := primitive.NewObjectID()
+	if _, err := db.GetBusinesses().InsertOne(ctx, bson.M{"_id": businessID, "plan": "pro"}); err != nil {
+		t.Fatal(err)
+	}
+	pro, _ := plans.DefaultCatalog().Get("pro")
+	limit := pro.Limit(model.LimitWebhooks)
+
+	body := `{"url": "https://example.com/hook", "events": ["` + model.EventDealCreated + `"]}`
+	statuses := concurrently(int(limit)+5, func() int {
+		w := httptest.NewRecorder()
+		env.CreateWebhook(w, request(http.MethodPost, "/v1/business/webhooks", body, businessID), nil)
+		return w.Code
+	})
+	if statuses[http.StatusOK] != int(limit) || statuses[http.StatusPaymentRequired] != 5 {
+		t.Errorf("statuses %v, want %d created and 5 over the plan", statuses, limit)
+	}
+	created, err := db.GetWebhooks().CountDocuments(ctx, bson.M{"business_id": businessID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created != limit {
+		t.Errorf("%d webhooks were created, the plan allows %d", created, limit)
+	}
+}
+
+func TestConcurrentPinsStayWithinThePlan(t *testing.T) {
+	db := testdb.Connect(t)
+	ctx := testdb.Context(t)
+	env := handlers.NewHandlerEnv(db, handlers.Services{Plans: plans.NewService(db, plans.DefaultCatalog())})
+
+	businessID := primitive.NewObjectID()
+	if _, err := db.GetBusinesses().InsertOne(ctx, bson.M{"_id": businessID}); err != nil {
+		t.Fatal(err)
+	}
+	free, _ := plans.DefaultCatalog().Get("free")
+	limit := free.Limit(model.LimitPinnedDeals)
+
+	var dealIDs []primitive.ObjectID
+	for i := int64(0); i < limit+3; i++ {
+		dealID := primitive.NewObjectID()
+		if _, err := db.GetDeals().InsertOne(ctx, bson.M{"_id": dealID, "business_id": businessID, "status": model.DealLive}); err != nil {
+			t.Fatal(err)
+		}
+		dealIDs = append(dealIDs, dealID)
+	}
+
+	next := make(chan primitive.ObjectID, len(dealIDs))
+	for _, dealID := range dealIDs {
+		next <- dealID
+	}
+	statuses := concurrently(len(dealIDs), func() int {
+		w := httptest.NewRecorder()
+		env.PinDeal(w, request(http.MethodPost, "/v1/business/deals/pin", `{"id": "`+(<-next).Hex()+`"}`, businessID), nil)
+		return w.Code
+	})
+
+	business := new(model.BusinessUser)
+	if err := db.GetBusinesses().FindOne(business, ctx, bson.M{"_id": businessID}); err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(business.PinnedDeals)) > limit || len(business.PinnedDeals) == 0 {
+		t.Errorf("%d deals are pinned, the plan allows %d", len(business.PinnedDeals), limit)
+	}
+	// a pin that was answered with 200 must not be overwritten by another one
+	if statuses[http.StatusOK] != len(business.PinnedDeals) {
+		t.Errorf("%d pins succeeded but %d deals are pinned, statuses %v", statuses[http.StatusOK], len(business.PinnedDeals), statuses)
+	}
+}
@@ -0,0 +1,84 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/app/plans"
+	"github.com/CoffeeHausGames/whir-server/app/promotions"
+	"github.com/CoffeeHausGames/whir-server/app/router/handlers"
+	"github.com/CoffeeHausGames/whir-server/tests/testdb"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPromotionBudgetsAreCappedByThePlan(t *testing.T) {
+	db := testdb.Connect(t)
+	ctx := testdb.Context(t)
+	env := handlers.NewHandlerEnv(db, handlers.Services{
+		Plans:      plans.NewService(db, plans.DefaultCatalog()),
+		Promotions: promotions.NewService(db, promotions.Pricing{Impression: 1, Click: 25}),
+	})
+
+	businessID, dealID := primitive.NewObjectID(), primitive.NewObjectID()
+	business := bson.M{"_id": businessID, "location": bson.M{"type": "Point", "coordinates": bson.A{-87.62, 41.88}}}
+	if _, err := db.GetBusinesses().InsertOne(ctx, business); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetDeals().InsertOne(ctx, bson.M{"_id": dealID, "business_id": businessID, "status": model.DealLive}); err != nil {
+		t.Fatal(err)
+	}
+	endDate := time.Now().UTC().Add(7 * 24 * time.Hour).Format(time.RFC3339)
+	create := func(budget int) (int, string) {
+		body := `{"deal_id": "` + dealID.Hex() + `", "budget": ` + strconv.Itoa(budget) + `, "end_date": "` + endDate + `"}`
+		w := httptest.NewRecorder()
+		env.CreatePromotion(w, request(http.MethodPost, "/v1/business/promotions", body, businessID), nil)
+		var response struct {
+			Data model.Promotion `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response.Data.ID.Hex()
+	}
+	update := func(id string, body string) int {
+		w := httptest.NewRecorder()
+		ps := httprouter.Params{{Key: "id", Value: id}}
+		env.UpdatePromotion(w, request(http.MethodPut, "/v1/business/promotions/"+id, body, businessID), ps)
+		return w.Code
+	}
+
+	// the free plan has no promotion budget
+	if status, _ := create(500); status != http.StatusPaymentRequired {
+		t.Errorf("promotion on the free plan: status %d, want %d", status, http.StatusPaymentRequired)
+	}
+
+	if _, err := db.GetBusinesses().UpdateOne(ctx, bson.M{"_id": businessID}, bson.M{"$set": bson.M{"plan": "pro"}}); err != nil {
+		t.Fatal(err)
+	}
+	status, first := create(30000)
+	if status != http.StatusOK {
+		t.Fatalf("promotion within the pro budget: status %d", status)
+	}
+	if status, _ := create(30000); status != http.StatusPaymentRequired {
+		t.Errorf("promotion over the pro budget: status %d, want %d", status, http.StatusPaymentRequired)
+	}
+	if status, _ := create(20000); status != http.StatusOK {
+		t.Errorf("promotion up to the pro budget: status %d, want %d", status, http.StatusOK)
+	}
+	if status := update(first, `{"budget": 30001}`); status != http.StatusPaymentRequired {
+		t.Errorf("raising a budget over the plan: status %d, want %d", status, http.StatusPaymentRequired)
+	}
+	if status := update(first, `{"budget": 1000}`); status != http.StatusOK {
+		t.Errorf("lowering a budget: status %d, want %d", status, http.StatusOK)
+	}
+	if status, _ := create(29000); status != http.StatusOK {
+		t.Errorf("promotion in the budget that was freed: status %d, want %d", status, http.StatusOK)
+	}
+}
@@ -0,0 +1,174 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/app/router/handlers"
+	"github.com/CoffeeHausGames/whir-server/tests/testdb"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestConcurrentClaimsStayWithinTheDailyLimit(t *testing.T) {
+	db := testdb.Connect(t)
+	ctx := testdb.Context(t)
+	env := handlers.NewHandlerEnv(db, handlers.Services{})
+
+	name := "Two coffees a day"
+	limit, quantity := 2, 100
+	deal := &model.Deal{
+		ID:           primitive.NewObjectID(),
+		Business_id:  primitive.NewObjectID(),
+		Name:         &name,
+		Claim_limits: &model.ClaimLimits{Per_user_per_day: &limit},
+		Quantity:     &quantity,
+		Remaining:    &quantity,
+	}
+	if _, err := db.GetBusinesses().InsertOne(ctx, bson.M{"_id": deal.Business_id}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetDeals().InsertOne(ctx, deal); err != nil {
+		t.Fatal(err)
+	}
+
+	userID := primitive.NewObjectID()
+	body := `{"deal_id": "` + deal.ID.Hex() + `"}`
+	claims := 8
+	statuses := make(chan int, claims)
+	var wg sync.WaitGroup
+	for i := 0; i < claims; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			env.ClaimDeal(w, request(http.MethodPost, "/v1/user/redemptions", body, userID), nil)
+			statuses <- w.Code
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	claimed, limited := 0, 0
+	for status := range statuses {
+		switch status {
+		case http.StatusOK:
+			claimed++
+		case http.StatusTooManyRequests:
+			limited++
+		default:
+			t.Errorf("unexpected status %d", status)
+		}
+	}
+	if claimed != limit || limited != claims-limit {
+		t.Errorf("%d claims went through and %d were limited, want %d and %d", claimed, limited, limit, claims-limit)
+	}
+	count, err := db.GetRedemptions().CountDocuments(ctx, bson.M{"deal_id": deal.ID, "user_id": userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != int64(limit) {
+		t.Errorf("%d redemptions were created, want %d", count, limit)
+	}
+	// the limited claims did not take from the inventory
+	stored := new(model.Deal)
+	if err := db.GetDeals().FindOne(stored, ctx, bson.M{"_id": deal.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if *stored.Remaining != quantity-limit {
+		t.Errorf("%d remaining, want %d", *stored.Remaining, quantity-limit)
+	}
+
+	// the limit is per user
+	w := httptest.NewRecorder()
+	env.ClaimDeal(w, request(http.MethodPost, "/v1/user/redemptions", body, primitive.NewObjectID()), nil)
+	if w.Code != http.StatusOK {
+		t.Errorf("another user got %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestDealsHeldBackByModerationCanNotBeClaimed(t *testing.T) {
+	db := testdb.Connect(t)
+	ctx := testdb.Context(t)
+	env := handlers.NewHandlerEnv(db, handlers.Services{})
+	businessID := primitive.NewObjectID()
+	if _, err := db.GetBusinesses().InsertOne(ctx, bson.M{"_id": businessID}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, status := range []string{model.ModerationPending, model.ModerationRejected, model.ModerationHidden, model.ModerationApproved} {
+		dealID := primitive.NewObjectID()
+		deal := bson.M{"_id": dealID, "business_id": businessID, "status": model.DealLive, "moderation_status": status}
+		if _, err := db.GetDeals().InsertOne(ctx, deal); err != nil {
+			t.Fatal(err)
+		}
+		want := http.StatusNotFound
+		if status == model.ModerationApproved {
+			want = http.StatusOK
+		}
+		w := httptest.NewRecorder()
+		env.ClaimDeal(w, request(http.MethodPost, "/v1/user/redemptions", `{"deal_id": "`+dealID.Hex()+`"}`, primitive.NewObjectID()), nil)
+		if w.Code != want {
+			t.Errorf("claiming a %s deal got %d, want %d", status, w.Code, want)
+		}
+	}
+	if count, _ := db.GetRedemptions().CountDocuments(ctx, bson.M{}); count != 1 {
+		t.Errorf("%d redemptions were created, want only the approved deal", count)
+	}
+}
+
+func TestDealsOfSuspendedBusinessesCanNotBeClaimedOrRedeemed(t *testing.T) {
+	db := testdb.Connect(t)
+	ctx := testdb.Context(t)
+	env := handlers.NewHandlerEnv(db, handlers.Services{})
+
+	businessID, dealID := primitive.NewObjectID(), primitive.NewObjectID()
+	if _, err := db.GetBusinesses().InsertOne(ctx, bson.M{"_id": businessID}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetDeals().InsertOne(ctx, bson.M{"_id": dealID, "business_id": businessID, "status": model.DealLive}); err != nil {
+		t.Fatal(err)
+	}
+	claim := func() int {
+		w := httptest.NewRecorder()
+		env.ClaimDeal(w, request(http.MethodPost, "/v1/user/redemptions", `{"deal_id": "`+dealID.Hex()+`"}`, primitive.NewObjectID()), nil)
+		return w.Code
+	}
+	if status := claim(); status != http.StatusOK {
+		t.Fatalf("claiming got %d, want %d", status, http.StatusOK)
+	}
+	redemption := new(model.Redemption)
+	if err := db.GetRedemptions().FindOne(redemption, ctx, bson.M{"deal_id": dealID}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := db.GetBusinesses().UpdateOne(ctx, bson.M{"_id": businessID}, bson.M{"$set": bson.M{"suspended_at": time.Now().UTC()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := claim(); status != http.StatusNotFound {
+		t.Errorf("claiming a deal of a suspended business got %d, want %d", status, http.StatusNotFound)
+	}
+	body := `{"code": "` + redemption.Code + `"}`
+	w := httptest.NewRecorder()
+	env.VerifyRedemption(w, request(http.MethodPost, "/v1/business/redemptions/verify", body, businessID), nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("verifying a code of a suspended business got %d, want %d", w.Code, http.StatusNotFound)
+	}
+	w = httptest.NewRecorder()
+	env.RedeemRedemption(w, request(http.MethodPost, "/v1/business/redemptions/redeem", body, businessID), nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("redeeming a code of a suspended business got %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if err := db.GetRedemptions().FindOne(redemption, ctx, bson.M{"_id": redemption.ID}); err != nil || redemption.Status != model.RedemptionClaimed {
+		t.Errorf("redemption is %s, want it still claimed: %v", redemption.Status, err)
+	}
+	if count, _ := db.GetRedemptions().CountDocuments(ctx, bson.M{"deal_id": dealID}); count != 1 {
+		t.Errorf("%d redemptions, want only the one from before the suspension", count)
+	}
+}
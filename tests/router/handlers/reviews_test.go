@@ -0,0 +1,95 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/app/moderation"
+	"github.com/CoffeeHausGames/whir-server/app/router/handlers"
+	"github.com/CoffeeHausGames/whir-server/tests/testdb"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestDealReviewsAreRatedOnTheDeal(t *testing.T) {
+	db := testdb.Connect(t)
+	ctx := testdb.Context(t)
+	env := handlers.NewHandlerEnv(db, handlers.Services{
+		Moderation: moderation.NewService(db, moderation.NewScreener(nil, true, true)),
+	})
+
+	businessID, dealID, userID := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	if _, err := db.GetBusinesses().InsertOne(ctx, bson.M{"_id": businessID, "business_name": "Corner Cafe"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetDeals().InsertOne(ctx, bson.M{"_id": dealID, "business_id": businessID, "status": model.DealLive}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetUsers().InsertOne(ctx, bson.M{"_id": userID, "first_name": "Ada"}); err != nil {
+		t.Fatal(err)
+	}
+	redemption := bson.M{"_id": primitive.NewObjectID(), "user_id": userID, "business_id": businessID, "deal_id": dealID,
+		"status": model.RedemptionRedeemed, "claimed_at": time.Now().UTC()}
+	if _, err := db.GetRedemptions().InsertOne(ctx, redemption); err != nil {
+		t.Fatal(err)
+	}
+
+	var dealReview string
+	for _, body := range []string{
+		`{"target_type": "business", "target_id": "` + businessID.Hex() + `", "rating": 5}`,
+		`{"target_type": "deal", "target_id": "` + dealID.Hex() + `", "rating": 1}`,
+	} {
+		w := httptest.NewRecorder()
+		env.CreateReview(w, request(http.MethodPost, "/v1/reviews", body, userID), nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("creating a review: status %d: %s", w.Code, w.Body.String())
+		}
+		review := new(model.Review)
+		if err := db.GetReviews().FindOne(review, ctx, bson.M{"target_id": dealID}); err == nil {
+			dealReview = review.ID.Hex()
+		}
+	}
+
+	business := new(model.BusinessUser)
+	if err := db.GetBusinesses().FindOne(business, ctx, bson.M{"_id": businessID}); err != nil {
+		t.Fatal(err)
+	}
+	if business.Rating == nil || business.Rating.Sum != 5 || business.Rating.Count != 1 {
+		t.Errorf("business rating = %+v, want only the business review", business.Rating)
+	}
+	deal := new(model.Deal)
+	if err := db.GetDeals().FindOne(deal, ctx, bson.M{"_id": dealID}); err != nil {
+		t.Fatal(err)
+	}
+	deal.ComputeFields()
+	if summary := deal.Rating_summary; summary == nil || summary.Average != 1 || summary.Count != 1 {
+		t.Errorf("deal rating = %+v, want the deal review", summary)
+	}
+
+	w := httptest.NewRecorder()
+	ps := httprouter.Params{{Key: "id", Value: dealReview}}
+	env.DeleteReview(w, request(http.MethodDelete, "/v1/reviews/"+dealReview, "", userID), ps)
+	if w.Code != http.StatusOK {
+		t.Fatalf("deleting the deal review: status %d: %s", w.Code, w.Body.String())
+	}
+	deal = new(model.Deal)
+	if err := db.GetDeals().FindOne(deal, ctx, bson.M{"_id": dealID}); err != nil {
+		t.Fatal(err)
+	}
+	if deal.Rating == nil || deal.Rating.Count != 0 || deal.Rating.Summary() != nil {
+		t.Errorf("deal rating after the review was deleted = %+v", deal.Rating)
+	}
+	business = new(model.BusinessUser)
+	if err := db.GetBusinesses().FindOne(business, ctx, bson.M{"_id": businessID}); err != nil {
+		t.Fatal(err)
+	}
+	if business.Rating == nil || business.Rating.Count != 1 {
+		t.Errorf("business rating after the deal review was deleted = %+v", business.Rating)
+	}
+}
@@ -0,0 +1,144 @@
+package database_test
+
+import (
+	"testing"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/tests/testdb"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestClaimLimitTotalsBecomeQuantities(t *testing.T) {
+	db := testdb.Connect(t)
+	ctx := testdb.Context(t)
+	deals := db.GetDeals()
+
+	// deals saved while the total claims were limited with claim_limits.total
+	withDailyLimit := primitive.NewObjectID()
+	totalOnly := primitive.NewObjectID()
+	withQuantity := primitive.NewObjectID()
+	for _, deal := range []bson.M{
+		{"_id": withDailyLimit, "claim_limits": bson.M{"total": 3, "per_user_per_day": 1}},
+		{"_id": totalOnly, "claim_limits": bson.M{"total": 1}},
+		{"_id": withQuantity, "claim_limits": bson.M{"total": 10}, "quantity": 5, "remaining": 4},
+	} {
+		if _, err := deals.InsertOne(ctx, deal); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// claims already made count against the total
+	for _, dealID := range []primitive.ObjectID{withDailyLimit, totalOnly, totalOnly} {
+		if _, err := db.GetRedemptions().InsertOne(ctx, bson.M{"_id": primitive.NewObjectID(), "deal_id": dealID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// running it again changes nothing
+	for i := 0; i < 2; i++ {
+		if err := db.Migrate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		id        primitive.ObjectID
+		quantity  int
+		remaining int
+		daily     *int
+	}{
+		{withDailyLimit, 3, 2, intPtr(1)},
+		{totalOnly, 1, 0, nil},
+		{withQuantity, 5, 4, nil},
+	}
+	for _, test := range tests {
+		deal := new(model.Deal)
+		if err := deals.FindOne(deal, ctx, bson.M{"_id": test.id}); err != nil {
+			t.Fatal(err)
+		}
+		if deal.Quantity == nil || *deal.Quantity != test.quantity || deal.Remaining == nil || *deal.Remaining != test.remaining {
+			t.Errorf("deal %s has quantity %v and remaining %v, want %d and %d", test.id.Hex(), deal.Quantity, deal.Remaining, test.quantity, test.remaining)
+		}
+		if test.daily == nil && deal.Claim_limits != nil && deal.Claim_limits.Per_user_per_day != nil {
+			t.Errorf("deal %s has a daily limit, want none", test.id.Hex())
+		}
+		if test.daily != nil && (deal.Claim_limits == nil || deal.Claim_limits.Per_user_per_day == nil || *deal.Claim_limits.Per_user_per_day != *test.daily) {
+			t.Errorf("deal %s lost its daily limit", test.id.Hex())
+		}
+	}
+	left, err := deals.CountDocuments(ctx, bson.M{"claim_limits.total": bson.M{"$exists": true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left != 0 {
+		t.Errorf("%d deals still have a total claim limit", left)
+	}
+}
+
+func TestDealReviewsMoveOutOfBusinessRatings(t *testing.T) {
+	db := testdb.Connect(t)
+	ctx := testdb.Context(t)
+
+	// the business rating used to count the reviews of its deals
+	businessID, dealID, onlyDealReviews := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	for _, business := range []bson.M{
+		{"_id": businessID, "rating": bson.M{"sum": 9, "count": 3}},
+		{"_id": onlyDealReviews, "rating": bson.M{"sum": 2, "count": 1}},
+	} {
+		if _, err := db.GetBusinesses().InsertOne(ctx, business); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.GetDeals().InsertOne(ctx, bson.M{"_id": dealID, "business_id": businessID}); err != nil {
+		t.Fatal(err)
+	}
+	for _, review := range []bson.M{
+		{"target_type": model.ReviewTargetBusiness, "target_id": businessID, "rating": 5},
+		{"target_type": model.ReviewTargetBusiness, "target_id": businessID, "rating": 1, "moderation_status": model.ModerationHidden},
+		{"target_type": model.ReviewTargetDeal, "target_id": dealID, "rating": 3},
+		{"target_type": model.ReviewTargetDeal, "target_id": dealID, "rating": 1},
+	} {
+		review["_id"] = primitive.NewObjectID()
+		if _, err := db.GetReviews().InsertOne(ctx, review); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	// a review counted after the migration is not counted again on the next start
+	if _, err := db.GetBusinesses().UpdateOne(ctx, bson.M{"_id": businessID}, bson.M{"$inc": bson.M{"rating.sum": 4, "rating.count": 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	business := new(model.BusinessUser)
+	if err := db.GetBusinesses().FindOne(business, ctx, bson.M{"_id": businessID}); err != nil {
+		t.Fatal(err)
+	}
+	if business.Rating == nil || business.Rating.Sum != 9 || business.Rating.Count != 2 {
+		t.Errorf("business rating = %+v, want a sum of 9 over 2 reviews", business.Rating)
+	}
+	other := new(model.BusinessUser)
+	if err := db.GetBusinesses().FindOne(other, ctx, bson.M{"_id": onlyDealReviews}); err != nil {
+		t.Fatal(err)
+	}
+	if other.Rating != nil {
+		t.Errorf("business without reviews has rating %+v", other.Rating)
+	}
+	deal := new(model.Deal)
+	if err := db.GetDeals().FindOne(deal, ctx, bson.M{"_id": dealID}); err != nil {
+		t.Fatal(err)
+	}
+	if deal.Rating == nil || deal.Rating.Sum != 4 || deal.Rating.Count != 2 {
+		t.Errorf("deal rating = %+v, want a sum of 4 over 2 reviews", deal.Rating)
+	}
+}
+
+func intPtr(v int) *int {
+	return &v
+}
@@ -0,0 +1,43 @@
+package database_test
+
+import (
+	"testing"
+
+	"github.com/CoffeeHausGames/whir-server/tests/testdb"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestInsertOneReturnsTheInsertedID(t *testing.T) {
+	db := testdb.Connect(t)
+	ctx := testdb.Context(t)
+
+	id := primitive.NewObjectID()
+	insertedID, err := db.GetTasks().InsertOne(ctx, bson.M{"_id": id, "type": "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if insertedID != id {
+		t.Errorf("inserted ID is %s, want %s", insertedID.Hex(), id.Hex())
+	}
+}
+
+func TestInsertOneDuplicateKeyIsAnError(t *testing.T) {
+	db := testdb.Connect(t)
+	ctx := testdb.Context(t)
+	tasks := db.GetTasks()
+
+	// idempotency_key has a unique index
+	if _, err := tasks.InsertOne(ctx, bson.M{"_id": primitive.NewObjectID(), "idempotency_key": "once"}); err != nil {
+		t.Fatal(err)
+	}
+	insertedID, err := tasks.InsertOne(ctx, bson.M{"_id": primitive.NewObjectID(), "idempotency_key": "once"})
+	if !mongo.IsDuplicateKeyError(err) {
+		t.Fatalf("second insert returned %v, want a duplicate key error", err)
+	}
+	if !insertedID.IsZero() {
+		t.Errorf("a failed insert returned the ID %s", insertedID.Hex())
+	}
+}
@@ -0,0 +1,34 @@
+package storage_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/CoffeeHausGames/whir-server/app/storage"
+)
+
+func TestPrivateStorageIsKeptApart(t *testing.T) {
+	public := t.TempDir()
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr bool
+	}{
+		{"local next to the public files", map[string]string{"STORAGE_LOCAL_PATH": public, "STORAGE_PRIVATE_PATH": t.TempDir()}, false},
+		{"local inside the public files", map[string]string{"STORAGE_LOCAL_PATH": public, "STORAGE_PRIVATE_PATH": filepath.Join(public, "private")}, true},
+		{"local as the public files", map[string]string{"STORAGE_LOCAL_PATH": public, "STORAGE_PRIVATE_PATH": public}, true},
+		{"s3 without a private bucket", map[string]string{"STORAGE_DRIVER": "s3", "S3_BUCKET": "media"}, true},
+		{"s3 with the public bucket", map[string]string{"STORAGE_DRIVER": "s3", "S3_BUCKET": "media", "S3_PRIVATE_BUCKET": "media"}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, name := range []string{"STORAGE_DRIVER", "STORAGE_LOCAL_PATH", "STORAGE_PRIVATE_PATH", "S3_BUCKET", "S3_PRIVATE_BUCKET"} {
+				t.Setenv(name, test.env[name])
+			}
+			_, err := storage.NewPrivateFromEnv()
+			if (err != nil) != test.wantErr {
+				t.Errorf("NewPrivateFromEnv() error = %v, want an error: %v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package tasks_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/app/queue"
+	"github.com/CoffeeHausGames/whir-server/app/tasks"
+	"github.com/CoffeeHausGames/whir-server/tests/testdb"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestGeocodeIsQueuedForEveryAddressChange(t *testing.T) {
+	db := testdb.Connect(t)
+	ctx := testdb.Context(t)
+	q := queue.New(db.GetTasks(), db.GetDeadTasks())
+
+	businessID := primitive.NewObjectID()
+	a := &model.Address{Street: "1 Main St", City: "Springfield", PostalCode: "12345"}
+	b := &model.Address{Street: "2 Oak Ave", City: "Springfield", PostalCode: "12345"}
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// A, then B, then back to A
+	for i, address := range []*model.Address{a, b, a} {
+		if err := tasks.EnqueueGeocodeBusiness(ctx, q, businessID, address, start.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the same change enqueued twice, e.g. a retried request
+	if err := tasks.EnqueueGeocodeBusiness(ctx, q, businessID, a, start.Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := db.GetTasks().CountDocuments(ctx, bson.M{"type": tasks.GeocodeBusiness})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("%d geocode tasks queued, want 3", count)
+	}
+}
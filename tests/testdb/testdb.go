@@ -0,0 +1,50 @@
+package testdb
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/server/database"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// testdb gives tests a database of their own on the mongodb server at MONGODB_TEST_URL.
+// Tests that need a database are skipped when it is not set
+
+// Connect returns a new database with the indexes of the server, it is dropped when the test ends
+func Connect(t *testing.T) *database.Database {
+	t.Helper()
+	url := os.Getenv("MONGODB_TEST_URL")
+	if url == "" {
+		t.Skip("MONGODB_TEST_URL is not set")
+	}
+
+	db, err := database.ConnectTo(url, "whir_test_"+primitive.NewObjectID().Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := db.Drop(ctx); err != nil {
+			t.Log(err)
+		}
+		db.Close()
+	})
+
+	// a server for tests may not have every kind of index (e.g. TTL or geo), the unique ones are what tests rely on
+	if err := db.EnsureIndexes(); err != nil {
+		t.Log(err)
+	}
+	return db
+}
+
+// Context returns a context for the calls of a test
+func Context(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
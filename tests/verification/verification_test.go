@@ -0,0 +1,103 @@
+package verification_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/app/server/database"
+	"github.com/CoffeeHausGames/whir-server/app/storage"
+	"github.com/CoffeeHausGames/whir-server/app/verification"
+	"github.com/CoffeeHausGames/whir-server/tests/testdb"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// caller records the numbers it called and fails when err is set
+type caller struct {
+	called []string
+	err    error
+}
+
+func (c *caller) CallWithCode(ctx context.Context, phoneNumber string, code string) error {
+	c.called = append(c.called, phoneNumber)
+	return c.err
+}
+
+func newService(t *testing.T, db *database.Database, c *caller) *verification.Service {
+	store, err := storage.NewLocalStorage(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return verification.NewService(db, store, c, verification.LogSender{})
+}
+
+func newBusiness(t *testing.T, db *database.Database, phoneNumber string) *model.BusinessUser {
+	business := &model.BusinessUser{ID: primitive.NewObjectID()}
+	if phoneNumber != "" {
+		business.Phone_number = &phoneNumber
+	}
+	if _, err := db.GetBusinesses().InsertOne(testdb.Context(t), business); err != nil {
+		t.Fatal(err)
+	}
+	return business
+}
+
+func TestPhoneCodeCallsTheListingNumber(t *testing.T) {
+	db := testdb.Connect(t)
+	c := &caller{}
+	service := newService(t, db, c)
+
+	business := newBusiness(t, db, "+13125550100")
+	request, err := service.RequestPhoneCode(testdb.Context(t), business)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.called) != 1 || c.called[0] != "+13125550100" {
+		t.Errorf("called %v, want the listing number", c.called)
+	}
+	if request.Phone_number != "+13125550100" || request.Status != model.VerificationAwaitingCode {
+		t.Errorf("request = %+v", request)
+	}
+
+	_, err = service.RequestPhoneCode(testdb.Context(t), newBusiness(t, db, ""))
+	if !errors.Is(err, verification.ErrNoPhoneNumber) {
+		t.Errorf("business without a phone number: error %v, want %v", err, verification.ErrNoPhoneNumber)
+	}
+	if len(c.called) != 1 {
+		t.Errorf("called %v, want no call without a phone number", c.called)
+	}
+}
+
+func TestFailedCallsCountTowardsTheDailyCodes(t *testing.T) {
+	db := testdb.Connect(t)
+	ctx := testdb.Context(t)
+	c := &caller{err: errors.New("the line is busy")}
+	service := newService(t, db, c)
+	business := newBusiness(t, db, "+13125550100")
+
+	for i := 0; i < verification.MaxCodesPerDay; i++ {
+		if _, err := service.RequestPhoneCode(ctx, business); err == nil {
+			t.Fatal("a failed call returned no error")
+		}
+	}
+	if _, err := service.RequestPhoneCode(ctx, business); !errors.Is(err, verification.ErrTooManyCodes) {
+		t.Errorf("error %v, want %v", err, verification.ErrTooManyCodes)
+	}
+	if len(c.called) != verification.MaxCodesPerDay {
+		t.Errorf("%d calls, want %d", len(c.called), verification.MaxCodesPerDay)
+	}
+	// the requests were saved before the call and canceled when it failed
+	canceled, err := db.GetVerificationRequests().CountDocuments(ctx, bson.M{"business_id": business.ID, "status": model.VerificationCanceled})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if canceled != int64(verification.MaxCodesPerDay) {
+		t.Errorf("%d canceled requests, want %d", canceled, verification.MaxCodesPerDay)
+	}
+	if _, err := service.ConfirmCode(ctx, business.ID, "000000", nil); !errors.Is(err, verification.ErrNoCodePending) {
+		t.Errorf("confirming after failed calls: error %v, want %v", err, verification.ErrNoCodePending)
+	}
+}
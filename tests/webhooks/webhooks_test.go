@@ -0,0 +1,297 @@
+package webhooks_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CoffeeHausGames/whir-server/app/model"
+	"github.com/CoffeeHausGames/whir-server/app/queue"
+	"github.com/CoffeeHausGames/whir-server/app/server/database"
+	"github.com/CoffeeHausGames/whir-server/app/webhooks"
+	"github.com/CoffeeHausGames/whir-server/tests/testdb"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const secret = "whsec_test"
+
+// received is a request the receiver got
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+// receiver is a local webhook receiver that answers with the next status of statuses, then 200
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	requests []received
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	rec := &receiver{statuses: statuses}
+	rec.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		rec.requests = append(rec.requests, received{header: r.Header.Clone(), body: body})
+		status := http.StatusOK
+		if len(rec.statuses) > 0 {
+			status, rec.statuses = rec.statuses[0], rec.statuses[1:]
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, http.StatusText(status))
+	}))
+	t.Cleanup(rec.Close)
+	return rec
+}
+
+// setup queues a delivery to url and returns what sends it
+func setup(t *testing.T, db *database.Database, q *queue.Queue, url string) (*model.WebhookSubscription, *webhooks.Deliverer) {
+	ctx := testdb.Context(t)
+	subscription := &model.WebhookSubscription{
+		ID:          primitive.NewObjectID(),
+		Business_id: primitive.NewObjectID(),
+		Url:         url,
+		Events:      []string{model.WebhookAllEvents},
+		Secret:      secret,
+		Active:      true,
+	}
+	if _, err := db.GetWebhooks().InsertOne(ctx, subscription); err != nil {
+		t.Fatal(err)
+	}
+	publisher := webhooks.NewPublisher(db.GetWebhooks(), db.GetWebhookDeliveries(), q)
+	err := publisher.Publish(ctx, subscription.Business_id, model.WebhookDealCreated, primitive.NewObjectID().Hex(), map[string]string{"name": "Coffee"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return subscription, webhooks.NewDeliverer(db.GetWebhooks(), db.GetWebhookDeliveries())
+}
+
+// deliver leases the delivery task and runs it the way the worker pool does
+func deliver(t *testing.T, q *queue.Queue, deliverer *webhooks.Deliverer) (*model.Task, error) {
+	ctx := testdb.Context(t)
+	task, err := q.Lease(ctx, "worker", []string{webhooks.DeliverTask}, time.Minute)
+	if err != nil || task == nil {
+		t.Fatalf("lease returned %v, %v, want the delivery task", task, err)
+	}
+	err = deliverer.Handler()(ctx, task)
+	if err != nil {
+		if failErr := q.Fail(ctx, task, err); failErr != nil {
+			t.Fatal(failErr)
+		}
+	} else if err := q.Complete(ctx, task); err != nil {
+		t.Fatal(err)
+	}
+	return task, err
+}
+
+func findDelivery(t *testing.T, db *database.Database, subscriptionID primitive.ObjectID) *model.WebhookDelivery {
+	delivery := new(model.WebhookDelivery)
+	if err := db.GetWebhookDeliveries().FindOne(delivery, testdb.Context(t), bson.M{"subscription_id": subscriptionID}); err != nil {
+		t.Fatal(err)
+	}
+	return delivery
+}
+
+// verify checks a signature the way a receiver would
+func verify(signature string, body []byte) bool {
+	var timestamp, v1 string
+	for _, part := range strings.Split(signature, ",") {
+		if strings.HasPrefix(part, "t=") {
+			timestamp = strings.TrimPrefix(part, "t=")
+		}
+		if strings.HasPrefix(part, "v1=") {
+			v1 = strings.TrimPrefix(part, "v1=")
+		}
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + string(body)))
+	return hmac.Equal([]byte(v1), []byte(hex.EncodeToString(mac.Sum(nil))))
+}
+
+func TestSign(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	body := []byte(`{"id":"1"}`)
+	signature := webhooks.Sign(secret, at, body)
+
+	if !strings.HasPrefix(signature, "t="+strconv.FormatInt(at.Unix(), 10)+",v1=") {
+		t.Errorf("signature %q does not start with the timestamp", signature)
+	}
+	if !verify(signature, body) {
+		t.Errorf("signature %q does not verify", signature)
+	}
+	if verify(signature, []byte(`{"id":"2"}`)) {
+		t.Error("signature verifies a different body")
+	}
+}
+
+func TestDeliveryIsSignedAndLogged(t *testing.T) {
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", "true")
+	db := testdb.Connect(t)
+	q := queue.New(db.GetTasks(), db.GetDeadTasks())
+	rec := newReceiver(t)
+	subscription, deliverer := setup(t, db, q, rec.URL)
+
+	if _, err := deliver(t, q, deliverer); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rec.requests) != 1 {
+		t.Fatalf("receiver got %d requests, want 1", len(rec.requests))
+	}
+	request := rec.requests[0]
+	delivery := findDelivery(t, db, subscription.ID)
+	if string(request.body) != delivery.Payload {
+		t.Errorf("receiver got %s, want the payload %s", request.body, delivery.Payload)
+	}
+	if request.header.Get("Whir-Event") != model.WebhookDealCreated || request.header.Get("Whir-Delivery") != delivery.ID.Hex() {
+		t.Errorf("receiver got event %q and delivery %q", request.header.Get("Whir-Event"), request.header.Get("Whir-Delivery"))
+	}
+	if !verify(request.header.Get("Whir-Signature"), request.body) {
+		t.Errorf("signature %q does not verify", request.header.Get("Whir-Signature"))
+	}
+
+	if delivery.Status != model.DeliverySucceeded || len(delivery.Attempts) != 1 {
+		t.Fatalf("delivery is %s with %d attempts, want succeeded with 1", delivery.Status, len(delivery.Attempts))
+	}
+	if attempt := delivery.Attempts[0]; attempt.Response_status != http.StatusOK || attempt.Error != "" {
+		t.Errorf("attempt logged status %d and error %q", attempt.Response_status, attempt.Error)
+	}
+}
+
+func TestFailedDeliveryIsRetriedWithBackoff(t *testing.T) {
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", "true")
+	db := testdb.Connect(t)
+	ctx := testdb.Context(t)
+	q := queue.New(db.GetTasks(), db.GetDeadTasks())
+	rec := newReceiver(t, http.StatusInternalServerError)
+	subscription, deliverer := setup(t, db, q, rec.URL)
+
+	before := time.Now().UTC()
+	task, err := deliver(t, q, deliverer)
+	if err == nil {
+		t.Fatal("a 500 from the receiver did not fail the task")
+	}
+	retried := new(model.Task)
+	if err := db.GetTasks().FindOne(retried, ctx, bson.M{"_id": task.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if retried.Status != model.TaskPending || retried.Run_at.Before(before.Add(queue.Backoff(1)-time.Second)) {
+		t.Errorf("failed delivery task is %s at %s, want pending after a backoff of %s", retried.Status, retried.Run_at, queue.Backoff(1))
+	}
+	delivery := findDelivery(t, db, subscription.ID)
+	if delivery.Status != model.DeliveryPending || len(delivery.Attempts) != 1 {
+		t.Fatalf("delivery is %s with %d attempts, want pending with 1", delivery.Status, len(delivery.Attempts))
+	}
+	if attempt := delivery.Attempts[0]; attempt.Response_status != http.StatusInternalServerError || attempt.Error == "" {
+		t.Errorf("attempt logged status %d and error %q, want the 500", attempt.Response_status, attempt.Error)
+	}
+	stored := new(model.WebhookSubscription)
+	if err := db.GetWebhooks().FindOne(stored, ctx, bson.M{"_id": subscription.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Consecutive_failures != 1 {
+		t.Errorf("%d failures counted, want 1", stored.Consecutive_failures)
+	}
+
+	// the backoff is over and the receiver is back
+	if _, err := db.GetTasks().UpdateOne(ctx, bson.M{"_id": task.ID}, bson.M{"$set": bson.M{"run_at": before}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := deliver(t, q, deliverer); err != nil {
+		t.Fatal(err)
+	}
+	delivery = findDelivery(t, db, subscription.ID)
+	if delivery.Status != model.DeliverySucceeded || len(delivery.Attempts) != 2 {
+		t.Errorf("delivery is %s with %d attempts, want succeeded with 2", delivery.Status, len(delivery.Attempts))
+	}
+	if err := db.GetWebhooks().FindOne(stored, ctx, bson.M{"_id": subscription.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Consecutive_failures != 0 {
+		t.Errorf("%d failures counted after a success, want 0", stored.Consecutive_failures)
+	}
+	// every attempt was signed with the same body
+	for _, request := range rec.requests {
+		if string(request.body) != delivery.Payload || !verify(request.header.Get("Whir-Signature"), request.body) {
+			t.Errorf("retry sent %s with signature %q", request.body, request.header.Get("Whir-Signature"))
+		}
+	}
+}
+
+func TestDeliveryFailsAfterTheLastAttempt(t *testing.T) {
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", "true")
+	db := testdb.Connect(t)
+	ctx := testdb.Context(t)
+	q := queue.New(db.GetTasks(), db.GetDeadTasks())
+	rec := newReceiver(t, http.StatusBadGateway)
+	subscription, deliverer := setup(t, db, q, rec.URL)
+
+	// every attempt but the last one is used up
+	_, err := db.GetTasks().UpdateOne(ctx, bson.M{"type": webhooks.DeliverTask}, bson.M{"$set": bson.M{"attempts": 7, "max_attempts": 8}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := deliver(t, q, deliverer); err != nil {
+		t.Fatalf("the last attempt returned %v, want the delivery marked failed", err)
+	}
+	delivery := findDelivery(t, db, subscription.ID)
+	if delivery.Status != model.DeliveryFailed || len(delivery.Attempts) != 1 {
+		t.Errorf("delivery is %s with %d attempts, want failed with 1", delivery.Status, len(delivery.Attempts))
+	}
+}
+
+func TestPrivateAddressesAreRefused(t *testing.T) {
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", "")
+	db := testdb.Connect(t)
+	q := queue.New(db.GetTasks(), db.GetDeadTasks())
+	rec := newReceiver(t)
+	subscription, deliverer := setup(t, db, q, rec.URL)
+
+	if _, err := deliver(t, q, deliverer); err == nil {
+		t.Fatal("delivery to a loopback address succeeded")
+	}
+	if len(rec.requests) != 0 {
+		t.Errorf("receiver got %d requests, want none", len(rec.requests))
+	}
+	delivery := findDelivery(t, db, subscription.ID)
+	if len(delivery.Attempts) != 1 || !strings.Contains(delivery.Attempts[0].Error, "private address") {
+		t.Errorf("attempts %+v, want the refused connection", delivery.Attempts)
+	}
+}
+
+func TestSharedAndMappedAddressesAreRefused(t *testing.T) {
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", "")
+	for _, url := range []string{
+		"http://100.64.0.1/hook",          // CGNAT, shared address space
+		"http://0.0.0.1/hook",             // this network
+		"http://[::ffff:100.64.0.1]/hook", // IPv4 mapped IPv6
+		"http://[::ffff:7f00:1]/hook",     // 127.0.0.1 mapped
+	} {
+		t.Run(url, func(t *testing.T) {
+			db := testdb.Connect(t)
+			q := queue.New(db.GetTasks(), db.GetDeadTasks())
+			subscription, deliverer := setup(t, db, q, url)
+
+			if _, err := deliver(t, q, deliverer); err == nil {
+				t.Fatal("delivery succeeded")
+			}
+			delivery := findDelivery(t, db, subscription.ID)
+			if len(delivery.Attempts) != 1 || !strings.Contains(delivery.Attempts[0].Error, "private address") {
+				t.Errorf("attempts %+v, want the refused connection", delivery.Attempts)
+			}
+		})
+	}
+}